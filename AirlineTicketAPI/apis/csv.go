@@ -0,0 +1,42 @@
+package apis
+
+import (
+	"Rest/model"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const csvDateFormat = "2006-01-02"
+
+var csvHeader = []string{
+	"flight", "from", "to", "departure", "locator", "passenger", "type", "surname", "name", "dateOfBirth", "gender",
+	"nationality", "documentType", "document", "documentExpiry", "seat", "checkedIn", "missing",
+}
+
+// WriteCSV writes one line per passenger of the manifest after a header line
+func WriteCSV(w io.Writer, manifest *model.Manifest) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(csvHeader); err != nil {
+		return err
+	}
+
+	flight := manifest.Flight
+	for _, p := range manifest.Passengers {
+		expiry := ""
+		if p.DocumentExpiry != nil {
+			expiry = p.DocumentExpiry.Format(csvDateFormat)
+		}
+		record := []string{
+			flight.Number, flight.From, flight.To, flight.Date.UTC().Format("2006-01-02T15:04Z"), p.Locator, p.ID, string(p.Type),
+			p.Surname, p.Name, p.DateOfBirth.Format(csvDateFormat), p.Gender, p.Nationality, p.DocumentType, p.Document, expiry,
+			p.Seat, strconv.FormatBool(p.CheckedIn), strings.Join(p.Missing, "; "),
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,83 @@
+// Package apis writes the Advance Passenger Information manifests of flights for the border authorities
+package apis
+
+import (
+	"Rest/model"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formats a manifest can be written in
+const (
+	CSV    = "csv"
+	PAXLST = "paxlst"
+)
+
+var ErrUnknownFormat = errors.New("manifest format must be csv or paxlst")
+
+// Exporter writes manifests into a local directory, the carrier code is the sender of PAXLST messages
+type Exporter struct {
+	dir     string
+	carrier string
+}
+
+// NewExporterFromEnv writes into APIS_DIR, manifests if it is not set
+func NewExporterFromEnv(carrier string) *Exporter {
+	dir := os.Getenv("APIS_DIR")
+	if dir == "" {
+		dir = "manifests"
+	}
+	return &Exporter{dir, carrier}
+}
+
+// Export writes the manifest in the format and returns the path of the file.
+// Manifests carry document numbers, so only the owner can read them.
+func (e *Exporter) Export(manifest *model.Manifest, format string) (string, error) {
+	var data bytes.Buffer
+	var err error
+	extension := format
+	switch format {
+	case CSV, "":
+		format, extension = CSV, CSV
+		err = WriteCSV(&data, manifest)
+	case PAXLST:
+		extension = "edi"
+		err = WritePAXLST(&data, manifest, e.carrier)
+	default:
+		return "", ErrUnknownFormat
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(e.dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(e.dir, Filename(manifest, extension))
+	// Written under a temporary name first so that nobody picks up half a manifest
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// Filename names the manifest after the flight, its route and departure date, a newer export replaces the file
+func Filename(manifest *model.Manifest, extension string) string {
+	flight := manifest.Flight
+	number := flight.Number
+	if number == "" {
+		number = flight.ID.Hex()
+	}
+	name := fmt.Sprintf("%s-%s%s-%s", number, flight.From, flight.To, flight.Date.UTC().Format("20060102"))
+	name = strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(name)
+	return name + "." + extension
+}
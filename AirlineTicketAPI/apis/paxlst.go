@@ -0,0 +1,108 @@
+package apis
+
+import (
+	"Rest/model"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Service characters of the PAXLST message, the UNA segment announces them to the receiver
+const (
+	una              = "UNA:+.? '"
+	segmentEnd       = "'"
+	elementSeparator = "+"
+	componentSep     = ":"
+)
+
+// The release character ? escapes service characters that appear in the data
+var escaper = strings.NewReplacer("?", "??", "'", "?'", "+", "?+", ":", "?:")
+
+// Document type codes of the DOC segment
+var documentCodes = map[string]string{
+	model.PassportDocument: "P",
+	model.IdCardDocument:   "I",
+}
+
+// WritePAXLST writes the manifest as a UN/EDIFACT PAXLST message in the layout of the IATA/WCO guidelines.
+// It is close to what the authorities accept but not certified against any of them.
+func WritePAXLST(w io.Writer, manifest *model.Manifest, carrier string) error {
+	flight := manifest.Flight
+	created := manifest.CreatedAt.UTC()
+	reference := created.Format("060102150405")
+	receiver := manifest.ToCountry + "APIS"
+	if manifest.ToCountry == "" {
+		receiver = "APIS"
+	}
+
+	segments := [][]string{
+		{"UNB", "UNOA:4", escape(carrier), escape(receiver), created.Format("060102") + componentSep + created.Format("1504"), reference},
+		{"UNG", "PAXLST", escape(carrier), escape(receiver), created.Format("060102") + componentSep + created.Format("1504"), reference, "UN", "D:05B"},
+		{"UNH", reference, "PAXLST:D:05B:UN:IATA"},
+		{"BGM", "745"},
+		{"NAD", "MS", "", "", escape(carrier)},
+		{"TDT", "20", escape(carrier + flight.Number)},
+		{"LOC", "125", escape(flight.From)},
+		{"DTM", "189:" + flight.Date.UTC().Format("0601021504") + ":201"},
+		{"LOC", "87", escape(flight.To)},
+	}
+	for _, p := range manifest.Passengers {
+		segments = append(segments, passengerSegments(flight, &p)...)
+	}
+	segments = append(segments, []string{"CNT", fmt.Sprintf("42:%d", len(manifest.Passengers))})
+	// UNT counts the segments of the message from UNH up to and including itself
+	segments = append(segments, []string{"UNT", fmt.Sprint(len(segments) - 2 + 1), reference})
+	segments = append(segments,
+		[]string{"UNE", "1", reference},
+		[]string{"UNZ", "1", reference},
+	)
+
+	var message strings.Builder
+	message.WriteString(una + "\n")
+	for _, segment := range segments {
+		// Empty elements at the end of a segment are left out
+		for len(segment) > 1 && segment[len(segment)-1] == "" {
+			segment = segment[:len(segment)-1]
+		}
+		message.WriteString(strings.Join(segment, elementSeparator) + segmentEnd + "\n")
+	}
+	_, err := io.WriteString(w, message.String())
+	return err
+}
+
+// passengerSegments is the segment group of one passenger, data that is missing is left out
+func passengerSegments(flight *model.Flight, p *model.ManifestPassenger) [][]string {
+	segments := [][]string{
+		{"NAD", "FL", "", "", escape(strings.ToUpper(p.Surname)) + componentSep + escape(strings.ToUpper(p.Name))},
+	}
+	if p.Gender != "" {
+		segments = append(segments, []string{"ATT", "2", "", p.Gender})
+	}
+	segments = append(segments,
+		[]string{"DTM", "329:" + p.DateOfBirth.Format("060102")},
+		[]string{"LOC", "178", escape(flight.From)},
+		[]string{"LOC", "179", escape(flight.To)},
+	)
+	if p.Nationality != "" {
+		segments = append(segments, []string{"NAT", "2", p.Nationality})
+	}
+	segments = append(segments, []string{"RFF", "AVF:" + escape(p.Locator)})
+	if p.Seat != "" {
+		segments = append(segments, []string{"RFF", "SEA:" + escape(p.Seat)})
+	}
+	if p.Document != "" {
+		code := documentCodes[p.DocumentType]
+		if code == "" {
+			code = "F"
+		}
+		segments = append(segments, []string{"DOC", code + ":110:111", escape(p.Document)})
+		if p.DocumentExpiry != nil {
+			segments = append(segments, []string{"DTM", "36:" + p.DocumentExpiry.Format("060102")})
+		}
+	}
+	return segments
+}
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
@@ -0,0 +1,47 @@
+package boarding
+
+import (
+	"errors"
+	"image/png"
+	"io"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/pdf417"
+	"github.com/boombuler/barcode/qr"
+)
+
+// Symbologies the boarding pass barcode can be rendered in, both are accepted by IATA for BCBP
+const (
+	PDF417 = "pdf417"
+	QR     = "qr"
+)
+
+var ErrUnknownSymbology = errors.New("barcode format must be pdf417 or qr")
+
+// WritePNG renders the barcode content in the symbology as a PNG image
+func WritePNG(w io.Writer, content string, symbology string) error {
+	var code barcode.Barcode
+	var err error
+	width, height := 0, 0
+	switch symbology {
+	case PDF417, "":
+		code, err = pdf417.Encode(content, 4)
+		if err == nil {
+			width, height = code.Bounds().Dx()*3, code.Bounds().Dy()*3
+		}
+	case QR:
+		code, err = qr.Encode(content, qr.M, qr.Auto)
+		width, height = 300, 300
+	default:
+		return ErrUnknownSymbology
+	}
+	if err != nil {
+		return err
+	}
+
+	code, err = barcode.Scale(code, width, height)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, code)
+}
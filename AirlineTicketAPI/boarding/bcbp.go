@@ -0,0 +1,185 @@
+// Package boarding encodes boarding passes in the IATA Bar Coded Boarding Pass (BCBP) format
+package boarding
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfantSeat is printed instead of a seat for infants travelling on an adult's lap
+const InfantSeat = "INF"
+
+// Length of the mandatory items of a single leg pass, everything after them is conditional or security data
+const mandatoryLength = 60
+
+// Security data is "^", its type, its length in two hex digits and the truncated HMAC of the mandatory items
+const (
+	securityType   = "1"
+	signatureBytes = 8
+)
+
+var (
+	ErrInvalidPass  = errors.New("not a boarding pass")
+	ErrForgedPass   = errors.New("boarding pass signature does not match")
+	ErrUnsignedPass = errors.New("boarding pass is not signed")
+	// ErrInvalidAirport is returned for airports that are not given as three letter IATA codes
+	ErrInvalidAirport = errors.New("airports must be three letter IATA codes")
+	transliterator    = strings.NewReplacer("Č", "C", "Ć", "C", "Š", "S", "Ž", "Z", "Đ", "DJ", "Ä", "AE", "Ö", "OE", "Ü", "UE", "ß", "SS")
+)
+
+// Pass holds the mandatory items of a one leg boarding pass
+type Pass struct {
+	Surname      string
+	Name         string
+	Locator      string
+	From         string
+	To           string
+	Carrier      string
+	FlightNumber string
+	Date         time.Time
+	Compartment  string
+	Seat         string
+	Sequence     int
+}
+
+// Encode writes the pass as a format code M, one leg BCBP string without conditional items.
+// It returns ErrInvalidAirport unless From and To are IATA airport codes, a cut down city name would send the passenger elsewhere.
+func (p *Pass) Encode() (string, error) {
+	if !airportCode(p.From) || !airportCode(p.To) {
+		return "", ErrInvalidAirport
+	}
+	var b strings.Builder
+	b.WriteString("M1")
+	b.WriteString(field(passengerName(p.Surname, p.Name), 20))
+	b.WriteString("E")
+	b.WriteString(field(p.Locator, 7))
+	b.WriteString(field(p.From, 3))
+	b.WriteString(field(p.To, 3))
+	b.WriteString(field(p.Carrier, 3))
+	b.WriteString(field(flightNumber(p.FlightNumber), 5))
+	b.WriteString(fmt.Sprintf("%03d", p.Date.YearDay()))
+	b.WriteString(field(p.Compartment, 1))
+	b.WriteString(field(seat(p.Seat), 4))
+	b.WriteString(field(fmt.Sprintf("%04d", p.Sequence), 5))
+	// Passenger status 1: ticket issued and passenger checked in
+	b.WriteString("1")
+	// No conditional items follow
+	b.WriteString("00")
+	return b.String(), nil
+}
+
+// Sign appends the security data that lets the gate tell our boarding passes from forged ones
+func Sign(data string, key []byte) string {
+	signature := signature(data, key)
+	return fmt.Sprintf("%s^%s%02X%s", data, securityType, len(signature), signature)
+}
+
+// Verify checks the security data of a scanned boarding pass and returns the pass it holds
+func Verify(barcode string, key []byte) (*Pass, error) {
+	if len(barcode) < mandatoryLength {
+		return nil, ErrInvalidPass
+	}
+	data, security, found := strings.Cut(barcode, "^")
+	if !found {
+		return nil, ErrUnsignedPass
+	}
+	if len(security) < 3 || security[:1] != securityType {
+		return nil, ErrUnsignedPass
+	}
+	if !hmac.Equal([]byte(security[3:]), []byte(signature(data, key))) {
+		return nil, ErrForgedPass
+	}
+	return Parse(data)
+}
+
+// Parse reads the mandatory items of a one leg pass. The year of the flight date is not part of
+// the format, the date returned is the day of the year in the current year.
+func Parse(data string) (*Pass, error) {
+	if len(data) < mandatoryLength || data[:2] != "M1" || data[22] != 'E' {
+		return nil, ErrInvalidPass
+	}
+	day, err := strconv.Atoi(data[44:47])
+	if err != nil {
+		return nil, ErrInvalidPass
+	}
+	sequence, err := strconv.Atoi(strings.TrimSpace(data[52:57]))
+	if err != nil {
+		return nil, ErrInvalidPass
+	}
+	surname, name, _ := strings.Cut(strings.TrimSpace(data[2:22]), "/")
+	return &Pass{
+		Surname:      surname,
+		Name:         name,
+		Locator:      strings.TrimSpace(data[23:30]),
+		From:         strings.TrimSpace(data[30:33]),
+		To:           strings.TrimSpace(data[33:36]),
+		Carrier:      strings.TrimSpace(data[36:39]),
+		FlightNumber: strings.TrimSpace(data[39:44]),
+		Date:         time.Date(time.Now().Year(), 1, day, 0, 0, 0, 0, time.UTC),
+		Compartment:  data[47:48],
+		Seat:         strings.TrimLeft(strings.TrimSpace(data[48:52]), "0"),
+		Sequence:     sequence,
+	}, nil
+}
+
+func signature(data string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)[:signatureBytes]))
+}
+
+// passengerName is SURNAME/NAME in upper case ASCII, scanners do not handle anything else
+func passengerName(surname string, name string) string {
+	full := transliterator.Replace(strings.ToUpper(surname + "/" + name))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || r == '/' || r == ' ' || r == '-' {
+			return r
+		}
+		return -1
+	}, full)
+}
+
+func airportCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// flightNumber pads the digits to four and keeps an operational suffix, 12A becomes "0012A"
+func flightNumber(number string) string {
+	digits := strings.TrimRight(number, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	suffix := number[len(digits):]
+	n, _ := strconv.Atoi(digits)
+	return fmt.Sprintf("%04d%s", n, suffix)
+}
+
+// seat pads the row to three digits, 7C becomes "007C"
+func seat(s string) string {
+	if s == "" || s == InfantSeat {
+		return InfantSeat
+	}
+	if len(s) < 4 {
+		s = strings.Repeat("0", 4-len(s)) + s
+	}
+	return s
+}
+
+// field left justifies the value and cuts or pads it with spaces to the field's length
+func field(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value + strings.Repeat(" ", length-len(value))
+}
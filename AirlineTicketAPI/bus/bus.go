@@ -0,0 +1,172 @@
+package bus
+
+import (
+	"Rest/model"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many past updates are kept for clients reconnecting with a Last-Event-ID
+const historySize = 1024
+
+// How many updates may wait for a subscriber before it is considered too slow and dropped
+const subscriberBuffer = 64
+
+// FlightUpdate carries the seat count, price and status of a flight after it changed
+type FlightUpdate struct {
+	ID                 string     `json:"-"`
+	FlightId           string     `json:"flightId"`
+	FreeSeats          int        `json:"freeseats"`
+	Price              float32    `json:"price"`
+	Status             string     `json:"status"`
+	Gate               string     `json:"gate,omitempty"`
+	Terminal           string     `json:"terminal,omitempty"`
+	EstimatedDeparture *time.Time `json:"estimatedDeparture,omitempty"`
+	At                 time.Time  `json:"at"`
+
+	seq uint64
+}
+
+// Bus is an in-process publish/subscribe hub for flight updates.
+// Event ids are "<epoch>-<sequence>", the epoch changes on every start so ids from a previous process are never replayed.
+type Bus struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []FlightUpdate
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+type Subscription struct {
+	C       chan FlightUpdate
+	flights map[string]bool
+	// Lagged is set when the subscriber could not keep up and was dropped
+	Lagged bool
+}
+
+func New() *Bus {
+	return &Bus{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish sends the current state of the flight to every subscriber interested in it.
+// Subscribers whose buffer is full are closed instead of blocking the publisher.
+func (b *Bus) Publish(flight *model.Flight) {
+	if b == nil || flight == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.seq++
+	update := FlightUpdate{
+		ID:                 fmt.Sprintf("%s-%d", b.epoch, b.seq),
+		FlightId:           flight.ID.Hex(),
+		FreeSeats:          flight.FreeSeats,
+		Price:              flight.Price,
+		Status:             flight.CurrentStatus(),
+		Gate:               flight.Gate,
+		Terminal:           flight.Terminal,
+		EstimatedDeparture: flight.EstimatedDeparture,
+		At:                 time.Now(),
+		seq:                b.seq,
+	}
+	b.history = append(b.history, update)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+
+	for sub := range b.subscribers {
+		if !sub.wants(update.FlightId) {
+			continue
+		}
+		select {
+		case sub.C <- update:
+		default:
+			sub.Lagged = true
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe registers interest in the given flights, all flights if none are given.
+// Updates published after lastEventID are returned for replay; complete is false when
+// lastEventID is unknown or too old, in which case the client should reload the flights.
+func (b *Bus) Subscribe(flightIds []string, lastEventID string) (sub *Subscription, replay []FlightUpdate, complete bool) {
+	sub = &Subscription{C: make(chan FlightUpdate, subscriberBuffer), flights: map[string]bool{}}
+	for _, id := range flightIds {
+		if id = strings.TrimSpace(id); id != "" {
+			sub.flights[id] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.C)
+		return sub, nil, false
+	}
+	b.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	seq, ok := b.parseID(lastEventID)
+	if !ok || (len(b.history) > 0 && seq < b.history[0].seq-1) {
+		return sub, nil, false
+	}
+	for _, update := range b.history {
+		if update.seq > seq && sub.wants(update.FlightId) {
+			replay = append(replay, update)
+		}
+	}
+	return sub, replay, true
+}
+
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(sub)
+}
+
+// Close ends all subscriptions, used on server shutdown so streaming requests return
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		b.drop(sub)
+	}
+}
+
+func (b *Bus) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+func (b *Bus) parseID(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n > b.seq {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Subscription) wants(flightId string) bool {
+	return len(s.flights) == 0 || s.flights[flightId]
+}
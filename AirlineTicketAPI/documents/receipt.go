@@ -0,0 +1,180 @@
+// Package documents renders the PDF documents handed to customers
+package documents
+
+import (
+	"Rest/model"
+	"Rest/payments"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const dateFormat = "02.01.2006 15:04"
+
+// Receipt is everything printed on the e-ticket and itinerary receipt of a booking.
+// Payment is nil for bookings that were not paid by card.
+type Receipt struct {
+	Booking  *model.Booking
+	Flights  map[string]*model.Flight
+	Payment  *payments.Payment
+	IssuedAt time.Time
+}
+
+// ReceiptFilename is the name the receipt is downloaded and attached under
+func ReceiptFilename(booking *model.Booking) string {
+	return "e-ticket-" + booking.Locator + ".pdf"
+}
+
+// Render draws the receipt on A4 pages and returns the PDF
+func (r *Receipt) Render() ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("E-ticket "+r.Booking.Locator, true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AddPage()
+	// The core fonts are cp1252, names outside of it would otherwise come out garbled
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, "E-ticket and itinerary receipt", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, "Booking reference (PNR): "+r.Booking.Locator, "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Booked: "+r.Booking.CreatedAt.Format(dateFormat)+"    Issued: "+r.IssuedAt.Format(dateFormat), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Status: "+r.Booking.Status, "", 1, "L", false, 0, "")
+
+	r.passengers(pdf, tr)
+	r.flights(pdf)
+	r.fares(pdf)
+	r.payment(pdf)
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (r *Receipt) passengers(pdf *gofpdf.Fpdf, tr func(string) string) {
+	heading(pdf, "Passengers")
+	widths := []float64{15, 75, 25, 65}
+	header(pdf, widths, "#", "Name", "Type", "Ticket numbers")
+	for _, passenger := range r.Booking.Passengers {
+		tickets := []string{}
+		for _, coupon := range r.Booking.Coupons {
+			if coupon.PassengerId == passenger.ID {
+				tickets = append(tickets, coupon.Number)
+			}
+		}
+		row(pdf, widths, passenger.ID, tr(passenger.Surname+" / "+passenger.Name), string(passenger.Type), strings.Join(tickets, ", "))
+	}
+}
+
+func (r *Receipt) flights(pdf *gofpdf.Fpdf) {
+	heading(pdf, "Itinerary")
+	widths := []float64{15, 40, 40, 45, 40}
+	header(pdf, widths, "#", "From", "To", "Departure", "Status")
+	for i, segment := range r.Booking.Segments {
+		flight, ok := r.Flights[segment.FlightId]
+		if !ok {
+			row(pdf, widths, fmt.Sprint(i+1), "-", "-", "-", "unknown flight")
+			continue
+		}
+		row(pdf, widths, fmt.Sprint(i+1), flight.From, flight.To, flight.Date.Format(dateFormat), flight.CurrentStatus())
+	}
+}
+
+func (r *Receipt) fares(pdf *gofpdf.Fpdf) {
+	heading(pdf, "Fare breakdown")
+	widths := []float64{140, 40}
+	for i, segment := range r.Booking.Segments {
+		fare := segment.Fare
+		if fare == nil {
+			continue
+		}
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(0, 6, fmt.Sprintf("Flight %d, %d passenger(s)", i+1, fare.Passengers), "", 1, "L", false, 0, "")
+		charge(pdf, widths, "Base fare", fare.BaseFare, fare.Currency)
+		for _, tax := range fare.Taxes {
+			charge(pdf, widths, tax.Description, tax.Amount, fare.Currency)
+		}
+		charge(pdf, widths, "Fuel surcharge", fare.FuelSurcharge, fare.Currency)
+		charge(pdf, widths, "Service fee", fare.ServiceFee, fare.Currency)
+		charge(pdf, widths, "Seat selection", fare.SeatFees, fare.Currency)
+		charge(pdf, widths, "Checked baggage", fare.BaggageFees, fare.Currency)
+		for _, line := range fare.Ancillaries {
+			charge(pdf, widths, line.Description, line.Amount, fare.Currency)
+		}
+		for _, line := range fare.Discounts {
+			charge(pdf, widths, line.Description, line.Amount, fare.Currency)
+		}
+		if fare.Points > 0 {
+			charge(pdf, widths, fmt.Sprintf("Paid with %d points", fare.Points), -fare.PaidWithPoints, fare.Currency)
+		}
+		pdf.SetFont("Helvetica", "B", 10)
+		amount(pdf, widths, "Flight total", fare.Total, fare.Currency)
+	}
+	for _, change := range r.Booking.Changes {
+		charge(pdf, widths, "Change fee ("+change.ChangedAt.Format(dateFormat)+")", change.ChangeFee, change.Currency)
+	}
+	pdf.Ln(2)
+	pdf.SetFont("Helvetica", "B", 12)
+	amount(pdf, widths, "Total", r.Booking.Total, r.Booking.Currency)
+}
+
+func (r *Receipt) payment(pdf *gofpdf.Fpdf) {
+	heading(pdf, "Payment")
+	widths := []float64{140, 40}
+	if r.Payment == nil {
+		pdf.CellFormat(0, 6, "No card payment recorded for this booking", "", 1, "L", false, 0, "")
+		return
+	}
+	pdf.CellFormat(0, 6, "Card ending in "+r.Payment.CardLast4, "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Payment reference: "+r.Payment.ID.Hex(), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Status: "+string(r.Payment.Status), "", 1, "L", false, 0, "")
+	amount(pdf, widths, "Charged", r.Payment.Amount, r.Payment.Currency)
+	if r.Payment.Refunded > 0 {
+		amount(pdf, widths, "Refunded", r.Payment.Refunded, r.Payment.Currency)
+	}
+}
+
+func heading(pdf *gofpdf.Fpdf, title string) {
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 13)
+	pdf.CellFormat(0, 8, title, "B", 1, "L", false, 0, "")
+	pdf.Ln(1)
+	pdf.SetFont("Helvetica", "", 10)
+}
+
+func header(pdf *gofpdf.Fpdf, widths []float64, columns ...string) {
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(230, 230, 230)
+	for i, column := range columns {
+		pdf.CellFormat(widths[i], 7, column, "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 10)
+}
+
+func row(pdf *gofpdf.Fpdf, widths []float64, columns ...string) {
+	for i, column := range columns {
+		pdf.CellFormat(widths[i], 7, column, "1", 0, "L", false, 0, "")
+	}
+	pdf.Ln(-1)
+}
+
+// charge prints a fare line unless nothing was charged for it
+func charge(pdf *gofpdf.Fpdf, widths []float64, label string, value float64, currency string) {
+	if value != 0 {
+		amount(pdf, widths, label, value, currency)
+	}
+}
+
+// amount prints a label with its amount aligned right and resets the font to regular
+func amount(pdf *gofpdf.Fpdf, widths []float64, label string, value float64, currency string) {
+	pdf.CellFormat(widths[0], 6, label, "", 0, "L", false, 0, "")
+	pdf.CellFormat(widths[1], 6, fmt.Sprintf("%.2f %s", value, currency), "", 1, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+}
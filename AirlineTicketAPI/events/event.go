@@ -0,0 +1,115 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Domain event types
+const (
+	UserRegistered      = "UserRegistered"
+	UserDeleted         = "UserDeleted"
+	TicketPurchased     = "TicketPurchased"
+	TicketDisrupted     = "TicketDisrupted"
+	FlightCreated       = "FlightCreated"
+	FlightUpdated       = "FlightUpdated"
+	FlightSeatsChanged  = "FlightSeatsChanged"
+	FlightStatusChanged = "FlightStatusChanged"
+	FlightCancelled     = "FlightCancelled"
+	BookingConfirmed    = "BookingConfirmed"
+	BookingCancelled    = "BookingCancelled"
+	BookingDisrupted    = "BookingDisrupted"
+	BookingRebooked     = "BookingRebooked"
+	BookingChanged      = "BookingChanged"
+	BookingAnonymized   = "BookingAnonymized"
+	RefundIssued        = "RefundIssued"
+	PaymentUpdated      = "PaymentUpdated"
+)
+
+// Aggregates the events belong to, events of one aggregate are delivered in the order they happened
+const (
+	UserAggregate    = "user"
+	TicketAggregate  = "ticket"
+	FlightAggregate  = "flight"
+	BookingAggregate = "booking"
+	PaymentAggregate = "payment"
+)
+
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+)
+
+// Event is a committed state change as stored in the outbox
+type Event struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type          string             `bson:"type" json:"type"`
+	AggregateType string             `bson:"aggregateType" json:"aggregateType"`
+	AggregateId   string             `bson:"aggregateId" json:"aggregateId"`
+	// Sequence numbers the events of an aggregate from 1 without gaps
+	Sequence   int64           `bson:"sequence" json:"sequence"`
+	Payload    json.RawMessage `bson:"payload" json:"payload"`
+	OccurredAt time.Time       `bson:"occurredAt" json:"occurredAt"`
+
+	Status        string     `bson:"status" json:"-"`
+	DeliveredTo   []string   `bson:"deliveredTo" json:"-"`
+	Attempts      int        `bson:"attempts" json:"-"`
+	NextAttemptAt time.Time  `bson:"nextAttemptAt" json:"-"`
+	LastError     string     `bson:"lastError,omitempty" json:"-"`
+	DeliveredAt   *time.Time `bson:"deliveredAt,omitempty" json:"-"`
+}
+
+// New creates a pending event, the payload is the aggregate's state after the change
+func New(eventType string, aggregateType string, aggregateId string, payload interface{}) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Event{
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateId:   aggregateId,
+		Payload:       data,
+		OccurredAt:    now,
+		Status:        Pending,
+		DeliveredTo:   []string{},
+		NextAttemptAt: now,
+	}, nil
+}
+
+// AggregateKey identifies the aggregate across all aggregate types
+func (e *Event) AggregateKey() string {
+	return e.AggregateType + ":" + e.AggregateId
+}
+
+func (e *Event) DeliveredToSink(name string) bool {
+	for _, sink := range e.DeliveredTo {
+		if sink == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrub replaces the fields the payload has with the given values, so personal data of an erased aggregate
+// does not outlive it in the outbox. Other fields stay as they are, a payload that is not an object is left alone.
+func Scrub(payload json.RawMessage, fields map[string]interface{}) (json.RawMessage, error) {
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &data); err != nil || data == nil {
+		return payload, nil
+	}
+	for key, value := range fields {
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = raw
+	}
+	return json.Marshal(data)
+}
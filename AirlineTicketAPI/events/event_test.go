@@ -0,0 +1,40 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestScrubReplacesOnlyFieldsThePayloadHas(t *testing.T) {
+	payload := json.RawMessage(`{"id":"1","name":"Ana","email":"ana@example.com"}`)
+
+	scrubbed, err := Scrub(payload, map[string]interface{}{"name": "DELETED", "email": "deleted@invalid", "username": "deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]string
+	if err := json.Unmarshal(scrubbed, &data); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"id": "1", "name": "DELETED", "email": "deleted@invalid"}
+	if len(data) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, data)
+	}
+	for key, value := range expected {
+		if data[key] != value {
+			t.Fatalf("expected %v, got %v", expected, data)
+		}
+	}
+}
+
+func TestScrubLeavesPayloadsThatAreNotObjects(t *testing.T) {
+	for _, payload := range []string{`null`, `[1,2]`, `"text"`} {
+		scrubbed, err := Scrub(json.RawMessage(payload), map[string]interface{}{"name": "DELETED"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(scrubbed) != payload {
+			t.Fatalf("payload %s changed to %s", payload, scrubbed)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	batchSize = 500
+	// Only the relay holding the lease delivers, so events of an aggregate are never sent by two instances at once
+	leaseTTL      = 30 * time.Second
+	deliveryDelay = time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+// Store is the outbox the events are relayed from
+type Store interface {
+	// AcquireLease makes owner the only relay for ttl, it returns false while another relay holds the lease
+	AcquireLease(owner string, ttl time.Duration) (bool, error)
+	// Pending returns up to limit due events ordered by aggregate and sequence
+	Pending(limit int) ([]*Event, error)
+	// HasPendingBefore tells whether an earlier event of the same aggregate is still waiting
+	HasPendingBefore(event *Event) (bool, error)
+	// MarkDelivered stores the sinks the event was delivered to and, once every sink got it, that it is done
+	MarkDelivered(event *Event) error
+	// Postpone delays the event and all later events of its aggregate
+	Postpone(event *Event, until time.Time, reason string) error
+}
+
+// Relay delivers the outbox to the sinks at least once, keeping the order of events within every aggregate
+type Relay struct {
+	logger *log.Logger
+	store  Store
+	sinks  []Sink
+	owner  string
+}
+
+func NewRelay(l *log.Logger, s Store, sinks []Sink) *Relay {
+	host, _ := os.Hostname()
+	return &Relay{l, s, sinks, fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())}
+}
+
+// Start relays pending events every interval until the context is done
+func (r *Relay) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.relay(ctx)
+			}
+		}
+	}()
+}
+
+func (r *Relay) relay(ctx context.Context) {
+	if ok, err := r.store.AcquireLease(r.owner, leaseTTL); !ok || err != nil {
+		return
+	}
+	leasedAt := time.Now()
+
+	pending, err := r.store.Pending(batchSize)
+	if err != nil {
+		r.logger.Print("Unable to read the outbox: ", err)
+		return
+	}
+
+	checked := map[string]bool{}
+	blocked := map[string]bool{}
+	for _, event := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(leasedAt) > leaseTTL/2 {
+			if ok, err := r.store.AcquireLease(r.owner, leaseTTL); !ok || err != nil {
+				return
+			}
+			leasedAt = time.Now()
+		}
+
+		key := event.AggregateKey()
+		if blocked[key] {
+			continue
+		}
+		if !checked[key] {
+			checked[key] = true
+			// An earlier event that is waiting for a retry holds back the rest of its aggregate
+			if earlier, err := r.store.HasPendingBefore(event); earlier || err != nil {
+				blocked[key] = true
+				continue
+			}
+		}
+
+		if err := r.deliver(ctx, event); err != nil {
+			blocked[key] = true
+			event.Attempts++
+			until := time.Now().Add(retryDelay(event.Attempts))
+			r.logger.Printf("Delivering event %s (%s) failed, retrying at %s: %v", event.ID.Hex(), event.Type, until.Format(time.RFC3339), err)
+			if err := r.store.Postpone(event, until, err.Error()); err != nil {
+				r.logger.Printf("Unable to postpone event %s: %v", event.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+// deliver hands the event to every sink that did not get it yet
+func (r *Relay) deliver(ctx context.Context, event *Event) error {
+	for _, sink := range r.sinks {
+		if event.DeliveredToSink(sink.Name()) {
+			continue
+		}
+		if err := sink.Deliver(ctx, event); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+		event.DeliveredTo = append(event.DeliveredTo, sink.Name())
+		if err := r.store.MarkDelivered(event); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	event.Status = Delivered
+	event.DeliveredAt = &now
+	return r.store.MarkDelivered(event)
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := deliveryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,208 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore is an outbox kept in memory
+type memoryStore struct {
+	mu     sync.Mutex
+	events []*Event
+	owner  string
+}
+
+func (s *memoryStore) AcquireLease(owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner != "" && s.owner != owner {
+		return false, nil
+	}
+	s.owner = owner
+	return true, nil
+}
+
+func (s *memoryStore) Pending(limit int) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := []*Event{}
+	for _, event := range s.events {
+		if event.Status == Pending && !event.NextAttemptAt.After(time.Now()) {
+			pending = append(pending, event)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].AggregateKey() != pending[j].AggregateKey() {
+			return pending[i].AggregateKey() < pending[j].AggregateKey()
+		}
+		return pending[i].Sequence < pending[j].Sequence
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (s *memoryStore) HasPendingBefore(event *Event) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.events {
+		if other.Status == Pending && other.AggregateKey() == event.AggregateKey() && other.Sequence < event.Sequence {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) MarkDelivered(event *Event) error {
+	return nil
+}
+
+func (s *memoryStore) Postpone(event *Event, until time.Time, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.NextAttemptAt = until
+	event.LastError = reason
+	return nil
+}
+
+func (s *memoryStore) add(t *testing.T, eventType string, aggregateId string, sequence int64) *Event {
+	t.Helper()
+	event, err := New(eventType, FlightAggregate, aggregateId, map[string]interface{}{"id": aggregateId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.ID = primitive.NewObjectID()
+	event.Sequence = sequence
+	s.events = append(s.events, event)
+	return event
+}
+
+// failingSink fails every delivery of the events it is told to
+type failingSink struct {
+	fail map[string]bool
+}
+
+func (s *failingSink) Name() string {
+	return "failing"
+}
+
+func (s *failingSink) Deliver(ctx context.Context, event *Event) error {
+	if s.fail[event.Type] {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func newTestRelay(store Store, sinks ...Sink) *Relay {
+	return NewRelay(log.New(io.Discard, "", 0), store, sinks)
+}
+
+func TestRelayDeliversToMemorySinkInAggregateOrder(t *testing.T) {
+	store := &memoryStore{}
+	store.add(t, FlightSeatsChanged, "b", 1)
+	store.add(t, FlightSeatsChanged, "a", 2)
+	store.add(t, FlightCreated, "a", 1)
+	sink := NewMemorySink()
+	relay := newTestRelay(store, sink)
+
+	relay.relay(context.Background())
+
+	delivered := sink.Events()
+	if len(delivered) != 3 {
+		t.Fatalf("expected 3 delivered events, got %d", len(delivered))
+	}
+	order := []string{}
+	for _, event := range delivered {
+		order = append(order, event.AggregateId+":"+event.Type)
+	}
+	expected := []string{"a:" + FlightCreated, "a:" + FlightSeatsChanged, "b:" + FlightSeatsChanged}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected order %v, got %v", expected, order)
+		}
+	}
+	for _, event := range store.events {
+		if event.Status != Delivered || event.DeliveredAt == nil || !event.DeliveredToSink("memory") {
+			t.Fatalf("event %s #%d was not marked delivered", event.AggregateId, event.Sequence)
+		}
+	}
+
+	relay.relay(context.Background())
+	if len(sink.Events()) != 3 {
+		t.Fatal("delivered events were relayed again")
+	}
+}
+
+func TestRelayPostponesFailedEventAndHoldsBackItsAggregate(t *testing.T) {
+	store := &memoryStore{}
+	first := store.add(t, FlightCreated, "a", 1)
+	second := store.add(t, FlightSeatsChanged, "a", 2)
+	other := store.add(t, FlightSeatsChanged, "b", 1)
+	sink := NewMemorySink()
+	failing := &failingSink{fail: map[string]bool{FlightCreated: true}}
+	relay := newTestRelay(store, sink, failing)
+
+	relay.relay(context.Background())
+
+	if first.Status != Pending || first.Attempts != 1 || first.LastError == "" || !first.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("failed event was not postponed: %+v", first)
+	}
+	if !first.DeliveredToSink("memory") || first.DeliveredToSink("failing") {
+		t.Fatalf("expected the failed event to be delivered only to the memory sink, got %v", first.DeliveredTo)
+	}
+	if second.Status != Pending || len(second.DeliveredTo) != 0 {
+		t.Fatal("a later event of the failed aggregate was delivered")
+	}
+	if other.Status != Delivered {
+		t.Fatal("an event of another aggregate was held back")
+	}
+
+	// Once the sink recovers the held back events follow, the memory sink does not get the first one twice
+	failing.fail = map[string]bool{}
+	first.NextAttemptAt = time.Now()
+	relay.relay(context.Background())
+
+	if first.Status != Delivered || second.Status != Delivered {
+		t.Fatal("events were not delivered after the sink recovered")
+	}
+	if len(sink.Events()) != 3 {
+		t.Fatalf("expected 3 events in the memory sink, got %d", len(sink.Events()))
+	}
+}
+
+func TestRelayWithoutLeaseDeliversNothing(t *testing.T) {
+	store := &memoryStore{owner: "another relay"}
+	store.add(t, FlightSeatsChanged, "a", 1)
+	sink := NewMemorySink()
+
+	newTestRelay(store, sink).relay(context.Background())
+
+	if len(sink.Events()) != 0 {
+		t.Fatal("a relay without the lease delivered events")
+	}
+}
+
+func TestRetryDelayDoublesUpToTheMaximum(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		9:  256 * time.Second,
+		10: maxRetryDelay,
+		50: maxRetryDelay,
+	}
+	for attempts, expected := range cases {
+		if delay := retryDelay(attempts); delay != expected {
+			t.Errorf("retryDelay(%d) = %s, expected %s", attempts, delay, expected)
+		}
+	}
+}
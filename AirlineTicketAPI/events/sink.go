@@ -0,0 +1,129 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives the events relayed from the outbox. Delivery is at least once,
+// so a sink may see an event again and should deduplicate by its ID.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event *Event) error
+}
+
+// NewSinksFromEnv builds the sinks listed in OUTBOX_SINKS (comma separated: log, webhook, memory), log if it is not set.
+// The webhook sink posts to OUTBOX_WEBHOOK_URL.
+func NewSinksFromEnv(l *log.Logger) []Sink {
+	names := os.Getenv("OUTBOX_SINKS")
+	if names == "" {
+		names = "log"
+	}
+
+	sinks := []Sink{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "log":
+			sinks = append(sinks, NewLogSink(l))
+		case "webhook":
+			sinks = append(sinks, NewWebhookSink(os.Getenv("OUTBOX_WEBHOOK_URL")))
+		case "memory":
+			sinks = append(sinks, NewMemorySink())
+		default:
+			l.Printf("Unknown outbox sink %q ignored", name)
+		}
+	}
+	return sinks
+}
+
+// LogSink writes every event to the log
+type LogSink struct {
+	logger *log.Logger
+}
+
+func NewLogSink(l *log.Logger) *LogSink {
+	return &LogSink{l}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Deliver(ctx context.Context, event *Event) error {
+	s.logger.Printf("Event %s %s %s #%d: %s", event.ID.Hex(), event.Type, event.AggregateKey(), event.Sequence, event.Payload)
+	return nil
+}
+
+// WebhookSink posts every event as JSON to a URL, any status other than 2xx is a failed delivery
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url, &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Event-Id", event.ID.Hex())
+	request.Header.Set("X-Event-Type", event.Type)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// MemorySink keeps the delivered events so tests can check them
+type MemorySink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Name() string {
+	return "memory"
+}
+
+func (s *MemorySink) Deliver(ctx context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *event)
+	return nil
+}
+
+// Events returns a copy of all events delivered so far
+func (s *MemorySink) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
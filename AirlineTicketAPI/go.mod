@@ -1,11 +1,13 @@
 module Rest
 
-go 1.18
+go 1.20
 
 require (
+	github.com/boombuler/barcode v1.1.0
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/jung-kurt/gofpdf v1.16.2
 	go.mongodb.org/mongo-driver v1.11.0
 )
 
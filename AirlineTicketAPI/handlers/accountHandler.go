@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"Rest/tokens"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A password reset link works once within resetTokenTTL
+const resetTokenTTL = time.Hour
+
+// resetPasswordPage is opened from the link in a password reset email, it posts the new password with the token of the link
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>Set a new password</title>
+</head>
+<body>
+<h1>Set a new password</h1>
+<form id="reset">
+<input type="password" id="password" autocomplete="new-password" minlength="8" maxlength="72" required>
+<button type="submit">Save</button>
+</form>
+<p id="result"></p>
+<script>
+const token = {{.}};
+document.getElementById("reset").addEventListener("submit", async (event) => {
+	event.preventDefault();
+	const response = await fetch("/password/reset", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({token: token, password: document.getElementById("password").value})
+	});
+	document.getElementById("result").textContent = response.ok ? "Your password was changed, log in with it." : await response.text();
+});
+</script>
+</body>
+</html>
+`))
+
+// AccountHandler lets users manage their own account under /me, verify their email and recover a forgotten password
+type AccountHandler struct {
+	logger *log.Logger
+
+	userRepo    *repo.UserRepo
+	bookingRepo *repo.BookingRepo
+	flightRepo  *repo.FlightRepo
+	notifier    *notifications.Notifier
+	verifier    *EmailVerifier
+}
+
+func NewAccountsHandler(l *log.Logger, u *repo.UserRepo, b *repo.BookingRepo, f *repo.FlightRepo, n *notifications.Notifier,
+	v *EmailVerifier) *AccountHandler {
+	return &AccountHandler{l, u, b, f, n, v}
+}
+
+func (a *AccountHandler) GetMe(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(a.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	user.Password = ""
+
+	err = user.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// UpdateMe edits the profile. A new email is only used once the link sent to it is opened,
+// afterwards the user logs in again since tokens carry the email they were issued for.
+func (a *AccountHandler) UpdateMe(rw http.ResponseWriter, h *http.Request) {
+	update := h.Context().Value(KeyProduct{}).(*model.ProfileUpdate)
+	user, err := CurrentUser(a.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	if err := update.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if update.Language != "" && !notifications.Supported(update.Language) {
+		http.Error(rw, "language must be one of "+strings.Join(notifications.Languages, ", "), http.StatusBadRequest)
+		return
+	}
+	if update.Email != "" && update.Email != user.Email {
+		if existing, _ := a.userRepo.GetByEmail(update.Email); existing != nil {
+			http.Error(rw, "User with given email already exists", http.StatusBadRequest)
+			return
+		}
+	}
+
+	pending := user.PendingEmail
+	update.Apply(user)
+	if update.Email == user.Email && user.PendingEmail != "" {
+		// Going back to the current email drops the change, a verification of the current email stays
+		user.PendingEmail = ""
+		if user.EmailVerification != nil && user.EmailVerification.Email != user.Email {
+			user.EmailVerification = nil
+		}
+	}
+	if user.PendingEmail != "" && user.PendingEmail != pending {
+		err := a.verifier.Send(user, user.PendingEmail)
+		if err == repo.ErrVerificationThrottled {
+			http.Error(rw, "A verification email was sent a moment ago, try again in a minute", http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to send the verification email", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := a.userRepo.UpdateProfile(user); err != nil {
+		http.Error(rw, "Unable to save the profile", http.StatusInternalServerError)
+		return
+	}
+
+	user.Password = ""
+	user.ToJSON(rw)
+}
+
+// VerifyEmail is opened from the link in a verification email, every link works once.
+// Verifying the email of a new account completes the registration.
+func (a *AccountHandler) VerifyEmail(rw http.ResponseWriter, h *http.Request) {
+	user, claims, err := a.verifier.Verify(h.URL.Query().Get("token"))
+	if err == tokens.ErrExpiredToken {
+		http.Error(rw, "Verification link has expired, ask for a new one", http.StatusGone)
+		return
+	}
+	if err == repo.ErrTokenUsed {
+		http.Error(rw, "Verification link was already used", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Verification link is invalid", http.StatusBadRequest)
+		return
+	}
+
+	email := user.EmailVerification.Email
+	if existing, _ := a.userRepo.GetByEmail(email); existing != nil && existing.ID != user.ID {
+		http.Error(rw, "User with given email already exists", http.StatusConflict)
+		return
+	}
+	err = a.userRepo.ConfirmEmail(claims.Subject, claims.Nonce, email)
+	if err == repo.ErrTokenUsed {
+		http.Error(rw, "Verification link was already used", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to verify the email", http.StatusInternalServerError)
+		return
+	}
+	if user.EmailUnverified {
+		notifyUser(a.logger, a.notifier, user, notifications.RegistrationTemplate, notifications.Data{})
+	}
+	fmt.Fprintf(rw, "Email address %s is verified\n", email)
+}
+
+// ResendVerification sends a new link to an email that is waiting to be verified. The answer is the same
+// whether or not the email belongs to an account, and links are sent at most once a minute.
+func (a *AccountHandler) ResendVerification(rw http.ResponseWriter, h *http.Request) {
+	address := h.Context().Value(KeyProduct{}).(*model.EmailAddress)
+	email := strings.TrimSpace(address.Email)
+	if !model.ValidEmail(email) {
+		http.Error(rw, "email is not a valid email address", http.StatusBadRequest)
+		return
+	}
+
+	user, err := a.userRepo.GetByEmail(email)
+	if err != nil || !user.EmailUnverified {
+		user, err = a.userRepo.GetByPendingEmail(email)
+	}
+	if err == nil {
+		if err := a.verifier.Send(user, email); err != nil && err != repo.ErrVerificationThrottled {
+			a.logger.Printf("Verification of %s was not sent again: %v", user.ID.Hex(), err)
+		}
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(rw, "If the address is waiting to be verified, a new link is on its way")
+}
+
+// ChangePassword sets a new password after checking the current one. Every session is revoked,
+// the answer carries a new token so that the user stays logged in where they changed it.
+func (a *AccountHandler) ChangePassword(rw http.ResponseWriter, h *http.Request) {
+	change := h.Context().Value(KeyProduct{}).(*model.PasswordChange)
+	user, err := CurrentUser(a.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	if !CheckPasswordHash(change.CurrentPassword, user.Password) {
+		http.Error(rw, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err := model.ValidatePassword(change.NewPassword); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(change.NewPassword)
+	if err != nil {
+		http.Error(rw, "Unable to change the password", http.StatusInternalServerError)
+		return
+	}
+	user, err = a.userRepo.SetPassword(user.ID, hash)
+	if err != nil {
+		http.Error(rw, "Unable to change the password", http.StatusInternalServerError)
+		return
+	}
+	a.logger.Printf("Password of user %s was changed, its other sessions were revoked", user.ID.Hex())
+
+	role := roleName(user.Role)
+	validToken, err := GenerateJWT(user.Email, role, user.SessionVersion)
+	if err != nil {
+		http.Error(rw, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	token := model.Token{Role: role, Email: user.Email, TokenString: validToken}
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(token)
+}
+
+// ForgotPassword emails a single-use link for setting a new password. The answer is the same whether or not
+// the email belongs to an account, and links are sent at most once a minute.
+func (a *AccountHandler) ForgotPassword(rw http.ResponseWriter, h *http.Request) {
+	address := h.Context().Value(KeyProduct{}).(*model.EmailAddress)
+	email := strings.TrimSpace(address.Email)
+	if !model.ValidEmail(email) {
+		http.Error(rw, "email is not a valid email address", http.StatusBadRequest)
+		return
+	}
+
+	if user, err := a.userRepo.GetByEmail(email); err == nil && user.DeletedAt == nil {
+		a.sendPasswordReset(user)
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(rw, "If the address belongs to an account, a link for setting a new password is on its way")
+}
+
+func (a *AccountHandler) sendPasswordReset(user *model.User) {
+	token, err := tokens.Random()
+	if err != nil {
+		a.logger.Printf("Password reset of %s was not started: %v", user.ID.Hex(), err)
+		return
+	}
+	now := time.Now()
+	reset := &model.PasswordReset{TokenHash: tokens.Hash(token), ExpiresAt: now.Add(resetTokenTTL), SentAt: now}
+	err = a.userRepo.StartPasswordReset(user.ID, reset, now.Add(-resendInterval))
+	if err == repo.ErrResetThrottled {
+		return
+	}
+	if err != nil {
+		a.logger.Printf("Password reset of %s was not started: %v", user.ID.Hex(), err)
+		return
+	}
+	notifyUser(a.logger, a.notifier, user, notifications.PasswordResetTemplate, notifications.Data{
+		"Link":             a.verifier.link("/password/reset", token),
+		"ExpiresInMinutes": int(resetTokenTTL.Minutes()),
+	})
+}
+
+// ResetPasswordPage answers the link from a password reset email with a form for the new password,
+// APP_URL may also point to a front end that posts the token and the password to ResetPassword itself
+func (a *AccountHandler) ResetPasswordPage(rw http.ResponseWriter, h *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := resetPasswordPage.Execute(rw, h.URL.Query().Get("token")); err != nil {
+		a.logger.Print("Unable to render the password reset page: ", err)
+	}
+}
+
+// ResetPassword sets a new password with the token from a password reset email and logs the user out everywhere
+func (a *AccountHandler) ResetPassword(rw http.ResponseWriter, h *http.Request) {
+	newPassword := h.Context().Value(KeyProduct{}).(*model.NewPassword)
+	if err := model.ValidatePassword(newPassword.Password); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if newPassword.Token == "" {
+		http.Error(rw, "Reset link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(newPassword.Password)
+	if err != nil {
+		http.Error(rw, "Unable to reset the password", http.StatusInternalServerError)
+		return
+	}
+	user, err := a.userRepo.ResetPassword(tokens.Hash(newPassword.Token), hash, time.Now())
+	if err == repo.ErrTokenUsed {
+		http.Error(rw, "Reset link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to reset the password", http.StatusInternalServerError)
+		return
+	}
+	a.logger.Printf("Password of user %s was reset, its sessions were revoked", user.ID.Hex())
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMe deletes the account once all of its flights are flown or cancelled. Passenger names and documents
+// of past bookings, holds and waitlist entries are anonymized, also in the events and webhook deliveries
+// that carried them, saved travelers are deleted; fares and payments stay for the accounts.
+func (a *AccountHandler) DeleteMe(rw http.ResponseWriter, h *http.Request) {
+	deletion := h.Context().Value(KeyProduct{}).(*model.AccountDeletion)
+	user, err := CurrentUser(a.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	if !CheckPasswordHash(deletion.Password, user.Password) {
+		http.Error(rw, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+	userId := user.ID.Hex()
+
+	bookings, err := a.bookingRepo.GetAllByUserId(userId)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	for _, booking := range bookings {
+		if booking.Status != model.BookingConfirmed {
+			continue
+		}
+		for _, segment := range booking.Segments {
+			flight, err := a.flightRepo.GetById(segment.FlightId)
+			if err == nil && flight.CurrentStatus() != model.FlightCancelled && !flight.Flown(now) {
+				http.Error(rw, "Booking "+booking.Locator+" has flights to come, cancel it before deleting the account", http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	if err := a.userRepo.Anonymize(user.ID, bookings, now); err != nil {
+		http.Error(rw, "Unable to delete the account", http.StatusInternalServerError)
+		return
+	}
+	a.logger.Printf("Account %s was deleted and anonymized", userId)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AccountHandler) MiddlewareProfileUpdateDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		update := &model.ProfileUpdate{}
+		err := update.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, update)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *AccountHandler) MiddlewarePasswordChangeDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		change := &model.PasswordChange{}
+		err := change.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, change)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *AccountHandler) MiddlewareNewPasswordDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		newPassword := &model.NewPassword{}
+		err := newPassword.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, newPassword)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *AccountHandler) MiddlewareAccountDeletionDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		deletion := &model.AccountDeletion{}
+		err := deletion.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, deletion)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *AccountHandler) MiddlewareEmailAddressDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		address := &model.EmailAddress{}
+		err := address.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, address)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
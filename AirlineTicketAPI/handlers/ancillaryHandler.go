@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/payments"
+	"Rest/repo"
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AncillaryHandler manages the catalog of ancillaries and sells them for bookings that are already paid
+type AncillaryHandler struct {
+	logger *log.Logger
+
+	repo       *repo.AncillaryRepo
+	flightRepo *repo.FlightRepo
+	bookings   *BookingHandler
+	holds      *HoldHandler
+}
+
+func NewAncillariesHandler(l *log.Logger, r *repo.AncillaryRepo, f *repo.FlightRepo, bh *BookingHandler, hh *HoldHandler) *AncillaryHandler {
+	return &AncillaryHandler{l, r, f, bh, hh}
+}
+
+func (a *AncillaryHandler) GetAllAncillaries(rw http.ResponseWriter, h *http.Request) {
+	ancillaries, err := a.repo.GetAll()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		a.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = ancillaries.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+		return
+	}
+}
+
+func (a *AncillaryHandler) CreateAncillary(rw http.ResponseWriter, h *http.Request) {
+	ancillaryDTO := h.Context().Value(KeyProduct{}).(*model.Ancillary)
+	if err := ancillaryDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ancillary := model.Ancillary{Kind: ancillaryDTO.Kind, Code: ancillaryDTO.Code, Name: ancillaryDTO.Name, Price: ancillaryDTO.Price,
+		From: ancillaryDTO.From, To: ancillaryDTO.To, FlightId: ancillaryDTO.FlightId, Inventory: ancillaryDTO.Inventory}
+	if err := a.repo.Insert(&ancillary); err != nil {
+		http.Error(rw, "Unable to save ancillary", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	ancillary.ToJSON(rw)
+}
+
+func (a *AncillaryHandler) DeleteAncillary(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	if err := a.repo.Delete(id); err != nil {
+		http.Error(rw, "Unable to delete ancillary", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// GetFlightAncillaries lists the ancillaries offered on the flight with how many of each are left
+func (a *AncillaryHandler) GetFlightAncillaries(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	flight, err := a.flightRepo.GetById(id)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	catalog, err := a.repo.GetAll()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	sold, err := a.repo.Sold(id)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	offered := model.Ancillaries{}
+	for _, ancillary := range catalog {
+		if !ancillary.OfferedOn(flight) {
+			continue
+		}
+		if ancillary.Inventory > 0 {
+			remaining := ancillary.Inventory - sold[ancillary.ID.Hex()]
+			if remaining < 0 {
+				remaining = 0
+			}
+			ancillary.Remaining = &remaining
+		}
+		offered = append(offered, ancillary)
+	}
+
+	err = offered.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// BuyAncillaries adds ancillaries to the logged in user's confirmed booking. The inventory is taken and the card
+// charged before the booking is changed, both are given back if the booking cannot be saved.
+func (a *AncillaryHandler) BuyAncillaries(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.AncillaryRequest)
+	if len(request.Ancillaries) == 0 {
+		http.Error(rw, "At least one ancillary is required", http.StatusBadRequest)
+		return
+	}
+
+	booking, ok := a.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	if booking.Status != model.BookingConfirmed {
+		http.Error(rw, "Ancillaries can only be added to a confirmed booking", http.StatusConflict)
+		return
+	}
+
+	catalog, err := a.repo.GetAll()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	flights := []*model.Flight{}
+	items := []model.AncillaryItem{}
+	for _, segment := range booking.Segments {
+		flight, err := a.flightRepo.GetById(segment.FlightId)
+		if err != nil {
+			continue
+		}
+		segmentItems, err := catalog.Price(request.Ancillaries, flight, booking.Passengers, now)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(segmentItems) == 0 {
+			continue
+		}
+		if segment.Fare == nil {
+			http.Error(rw, errSegmentUnpriced.Error(), http.StatusConflict)
+			return
+		}
+		if flight.CurrentStatus() == model.FlightCancelled || !flight.Date.After(now) {
+			http.Error(rw, errFlightUnavailable.Error(), http.StatusConflict)
+			return
+		}
+		flights = append(flights, flight)
+		items = append(items, segmentItems...)
+	}
+	// Selections for flights that are not on the booking were skipped while pricing
+	if len(items) != len(request.Ancillaries) {
+		http.Error(rw, "Ancillaries can only be bought for flights of the booking", http.StatusBadRequest)
+		return
+	}
+
+	total := 0.0
+	for _, item := range items {
+		total += item.Amount
+	}
+	total = math.Round(total*100) / 100
+	if total > 0 && request.Card == nil {
+		http.Error(rw, "A card is required to pay for the ancillaries", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.bookings.reserveAncillaries(request.Ancillaries, flights); err != nil {
+		writeReservationError(rw, err)
+		return
+	}
+
+	var payment *payments.Payment
+	if total > 0 {
+		payment, err = a.holds.chargeBooking(booking, total, *request.Card)
+		if err != nil {
+			a.bookings.releaseAncillaries(catalog, request.Ancillaries)
+			if payment == nil {
+				http.Error(rw, "Unable to start the payment", http.StatusInternalServerError)
+				return
+			}
+			writePaymentError(rw, err, payment)
+			return
+		}
+		for i := range items {
+			items[i].PaymentId = payment.ID.Hex()
+		}
+	}
+
+	booking.AddAncillaries(items)
+	if err := a.bookings.repo.AddAncillaries(booking); err != nil {
+		if payment != nil {
+			a.holds.refundCharge(payment)
+		}
+		a.bookings.releaseAncillaries(catalog, request.Ancillaries)
+		if err == repo.ErrBookingNotCancellable {
+			http.Error(rw, "Booking changed in the meantime, the ancillaries were not added", http.StatusConflict)
+			return
+		}
+		http.Error(rw, "Unable to save the ancillaries", http.StatusInternalServerError)
+		return
+	}
+	a.bookings.notifyConfirmation(booking)
+
+	err = booking.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (a *AncillaryHandler) MiddlewareAncillaryDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		ancillary := &model.Ancillary{}
+		err := ancillary.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, ancillary)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *AncillaryHandler) MiddlewareAncillaryRequestDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.AncillaryRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"Rest/apis"
+	"Rest/model"
+	"Rest/repo"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Flights departing within this window are checked for manifests that are due, a delayed flight closes check-in late
+const (
+	manifestLookBehind = 24 * time.Hour
+	manifestLookAhead  = 7 * 24 * time.Hour
+)
+
+// ApisHandler manages the Advance Passenger Information the authorities require on international routes:
+// the countries of the airports, the document rules between countries, the data of the passengers and the manifests.
+type ApisHandler struct {
+	logger *log.Logger
+
+	repo        *repo.ApisRepo
+	flightRepo  *repo.FlightRepo
+	checkInRepo *repo.CheckInRepo
+	bookings    *BookingHandler
+	exporter    *apis.Exporter
+}
+
+func NewApisHandler(l *log.Logger, r *repo.ApisRepo, f *repo.FlightRepo, c *repo.CheckInRepo, bh *BookingHandler) *ApisHandler {
+	return &ApisHandler{l, r, f, c, bh, apis.NewExporterFromEnv(carrierFromEnv())}
+}
+
+func (a *ApisHandler) GetAllAirports(rw http.ResponseWriter, h *http.Request) {
+	airports, err := a.repo.GetAllAirports()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		a.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = airports.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (a *ApisHandler) CreateAirport(rw http.ResponseWriter, h *http.Request) {
+	airportDTO := h.Context().Value(KeyProduct{}).(*model.Airport)
+	if err := airportDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	airport := model.Airport{Code: airportDTO.Code, Name: airportDTO.Name, Country: airportDTO.Country}
+	err := a.repo.InsertAirport(&airport)
+	if err == repo.ErrAirportExists {
+		http.Error(rw, "Airport "+airport.Code+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to save airport", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	airport.ToJSON(rw)
+}
+
+func (a *ApisHandler) DeleteAirport(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	if err := a.repo.DeleteAirport(id); err != nil {
+		http.Error(rw, "Unable to delete airport", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApisHandler) GetAllDocumentRules(rw http.ResponseWriter, h *http.Request) {
+	rules, err := a.repo.GetAllRules()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		a.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = rules.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (a *ApisHandler) CreateDocumentRule(rw http.ResponseWriter, h *http.Request) {
+	ruleDTO := h.Context().Value(KeyProduct{}).(*model.DocumentRule)
+	if err := ruleDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule := model.DocumentRule{FromCountry: ruleDTO.FromCountry, ToCountry: ruleDTO.ToCountry, Documents: ruleDTO.Documents,
+		RequireNationality: ruleDTO.RequireNationality, RequireGender: ruleDTO.RequireGender, RequireExpiry: ruleDTO.RequireExpiry,
+		MinValidityDays: ruleDTO.MinValidityDays}
+	if err := a.repo.InsertRule(&rule); err != nil {
+		http.Error(rw, "Unable to save document rule", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	rule.ToJSON(rw)
+}
+
+func (a *ApisHandler) DeleteDocumentRule(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	if err := a.repo.DeleteRule(id); err != nil {
+		http.Error(rw, "Unable to delete document rule", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// requirements returns the document rule of the flight and the countries it flies between.
+// The rule is nil when the authorities require no data for the route.
+func (a *ApisHandler) requirements(flight *model.Flight) (*model.DocumentRule, string, string, error) {
+	airports, err := a.repo.GetAllAirports()
+	if err != nil {
+		return nil, "", "", err
+	}
+	rules, err := a.repo.GetAllRules()
+	if err != nil {
+		return nil, "", "", err
+	}
+	from, to := airports.Country(flight.From), airports.Country(flight.To)
+	return rules.Match(from, to), from, to, nil
+}
+
+// airportCodes returns the IATA codes of the airports the flight flies between, empty for airports missing from the airport table
+func (a *ApisHandler) airportCodes(flight *model.Flight) (string, string, error) {
+	airports, err := a.repo.GetAllAirports()
+	if err != nil {
+		return "", "", err
+	}
+	return airports.Code(flight.From), airports.Code(flight.To), nil
+}
+
+// GetApiStatus lists for every flight of the logged in user's booking which passengers still miss required data
+func (a *ApisHandler) GetApiStatus(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := a.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+
+	statuses := model.PassengerApiStatuses{}
+	for _, segment := range booking.Segments {
+		flight, err := a.flightRepo.GetById(segment.FlightId)
+		if err != nil {
+			http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+			return
+		}
+		rule, _, _, err := a.requirements(flight)
+		if err != nil {
+			http.Error(rw, "Database exception", http.StatusInternalServerError)
+			return
+		}
+		for i := range booking.Passengers {
+			p := &booking.Passengers[i]
+			statuses = append(statuses, &model.PassengerApiStatus{
+				FlightId:    segment.FlightId,
+				PassengerId: p.ID,
+				Required:    rule != nil,
+				Missing:     rule.Missing(p, flight.Departure()),
+			})
+		}
+	}
+
+	err := statuses.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// UpdateApiData stores the travel document of a passenger of the logged in user's booking.
+// It can be changed until check-in closes on the last flight of the booking.
+func (a *ApisHandler) UpdateApiData(rw http.ResponseWriter, h *http.Request) {
+	data := h.Context().Value(KeyProduct{}).(*model.ApiData)
+	vars := mux.Vars(h)
+	booking, ok := a.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	if booking.Status != model.BookingConfirmed {
+		http.Error(rw, "Booking is not confirmed", http.StatusConflict)
+		return
+	}
+	passenger := booking.Passenger(vars["passengerId"])
+	if passenger == nil {
+		http.Error(rw, "Passenger with given id not found", http.StatusNotFound)
+		return
+	}
+	if err := data.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	open := false
+	now := time.Now()
+	for _, segment := range booking.Segments {
+		flight, err := a.flightRepo.GetById(segment.FlightId)
+		if err != nil {
+			continue
+		}
+		if _, closes := flight.CheckInWindow(); now.Before(closes) {
+			open = true
+		}
+	}
+	if !open {
+		http.Error(rw, "Check-in has closed on every flight of the booking", http.StatusConflict)
+		return
+	}
+
+	data.Apply(passenger)
+	err := a.bookings.repo.UpdatePassenger(booking, passenger)
+	if err == repo.ErrPassengerNotFound {
+		http.Error(rw, "Booking is not confirmed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to save the passenger", http.StatusInternalServerError)
+		return
+	}
+	booking.ToJSON(rw)
+}
+
+// manifest lists every passenger holding an open ticket for the flight with their check-in and missing data
+func (a *ApisHandler) manifest(flight *model.Flight) (*model.Manifest, error) {
+	flightId := flight.ID.Hex()
+	rule, from, to, err := a.requirements(flight)
+	if err != nil {
+		return nil, err
+	}
+	bookings, err := a.bookings.repo.GetConfirmedByFlightId(flightId)
+	if err != nil {
+		return nil, err
+	}
+	checkIns, err := a.checkInRepo.GetByFlight(flightId)
+	if err != nil {
+		return nil, err
+	}
+	checkedIn := map[string]*model.CheckIn{}
+	for _, checkIn := range checkIns {
+		checkedIn[checkIn.BookingId+"/"+checkIn.PassengerId] = checkIn
+	}
+
+	manifest := &model.Manifest{Flight: flight, FromCountry: from, ToCountry: to, Passengers: []model.ManifestPassenger{}, CreatedAt: time.Now()}
+	for _, booking := range bookings {
+		for i := range booking.Passengers {
+			p := &booking.Passengers[i]
+			// Bookings made before coupons existed have none, their passengers travel
+			if coupon := booking.Coupon(p.ID, flightId); len(booking.Coupons) > 0 && (coupon == nil || coupon.Status != model.CouponOpen) {
+				continue
+			}
+			entry := model.ManifestPassenger{Passenger: *p, Locator: booking.Locator, Missing: rule.Missing(p, flight.Departure())}
+			if checkIn, ok := checkedIn[booking.ID.Hex()+"/"+p.ID]; ok {
+				entry.Seat, entry.CheckedIn = checkIn.Seat, true
+			}
+			manifest.Passengers = append(manifest.Passengers, entry)
+		}
+	}
+	return manifest, nil
+}
+
+// GetManifest shows the manifest of a flight as it would be exported now
+func (a *ApisHandler) GetManifest(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	flight, err := a.flightRepo.GetById(vars["id"])
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	manifest, err := a.manifest(flight)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	err = manifest.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		a.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// ExportManifest writes the manifest of a flight into the manifest directory, ?format=paxlst for EDIFACT instead of CSV
+func (a *ApisHandler) ExportManifest(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	format := strings.ToLower(h.URL.Query().Get("format"))
+	if format == "" {
+		format = apis.CSV
+	}
+	if format != apis.CSV && format != apis.PAXLST {
+		http.Error(rw, apis.ErrUnknownFormat.Error(), http.StatusBadRequest)
+		return
+	}
+	flight, err := a.flightRepo.GetById(vars["id"])
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+
+	export, err := a.export(flight, format)
+	if err != nil {
+		http.Error(rw, "Unable to export the manifest", http.StatusInternalServerError)
+		a.logger.Print("Unable to export the manifest: ", err)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	export.ToJSON(rw)
+}
+
+func (a *ApisHandler) export(flight *model.Flight, format string) (*model.ManifestExport, error) {
+	manifest, err := a.manifest(flight)
+	if err != nil {
+		return nil, err
+	}
+	file, err := a.exporter.Export(manifest, format)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ManifestExport{
+		FlightId:   flight.ID.Hex(),
+		Format:     format,
+		File:       file,
+		Passengers: len(manifest.Passengers),
+		Incomplete: manifest.Incomplete(),
+		ExportedAt: manifest.CreatedAt,
+	}, nil
+}
+
+// Start exports the manifests of flights on routes with document rules once their check-in has closed,
+// every interval until the context is done. The export is claimed on the flight, so it runs once per flight.
+func (a *ApisHandler) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.exportDue()
+			}
+		}
+	}()
+}
+
+func (a *ApisHandler) exportDue() {
+	now := time.Now()
+	flights, err := a.flightRepo.GetPendingManifests(now.Add(-manifestLookBehind), now.Add(manifestLookAhead))
+	if err != nil {
+		a.logger.Print("Unable to read the flights with pending manifests: ", err)
+		return
+	}
+	for _, flight := range flights {
+		if _, closes := flight.CheckInWindow(); now.Before(closes) {
+			continue
+		}
+		rule, _, _, err := a.requirements(flight)
+		if err != nil || rule == nil {
+			continue
+		}
+		flightId := flight.ID.Hex()
+		if err := a.flightRepo.MarkManifestExported(flightId, now); err != nil {
+			continue
+		}
+		for _, format := range []string{apis.CSV, apis.PAXLST} {
+			export, err := a.export(flight, format)
+			if err != nil {
+				a.logger.Printf("Manifest of flight %s was not exported: %v", flightId, err)
+				a.flightRepo.UnmarkManifestExported(flightId)
+				break
+			}
+			if export.Incomplete > 0 {
+				a.logger.Printf("Manifest %s lists %d passengers without complete API data", export.File, export.Incomplete)
+			}
+		}
+	}
+}
+
+func (a *ApisHandler) MiddlewareAirportDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		airport := &model.Airport{}
+		err := airport.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, airport)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *ApisHandler) MiddlewareDocumentRuleDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		rule := &model.DocumentRule{}
+		err := rule.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, rule)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (a *ApisHandler) MiddlewareApiDataDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		data := &model.ApiData{}
+		err := data.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			a.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, data)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,724 @@
+package handlers
+
+import (
+	"Rest/documents"
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/payments"
+	"Rest/repo"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type BookingHandler struct {
+	logger *log.Logger
+
+	repo        *repo.BookingRepo
+	flightRepo  *repo.FlightRepo
+	userRepo    *repo.UserRepo
+	feeRepo     *repo.FeeRuleRepo
+	refundRepo  *repo.RefundRepo
+	paymentRepo *repo.PaymentRepo
+	provider    payments.Provider
+	notifier    *notifications.Notifier
+
+	overbookingRepo *repo.OverbookingRepo
+	ancillaryRepo   *repo.AncillaryRepo
+	promotionRepo   *repo.PromotionRepo
+	loyaltyRepo     *repo.LoyaltyRepo
+}
+
+var errAncillaryNotOffered = errors.New("ancillary is not offered on the flight")
+
+func NewBookingsHandler(l *log.Logger, r *repo.BookingRepo, f *repo.FlightRepo, u *repo.UserRepo, fr *repo.FeeRuleRepo, rr *repo.RefundRepo,
+	pr *repo.PaymentRepo, p payments.Provider, n *notifications.Notifier, or *repo.OverbookingRepo, ar *repo.AncillaryRepo,
+	pmr *repo.PromotionRepo, lr *repo.LoyaltyRepo) *BookingHandler {
+	return &BookingHandler{l, r, f, u, fr, rr, pr, p, n, or, ar, pmr, lr}
+}
+
+// sellable is how many seats of the flight can still be sold, its overbooking limit included
+func (b *BookingHandler) sellable(flight *model.Flight) (int, error) {
+	rules, err := b.overbookingRepo.GetAllRules()
+	if err != nil {
+		return 0, err
+	}
+	return flight.FreeSeats + rules.Limit(flight), nil
+}
+
+// reserveSeats takes seats for all passengers on every flight of the request, its ancillaries, a use of its promo codes
+// and the points of an award booking for the user, all or nothing. Flights may be sold beyond their capacity up to
+// their overbooking limit.
+func (b *BookingHandler) reserveSeats(userId string, request *model.BookingRequest) ([]*model.Flight, error) {
+	rules, err := b.overbookingRepo.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+	seats := request.SeatedPassengers()
+	flights := []*model.Flight{}
+	for _, flightId := range request.FlightIds {
+		limit := 0
+		// A missing flight is reported by ReserveSeats
+		if flight, err := b.flightRepo.GetById(flightId); err == nil {
+			limit = rules.Limit(flight)
+		}
+		flight, err := b.flightRepo.ReserveSeats(flightId, seats, limit)
+		if err != nil {
+			b.releaseSeats(request.FlightIds[:len(flights)], seats)
+			if err == repo.ErrNotEnoughSeats {
+				return nil, fmt.Errorf("flight %s is not available for that many passengers: %w", flightId, err)
+			}
+			return nil, err
+		}
+		flights = append(flights, flight)
+	}
+	if err := b.reserveAncillaries(request.Ancillaries, flights); err != nil {
+		b.releaseSeats(request.FlightIds, seats)
+		return nil, err
+	}
+	if err := b.redeemPromotions(userId, request, flights); err != nil {
+		b.releaseRequest(userId, &model.BookingRequest{FlightIds: request.FlightIds, Passengers: request.Passengers, Ancillaries: request.Ancillaries})
+		return nil, err
+	}
+	if err := b.redeemPoints(userId, request, flights); err != nil {
+		b.releaseRequest(userId, &model.BookingRequest{FlightIds: request.FlightIds, Passengers: request.Passengers, Ancillaries: request.Ancillaries,
+			PromoCodes: request.PromoCodes})
+		return nil, err
+	}
+	return flights, nil
+}
+
+// redeemPoints takes the points of an award booking from the user's loyalty account and keeps them on the request
+func (b *BookingHandler) redeemPoints(userId string, request *model.BookingRequest, flights []*model.Flight) error {
+	if !request.PayWithPoints {
+		return nil
+	}
+	booking, err := b.priceBooking(userId, request, flights)
+	if err != nil {
+		return err
+	}
+	entry := model.LedgerEntry{
+		UserId:      userId,
+		Kind:        model.LedgerRedeem,
+		Points:      -booking.Points(),
+		Description: fmt.Sprintf("Award booking of %d flight(s)", len(flights)),
+		CreatedAt:   time.Now(),
+	}
+	if err := b.loyaltyRepo.Post(&entry); err != nil {
+		return err
+	}
+	request.Points = booking.Points()
+	return nil
+}
+
+// returnPoints gives points of an award booking back to the user's loyalty account
+func (b *BookingHandler) returnPoints(userId string, bookingId string, points int, description string) {
+	if points <= 0 {
+		return
+	}
+	entry := model.LedgerEntry{
+		UserId:      userId,
+		Kind:        model.LedgerReturn,
+		Points:      points,
+		BookingId:   bookingId,
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+	if err := b.loyaltyRepo.Post(&entry); err != nil {
+		b.logger.Printf("%d points were not given back to user %s: %v", points, userId, err)
+	}
+}
+
+// redeemPromotions counts a use of every promo code of the request for the user, all or nothing.
+// The request is priced first so that no use is counted for codes that do not apply.
+func (b *BookingHandler) redeemPromotions(userId string, request *model.BookingRequest, flights []*model.Flight) error {
+	if len(request.PromoCodes) == 0 {
+		return nil
+	}
+	if _, err := b.priceBooking(userId, request, flights); err != nil {
+		return err
+	}
+	promotions, err := b.promotionRepo.GetByCodes(request.PromoCodes)
+	if err != nil {
+		return err
+	}
+	for i, promotion := range promotions {
+		if err := b.promotionRepo.Redeem(promotion, userId); err != nil {
+			b.releasePromotions(userId, promotions[:i])
+			return fmt.Errorf("%s: %w", promotion.Code, err)
+		}
+	}
+	return nil
+}
+
+func (b *BookingHandler) releasePromotions(userId string, promotions model.Promotions) {
+	for _, promotion := range promotions {
+		if err := b.promotionRepo.Release(promotion, userId); err != nil {
+			b.logger.Printf("Unable to give back a use of promo code %s: %v", promotion.Code, err)
+		}
+	}
+}
+
+// recordRedemptions keeps the discount the new booking got with each of its promo codes for the campaign reports
+func (b *BookingHandler) recordRedemptions(booking *model.Booking) {
+	for _, code := range booking.PromoCodes {
+		redemption := model.Redemption{
+			Code:       code,
+			UserId:     booking.UserId,
+			BookingId:  booking.ID.Hex(),
+			Locator:    booking.Locator,
+			Discount:   booking.Discount(code),
+			Currency:   booking.Currency,
+			RedeemedAt: booking.CreatedAt,
+		}
+		if err := b.promotionRepo.InsertRedemption(&redemption); err != nil {
+			b.logger.Printf("Redemption of promo code %s by booking %s was not recorded: %v", code, booking.Locator, err)
+		}
+	}
+}
+
+// reserveAncillaries takes the inventory of the selected ancillaries on their flights, all or nothing
+func (b *BookingHandler) reserveAncillaries(selections []model.AncillarySelection, flights []*model.Flight) error {
+	if len(selections) == 0 {
+		return nil
+	}
+	catalog, err := b.ancillaryRepo.GetAll()
+	if err != nil {
+		return err
+	}
+	byId := map[string]*model.Flight{}
+	for _, flight := range flights {
+		byId[flight.ID.Hex()] = flight
+	}
+
+	for i, selection := range selections {
+		var err error
+		ancillary := catalog.Find(selection.AncillaryId)
+		flight := byId[selection.FlightId]
+		if ancillary == nil || flight == nil || !ancillary.OfferedOn(flight) {
+			err = fmt.Errorf("ancillary %s on flight %s: %w", selection.AncillaryId, selection.FlightId, errAncillaryNotOffered)
+		} else if ancillary.Inventory > 0 {
+			err = b.ancillaryRepo.Reserve(selection.AncillaryId, selection.FlightId, selection.Quantity, ancillary.Inventory)
+			if err == repo.ErrAncillarySoldOut {
+				err = fmt.Errorf("%s on flight %s: %w", ancillary.Name, selection.FlightId, err)
+			}
+		}
+		if err != nil {
+			b.releaseAncillaries(catalog, selections[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReservationError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, repo.ErrNotEnoughSeats) || errors.Is(err, repo.ErrAncillarySoldOut) {
+		http.Error(rw, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	if errors.Is(err, errAncillaryNotOffered) || errors.Is(err, repo.ErrPromotionNotFound) || errors.Is(err, model.ErrPromotionNotApplicable) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repo.ErrPromotionUsedUp) || errors.Is(err, repo.ErrPromotionUserLimit) || errors.Is(err, repo.ErrNotEnoughPoints) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(rw, "Unable to reserve seats", http.StatusInternalServerError)
+}
+
+// priceBooking builds the confirmed booking for the request on the given flights, it is not stored yet
+func (b *BookingHandler) priceBooking(userId string, request *model.BookingRequest, flights []*model.Flight) (*model.Booking, error) {
+	rules, err := b.feeRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	seats := request.SeatedPassengers()
+	booking := model.Booking{
+		UserId:     userId,
+		Passengers: request.Passengers,
+		Segments:   []model.Segment{},
+		Currency:   model.Currency,
+		Status:     model.BookingConfirmed,
+		CreatedAt:  time.Now(),
+	}
+	catalog := model.Ancillaries{}
+	if len(request.Ancillaries) > 0 {
+		if catalog, err = b.ancillaryRepo.GetAll(); err != nil {
+			return nil, err
+		}
+	}
+	for _, flight := range flights {
+		fare := rules.Breakdown(flight, seats, request.SelectedSeats, request.CheckedBags)
+		items, err := catalog.Price(request.Ancillaries, flight, request.Passengers, booking.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		fare.AddAncillaries(items)
+		booking.Ancillaries = append(booking.Ancillaries, items...)
+		// The booking keeps the rules it was sold under, even if the flight's rules change later
+		rules := flight.FareRules()
+		booking.Segments = append(booking.Segments, model.Segment{FlightId: flight.ID.Hex(), FareClass: request.FareClass, Fare: fare, Rules: &rules})
+	}
+
+	if len(request.PromoCodes) > 0 {
+		promotions, err := b.promotionRepo.GetByCodes(request.PromoCodes)
+		if err != nil {
+			return nil, err
+		}
+		fares := []*model.FareBreakdown{}
+		for _, segment := range booking.Segments {
+			fares = append(fares, segment.Fare)
+		}
+		if err := promotions.Apply(fares, flights, request.FareClass, booking.CreatedAt); err != nil {
+			return nil, err
+		}
+		booking.PromoCodes = request.PromoCodes
+	}
+	for _, segment := range booking.Segments {
+		if request.PayWithPoints {
+			segment.Fare.PayWithPoints()
+		}
+		booking.Total += segment.Fare.Total
+	}
+	booking.Total = math.Round(booking.Total*100) / 100
+	return &booking, nil
+}
+
+// releaseSeats gives seats back to the flights when a reservation cannot be completed
+func (b *BookingHandler) releaseSeats(flightIds []string, seats int) {
+	for _, flightId := range flightIds {
+		if _, err := b.flightRepo.ReleaseSeats(flightId, seats); err != nil {
+			b.logger.Printf("Unable to release %d seats on flight %s: %v", seats, flightId, err)
+		}
+	}
+}
+
+// releaseRequest gives back the seats, the ancillaries and the promo code uses reserved for the user's request
+// that did not become a booking
+func (b *BookingHandler) releaseRequest(userId string, request *model.BookingRequest) {
+	b.releaseSeats(request.FlightIds, request.SeatedPassengers())
+	b.returnPoints(userId, "", request.Points, "Award booking not completed")
+	if len(request.PromoCodes) > 0 {
+		if promotions, err := b.promotionRepo.GetByCodes(request.PromoCodes); err == nil {
+			b.releasePromotions(userId, promotions)
+		} else {
+			b.logger.Printf("Uses of promo codes %v were not given back: %v", request.PromoCodes, err)
+		}
+	}
+	if len(request.Ancillaries) == 0 {
+		return
+	}
+	catalog, err := b.ancillaryRepo.GetAll()
+	if err != nil {
+		b.logger.Printf("Unable to release ancillaries of flights %v: %v", request.FlightIds, err)
+		return
+	}
+	b.releaseAncillaries(catalog, request.Ancillaries)
+}
+
+// moveAncillaries adds the ancillaries bought for the old flight of the move to it, they go along to the new flight.
+// Every one of them must be offered on the new flight, ancillaries without inventory are not counted.
+func (b *BookingHandler) moveAncillaries(booking *model.Booking, move *model.InventoryMove, to *model.Flight) error {
+	move.Inventory = map[string]int{}
+	given := booking.AncillariesOn(move.FromFlightId)
+	if len(given) == 0 {
+		return nil
+	}
+	catalog, err := b.ancillaryRepo.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, selection := range given {
+		ancillary := catalog.Find(selection.AncillaryId)
+		if ancillary == nil || !ancillary.OfferedOn(to) {
+			return fmt.Errorf("ancillary %s on flight %s: %w", selection.AncillaryId, move.ToFlightId, errAncillaryNotOffered)
+		}
+		if ancillary.Inventory > 0 {
+			move.Ancillaries = append(move.Ancillaries, selection)
+			move.Inventory[selection.AncillaryId] = ancillary.Inventory
+		}
+	}
+	return nil
+}
+
+// releaseAncillaries gives the selected ancillaries back to the inventory of their flights
+func (b *BookingHandler) releaseAncillaries(catalog model.Ancillaries, selections []model.AncillarySelection) {
+	for _, selection := range selections {
+		// Ancillaries without inventory were never counted
+		if ancillary := catalog.Find(selection.AncillaryId); ancillary != nil && ancillary.Inventory == 0 {
+			continue
+		}
+		if err := b.ancillaryRepo.Release(selection.AncillaryId, selection.FlightId, selection.Quantity); err != nil {
+			b.logger.Printf("Unable to release ancillary %s on flight %s: %v", selection.AncillaryId, selection.FlightId, err)
+		}
+	}
+}
+
+func (b *BookingHandler) GetMyBookings(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(b.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	bookings, err := b.repo.GetAllByUserId(user.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	for _, booking := range bookings {
+		b.withFlightStatus(booking)
+	}
+
+	err = bookings.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		b.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (b *BookingHandler) GetBookingById(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := b.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+
+	b.withFlightStatus(booking)
+	err := booking.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		b.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// CancelBooking cancels the logged in user's booking, applying the fare rules of every segment
+func (b *BookingHandler) CancelBooking(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := b.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	b.writeCancellation(rw, booking.ID.Hex(), false, "Cancelled by customer")
+}
+
+// AdminCancelBooking cancels any booking without a cancellation penalty
+func (b *BookingHandler) AdminCancelBooking(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	b.writeCancellation(rw, vars["id"], true, "Cancelled by admin")
+}
+
+func (b *BookingHandler) writeCancellation(rw http.ResponseWriter, id string, byAdmin bool, reason string) {
+	refund, err := b.cancelBooking(id, byAdmin, reason)
+	if err == repo.ErrBookingNotCancellable {
+		http.Error(rw, "Booking is not confirmed or does not exist", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to cancel the booking", http.StatusInternalServerError)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	refund.ToJSON(rw)
+}
+
+// cancelBooking cancels the booking together with recording its refund, then gives the seats of flights that did not
+// depart back and pays the refund out. A refund that could not be paid out stays recorded for manual settlement.
+func (b *BookingHandler) cancelBooking(id string, waivePenalty bool, reason string) (*model.Refund, error) {
+	booking, err := b.repo.GetById(id)
+	if err != nil || booking.Status != model.BookingConfirmed {
+		return nil, repo.ErrBookingNotCancellable
+	}
+
+	now := time.Now()
+	refund := model.Refund{
+		BookingId: booking.ID.Hex(),
+		UserId:    booking.UserId,
+		Currency:  booking.Currency,
+		ByAdmin:   waivePenalty,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	release := &model.InventoryRelease{Seats: booking.SeatedPassengers()}
+	selections := []model.AncillarySelection{}
+	points := 0
+	for i := range booking.Segments {
+		segment := &booking.Segments[i]
+		flight, err := b.flightRepo.GetById(segment.FlightId)
+		if err != nil {
+			b.logger.Printf("Flight %s of booking %s not found, nothing refunded for it", segment.FlightId, booking.Locator)
+			continue
+		}
+		// Nothing is returned for flights already flown, a cancelled flight was never flown
+		if flight.Departure().Before(now) && flight.Status != model.FlightCancelled {
+			continue
+		}
+		amount, penalty := model.SegmentRefund(segment, flight.Departure(), now, waivePenalty)
+		refund.Amount += amount
+		refund.Penalty += penalty
+		// Points paid for flights that are not flown are always returned
+		if segment.Fare != nil {
+			points += segment.Fare.Points
+		}
+		release.FlightIds = append(release.FlightIds, segment.FlightId)
+		selections = append(selections, booking.AncillariesOn(segment.FlightId)...)
+	}
+	refund.Amount = math.Round(refund.Amount*100) / 100
+	refund.Penalty = math.Round(refund.Penalty*100) / 100
+
+	if len(selections) > 0 {
+		catalog, err := b.ancillaryRepo.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, selection := range selections {
+			// Ancillaries without inventory were never counted
+			if ancillary := catalog.Find(selection.AncillaryId); ancillary == nil || ancillary.Inventory > 0 {
+				release.Ancillaries = append(release.Ancillaries, selection)
+			}
+		}
+	}
+
+	// The booking, the refund and the seats and ancillaries given back change in one transaction
+	booking, err = b.repo.Cancel(booking, &refund, release)
+	if err != nil {
+		return nil, err
+	}
+	for _, flightId := range release.FlightIds {
+		b.flightRepo.Publish(flightId)
+	}
+
+	b.refundPayment(booking, &refund)
+	b.returnPoints(booking.UserId, booking.ID.Hex(), points, "Booking "+booking.Locator+" cancelled")
+	if err := b.refundRepo.Settle(&refund); err != nil {
+		b.logger.Printf("Outcome of the refund of booking %s was not saved: %v", booking.Locator, err)
+	}
+
+	notifyBookingOwner(b.logger, b.notifier, b.userRepo, booking, notifications.CancellationTemplate, notifications.Data{
+		"Refund":   refund.Amount,
+		"Penalty":  refund.Penalty,
+		"Currency": refund.Currency,
+	})
+	return &refund, nil
+}
+
+// GetReceipt downloads the PDF e-ticket and itinerary receipt of the logged in user's booking
+func (b *BookingHandler) GetReceipt(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := b.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+
+	pdf, err := b.receipt(booking).Render()
+	if err != nil {
+		http.Error(rw, "Unable to generate the receipt", http.StatusInternalServerError)
+		b.logger.Print("Unable to generate the receipt: ", err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/pdf")
+	rw.Header().Set("Content-Disposition", `attachment; filename="`+documents.ReceiptFilename(booking)+`"`)
+	rw.Write(pdf)
+}
+
+// receipt gathers the flights and the payment printed on the booking's receipt
+func (b *BookingHandler) receipt(booking *model.Booking) *documents.Receipt {
+	receipt := &documents.Receipt{Booking: booking, Flights: map[string]*model.Flight{}, IssuedAt: time.Now()}
+	for _, segment := range booking.Segments {
+		if flight, err := b.flightRepo.GetById(segment.FlightId); err == nil {
+			receipt.Flights[segment.FlightId] = flight
+		}
+	}
+	if booking.PaymentId != "" {
+		if payment, err := b.paymentRepo.GetById(booking.PaymentId); err == nil {
+			receipt.Payment = payment
+		}
+	}
+	return receipt
+}
+
+// notifyConfirmation emails the itinerary of a newly confirmed booking to its owner, with the receipt attached
+func (b *BookingHandler) notifyConfirmation(booking *model.Booking) {
+	b.withFlightStatus(booking)
+	flights := []notifications.Data{}
+	for _, flight := range booking.Flights {
+		flights = append(flights, notifications.Data{"From": flight.From, "To": flight.To, "Date": flight.Date.Format(emailDateFormat)})
+	}
+
+	attachments := []notifications.Attachment{}
+	if pdf, err := b.receipt(booking).Render(); err == nil {
+		attachments = append(attachments, notifications.Attachment{Filename: documents.ReceiptFilename(booking), ContentType: "application/pdf", Data: pdf})
+	} else {
+		b.logger.Printf("Unable to generate the receipt of booking %s, confirmation sent without it: %v", booking.Locator, err)
+	}
+
+	notifyBookingOwner(b.logger, b.notifier, b.userRepo, booking, notifications.BookingConfirmationTemplate, notifications.Data{
+		"Flights":    flights,
+		"Passengers": booking.Passengers,
+		"Total":      booking.Total,
+		"Currency":   booking.Currency,
+	}, attachments...)
+}
+
+// refundPayment returns the refund amount to the cards the booking was paid with. The booking's own payment is
+// refunded first, then the payments of its exchanges and of ancillaries bought later, each up to what it captured.
+func (b *BookingHandler) refundPayment(booking *model.Booking, refund *model.Refund) {
+	if booking.PaymentId == "" || refund.Amount <= 0 {
+		return
+	}
+	refund.PaymentId = booking.PaymentId
+
+	paymentIds := []string{booking.PaymentId}
+	for _, change := range booking.Changes {
+		paymentIds = appendPaymentId(paymentIds, change.PaymentId)
+	}
+	for _, item := range booking.Ancillaries {
+		paymentIds = appendPaymentId(paymentIds, item.PaymentId)
+	}
+
+	left := refund.Amount
+	for _, paymentId := range paymentIds {
+		if left <= 0 {
+			break
+		}
+		payment, err := b.paymentRepo.GetById(paymentId)
+		if err != nil {
+			b.logger.Printf("Payment %s of booking %s not found, refund has to be settled manually", paymentId, booking.Locator)
+			return
+		}
+		amount := math.Min(left, math.Round((payment.Amount-payment.Refunded)*100)/100)
+		if amount <= 0 {
+			continue
+		}
+		if !b.refundTo(booking, payment, amount) {
+			return
+		}
+		left = math.Round((left-amount)*100) / 100
+	}
+	if left > 0 {
+		b.logger.Printf("Refund of booking %s exceeds what was paid by %.2f, the rest has to be settled manually", booking.Locator, left)
+		return
+	}
+	refund.Settled = true
+}
+
+func appendPaymentId(paymentIds []string, paymentId string) []string {
+	if paymentId == "" {
+		return paymentIds
+	}
+	for _, id := range paymentIds {
+		if id == paymentId {
+			return paymentIds
+		}
+	}
+	return append(paymentIds, paymentId)
+}
+
+// refundTo returns the amount to the card of the payment and records it on the payment
+func (b *BookingHandler) refundTo(booking *model.Booking, payment *payments.Payment, amount float64) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.provider.Refund(ctx, payment.Reference, amount); err != nil {
+		b.logger.Printf("Refund of booking %s failed, it has to be settled manually: %v", booking.Locator, err)
+		return false
+	}
+
+	from := payment.Status
+	payment.Refunded = math.Round((payment.Refunded+amount)*100) / 100
+	status := payments.PartiallyRefunded
+	if payment.Refunded >= payment.Amount {
+		status = payments.Refunded
+	}
+	if err := payment.MoveTo(status); err != nil {
+		b.logger.Print(err)
+	} else if err := b.paymentRepo.Update(payment, from); err != nil {
+		b.logger.Printf("Refund of booking %s settled but not saved on its payment: %v", booking.Locator, err)
+	}
+	return true
+}
+
+// LookupBooking finds a booking by record locator and the surname of any of its passengers, without logging in.
+// It shows names, segments, status and check-in eligibility, never documents, dates of birth or payments.
+func (b *BookingHandler) LookupBooking(rw http.ResponseWriter, h *http.Request) {
+	locator := h.URL.Query().Get("locator")
+	surname := h.URL.Query().Get("surname")
+	if locator == "" || surname == "" {
+		http.Error(rw, "Locator and surname are required", http.StatusBadRequest)
+		return
+	}
+
+	booking, err := b.repo.GetByLocator(locator)
+	if err != nil || !booking.HasPassenger(surname) {
+		http.Error(rw, "Booking not found", http.StatusNotFound)
+		return
+	}
+
+	// Anyone can look a booking up, so only the redacted summary is shown
+	flights := map[string]*model.Flight{}
+	for _, segment := range booking.Segments {
+		if flight, err := b.flightRepo.GetById(segment.FlightId); err == nil {
+			flights[segment.FlightId] = flight
+		}
+	}
+	err = booking.Summary(flights, time.Now()).ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		b.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// withFlightStatus attaches the current operational status of every segment's flight
+func (b *BookingHandler) withFlightStatus(booking *model.Booking) {
+	booking.Flights = []model.FlightStatus{}
+	for _, segment := range booking.Segments {
+		flight, err := b.flightRepo.GetById(segment.FlightId)
+		if err != nil {
+			continue
+		}
+		booking.Flights = append(booking.Flights, flight.StatusView())
+	}
+}
+
+// ownBooking loads the booking from the path and checks that it belongs to the logged in user
+func (b *BookingHandler) ownBooking(rw http.ResponseWriter, h *http.Request) (*model.Booking, bool) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	user, err := CurrentUser(b.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	booking, err := b.repo.GetById(id)
+	if err != nil || booking.UserId != user.ID.Hex() {
+		http.Error(rw, "Booking with given id not found", http.StatusNotFound)
+		b.logger.Printf("Booking with id: '%s' not found", id)
+		return nil, false
+	}
+	return booking, true
+}
+
+func (b *BookingHandler) MiddlewareBookingDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.BookingRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			b.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
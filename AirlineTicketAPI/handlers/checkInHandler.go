@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"Rest/boarding"
+	"Rest/model"
+	"Rest/repo"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Boarding passes carry AIRLINE_CODE as the operating carrier, YY (unknown carrier) if it is not set
+	defaultCarrier = "YY"
+	// Seats handed out automatically at check-in, row by row up to the capacity of the flight.
+	// Flights without a known capacity get autoSeatRows rows.
+	autoSeatRows    = 60
+	autoSeatLetters = "ABCDEF"
+)
+
+type CheckInHandler struct {
+	logger *log.Logger
+
+	repo       *repo.CheckInRepo
+	flightRepo *repo.FlightRepo
+	bookings   *BookingHandler
+	apis       *ApisHandler
+	// Signs the boarding passes so that the gate can reject forged ones
+	key     []byte
+	carrier string
+}
+
+func NewCheckInsHandler(l *log.Logger, r *repo.CheckInRepo, f *repo.FlightRepo, bh *BookingHandler, a *ApisHandler) *CheckInHandler {
+	key := os.Getenv("BOARDING_PASS_KEY")
+	if key == "" {
+		l.Println("BOARDING_PASS_KEY is not set, boarding passes are signed with a development key")
+		key = "development-boarding-pass-key"
+	}
+	return &CheckInHandler{l, r, f, bh, a, []byte(key), carrierFromEnv()}
+}
+
+func carrierFromEnv() string {
+	carrier := strings.ToUpper(os.Getenv("AIRLINE_CODE"))
+	if carrier == "" {
+		carrier = defaultCarrier
+	}
+	return carrier
+}
+
+// CheckIn checks passengers of the logged in user's booking in on one of its flights while the flight's
+// check-in window is open. Passengers get the seat they asked for or the first free one.
+func (c *CheckInHandler) CheckIn(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.CheckInRequest)
+	booking, ok := c.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	if err := request.Validate(booking); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flight, ok := c.checkInFlight(rw, booking, request.FlightId)
+	if !ok {
+		return
+	}
+	// Passengers of routes with document rules need complete API data before they get a boarding pass
+	rule, _, _, err := c.apis.requirements(flight)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	for _, seat := range request.Passengers {
+		if missing := rule.Missing(booking.Passenger(seat.PassengerId), flight.Departure()); len(missing) > 0 {
+			http.Error(rw, "Passenger "+seat.PassengerId+" is missing API data: "+strings.Join(missing, ", "), http.StatusConflict)
+			return
+		}
+	}
+	// Boarding passes name the airports by their IATA codes
+	from, to, err := c.apis.airportCodes(flight)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	for _, airport := range []struct{ place, code string }{{flight.From, from}, {flight.To, to}} {
+		if airport.code == "" {
+			http.Error(rw, "Airport "+airport.place+" has no IATA code, boarding passes cannot be issued", http.StatusConflict)
+			return
+		}
+	}
+	taken, err := c.repo.TakenSeats(flight.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	checkIns := model.CheckIns{}
+	for _, seat := range request.Passengers {
+		checkIn, err := c.checkInPassenger(booking, flight, from, to, seat, taken)
+		if err != nil {
+			// Passengers travelling together are checked in together or not at all
+			for _, done := range checkIns {
+				c.repo.Delete(done.ID)
+			}
+			writeCheckInError(rw, err, seat)
+			return
+		}
+		checkIns = append(checkIns, checkIn)
+	}
+
+	rw.WriteHeader(http.StatusCreated)
+	checkIns.ToJSON(rw)
+}
+
+// checkInFlight checks that the booking can be checked in on the flight right now
+func (c *CheckInHandler) checkInFlight(rw http.ResponseWriter, booking *model.Booking, flightId string) (*model.Flight, bool) {
+	if booking.Status != model.BookingConfirmed {
+		http.Error(rw, "Booking is not confirmed", http.StatusConflict)
+		return nil, false
+	}
+	if booking.PendingDisruption(flightId) != nil {
+		http.Error(rw, "Flight was cancelled, rebook or refund the booking first", http.StatusConflict)
+		return nil, false
+	}
+	onBooking := false
+	for _, segment := range booking.Segments {
+		onBooking = onBooking || segment.FlightId == flightId
+	}
+	if !onBooking {
+		http.Error(rw, "Flight is not on the booking", http.StatusBadRequest)
+		return nil, false
+	}
+
+	flight, err := c.flightRepo.GetById(flightId)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return nil, false
+	}
+	switch flight.CurrentStatus() {
+	case model.FlightCancelled, model.FlightDeparted, model.FlightLanded, model.FlightDiverted:
+		http.Error(rw, "Flight is "+flight.CurrentStatus()+", check-in is closed", http.StatusConflict)
+		return nil, false
+	}
+
+	opens, closes := flight.CheckInWindow()
+	now := time.Now()
+	if now.Before(opens) {
+		http.Error(rw, "Check-in opens at "+opens.Format(time.RFC3339), http.StatusConflict)
+		return nil, false
+	}
+	if !now.Before(closes) {
+		http.Error(rw, "Check-in closed at "+closes.Format(time.RFC3339), http.StatusConflict)
+		return nil, false
+	}
+	return flight, true
+}
+
+// checkInPassenger gives the passenger a seat and a boarding pass. A seat picked automatically that another
+// passenger took in the meantime is replaced by the next free one.
+func (c *CheckInHandler) checkInPassenger(booking *model.Booking, flight *model.Flight, from string, to string,
+	request model.SeatRequest, taken map[string]bool) (*model.CheckIn, error) {
+	passenger := booking.Passenger(request.PassengerId)
+	if coupon := booking.Coupon(passenger.ID, flight.ID.Hex()); coupon == nil || coupon.Status != model.CouponOpen {
+		return nil, errNoOpenCoupon
+	}
+
+	for {
+		seat := request.Seat
+		if seat == "" && passenger.Type != model.InfantPassenger {
+			seat = freeSeat(taken, seatCount(flight))
+			if seat == "" {
+				return nil, errNoFreeSeat
+			}
+		}
+
+		sequence, err := c.repo.NextSequence(flight.ID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		pass := boarding.Pass{
+			Surname:      passenger.Surname,
+			Name:         passenger.Name,
+			Locator:      booking.Locator,
+			From:         from,
+			To:           to,
+			Carrier:      c.carrier,
+			FlightNumber: flight.Number,
+			Date:         flight.Date,
+			Compartment:  "Y",
+			Seat:         seat,
+			Sequence:     sequence,
+		}
+		data, err := pass.Encode()
+		if err != nil {
+			return nil, err
+		}
+		checkIn := model.CheckIn{
+			BookingId:     booking.ID.Hex(),
+			Locator:       booking.Locator,
+			FlightId:      flight.ID.Hex(),
+			PassengerId:   passenger.ID,
+			PassengerName: passenger.Name + " " + passenger.Surname,
+			Seat:          seat,
+			Sequence:      sequence,
+			BoardingPass:  boarding.Sign(data, c.key),
+			Status:        model.CheckedIn,
+			CheckedInAt:   time.Now(),
+		}
+
+		err = c.repo.Insert(&checkIn)
+		if err == repo.ErrSeatTaken && request.Seat == "" {
+			taken[seat] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		taken[seat] = true
+		return &checkIn, nil
+	}
+}
+
+// GetBoardingPasses lists the check-ins of the logged in user's booking with their boarding passes
+func (c *CheckInHandler) GetBoardingPasses(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := c.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+
+	checkIns, err := c.repo.GetByBooking(booking.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	err = checkIns.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		c.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// GetBoardingPassBarcode renders the barcode of a boarding pass as a PNG, ?format=qr for a QR code instead of PDF417
+func (c *CheckInHandler) GetBoardingPassBarcode(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	checkInId := vars["checkInId"]
+	booking, ok := c.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+
+	checkIns, err := c.repo.GetByBooking(booking.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	for _, checkIn := range checkIns {
+		if checkIn.ID.Hex() != checkInId {
+			continue
+		}
+		var image bytes.Buffer
+		err := boarding.WritePNG(&image, checkIn.BoardingPass, h.URL.Query().Get("format"))
+		if err == boarding.ErrUnknownSymbology {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to render the barcode", http.StatusInternalServerError)
+			c.logger.Print("Unable to render the barcode: ", err)
+			return
+		}
+		rw.Header().Set("Content-Type", "image/png")
+		rw.Write(image.Bytes())
+		return
+	}
+	http.Error(rw, "Boarding pass with given id not found", http.StatusNotFound)
+}
+
+// ScanBoardingPass is used by gate agents. It checks the signature of the scanned boarding pass, that it was issued
+// for the flight at the gate and is still valid, and marks the passenger as boarded.
+func (c *CheckInHandler) ScanBoardingPass(rw http.ResponseWriter, h *http.Request) {
+	scan := h.Context().Value(KeyProduct{}).(*model.BoardingScan)
+
+	if _, err := boarding.Verify(scan.Barcode, c.key); err != nil {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+	checkIn, err := c.repo.GetByBoardingPass(scan.Barcode)
+	if err != nil {
+		http.Error(rw, "Boarding pass was not issued", http.StatusNotFound)
+		return
+	}
+	if scan.FlightId != "" && scan.FlightId != checkIn.FlightId {
+		http.Error(rw, "Boarding pass is for another flight", http.StatusConflict)
+		return
+	}
+
+	flight, err := c.flightRepo.GetById(checkIn.FlightId)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	switch flight.CurrentStatus() {
+	case model.FlightCancelled, model.FlightDeparted, model.FlightLanded, model.FlightDiverted:
+		http.Error(rw, "Flight is "+flight.CurrentStatus(), http.StatusConflict)
+		return
+	}
+	booking, err := c.bookings.repo.GetById(checkIn.BookingId)
+	if err != nil || booking.Status != model.BookingConfirmed {
+		http.Error(rw, "Booking was cancelled", http.StatusConflict)
+		return
+	}
+	if coupon := booking.Coupon(checkIn.PassengerId, checkIn.FlightId); coupon == nil || coupon.Status != model.CouponOpen {
+		http.Error(rw, "Ticket is no longer valid for this flight", http.StatusConflict)
+		return
+	}
+
+	checkIn, err = c.repo.Board(checkIn.ID, h.Header.Get("Email"))
+	if err == repo.ErrAlreadyBoarded {
+		http.Error(rw, "Passenger has already boarded", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to board the passenger", http.StatusInternalServerError)
+		return
+	}
+	checkIn.ToJSON(rw)
+}
+
+// GetFlightBoarding lists everyone checked in on a flight and whether they boarded, for the gate
+func (c *CheckInHandler) GetFlightBoarding(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	checkIns, err := c.repo.GetByFlight(id)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	err = checkIns.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		c.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+var (
+	errNoOpenCoupon = errors.New("ticket is not valid for this flight")
+	errNoFreeSeat   = errors.New("no free seat left")
+)
+
+func writeCheckInError(rw http.ResponseWriter, err error, request model.SeatRequest) {
+	switch err {
+	case repo.ErrSeatTaken:
+		http.Error(rw, "Seat "+request.Seat+" is taken", http.StatusConflict)
+	case repo.ErrAlreadyCheckedIn:
+		http.Error(rw, "Passenger "+request.PassengerId+" is already checked in", http.StatusConflict)
+	case errNoOpenCoupon, errNoFreeSeat:
+		http.Error(rw, "Passenger "+request.PassengerId+": "+err.Error(), http.StatusConflict)
+	default:
+		http.Error(rw, "Unable to check in", http.StatusInternalServerError)
+	}
+}
+
+// freeSeat returns the first of the flight's seats nobody has, front to back
+func freeSeat(taken map[string]bool, seats int) string {
+	letters := len(autoSeatLetters)
+	for i := 0; i < seats; i++ {
+		seat := fmt.Sprintf("%d%c", i/letters+1, autoSeatLetters[i%letters])
+		if !taken[seat] {
+			return seat
+		}
+	}
+	return ""
+}
+
+// seatCount is the number of seats handed out automatically on the flight
+func seatCount(flight *model.Flight) int {
+	if flight.Capacity > 0 {
+		return flight.Capacity
+	}
+	return autoSeatRows * len(autoSeatLetters)
+}
+
+func (c *CheckInHandler) MiddlewareCheckInDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.CheckInRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			c.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (c *CheckInHandler) MiddlewareBoardingScanDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		scan := &model.BoardingScan{}
+		err := scan.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			c.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, scan)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/payments"
+	"Rest/repo"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How long a single call to the payment provider may take
+const providerTimeout = 10 * time.Second
+
+var errCardChallenge = errors.New("cards that need 3-D Secure can only pay for new bookings")
+
+// checkout authorizes the price of the hold on the card and finishes the booking once the payment is authorized.
+// Cards that need 3-D Secure leave the payment in requires_action until the challenge is answered.
+func (hh *HoldHandler) checkout(rw http.ResponseWriter, hold *model.Hold, card payments.Card) {
+	booking, err := hh.priceHold(hold)
+	if errors.Is(err, model.ErrPromotionNotApplicable) || errors.Is(err, repo.ErrPromotionNotFound) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to price the booking", http.StatusInternalServerError)
+		return
+	}
+
+	payment := payments.Payment{
+		UserId:    hold.UserId,
+		HoldId:    hold.ID.Hex(),
+		Amount:    booking.Total,
+		Currency:  booking.Currency,
+		Status:    payments.Created,
+		CardLast4: card.Last4(),
+		History:   []payments.Transition{},
+		CreatedAt: time.Now(),
+	}
+	if err := hh.paymentRepo.Insert(&payment); err != nil {
+		http.Error(rw, "Unable to start the payment", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	reference, err := hh.provider.Authorize(ctx, payments.AuthorizeRequest{Amount: payment.Amount, Currency: payment.Currency, Card: card})
+	payment.Reference = reference
+
+	if err == payments.ErrChallengeRequired {
+		hh.movePayment(&payment, payments.RequiresAction)
+		rw.WriteHeader(http.StatusAccepted)
+		payment.ToJSON(rw)
+		return
+	}
+	if err != nil {
+		// The hold stays active so the customer can try another card
+		hh.failPayment(&payment, err)
+		writePaymentError(rw, err, &payment)
+		return
+	}
+
+	hh.movePayment(&payment, payments.Authorized)
+	hh.finish(rw, &payment)
+}
+
+// CompleteChallenge answers the 3-D Secure challenge of a payment and finishes its booking
+func (hh *HoldHandler) CompleteChallenge(rw http.ResponseWriter, h *http.Request) {
+	response := h.Context().Value(KeyProduct{}).(*payments.ChallengeResponse)
+	payment, ok := hh.ownPayment(rw, h)
+	if !ok {
+		return
+	}
+	if payment.Status != payments.RequiresAction {
+		http.Error(rw, "Payment is not waiting for a 3-D Secure challenge", http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	_, err := hh.provider.Authorize(ctx, payments.AuthorizeRequest{Reference: payment.Reference, ChallengeResponse: response.Code})
+	if err != nil {
+		hh.failPayment(payment, err)
+		writePaymentError(rw, err, payment)
+		return
+	}
+
+	hh.movePayment(payment, payments.Authorized)
+	hh.finish(rw, payment)
+}
+
+func (hh *HoldHandler) GetPayment(rw http.ResponseWriter, h *http.Request) {
+	payment, ok := hh.ownPayment(rw, h)
+	if !ok {
+		return
+	}
+
+	err := payment.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		hh.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// finish confirms the hold of an authorized payment, captures the money and only then stores the booking.
+// The authorization is voided whenever the booking cannot be confirmed.
+func (hh *HoldHandler) finish(rw http.ResponseWriter, payment *payments.Payment) {
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+
+	hold, err := hh.repo.Claim(payment.HoldId, model.HoldConfirmed)
+	if err != nil {
+		hh.voidPayment(ctx, payment)
+		if err == repo.ErrHoldNotActive {
+			http.Error(rw, "Hold has expired or was already used, the payment was voided", http.StatusGone)
+			return
+		}
+		http.Error(rw, "Unable to confirm the hold, the payment was voided", http.StatusInternalServerError)
+		return
+	}
+
+	booking, err := hh.priceHold(hold)
+	if err != nil || booking.Total != payment.Amount || booking.Points() != hold.Request.Points {
+		hh.voidPayment(ctx, payment)
+		hh.giveBack(hold)
+		http.Error(rw, "The price changed during checkout, the payment was voided", http.StatusConflict)
+		return
+	}
+
+	if err := hh.provider.Capture(ctx, payment.Reference, payment.Amount); err != nil {
+		hh.voidPayment(ctx, payment)
+		hh.giveBack(hold)
+		writePaymentError(rw, err, payment)
+		return
+	}
+	hh.movePayment(payment, payments.Captured)
+
+	booking.PaymentId = payment.ID.Hex()
+	if err := hh.bookings.repo.Insert(booking); err != nil {
+		if refundErr := hh.provider.Refund(ctx, payment.Reference, payment.Amount); refundErr != nil {
+			hh.logger.Printf("Payment %s captured for a booking that was not saved, refund it manually: %v", payment.ID.Hex(), refundErr)
+		} else {
+			payment.Refunded = payment.Amount
+			hh.movePayment(payment, payments.Refunded)
+		}
+		hh.giveBack(hold)
+		http.Error(rw, "Unable to save the booking, the payment was refunded", http.StatusInternalServerError)
+		return
+	}
+
+	from := payment.Status
+	payment.BookingId = booking.ID.Hex()
+	hh.savePayment(payment, from)
+	hh.repo.SetBookingId(hold.ID, booking.ID.Hex())
+	hh.bookings.recordRedemptions(booking)
+	hh.bookings.notifyConfirmation(booking)
+
+	if hold.Request.Ticket {
+		if ticket, err := hh.issueTicket(hold, booking); err == nil {
+			rw.WriteHeader(http.StatusCreated)
+			ticket.ToJSON(rw)
+			return
+		}
+		// The booking is paid and stands without the ticket, its owner sees it with their bookings
+		hh.logger.Printf("Ticket for booking %s was not issued", booking.ID.Hex())
+	}
+	rw.WriteHeader(http.StatusCreated)
+	booking.ToJSON(rw)
+}
+
+// issueTicket stores the ticket bought with the hold, priced like the one flight of its booking
+func (hh *HoldHandler) issueTicket(hold *model.Hold, booking *model.Booking) (*model.Ticket, error) {
+	ticket := &model.Ticket{
+		UserId:        hold.UserId,
+		FlightId:      booking.Segments[0].FlightId,
+		NumberOfSeats: hold.Seats,
+		SelectedSeats: hold.Request.SelectedSeats,
+		CheckedBags:   hold.Request.CheckedBags,
+		Fare:          booking.Segments[0].Fare,
+		BookingId:     booking.ID.Hex(),
+	}
+	if err := hh.ticketRepo.Insert(ticket); err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// priceHold prices the hold's request on the current state of its flights
+func (hh *HoldHandler) priceHold(hold *model.Hold) (*model.Booking, error) {
+	flights := []*model.Flight{}
+	for _, flightId := range hold.Request.FlightIds {
+		flight, err := hh.flightRepo.GetById(flightId)
+		if err != nil {
+			return nil, err
+		}
+		flights = append(flights, flight)
+	}
+	return hh.bookings.priceBooking(hold.UserId, &hold.Request, flights)
+}
+
+// chargeBooking takes an amount owed on an existing booking from the card, as for exchanges and ancillaries bought later.
+// The payment is returned whenever it was created, so that a failure can be shown to the customer.
+func (hh *HoldHandler) chargeBooking(booking *model.Booking, amount float64, card payments.Card) (*payments.Payment, error) {
+	payment := &payments.Payment{
+		UserId:    booking.UserId,
+		BookingId: booking.ID.Hex(),
+		Amount:    amount,
+		Currency:  booking.Currency,
+		Status:    payments.Created,
+		CardLast4: card.Last4(),
+		History:   []payments.Transition{},
+		CreatedAt: time.Now(),
+	}
+	if err := hh.paymentRepo.Insert(payment); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	reference, err := hh.provider.Authorize(ctx, payments.AuthorizeRequest{Amount: amount, Currency: payment.Currency, Card: card})
+	payment.Reference = reference
+	if err == payments.ErrChallengeRequired {
+		err = errCardChallenge
+	}
+	if err != nil {
+		hh.failPayment(payment, err)
+		return payment, err
+	}
+	hh.movePayment(payment, payments.Authorized)
+
+	if err := hh.provider.Capture(ctx, reference, amount); err != nil {
+		hh.voidPayment(ctx, payment)
+		return payment, err
+	}
+	hh.movePayment(payment, payments.Captured)
+	return payment, nil
+}
+
+// refundCharge gives back a captured payment when the booking could not be changed
+func (hh *HoldHandler) refundCharge(payment *payments.Payment) {
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	if err := hh.provider.Refund(ctx, payment.Reference, payment.Amount); err != nil {
+		hh.logger.Printf("Payment %s captured for a booking that was not changed, refund it manually: %v", payment.ID.Hex(), err)
+		return
+	}
+	payment.Refunded = payment.Amount
+	hh.movePayment(payment, payments.Refunded)
+}
+
+func (hh *HoldHandler) voidPayment(ctx context.Context, payment *payments.Payment) {
+	if err := hh.provider.Void(ctx, payment.Reference); err != nil {
+		hh.logger.Printf("Unable to void payment %s: %v", payment.ID.Hex(), err)
+		return
+	}
+	hh.movePayment(payment, payments.Voided)
+}
+
+func (hh *HoldHandler) failPayment(payment *payments.Payment, reason error) {
+	from := payment.Status
+	payment.Fail(reason)
+	hh.savePayment(payment, from)
+}
+
+func (hh *HoldHandler) movePayment(payment *payments.Payment, to payments.Status) {
+	from := payment.Status
+	if err := payment.MoveTo(to); err != nil {
+		hh.logger.Print(err)
+		return
+	}
+	hh.savePayment(payment, from)
+}
+
+func (hh *HoldHandler) savePayment(payment *payments.Payment, from payments.Status) {
+	if err := hh.paymentRepo.Update(payment, from); err != nil {
+		hh.logger.Printf("Unable to save payment %s: %v", payment.ID.Hex(), err)
+	}
+}
+
+func writePaymentError(rw http.ResponseWriter, err error, payment *payments.Payment) {
+	status := http.StatusPaymentRequired
+	if err == payments.ErrTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	rw.WriteHeader(status)
+	payment.ToJSON(rw)
+}
+
+func (hh *HoldHandler) ownPayment(rw http.ResponseWriter, h *http.Request) (*payments.Payment, bool) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	user, err := CurrentUser(hh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	payment, err := hh.paymentRepo.GetById(id)
+	if err != nil || payment.UserId != user.ID.Hex() {
+		http.Error(rw, "Payment with given id not found", http.StatusNotFound)
+		return nil, false
+	}
+	return payment, true
+}
+
+func (hh *HoldHandler) MiddlewareCardDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		card := &payments.Card{}
+		err := card.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			hh.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, card)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (hh *HoldHandler) MiddlewareChallengeDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		response := &payments.ChallengeResponse{}
+		err := response.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			hh.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, response)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ResolveByRebooking = "rebook"
+	ResolveByRefund    = "refund"
+)
+
+// DisruptionHandler runs the flight cancellation workflow: it marks the flight cancelled,
+// flags the affected bookings and lets them be rebooked onto the next flight or fully refunded.
+type DisruptionHandler struct {
+	logger *log.Logger
+
+	flightRepo  *repo.FlightRepo
+	bookingRepo *repo.BookingRepo
+	ticketRepo  *repo.TicketRepo
+	bookings    *BookingHandler
+}
+
+type FlightCancellation struct {
+	FlightId         string `json:"flightId"`
+	Status           string `json:"status"`
+	AffectedBookings int    `json:"affectedBookings"`
+	AffectedTickets  int    `json:"affectedTickets"`
+	Rebooked         int    `json:"rebooked"`
+	Refunded         int    `json:"refunded"`
+}
+
+func NewDisruptionsHandler(l *log.Logger, f *repo.FlightRepo, b *repo.BookingRepo, t *repo.TicketRepo, bh *BookingHandler) *DisruptionHandler {
+	return &DisruptionHandler{l, f, b, t, bh}
+}
+
+// DeleteFlight hard-deletes a flight only if nothing was ever sold on it, otherwise the flight is cancelled
+func (d *DisruptionHandler) DeleteFlight(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	// The sales are checked in the transaction that deletes the flight, a booking made meanwhile cancels it instead
+	switch err := d.flightRepo.DeleteUnsold(id); err {
+	case nil:
+		rw.WriteHeader(http.StatusNoContent)
+	case repo.ErrFlightNotFound:
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+	case repo.ErrFlightSold:
+		d.logger.Printf("Flight %s has sales, cancelling it instead of deleting", id)
+		d.writeCancellation(rw, id, h.URL.Query().Get("resolution"), h.Header.Get("Email"))
+	default:
+		http.Error(rw, "Unable to delete the flight", http.StatusInternalServerError)
+	}
+}
+
+// CancelFlight cancels the flight; the optional resolution query parameter (rebook or refund)
+// resolves all affected bookings at once instead of waiting for the passengers to choose.
+func (d *DisruptionHandler) CancelFlight(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	d.writeCancellation(rw, vars["id"], h.URL.Query().Get("resolution"), h.Header.Get("Email"))
+}
+
+func (d *DisruptionHandler) writeCancellation(rw http.ResponseWriter, id string, resolution string, cancelledBy string) {
+	if resolution != "" && resolution != ResolveByRebooking && resolution != ResolveByRefund {
+		http.Error(rw, "Resolution must be rebook or refund", http.StatusBadRequest)
+		return
+	}
+
+	flight, err := d.flightRepo.MarkCancelled(id, cancelledBy)
+	if err == repo.ErrFlightCancelled {
+		http.Error(rw, "Flight is already cancelled or does not exist", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to cancel the flight", http.StatusInternalServerError)
+		return
+	}
+
+	affected, err := d.bookingRepo.FlagDisrupted(id, "Flight cancelled")
+	if err != nil {
+		http.Error(rw, "Flight cancelled but affected bookings could not be flagged", http.StatusInternalServerError)
+		return
+	}
+
+	// Tickets from before bookings existed are flagged for staff, their owners are told about the cancellation
+	tickets, err := d.ticketRepo.FlagDisrupted(id, "Flight cancelled")
+	if err != nil {
+		http.Error(rw, "Flight cancelled but affected tickets could not be flagged", http.StatusInternalServerError)
+		return
+	}
+	for _, ticket := range tickets {
+		if user, err := d.bookings.userRepo.GetById(ticket.UserId); err == nil {
+			notifyUser(d.logger, d.bookings.notifier, user, notifications.ScheduleChangeTemplate, scheduleChangeData(model.FlightCancelled, flight))
+		}
+	}
+
+	result := FlightCancellation{FlightId: id, Status: flight.Status, AffectedBookings: len(affected), AffectedTickets: len(tickets)}
+	for _, booking := range affected {
+		switch resolution {
+		case ResolveByRebooking:
+			if _, err := d.rebook(booking, flight); err == nil {
+				result.Rebooked++
+				continue
+			}
+		case ResolveByRefund:
+			if _, err := d.refund(booking); err == nil {
+				result.Refunded++
+				continue
+			}
+		}
+		d.notify(booking, scheduleChangeData(model.FlightCancelled, flight))
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(result)
+}
+
+// RebookBooking moves a disrupted booking onto the next available flight on the same route. With several cancelled
+// flights the flightId query parameter picks the one to rebook, the first pending one otherwise.
+func (d *DisruptionHandler) RebookBooking(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := d.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	disruption := booking.PendingDisruption(h.URL.Query().Get("flightId"))
+	if disruption == nil {
+		http.Error(rw, "Booking has no pending disruption", http.StatusConflict)
+		return
+	}
+
+	cancelled, err := d.flightRepo.GetById(disruption.FlightId)
+	if err != nil {
+		http.Error(rw, "Cancelled flight not found", http.StatusNotFound)
+		return
+	}
+
+	next, err := d.rebook(booking, cancelled)
+	if err == repo.ErrDisruptionResolved {
+		http.Error(rw, "Booking has no pending disruption", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "No alternative flight is available, please request a refund", http.StatusNotAcceptable)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	next.ToJSON(rw)
+}
+
+// RefundBooking cancels a disrupted booking with a full refund
+func (d *DisruptionHandler) RefundBooking(rw http.ResponseWriter, h *http.Request) {
+	booking, ok := d.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	if booking.PendingDisruption("") == nil {
+		http.Error(rw, "Booking has no pending disruption", http.StatusConflict)
+		return
+	}
+
+	refund, err := d.refund(booking)
+	if err == repo.ErrBookingNotCancellable {
+		http.Error(rw, "Booking has no pending disruption", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to refund the booking", http.StatusInternalServerError)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	refund.ToJSON(rw)
+}
+
+// rebook takes seats on the next flight of the cancelled flight's route and moves the booking onto it
+func (d *DisruptionHandler) rebook(booking *model.Booking, cancelled *model.Flight) (*model.Flight, error) {
+	disruption := booking.PendingDisruption(cancelled.ID.Hex())
+	if disruption == nil {
+		return nil, repo.ErrDisruptionResolved
+	}
+	seats := booking.SeatedPassengers()
+	next, err := d.flightRepo.FindNextAvailable(cancelled.From, cancelled.To, cancelled.Date, seats)
+	if err != nil {
+		return nil, err
+	}
+	// Rebooked passengers only get seats that are really free, their ancillaries go along
+	move := &model.InventoryMove{FromFlightId: cancelled.ID.Hex(), ToFlightId: next.ID.Hex(), Seats: seats}
+	if err := d.bookings.moveAncillaries(booking, move, next); err != nil {
+		return nil, err
+	}
+
+	booking.MoveSegment(cancelled.ID.Hex(), next.ID.Hex())
+	disruption.RebookedFlightId = next.ID.Hex()
+	if err := d.bookingRepo.Rebook(booking, disruption, move); err != nil {
+		return nil, err
+	}
+	d.flightRepo.Publish(cancelled.ID.Hex())
+	d.flightRepo.Publish(next.ID.Hex())
+
+	data := scheduleChangeData(model.DisruptionRebooked, cancelled)
+	data["NewDate"] = next.Date.Format(emailDateFormat)
+	d.notify(booking, data)
+	return next, nil
+}
+
+// refund cancels the booking without any penalty, the cancellation resolves its pending disruptions as refunded
+func (d *DisruptionHandler) refund(booking *model.Booking) (*model.Refund, error) {
+	return d.bookings.cancelBooking(booking.ID.Hex(), true, "Flight cancelled")
+}
+
+// notify emails the booking's owner about the change, the cancellation email of a refund is sent by cancelBooking
+func (d *DisruptionHandler) notify(booking *model.Booking, data notifications.Data) {
+	notifyBookingOwner(d.logger, d.bookings.notifier, d.bookings.userRepo, booking, notifications.ScheduleChangeTemplate, data)
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/payments"
+	"Rest/repo"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	errNotOnFlight        = errors.New("booking has no confirmed segment on the flight")
+	errAlreadyOnFlight    = errors.New("booking is already on that flight")
+	errOtherRoute         = errors.New("flights must be on the same route")
+	errFlightUnavailable  = errors.New("flight has departed or was cancelled")
+	errCheckedInOnFlight  = errors.New("passengers are already checked in on the flight")
+	errSegmentUnpriced    = errors.New("segment has no fare to exchange")
+	errExchangeFlightGone = errors.New("flight with given id not found")
+	errAwardSegment       = errors.New("segments paid with points cannot be exchanged")
+)
+
+// ExchangeHandler moves a segment of a paid booking to another flight on the same route,
+// settling the fare difference and the change fee with the customer
+type ExchangeHandler struct {
+	logger *log.Logger
+
+	checkInRepo *repo.CheckInRepo
+	bookings    *BookingHandler
+	holds       *HoldHandler
+}
+
+func NewExchangesHandler(l *log.Logger, c *repo.CheckInRepo, bh *BookingHandler, hh *HoldHandler) *ExchangeHandler {
+	return &ExchangeHandler{l, c, bh, hh}
+}
+
+// QuoteExchange prices moving the logged in user's booking from one of its flights to another, nothing is changed
+func (e *ExchangeHandler) QuoteExchange(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.ExchangeRequest)
+	if err := request.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, ok := e.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	quote, _, err := e.quote(booking, request)
+	if err != nil {
+		writeExchangeError(rw, err)
+		return
+	}
+
+	err = quote.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		e.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// ExchangeBooking carries out a quoted exchange. The difference is paid first, then the booking moves together with its seats
+// and ancillaries in one transaction, the payment is refunded if the new flight filled up in the meantime.
+// Any money owed is returned only after the booking moved.
+func (e *ExchangeHandler) ExchangeBooking(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.ExchangeRequest)
+	if err := request.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, ok := e.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	quote, flight, err := e.quote(booking, request)
+	if err != nil {
+		writeExchangeError(rw, err)
+		return
+	}
+	if quote.Amount != request.Amount {
+		http.Error(rw, "The price of the exchange changed, quote it again", http.StatusConflict)
+		return
+	}
+	if quote.Amount > 0 && request.Card == nil {
+		http.Error(rw, "A card is required to pay for the exchange", http.StatusBadRequest)
+		return
+	}
+
+	move, err := e.inventoryMove(booking, quote, flight)
+	if err != nil {
+		writeReservationError(rw, err)
+		return
+	}
+
+	var payment *payments.Payment
+	if quote.Amount > 0 {
+		payment, err = e.holds.chargeBooking(booking, quote.Amount, *request.Card)
+		if err != nil {
+			if payment == nil {
+				http.Error(rw, "Unable to start the payment", http.StatusInternalServerError)
+				return
+			}
+			writePaymentError(rw, err, payment)
+			return
+		}
+	}
+
+	change := booking.Exchange(quote, flight.FareRules(), time.Now())
+	if payment != nil {
+		change.PaymentId = payment.ID.Hex()
+	}
+	// The booking and the seats and ancillaries of both flights change in one transaction
+	if err := e.bookings.repo.Exchange(booking, move); err != nil {
+		if payment != nil {
+			e.holds.refundCharge(payment)
+		}
+		if err == repo.ErrSegmentNotFound {
+			http.Error(rw, "Booking changed in the meantime, the exchange was not made", http.StatusConflict)
+			return
+		}
+		if err == repo.ErrNotEnoughSeats || err == repo.ErrAncillarySoldOut {
+			writeReservationError(rw, fmt.Errorf("flight %s is not available for that many passengers: %w", quote.ToFlightId, err))
+			return
+		}
+		http.Error(rw, "Unable to save the exchange", http.StatusInternalServerError)
+		return
+	}
+	e.bookings.flightRepo.Publish(quote.FromFlightId)
+	e.bookings.flightRepo.Publish(quote.ToFlightId)
+
+	if quote.Amount < 0 {
+		e.returnDifference(booking, quote)
+	}
+	e.bookings.notifyConfirmation(booking)
+
+	err = booking.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		e.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// quote checks that the booking can move between the flights of the request and prices it on the new flight
+func (e *ExchangeHandler) quote(booking *model.Booking, request *model.ExchangeRequest) (*model.ExchangeQuote, *model.Flight, error) {
+	if booking.Status != model.BookingConfirmed || !booking.HasSegment(request.FromFlightId) {
+		return nil, nil, errNotOnFlight
+	}
+	if booking.HasSegment(request.ToFlightId) {
+		return nil, nil, errAlreadyOnFlight
+	}
+
+	from, err := e.bookings.flightRepo.GetById(request.FromFlightId)
+	if err != nil {
+		return nil, nil, errExchangeFlightGone
+	}
+	to, err := e.bookings.flightRepo.GetById(request.ToFlightId)
+	if err != nil {
+		return nil, nil, errExchangeFlightGone
+	}
+	// Passengers of a cancelled flight are rebooked through the disruption flow, free of charge
+	for _, flight := range []*model.Flight{from, to} {
+		if flight.CurrentStatus() == model.FlightCancelled || !flight.Date.After(time.Now()) {
+			return nil, nil, errFlightUnavailable
+		}
+	}
+	if from.From != to.From || from.To != to.To {
+		return nil, nil, errOtherRoute
+	}
+
+	checkIns, err := e.checkInRepo.GetByBooking(booking.ID.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, checkIn := range checkIns {
+		if checkIn.FlightId == request.FromFlightId {
+			return nil, nil, errCheckedInOnFlight
+		}
+	}
+
+	var segment *model.Segment
+	for i := range booking.Segments {
+		if booking.Segments[i].FlightId == request.FromFlightId {
+			segment = &booking.Segments[i]
+		}
+	}
+	if segment.Fare == nil {
+		return nil, nil, errSegmentUnpriced
+	}
+	if segment.Fare.Points > 0 {
+		return nil, nil, errAwardSegment
+	}
+
+	rules, err := e.bookings.feeRepo.GetAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	quote := model.QuoteExchange(segment, rules.Reprice(segment, to))
+	quote.BookingId = booking.ID.Hex()
+	quote.ToFlightId = request.ToFlightId
+	return quote, to, nil
+}
+
+// inventoryMove checks that the new flight can take the booking's passengers and the ancillaries they bought for the old flight,
+// and returns what the exchange moves between the flights
+func (e *ExchangeHandler) inventoryMove(booking *model.Booking, quote *model.ExchangeQuote, to *model.Flight) (*model.InventoryMove, error) {
+	seats := booking.SeatedPassengers()
+	sellable, err := e.bookings.sellable(to)
+	if err != nil {
+		return nil, err
+	}
+	if sellable < seats {
+		return nil, fmt.Errorf("flight %s is not available for that many passengers: %w", quote.ToFlightId, repo.ErrNotEnoughSeats)
+	}
+	rules, err := e.bookings.overbookingRepo.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+	move := &model.InventoryMove{FromFlightId: quote.FromFlightId, ToFlightId: quote.ToFlightId, Seats: seats, Overbook: rules.Limit(to)}
+	if err := e.bookings.moveAncillaries(booking, move, to); err != nil {
+		return nil, err
+	}
+	return move, nil
+}
+
+// returnDifference refunds what the customer is owed when the new fare is cheaper than the old one plus the change fee
+func (e *ExchangeHandler) returnDifference(booking *model.Booking, quote *model.ExchangeQuote) {
+	refund := model.Refund{
+		BookingId: booking.ID.Hex(),
+		UserId:    booking.UserId,
+		Amount:    -quote.Amount,
+		Penalty:   quote.ChangeFee,
+		Currency:  quote.Currency,
+		Reason:    fmt.Sprintf("Exchanged to flight %s", quote.ToFlightId),
+		CreatedAt: time.Now(),
+	}
+	e.bookings.refundPayment(booking, &refund)
+	if err := e.bookings.refundRepo.Insert(&refund); err != nil {
+		e.logger.Printf("Booking %s exchanged but its refund was not recorded: %v", booking.Locator, err)
+	}
+}
+
+func writeExchangeError(rw http.ResponseWriter, err error) {
+	switch err {
+	case errExchangeFlightGone:
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errNotOnFlight, errAlreadyOnFlight, errOtherRoute, errFlightUnavailable, errCheckedInOnFlight, errSegmentUnpriced, errAwardSegment:
+		http.Error(rw, err.Error(), http.StatusConflict)
+	default:
+		http.Error(rw, "Unable to quote the exchange", http.StatusInternalServerError)
+	}
+}
+
+func (e *ExchangeHandler) MiddlewareExchangeDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.ExchangeRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			e.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/repo"
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type FeeHandler struct {
+	logger *log.Logger
+	repo   *repo.FeeRuleRepo
+}
+
+func NewFeesHandler(l *log.Logger, r *repo.FeeRuleRepo) *FeeHandler {
+	return &FeeHandler{l, r}
+}
+
+func (f *FeeHandler) GetAllFeeRules(rw http.ResponseWriter, h *http.Request) {
+	rules, err := f.repo.GetAll()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		f.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = rules.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		f.logger.Print("Unable to convert to json :", err)
+		return
+	}
+}
+
+func (f *FeeHandler) CreateFeeRule(rw http.ResponseWriter, h *http.Request) {
+	ruleDTO := h.Context().Value(KeyProduct{}).(*model.FeeRule)
+
+	if err := ruleDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule := model.FeeRule{Kind: ruleDTO.Kind, Code: ruleDTO.Code, Airport: ruleDTO.Airport, Side: ruleDTO.Side,
+		Amount: ruleDTO.Amount, Percent: ruleDTO.Percent, PerBooking: ruleDTO.PerBooking}
+	if err := f.repo.Insert(&rule); err != nil {
+		http.Error(rw, "Unable to save fee rule", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	rule.ToJSON(rw)
+}
+
+func (f *FeeHandler) DeleteFeeRule(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	err := f.repo.Delete(id)
+	if err == repo.ErrFeeRuleNotFound {
+		http.Error(rw, "Fee rule with given id not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to delete fee rule", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (f *FeeHandler) MiddlewareFeeRuleDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		rule := &model.FeeRule{}
+		err := rule.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			f.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, rule)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
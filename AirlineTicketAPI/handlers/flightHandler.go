@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"Rest/model"
+	"Rest/notifications"
 	"Rest/repo"
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -15,12 +18,20 @@ type KeyProduct1 struct{}
 type FlightHandler struct {
 	logger *log.Logger
 	// NoSQL: injecting product repository
-	repo *repo.FlightRepo
+	repo        *repo.FlightRepo
+	feeRepo     *repo.FeeRuleRepo
+	bookingRepo *repo.BookingRepo
+	userRepo    *repo.UserRepo
+	notifier    *notifications.Notifier
+
+	ancillaryRepo *repo.AncillaryRepo
+	promotionRepo *repo.PromotionRepo
 }
 
 // Injecting the logger makes this code much more testable.
-func NewFlightsHandler(l *log.Logger, r *repo.FlightRepo) *FlightHandler {
-	return &FlightHandler{l, r}
+func NewFlightsHandler(l *log.Logger, r *repo.FlightRepo, fr *repo.FeeRuleRepo, br *repo.BookingRepo, u *repo.UserRepo,
+	n *notifications.Notifier, ar *repo.AncillaryRepo, pr *repo.PromotionRepo) *FlightHandler {
+	return &FlightHandler{l, r, fr, br, u, n, ar, pr}
 }
 
 func (u *FlightHandler) GetAllFlights(rw http.ResponseWriter, h *http.Request) {
@@ -33,6 +44,7 @@ func (u *FlightHandler) GetAllFlights(rw http.ResponseWriter, h *http.Request) {
 		return
 	}
 
+	flights.HideStaff()
 	err = flights.ToJSON(rw)
 	if err != nil {
 		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
@@ -54,6 +66,22 @@ func (f *FlightHandler) SearchFlights(rw http.ResponseWriter, h *http.Request) {
 		return
 	}
 
+	rules, err := f.feeRepo.GetAll()
+	if err != nil {
+		// Fares without their taxes and fees would be shown as final
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		f.logger.Print("Database exception: ", err)
+		return
+	}
+	passengers := search.TicketNumber
+	if passengers < 1 {
+		passengers = 1
+	}
+	for _, flight := range flights {
+		flight.Fare = rules.Breakdown(flight, passengers, 0, 0)
+		flight.HideStaff()
+	}
+
 	err = flights.ToJSON(rw)
 	if err != nil {
 		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
@@ -75,6 +103,7 @@ func (u *FlightHandler) GetFlightById(rw http.ResponseWriter, h *http.Request) {
 		u.logger.Printf("Flight with id: '%s' not found", id)
 		return
 	}
+	flight.HideStaff()
 
 	err = flight.ToJSON(rw)
 	if err != nil {
@@ -84,21 +113,165 @@ func (u *FlightHandler) GetFlightById(rw http.ResponseWriter, h *http.Request) {
 	}
 }
 
+// GetStaffFlight shows operations staff the whole flight, with who changed its status
+func (f *FlightHandler) GetStaffFlight(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	flight, err := f.repo.GetById(id)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+
+	err = flight.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		f.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (f *FlightHandler) QuoteFlight(rw http.ResponseWriter, h *http.Request) {
+	quote := h.Context().Value(KeyProduct{}).(*model.QuoteRequest)
+	if err := quote.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flight, err := f.repo.GetById(quote.FlightId)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+
+	rules, err := f.feeRepo.GetAll()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	fare := rules.Breakdown(flight, quote.NumberOfSeats, quote.SelectedSeats, quote.CheckedBags)
+	if len(quote.Ancillaries) > 0 {
+		catalog, err := f.ancillaryRepo.GetAll()
+		if err != nil {
+			http.Error(rw, "Database exception", http.StatusInternalServerError)
+			return
+		}
+		// Ancillaries of a quote are all for the quoted flight
+		for i := range quote.Ancillaries {
+			quote.Ancillaries[i].FlightId = quote.FlightId
+		}
+		items, err := catalog.Price(quote.Ancillaries, flight, nil, time.Now())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fare.AddAncillaries(items)
+	}
+	// Quotes show the discount without counting a use of the codes
+	if len(quote.PromoCodes) > 0 {
+		promotions, err := f.promotionRepo.GetByCodes(quote.PromoCodes)
+		if errors.Is(err, repo.ErrPromotionNotFound) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Database exception", http.StatusInternalServerError)
+			return
+		}
+		if err := promotions.Apply([]*model.FareBreakdown{fare}, []*model.Flight{flight}, quote.FareClass, time.Now()); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if quote.PayWithPoints {
+		fare.PayWithPoints()
+	}
+
+	err = fare.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		f.logger.Print("Unable to convert to json :", err)
+	}
+}
+
 func (u *FlightHandler) CreateFlight(rw http.ResponseWriter, h *http.Request) {
 	flightDTO := h.Context().Value(KeyProduct{}).(*model.Flight)
-	flight := model.Flight{To: flightDTO.To, From: flightDTO.From, Price: flightDTO.Price, FreeSeats: flightDTO.FreeSeats, Date: flightDTO.Date}
+	rules := flightDTO.FareRules()
+	flight := model.Flight{To: flightDTO.To, From: flightDTO.From, Price: flightDTO.Price, FreeSeats: flightDTO.FreeSeats, Date: flightDTO.Date, Rules: &rules,
+		Number: flightDTO.Number, CheckInOpensMinutes: flightDTO.CheckInOpensMinutes, CheckInClosesMinutes: flightDTO.CheckInClosesMinutes,
+		Capacity: flightDTO.Capacity, OverbookingLimit: flightDTO.OverbookingLimit, Distance: flightDTO.Distance}
 	u.repo.Insert(&flight)
 	rw.WriteHeader(http.StatusCreated)
 	json.NewEncoder(rw).Encode(flight)
 	rw.Header().Set("Content-Type", "application/json")
 }
 
-func (p *FlightHandler) DeleteFlight(rw http.ResponseWriter, h *http.Request) {
+// UpdateFlightStatus lets operations staff change the status, gate and terminal of a flight
+func (f *FlightHandler) UpdateFlightStatus(rw http.ResponseWriter, h *http.Request) {
 	vars := mux.Vars(h)
 	id := vars["id"]
+	change := h.Context().Value(KeyProduct{}).(*model.StatusChange)
+
+	flight, err := f.repo.GetById(id)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	if flight.CurrentStatus() == model.FlightCancelled {
+		http.Error(rw, "Flight is cancelled", http.StatusConflict)
+		return
+	}
+	if err := change.Apply(flight); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind := scheduleChangeKind(flight, change)
+	change.ChangedBy = h.Header.Get("Email")
+	change.ChangedAt = time.Now()
 
-	p.repo.Delete(id)
-	rw.WriteHeader(http.StatusNoContent)
+	flight, err = f.repo.UpdateStatus(id, change)
+	if err == repo.ErrFlightCancelled {
+		http.Error(rw, "Flight is cancelled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to update flight status", http.StatusInternalServerError)
+		return
+	}
+	if kind != "" {
+		f.notifyScheduleChange(flight, kind)
+	}
+
+	flight.ToJSON(rw)
+}
+
+// scheduleChangeKind tells whether passengers have to be told about the status change, and how.
+// It compares the applied change with the flight as it was before the update.
+func scheduleChangeKind(flight *model.Flight, change *model.StatusChange) string {
+	switch {
+	case change.Status == model.FlightDelayed && change.EstimatedDeparture != nil &&
+		(flight.EstimatedDeparture == nil || !flight.EstimatedDeparture.Equal(*change.EstimatedDeparture)):
+		return model.FlightDelayed
+	case change.Status == model.FlightDiverted && flight.CurrentStatus() != model.FlightDiverted:
+		return model.FlightDiverted
+	case change.Gate != "" && change.Gate != flight.Gate, change.Terminal != "" && change.Terminal != flight.Terminal:
+		return "gate"
+	}
+	return ""
+}
+
+// notifyScheduleChange emails the owners of all confirmed bookings on the flight
+func (f *FlightHandler) notifyScheduleChange(flight *model.Flight, kind string) {
+	bookings, err := f.bookingRepo.GetConfirmedByFlightId(flight.ID.Hex())
+	if err != nil {
+		f.logger.Printf("Passengers of flight %s not notified about the change: %v", flight.ID.Hex(), err)
+		return
+	}
+	for _, booking := range bookings {
+		notifyBookingOwner(f.logger, f.notifier, f.userRepo, booking, notifications.ScheduleChangeTemplate, scheduleChangeData(kind, flight))
+	}
 }
 
 func (u *FlightHandler) MiddlewareFlightDeserialization(next http.Handler) http.Handler {
@@ -134,6 +307,40 @@ func (u *FlightHandler) MiddlewareSearchCriteriaDeserialization(next http.Handle
 	})
 }
 
+func (f *FlightHandler) MiddlewareStatusDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		change := &model.StatusChange{}
+		err := change.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			f.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, change)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (f *FlightHandler) MiddlewareQuoteDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		quote := &model.QuoteRequest{}
+		err := quote.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			f.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, quote)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
 func (u *FlightHandler) MiddlewareAuthDeserialization(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
 		auth := &model.Authentication{}
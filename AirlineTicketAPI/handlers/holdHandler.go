@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/payments"
+	"Rest/repo"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Seats stay on hold for HOLD_MINUTES, 15 if it is not set
+const defaultHoldMinutes = 15
+
+type HoldHandler struct {
+	logger *log.Logger
+
+	repo       *repo.HoldRepo
+	flightRepo *repo.FlightRepo
+	userRepo   *repo.UserRepo
+	bookings   *BookingHandler
+	holdFor    time.Duration
+
+	paymentRepo *repo.PaymentRepo
+	provider    payments.Provider
+
+	travelerRepo *repo.TravelerRepo
+	ticketRepo   *repo.TicketRepo
+}
+
+func NewHoldsHandler(l *log.Logger, r *repo.HoldRepo, f *repo.FlightRepo, u *repo.UserRepo, bh *BookingHandler,
+	pr *repo.PaymentRepo, p payments.Provider, tr *repo.TravelerRepo, t *repo.TicketRepo) *HoldHandler {
+	minutes, err := strconv.Atoi(os.Getenv("HOLD_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = defaultHoldMinutes
+	}
+	return &HoldHandler{l, r, f, u, bh, time.Duration(minutes) * time.Minute, pr, p, tr, t}
+}
+
+// CreateHold reserves seats for the booking request and returns a hold that has to be paid before it expires
+func (hh *HoldHandler) CreateHold(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.BookingRequest)
+	hold, ok := hh.createHold(rw, h, request)
+	if !ok {
+		return
+	}
+
+	rw.WriteHeader(http.StatusCreated)
+	hold.ToJSON(rw)
+}
+
+// CreateBooking holds the seats and pays for them with the card from the request in one step
+func (hh *HoldHandler) CreateBooking(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.BookingRequest)
+	if request.Card == nil {
+		http.Error(rw, "Card details are required", http.StatusBadRequest)
+		return
+	}
+
+	hold, ok := hh.createHold(rw, h, request)
+	if !ok {
+		return
+	}
+	hh.checkout(rw, hold, *request.Card)
+}
+
+// CreateTicket buys a ticket for one flight the way a booking is bought: its seats are held and paid with the card
+// from the request, the ticket is issued with the booking once the payment is captured
+func (hh *HoldHandler) CreateTicket(rw http.ResponseWriter, h *http.Request) {
+	ticket := h.Context().Value(KeyProduct{}).(*model.Ticket)
+	if ticket.Card == nil {
+		http.Error(rw, "Card details are required", http.StatusBadRequest)
+		return
+	}
+	user, err := CurrentUser(hh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	request, err := ticket.BookingRequest(user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hold, ok := hh.createHold(rw, h, request)
+	if !ok {
+		return
+	}
+	hh.checkout(rw, hold, *ticket.Card)
+}
+
+func (hh *HoldHandler) createHold(rw http.ResponseWriter, h *http.Request, request *model.BookingRequest) (*model.Hold, bool) {
+	user, err := CurrentUser(hh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err := hh.fillTravelers(user.ID.Hex(), request); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := request.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	hold, err := hh.placeHold(user.ID.Hex(), request)
+	if err == errHoldNotSaved {
+		http.Error(rw, "Unable to save the hold", http.StatusInternalServerError)
+		return nil, false
+	}
+	if err != nil {
+		writeReservationError(rw, err)
+		return nil, false
+	}
+	return hold, true
+}
+
+var errHoldNotSaved = errors.New("unable to save the hold")
+
+// fillTravelers completes the passengers selected from the user's saved traveler profiles
+func (hh *HoldHandler) fillTravelers(userId string, request *model.BookingRequest) error {
+	now := time.Now()
+	for i := range request.Passengers {
+		p := &request.Passengers[i]
+		if p.TravelerId == "" {
+			continue
+		}
+		traveler, err := hh.travelerRepo.GetById(p.TravelerId, userId)
+		if err == repo.ErrTravelerNotFound {
+			return fmt.Errorf("passenger %d: traveler %s not found", i+1, p.TravelerId)
+		}
+		if err != nil {
+			return fmt.Errorf("passenger %d: traveler could not be loaded", i+1)
+		}
+		traveler.FillPassenger(p, now)
+	}
+	return nil
+}
+
+// placeHold reserves the seats of a validated request for the user and stores the hold
+func (hh *HoldHandler) placeHold(userId string, request *model.BookingRequest) (*model.Hold, error) {
+	if _, err := hh.bookings.reserveSeats(userId, request); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	hold := model.Hold{
+		UserId:    userId,
+		Request:   *request,
+		Seats:     request.SeatedPassengers(),
+		Status:    model.HoldActive,
+		ExpiresAt: now.Add(hh.holdFor),
+		CreatedAt: now,
+	}
+	if err := hh.repo.Insert(&hold); err != nil {
+		hh.bookings.releaseRequest(userId, request)
+		return nil, errHoldNotSaved
+	}
+	return &hold, nil
+}
+
+// ConfirmHold pays for an active hold with the card in the body, the booking is created once the payment is captured
+func (hh *HoldHandler) ConfirmHold(rw http.ResponseWriter, h *http.Request) {
+	card := h.Context().Value(KeyProduct{}).(*payments.Card)
+	hold, ok := hh.ownHold(rw, h)
+	if !ok {
+		return
+	}
+	if hold.Status != model.HoldActive || !hold.ExpiresAt.After(time.Now()) {
+		http.Error(rw, "Hold has expired or was already used", http.StatusGone)
+		return
+	}
+
+	hh.checkout(rw, hold, *card)
+}
+
+// ReleaseHold gives the held seats back before the hold expires
+func (hh *HoldHandler) ReleaseHold(rw http.ResponseWriter, h *http.Request) {
+	hold, ok := hh.ownHold(rw, h)
+	if !ok {
+		return
+	}
+
+	hold, err := hh.repo.Claim(hold.ID.Hex(), model.HoldReleased)
+	if err == repo.ErrHoldNotActive {
+		http.Error(rw, "Hold has expired or was already used", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to release the hold", http.StatusInternalServerError)
+		return
+	}
+	hh.bookings.releaseRequest(hold.UserId, &hold.Request)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// StartReaper releases the seats of expired holds every interval until the context is done.
+// It is safe to run on several instances because every expired hold is claimed atomically by one reaper.
+func (hh *HoldHandler) StartReaper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hh.reapExpired()
+			}
+		}
+	}()
+}
+
+func (hh *HoldHandler) reapExpired() {
+	for {
+		hold, err := hh.repo.ClaimExpired()
+		if err == repo.ErrHoldNotActive {
+			return
+		}
+		if err != nil {
+			hh.logger.Print("Unable to reap expired holds: ", err)
+			return
+		}
+		hh.logger.Printf("Hold %s expired, releasing %d seats", hold.ID.Hex(), hold.Seats)
+		hh.bookings.releaseRequest(hold.UserId, &hold.Request)
+	}
+}
+
+// giveBack releases the seats of a claimed hold that could not be turned into a booking
+func (hh *HoldHandler) giveBack(hold *model.Hold) {
+	hh.bookings.releaseRequest(hold.UserId, &hold.Request)
+	hh.logger.Printf("Hold %s could not be confirmed, its seats were released", hold.ID.Hex())
+}
+
+func (hh *HoldHandler) ownHold(rw http.ResponseWriter, h *http.Request) (*model.Hold, bool) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	user, err := CurrentUser(hh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	hold, err := hh.repo.GetById(id)
+	if err != nil || hold.UserId != user.ID.Hex() {
+		http.Error(rw, "Hold with given id not found", http.StatusNotFound)
+		return nil, false
+	}
+	return hold, true
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/repo"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// Set on responses that were replayed from an earlier request with the same key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// Stored responses are kept for IDEMPOTENCY_TTL_HOURS, 24 if it is not set
+	defaultIdempotencyTTLHours = 24
+	// A request still running after this long is assumed lost and a retry may take its key over
+	idempotencyLockTimeout  = 2 * time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyHandler makes mutating requests safe to retry: the first response to an
+// Idempotency-Key is stored and replayed for every later request with the same key
+type IdempotencyHandler struct {
+	logger *log.Logger
+	repo   *repo.IdempotencyRepo
+	ttl    time.Duration
+}
+
+func NewIdempotencyHandler(l *log.Logger, r *repo.IdempotencyRepo) *IdempotencyHandler {
+	hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = defaultIdempotencyTTLHours
+	}
+	return &IdempotencyHandler{l, r, time.Duration(hours) * time.Hour}
+}
+
+// MiddlewareIdempotency handles POST, PUT, PATCH and DELETE requests that carry an Idempotency-Key.
+// Keys are scoped to the logged in user. Reusing a key for a different request returns 422,
+// retrying while the first request is still running returns 409.
+func (i *IdempotencyHandler) MiddlewareIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		key := h.Header.Get(IdempotencyKeyHeader)
+		if key == "" || !isMutating(h.Method) {
+			next.ServeHTTP(rw, h)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(rw, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to read the request", http.StatusBadRequest)
+			return
+		}
+		h.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := ""
+		if claims, err := TokenClaims(h); err == nil {
+			scope = fmt.Sprint(claims["email"])
+		}
+
+		now := time.Now()
+		record := &model.IdempotencyRecord{
+			ID:          hash(scope, key),
+			Key:         key,
+			Scope:       scope,
+			RequestHash: hash(h.Method, h.URL.RequestURI(), string(body)),
+			Status:      model.IdempotencyInProgress,
+			LockedUntil: now.Add(idempotencyLockTimeout),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(i.ttl),
+		}
+
+		stored, err := i.repo.Begin(record)
+		if err == repo.ErrIdempotencyKeyUsed {
+			if stored.RequestHash != record.RequestHash {
+				http.Error(rw, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			if stored.Status == model.IdempotencyCompleted {
+				replay(rw, stored)
+				return
+			}
+			if stored.LockedUntil.After(now) || i.repo.TakeOver(stored, record.LockedUntil) != nil {
+				http.Error(rw, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+				return
+			}
+			i.logger.Printf("Taking over idempotency key %q, its first request never finished", key)
+		} else if err != nil {
+			http.Error(rw, "Database exception", http.StatusInternalServerError)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: rw, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, h)
+
+		// Server side failures are not remembered so the client can retry them
+		if recorder.statusCode >= http.StatusInternalServerError {
+			i.repo.Delete(record.ID)
+			return
+		}
+		record.StatusCode = recorder.statusCode
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		if err := i.repo.Complete(record); err != nil {
+			i.logger.Printf("Unable to store the response for idempotency key %q: %v", key, err)
+		}
+	})
+}
+
+func replay(rw http.ResponseWriter, record *model.IdempotencyRecord) {
+	if record.ContentType != "" {
+		rw.Header().Set("Content-Type", record.ContentType)
+	}
+	rw.Header().Set(IdempotentReplayedHeader, "true")
+	rw.WriteHeader(record.StatusCode)
+	rw.Write(record.Body)
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func hash(parts ...string) string {
+	sum := sha256.New()
+	for _, part := range parts {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// responseRecorder passes the response on to the client and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
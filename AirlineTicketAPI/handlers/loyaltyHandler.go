@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"Rest/bus"
+	"Rest/model"
+	"Rest/repo"
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Flights that departed within this window are checked for points that were not credited yet
+const accrualWindow = 30 * 24 * time.Hour
+
+// LoyaltyHandler shows the frequent-flyer accounts and credits the points of flown segments
+type LoyaltyHandler struct {
+	logger *log.Logger
+
+	repo        *repo.LoyaltyRepo
+	userRepo    *repo.UserRepo
+	flightRepo  *repo.FlightRepo
+	bookingRepo *repo.BookingRepo
+	flightBus   *bus.Bus
+}
+
+func NewLoyaltyHandler(l *log.Logger, r *repo.LoyaltyRepo, u *repo.UserRepo, f *repo.FlightRepo, br *repo.BookingRepo, b *bus.Bus) *LoyaltyHandler {
+	return &LoyaltyHandler{l, r, u, f, br, b}
+}
+
+// GetMyAccount shows the logged in user's points balance, tier and how far the next tier is
+func (lh *LoyaltyHandler) GetMyAccount(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(lh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	userId := user.ID.Hex()
+
+	account, err := lh.repo.GetAccount(userId)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	qualifying, err := lh.repo.QualifyingPoints(userId, time.Now().Add(-model.TierWindow))
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	account.QualifyingPoints = qualifying
+	account.NextTierPoints = model.PointsToNextTier(qualifying)
+
+	err = account.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		lh.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// GetMyLedger lists every change of the logged in user's points balance, newest first
+func (lh *LoyaltyHandler) GetMyLedger(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(lh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	ledger, err := lh.repo.GetLedger(user.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	err = ledger.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		lh.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// Start credits points for flown segments until the context is done. Flights reported landed on the flight bus are
+// credited right away, every interval the flights of the accrual window are checked and expired tiers taken away.
+// Crediting is idempotent, so instances running it side by side never credit a segment twice.
+func (lh *LoyaltyHandler) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		sub, _, _ := lh.flightBus.Subscribe(nil, "")
+		defer func() {
+			if sub != nil {
+				lh.flightBus.Unsubscribe(sub)
+			}
+		}()
+
+		for {
+			// A nil channel blocks, so a dropped subscription waits for the next tick to subscribe again
+			var updates chan bus.FlightUpdate
+			if sub != nil {
+				updates = sub.C
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-updates:
+				if !ok {
+					sub = nil
+					continue
+				}
+				if update.Status == model.FlightLanded {
+					if flight, err := lh.flightRepo.GetById(update.FlightId); err == nil {
+						lh.accrue(flight)
+					}
+				}
+			case <-ticker.C:
+				if sub == nil {
+					sub, _, _ = lh.flightBus.Subscribe(nil, "")
+				}
+				lh.accrueRecent()
+				lh.refreshTiers()
+			}
+		}
+	}()
+}
+
+func (lh *LoyaltyHandler) accrueRecent() {
+	now := time.Now()
+	flights, err := lh.flightRepo.GetDepartedBetween(now.Add(-accrualWindow), now)
+	if err != nil {
+		lh.logger.Print("Unable to read the flown flights: ", err)
+		return
+	}
+	for _, flight := range flights {
+		if flight.Flown(now) {
+			lh.accrue(flight)
+		}
+	}
+}
+
+// accrue credits the owner of every confirmed booking on the flight with the points of one passenger.
+// Segments paid with points and segments whose coupons were all cancelled earn nothing.
+func (lh *LoyaltyHandler) accrue(flight *model.Flight) {
+	flightId := flight.ID.Hex()
+	bookings, err := lh.bookingRepo.GetConfirmedByFlightId(flightId)
+	if err != nil {
+		lh.logger.Printf("Unable to read the bookings of flight %s: %v", flightId, err)
+		return
+	}
+
+	for _, booking := range bookings {
+		for _, segment := range booking.Segments {
+			if segment.FlightId != flightId || (segment.Fare != nil && segment.Fare.Points > 0) || !hasOpenCoupon(booking, flightId) {
+				continue
+			}
+			entry := model.LedgerEntry{
+				UserId:      booking.UserId,
+				Kind:        model.LedgerEarn,
+				Points:      model.EarnPoints(flight.Distance, segment.FareClass),
+				BookingId:   booking.ID.Hex(),
+				FlightId:    flightId,
+				Description: "Flight " + flight.From + "-" + flight.To + " on " + flight.Date.Format(emailDateFormat),
+				CreatedAt:   time.Now(),
+			}
+			err := lh.repo.Post(&entry)
+			if err == repo.ErrAlreadyCredited {
+				continue
+			}
+			if err != nil {
+				lh.logger.Printf("Points of booking %s on flight %s were not credited: %v", booking.Locator, flightId, err)
+				continue
+			}
+			lh.updateTier(booking.UserId)
+		}
+	}
+}
+
+func hasOpenCoupon(booking *model.Booking, flightId string) bool {
+	// Bookings made before coupons existed have none, their segments were flown
+	if len(booking.Coupons) == 0 {
+		return true
+	}
+	for _, coupon := range booking.Coupons {
+		if coupon.FlightId == flightId && coupon.Status != model.CouponCancelled {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshTiers takes away tiers whose points left the tier window
+func (lh *LoyaltyHandler) refreshTiers() {
+	accounts, err := lh.repo.GetTiered()
+	if err != nil {
+		lh.logger.Print("Unable to read the loyalty tiers: ", err)
+		return
+	}
+	for _, account := range accounts {
+		lh.updateTier(account.UserId)
+	}
+}
+
+// updateTier gives the user the tier their points of the tier window qualify for
+func (lh *LoyaltyHandler) updateTier(userId string) {
+	qualifying, err := lh.repo.QualifyingPoints(userId, time.Now().Add(-model.TierWindow))
+	if err != nil {
+		return
+	}
+	account, err := lh.repo.GetAccount(userId)
+	if err != nil {
+		return
+	}
+	tier := model.TierFor(qualifying)
+	if tier == account.Tier {
+		return
+	}
+	if err := lh.repo.SetTier(userId, tier); err != nil {
+		return
+	}
+	if err := lh.userRepo.SetLoyaltyTier(userId, tier); err != nil {
+		lh.logger.Printf("Tier %q of user %s was not saved on the user: %v", tier, userId, err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"log"
+)
+
+// Date format used in the emails
+const emailDateFormat = "02.01.2006 15:04"
+
+// notifyUser queues an email for the user in their language.
+// A failure is only logged, an email must never fail the request that caused it.
+func notifyUser(l *log.Logger, n *notifications.Notifier, user *model.User, template string, data notifications.Data,
+	attachments ...notifications.Attachment) {
+	data["Name"] = user.Name
+	if err := n.Notify(user.Email, user.Language, template, data, attachments...); err != nil {
+		l.Printf("Unable to queue %s for %s: %v", template, user.Email, err)
+	}
+}
+
+// notifyBookingOwner emails the user who made the booking
+func notifyBookingOwner(l *log.Logger, n *notifications.Notifier, userRepo *repo.UserRepo, booking *model.Booking, template string,
+	data notifications.Data, attachments ...notifications.Attachment) {
+	user, err := userRepo.GetById(booking.UserId)
+	if err != nil {
+		l.Printf("Owner of booking %s not found, %s not sent", booking.Locator, template)
+		return
+	}
+	data["Locator"] = booking.Locator
+	notifyUser(l, n, user, template, data, attachments...)
+}
+
+// scheduleChangeData describes a change to one of the booked flights for the schedule_change template
+func scheduleChangeData(kind string, flight *model.Flight) notifications.Data {
+	data := notifications.Data{
+		"Kind":       kind,
+		"From":       flight.From,
+		"To":         flight.To,
+		"Date":       flight.Date.Format(emailDateFormat),
+		"NewDate":    "",
+		"Gate":       flight.Gate,
+		"Terminal":   flight.Terminal,
+		"DivertedTo": flight.DivertedTo,
+	}
+	if flight.EstimatedDeparture != nil {
+		data["NewDate"] = flight.EstimatedDeparture.Format(emailDateFormat)
+	}
+	return data
+}
@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Compensation per seated passenger when the gate agent does not give one, in model.Currency
+	defaultVoluntaryCompensation   = 250
+	defaultInvoluntaryCompensation = 400
+	// deniedBoardingKind tells the schedule_change template that the passenger was offloaded
+	deniedBoardingKind = "denied_boarding"
+)
+
+type OverbookingHandler struct {
+	logger *log.Logger
+
+	repo        *repo.OverbookingRepo
+	flightRepo  *repo.FlightRepo
+	checkInRepo *repo.CheckInRepo
+	bookings    *BookingHandler
+}
+
+func NewOverbookingHandler(l *log.Logger, r *repo.OverbookingRepo, f *repo.FlightRepo, c *repo.CheckInRepo, bh *BookingHandler) *OverbookingHandler {
+	return &OverbookingHandler{l, r, f, c, bh}
+}
+
+func (o *OverbookingHandler) GetAllOverbookingRules(rw http.ResponseWriter, h *http.Request) {
+	rules, err := o.repo.GetAllRules()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		o.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = rules.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		o.logger.Print("Unable to convert to json :", err)
+		return
+	}
+}
+
+func (o *OverbookingHandler) CreateOverbookingRule(rw http.ResponseWriter, h *http.Request) {
+	ruleDTO := h.Context().Value(KeyProduct{}).(*model.OverbookingRule)
+
+	if ruleDTO.From == "" || ruleDTO.To == "" {
+		http.Error(rw, "From and to are required", http.StatusBadRequest)
+		return
+	}
+	if ruleDTO.Percent <= 0 || ruleDTO.Percent > 100 {
+		http.Error(rw, "Percent must be above 0 and at most 100", http.StatusBadRequest)
+		return
+	}
+
+	rule := model.OverbookingRule{From: ruleDTO.From, To: ruleDTO.To, Percent: ruleDTO.Percent}
+	if err := o.repo.InsertRule(&rule); err != nil {
+		http.Error(rw, "Unable to save overbooking rule", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	rule.ToJSON(rw)
+}
+
+func (o *OverbookingHandler) DeleteOverbookingRule(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	if err := o.repo.DeleteRule(id); err != nil {
+		http.Error(rw, "Unable to delete overbooking rule", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// SetFlightOverbooking overrides the rule of the route for one flight, a null limit goes back to the rule
+func (o *OverbookingHandler) SetFlightOverbooking(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+	settings := h.Context().Value(KeyProduct{}).(*model.OverbookingSettings)
+
+	if settings.Capacity < 0 || (settings.Limit != nil && *settings.Limit < 0) {
+		http.Error(rw, "Capacity and overbookingLimit cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	flight, err := o.flightRepo.SetOverbooking(id, settings)
+	if err == repo.ErrFlightCancelled {
+		http.Error(rw, "Flight with given id not found or cancelled", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to update the flight", http.StatusInternalServerError)
+		return
+	}
+	flight.ToJSON(rw)
+}
+
+// Volunteer offers the seats of the logged in user's booking on a flight to passengers of an oversold flight.
+// The whole booking volunteers, so a party is never split up.
+func (o *OverbookingHandler) Volunteer(rw http.ResponseWriter, h *http.Request) {
+	request := h.Context().Value(KeyProduct{}).(*model.VolunteerRequest)
+
+	booking, ok := o.bookings.ownBooking(rw, h)
+	if !ok {
+		return
+	}
+	if booking.Status != model.BookingConfirmed || !booking.HasSegment(request.FlightId) {
+		http.Error(rw, "Booking has no confirmed segment on the flight", http.StatusConflict)
+		return
+	}
+	flight, err := o.flightRepo.GetById(request.FlightId)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	if flight.CurrentStatus() == model.FlightCancelled || !flight.Date.After(time.Now()) {
+		http.Error(rw, "Flight is no longer available", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	denied := model.DeniedBoarding{
+		FlightId:      request.FlightId,
+		BookingId:     booking.ID.Hex(),
+		Locator:       booking.Locator,
+		UserId:        booking.UserId,
+		Seats:         booking.SeatedPassengers(),
+		Voluntary:     true,
+		Status:        model.DeniedBoardingVolunteered,
+		Currency:      model.Currency,
+		VolunteeredAt: &now,
+	}
+	err = o.repo.Volunteer(&denied)
+	if err == repo.ErrAlreadyVolunteered {
+		http.Error(rw, "Booking already volunteered for the flight", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to volunteer", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	denied.ToJSON(rw)
+}
+
+// GetOversale shows the gate how far a flight is oversold and who volunteered
+func (o *OverbookingHandler) GetOversale(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	flight, err := o.flightRepo.GetById(id)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	rules, err := o.repo.GetAllRules()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	checkIns, err := o.checkInRepo.GetByFlight(id)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	volunteers, err := o.repo.GetByFlight(id, model.DeniedBoardingVolunteered)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	oversale := model.Oversale{
+		FlightId:   id,
+		Capacity:   flight.Capacity,
+		Limit:      rules.Limit(flight),
+		Volunteers: volunteers,
+	}
+	if flight.Capacity > 0 {
+		oversale.Sold = flight.Capacity - flight.FreeSeats
+	}
+	if flight.FreeSeats < 0 {
+		oversale.Oversold = -flight.FreeSeats
+	}
+	for _, checkIn := range checkIns {
+		// Infants sit on a lap and take no seat
+		if checkIn.Seat != "" {
+			oversale.CheckedIn++
+		}
+	}
+
+	err = oversale.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		o.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (o *OverbookingHandler) GetDeniedBoardings(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	denied, err := o.repo.GetByFlight(id, h.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	err = denied.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		o.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// DenyBoarding offloads bookings from an oversold flight until enough seats are free. Volunteers go first in the order
+// they volunteered, then bookings are taken involuntarily: not checked in before checked in, lower loyalty tier before
+// higher and the latest booking first. Offloaded bookings are rebooked onto the next flight of the route.
+func (o *OverbookingHandler) DenyBoarding(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+	request := h.Context().Value(KeyProduct{}).(*model.DeniedBoardingRequest)
+
+	if request.Seats < 0 || request.VoluntaryCompensation < 0 || request.InvoluntaryCompensation < 0 {
+		http.Error(rw, "Seats and compensation cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if request.VoluntaryCompensation == 0 {
+		request.VoluntaryCompensation = defaultVoluntaryCompensation
+	}
+	if request.InvoluntaryCompensation == 0 {
+		request.InvoluntaryCompensation = defaultInvoluntaryCompensation
+	}
+
+	flight, err := o.flightRepo.GetById(id)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	needed := request.Seats
+	if needed == 0 {
+		needed = -flight.FreeSeats
+	}
+	if needed <= 0 {
+		http.Error(rw, "Flight is not oversold", http.StatusConflict)
+		return
+	}
+
+	// The seats freed here are for the passengers at the gate, they must not be sold again
+	noOverbooking := 0
+	if _, err := o.flightRepo.SetOverbooking(id, &model.OverbookingSettings{Limit: &noOverbooking}); err != nil {
+		http.Error(rw, "Unable to stop overbooking the flight", http.StatusInternalServerError)
+		return
+	}
+
+	candidates, err := o.candidates(flight)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+
+	result := model.DeniedBoardingResult{FlightId: id, Needed: needed, Offloaded: model.DeniedBoardings{}}
+	freed := 0
+	for _, candidate := range candidates {
+		if freed >= needed {
+			break
+		}
+		compensation := request.InvoluntaryCompensation
+		if candidate.voluntary {
+			compensation = request.VoluntaryCompensation
+		}
+		denied, err := o.offload(flight, candidate, compensation, h.Header.Get("Email"))
+		if err != nil {
+			o.logger.Printf("Unable to offload booking %s from flight %s: %v", candidate.booking.Locator, id, err)
+			continue
+		}
+		freed += denied.Seats
+		result.Offloaded = append(result.Offloaded, denied)
+	}
+	if freed < needed {
+		result.Shortfall = needed - freed
+	}
+
+	err = result.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		o.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+type offloadCandidate struct {
+	booking   *model.Booking
+	voluntary bool
+	checkedIn bool
+	priority  int
+}
+
+// candidates orders the confirmed bookings of the flight in the order they are offloaded,
+// bookings with a passenger on board are left out
+func (o *OverbookingHandler) candidates(flight *model.Flight) ([]*offloadCandidate, error) {
+	flightId := flight.ID.Hex()
+	bookings, err := o.bookings.repo.GetConfirmedByFlightId(flightId)
+	if err != nil {
+		return nil, err
+	}
+	volunteers, err := o.repo.GetByFlight(flightId, model.DeniedBoardingVolunteered)
+	if err != nil {
+		return nil, err
+	}
+	checkIns, err := o.checkInRepo.GetByFlight(flightId)
+	if err != nil {
+		return nil, err
+	}
+
+	checkedIn := map[string]bool{}
+	boarded := map[string]bool{}
+	for _, checkIn := range checkIns {
+		checkedIn[checkIn.BookingId] = true
+		if checkIn.Status == model.Boarded {
+			boarded[checkIn.BookingId] = true
+		}
+	}
+	volunteered := map[string]int{}
+	for i, volunteer := range volunteers {
+		volunteered[volunteer.BookingId] = i
+	}
+
+	voluntary := make([]*offloadCandidate, len(volunteers))
+	involuntary := []*offloadCandidate{}
+	for _, booking := range bookings {
+		if boarded[booking.ID.Hex()] || booking.SeatedPassengers() == 0 {
+			continue
+		}
+		candidate := &offloadCandidate{booking: booking, checkedIn: checkedIn[booking.ID.Hex()]}
+		if i, ok := volunteered[booking.ID.Hex()]; ok {
+			candidate.voluntary = true
+			voluntary[i] = candidate
+			continue
+		}
+		if user, err := o.bookings.userRepo.GetById(booking.UserId); err == nil {
+			candidate.priority = model.TierPriority(user.LoyaltyTier)
+		}
+		involuntary = append(involuntary, candidate)
+	}
+
+	sort.SliceStable(involuntary, func(i, j int) bool {
+		a, b := involuntary[i], involuntary[j]
+		if a.checkedIn != b.checkedIn {
+			return !a.checkedIn
+		}
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return a.booking.CreatedAt.After(b.booking.CreatedAt)
+	})
+
+	candidates := []*offloadCandidate{}
+	// Volunteers whose booking was cancelled or has boarded leave a gap
+	for _, candidate := range voluntary {
+		if candidate != nil {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return append(candidates, involuntary...), nil
+}
+
+// offload moves the booking to the next flight of the route, gives its seats back to the oversold flight
+// and records the denied boarding with the compensation owed per seated passenger
+func (o *OverbookingHandler) offload(flight *model.Flight, candidate *offloadCandidate, compensation float64,
+	offloadedBy string) (*model.DeniedBoarding, error) {
+	booking := candidate.booking
+	flightId := flight.ID.Hex()
+	seats := booking.SeatedPassengers()
+
+	next, err := o.flightRepo.FindNextAvailable(flight.From, flight.To, flight.Date, seats)
+	if err != nil {
+		return nil, fmt.Errorf("no flight to rebook onto: %w", err)
+	}
+	next, err = o.flightRepo.ReserveSeats(next.ID.Hex(), seats, 0)
+	if err != nil {
+		return nil, err
+	}
+	booking.MoveSegment(flightId, next.ID.Hex())
+	if err := o.bookings.repo.MoveSegment(booking, flightId); err != nil {
+		o.bookings.releaseSeats([]string{next.ID.Hex()}, seats)
+		return nil, err
+	}
+
+	o.bookings.releaseSeats([]string{flightId}, seats)
+	if err := o.checkInRepo.Offload(booking.ID.Hex(), flightId); err != nil {
+		o.logger.Printf("Unable to remove the check-ins of booking %s on flight %s: %v", booking.Locator, flightId, err)
+	}
+
+	now := time.Now()
+	denied := &model.DeniedBoarding{
+		FlightId:         flightId,
+		BookingId:        booking.ID.Hex(),
+		Locator:          booking.Locator,
+		UserId:           booking.UserId,
+		Seats:            seats,
+		Voluntary:        candidate.voluntary,
+		Compensation:     math.Round(compensation*float64(seats)*100) / 100,
+		Currency:         model.Currency,
+		RebookedFlightId: next.ID.Hex(),
+		OffloadedAt:      &now,
+		OffloadedBy:      offloadedBy,
+	}
+	// The booking has moved already, a record that cannot be stored is only logged
+	if err := o.repo.Offload(denied); err != nil {
+		o.logger.Printf("Unable to record the denied boarding of booking %s: %v", booking.Locator, err)
+	}
+
+	data := scheduleChangeData(deniedBoardingKind, flight)
+	data["NewDate"] = next.Date.Format(emailDateFormat)
+	data["Compensation"] = fmt.Sprintf("%.2f %s", denied.Compensation, denied.Currency)
+	notifyBookingOwner(o.logger, o.bookings.notifier, o.bookings.userRepo, booking, notifications.ScheduleChangeTemplate, data)
+	return denied, nil
+}
+
+func (o *OverbookingHandler) MiddlewareOverbookingRuleDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		rule := &model.OverbookingRule{}
+		err := rule.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			o.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, rule)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (o *OverbookingHandler) MiddlewareOverbookingSettingsDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		settings := &model.OverbookingSettings{}
+		err := settings.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			o.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, settings)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (o *OverbookingHandler) MiddlewareVolunteerDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.VolunteerRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			o.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
+func (o *OverbookingHandler) MiddlewareDeniedBoardingDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.DeniedBoardingRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			o.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/repo"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// PromotionHandler lets marketing manage promo codes and follow their redemptions
+type PromotionHandler struct {
+	logger *log.Logger
+	repo   *repo.PromotionRepo
+}
+
+func NewPromotionsHandler(l *log.Logger, r *repo.PromotionRepo) *PromotionHandler {
+	return &PromotionHandler{l, r}
+}
+
+func (p *PromotionHandler) GetAllPromotions(rw http.ResponseWriter, h *http.Request) {
+	promotions, err := p.repo.GetAll()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		p.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = promotions.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		p.logger.Print("Unable to convert to json :", err)
+		return
+	}
+}
+
+func (p *PromotionHandler) CreatePromotion(rw http.ResponseWriter, h *http.Request) {
+	promotionDTO := h.Context().Value(KeyProduct{}).(*model.Promotion)
+	if err := promotionDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	promotion := model.Promotion{Code: promotionDTO.Code, Campaign: promotionDTO.Campaign, Kind: promotionDTO.Kind, Value: promotionDTO.Value,
+		ValidFrom: promotionDTO.ValidFrom, ValidUntil: promotionDTO.ValidUntil, From: promotionDTO.From, To: promotionDTO.To,
+		TravelFrom: promotionDTO.TravelFrom, TravelUntil: promotionDTO.TravelUntil, FareClasses: promotionDTO.FareClasses,
+		MaxUses: promotionDTO.MaxUses, MaxUsesPerUser: promotionDTO.MaxUsesPerUser, Stackable: promotionDTO.Stackable, CreatedAt: time.Now()}
+	err := p.repo.Insert(&promotion)
+	if err == repo.ErrPromotionExists {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to save promotion", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	promotion.ToJSON(rw)
+}
+
+func (p *PromotionHandler) DeletePromotion(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	if err := p.repo.Delete(id); err != nil {
+		http.Error(rw, "Unable to delete promotion", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// GetRedemptionReport shows how often every promo code was used and what it discounted, ?campaign= narrows it to one campaign
+func (p *PromotionHandler) GetRedemptionReport(rw http.ResponseWriter, h *http.Request) {
+	reports, err := p.repo.Report(h.URL.Query().Get("campaign"))
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		p.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = reports.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		p.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (p *PromotionHandler) MiddlewarePromotionDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		promotion := &model.Promotion{}
+		err := promotion.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			p.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, promotion)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"Rest/bus"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Comment line sent to idle streams so proxies do not close them
+const heartbeatInterval = 15 * time.Second
+
+type StreamHandler struct {
+	logger *log.Logger
+	bus    *bus.Bus
+}
+
+func NewStreamHandler(l *log.Logger, b *bus.Bus) *StreamHandler {
+	return &StreamHandler{l, b}
+}
+
+// StreamFlights pushes seat count, price and status changes of the flights in the ids query parameter
+// (comma separated, all flights if empty) as Server-Sent Events. A client reconnecting with Last-Event-ID
+// gets the updates it missed, or a "reset" event when they are no longer available and it has to reload.
+func (s *StreamHandler) StreamFlights(rw http.ResponseWriter, h *http.Request) {
+	controller := http.NewResponseController(rw)
+	// The stream outlives the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(rw, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var flightIds []string
+	if ids := h.URL.Query().Get("ids"); ids != "" {
+		flightIds = strings.Split(ids, ",")
+	}
+	lastEventID := h.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = h.URL.Query().Get("lastEventId")
+	}
+
+	sub, replay, complete := s.bus.Subscribe(flightIds, lastEventID)
+	defer s.bus.Unsubscribe(sub)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(rw, "retry: 3000\n\n")
+	if !complete {
+		fmt.Fprint(rw, "event: reset\ndata: {}\n\n")
+	}
+	for _, update := range replay {
+		if err := writeUpdate(rw, update); err != nil {
+			return
+		}
+	}
+	controller.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-h.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(rw, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			controller.Flush()
+		case update, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged {
+					// The client reconnects with its Last-Event-ID and catches up from the history
+					s.logger.Print("Dropping slow flight stream subscriber")
+				}
+				return
+			}
+			if err := writeUpdate(rw, update); err != nil {
+				return
+			}
+			controller.Flush()
+		}
+	}
+}
+
+func writeUpdate(rw http.ResponseWriter, update bus.FlightUpdate) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw, "id: %s\nevent: flight\ndata: %s\n\n", update.ID, data)
+	return err
+}
@@ -4,10 +4,8 @@ import (
 	"Rest/model"
 	"Rest/repo"
 	"context"
-	"encoding/json"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -70,46 +68,27 @@ func (u *TicketHandler) GetTicketById(rw http.ResponseWriter, h *http.Request) {
 	}
 }
 
-func (u *TicketHandler) CreateTicket(rw http.ResponseWriter, h *http.Request) {
-	log.Println("H: ")
-	ticketDTO := h.Context().Value(KeyProduct{}).(*model.Ticket)
-	user, err := u.userRepo.GetByUsername(ticketDTO.UserId)
-	if err != nil {
-		log.Fatalf("An error occurred while fetching the user by username: %v", err)
-	}
+func (u *TicketHandler) GetInvoice(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
 
-	ticket := model.Ticket{FlightId: ticketDTO.FlightId, UserId: user.ID.Hex(), NumberOfSeats: ticketDTO.NumberOfSeats}
-	log.Println("FLIGHTID: " + ticket.FlightId + " | " + ticketDTO.FlightId)
-	flight, err := u.flightRepo.GetById(ticketDTO.FlightId)
+	user, err := CurrentUser(u.userRepo, h)
 	if err != nil {
-		log.Fatalf("An error occurred while fetching the flight: %v", err)
-	}
-
-	if flight.Date.Before(time.Now()) {
-		log.Println("That flight has already departed")
-		rw.WriteHeader(http.StatusBadRequest)
+		http.Error(rw, "User not found", http.StatusUnauthorized)
 		return
 	}
 
-	if flight.FreeSeats < ticket.NumberOfSeats {
-		log.Println("That flight doesn't have enough available seats")
-		rw.WriteHeader(http.StatusNotAcceptable)
+	ticket, err := u.repo.GetById(id)
+	if err != nil || ticket.UserId != user.ID.Hex() {
+		http.Error(rw, "Ticket with given id not found", http.StatusNotFound)
+		u.logger.Printf("Ticket with id: '%s' not found", id)
 		return
 	}
 
-	flight.FreeSeats -= ticket.NumberOfSeats
-	if err := u.flightRepo.UpdateFlight(ticketDTO.FlightId, flight); err != nil {
-		log.Fatalf("An error occurred while updating the flight: %v", err)
-	}
-
-	if err := u.repo.Insert(&ticket); err != nil {
-		log.Fatalf("An error occurred while inserting the ticket: %v", err)
-	}
-
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(rw).Encode(ticket); err != nil {
-		log.Fatalf("An error occurred while encoding the response: %v", err)
+	err = model.NewInvoice(ticket).ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		u.logger.Print("Unable to convert to json :", err)
 	}
 }
 
@@ -119,7 +98,7 @@ func (u *TicketHandler) MiddlewareTicketDeserialization(next http.Handler) http.
 		err := ticket.FromJSON(h.Body)
 		if err != nil {
 			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
-			u.logger.Fatal(err)
+			u.logger.Print(err)
 			return
 		}
 
@@ -136,7 +115,7 @@ func (u *TicketHandler) MiddlewareAuthDeserialization(next http.Handler) http.Ha
 		err := auth.FromJSON(h.Body)
 		if err != nil {
 			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
-			u.logger.Fatal(err)
+			u.logger.Print(err)
 			return
 		}
 
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/repo"
+	"Rest/vault"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TravelerHandler manages the saved traveler profiles of the logged in user. Document numbers are masked in
+// every response, they are only decrypted to fill in the passengers of a booking.
+type TravelerHandler struct {
+	logger *log.Logger
+
+	repo     *repo.TravelerRepo
+	userRepo *repo.UserRepo
+}
+
+func NewTravelersHandler(l *log.Logger, r *repo.TravelerRepo, u *repo.UserRepo) *TravelerHandler {
+	return &TravelerHandler{l, r, u}
+}
+
+func (t *TravelerHandler) GetMyTravelers(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(t.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	travelers, err := t.repo.GetByUserId(user.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	for _, traveler := range travelers {
+		maskDocuments(traveler)
+	}
+
+	err = travelers.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		t.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (t *TravelerHandler) GetTravelerById(rw http.ResponseWriter, h *http.Request) {
+	traveler, ok := t.ownTraveler(rw, h)
+	if !ok {
+		return
+	}
+	maskDocuments(traveler)
+
+	err := traveler.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		t.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (t *TravelerHandler) CreateTraveler(rw http.ResponseWriter, h *http.Request) {
+	travelerDTO := h.Context().Value(KeyProduct{}).(*model.Traveler)
+	user, err := CurrentUser(t.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	if err := travelerDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	traveler := model.Traveler{UserId: user.ID.Hex(), Name: travelerDTO.Name, Surname: travelerDTO.Surname, DateOfBirth: travelerDTO.DateOfBirth,
+		Gender: travelerDTO.Gender, Documents: travelerDTO.Documents, FrequentFlyers: travelerDTO.FrequentFlyers, CreatedAt: now, UpdatedAt: now}
+	if traveler.Documents == nil {
+		traveler.Documents = []model.TravelDocument{}
+	}
+	if err := t.repo.Insert(&traveler); err != nil {
+		http.Error(rw, "Unable to save traveler", http.StatusInternalServerError)
+		return
+	}
+	maskDocuments(&traveler)
+	rw.WriteHeader(http.StatusCreated)
+	traveler.ToJSON(rw)
+}
+
+// UpdateTraveler replaces the profile. Document numbers sent back masked keep the stored number of the document at their position.
+func (t *TravelerHandler) UpdateTraveler(rw http.ResponseWriter, h *http.Request) {
+	travelerDTO := h.Context().Value(KeyProduct{}).(*model.Traveler)
+	stored, ok := t.ownTraveler(rw, h)
+	if !ok {
+		return
+	}
+	travelerDTO.KeepNumbers(stored)
+	if err := travelerDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traveler := model.Traveler{ID: stored.ID, UserId: stored.UserId, Name: travelerDTO.Name, Surname: travelerDTO.Surname,
+		DateOfBirth: travelerDTO.DateOfBirth, Gender: travelerDTO.Gender, Documents: travelerDTO.Documents,
+		FrequentFlyers: travelerDTO.FrequentFlyers, CreatedAt: stored.CreatedAt, UpdatedAt: time.Now()}
+	if traveler.Documents == nil {
+		traveler.Documents = []model.TravelDocument{}
+	}
+	if err := t.repo.Update(&traveler); err != nil {
+		http.Error(rw, "Unable to save traveler", http.StatusInternalServerError)
+		return
+	}
+	maskDocuments(&traveler)
+	traveler.ToJSON(rw)
+}
+
+func (t *TravelerHandler) DeleteTraveler(rw http.ResponseWriter, h *http.Request) {
+	traveler, ok := t.ownTraveler(rw, h)
+	if !ok {
+		return
+	}
+
+	if err := t.repo.Delete(traveler.ID.Hex(), traveler.UserId); err != nil {
+		http.Error(rw, "Unable to delete traveler", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (t *TravelerHandler) ownTraveler(rw http.ResponseWriter, h *http.Request) (*model.Traveler, bool) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	user, err := CurrentUser(t.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	traveler, err := t.repo.GetById(id, user.ID.Hex())
+	if err == repo.ErrTravelerNotFound {
+		http.Error(rw, "Traveler with given id not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return nil, false
+	}
+	return traveler, true
+}
+
+func maskDocuments(traveler *model.Traveler) {
+	for i := range traveler.Documents {
+		traveler.Documents[i].Number = vault.Mask(traveler.Documents[i].Number)
+	}
+}
+
+func (t *TravelerHandler) MiddlewareTravelerDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		traveler := &model.Traveler{}
+		err := traveler.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			t.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, traveler)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"Rest/model"
+	"Rest/notifications"
 	"Rest/repo"
 	"context"
 	"encoding/json"
@@ -20,12 +21,14 @@ type KeyProduct struct{}
 type UserHandler struct {
 	logger *log.Logger
 	// NoSQL: injecting product repository
-	repo *repo.UserRepo
+	repo     *repo.UserRepo
+	notifier *notifications.Notifier
+	verifier *EmailVerifier
 }
 
 // Injecting the logger makes this code much more testable.
-func NewUsersHandler(l *log.Logger, r *repo.UserRepo) *UserHandler {
-	return &UserHandler{l, r}
+func NewUsersHandler(l *log.Logger, r *repo.UserRepo, n *notifications.Notifier, v *EmailVerifier) *UserHandler {
+	return &UserHandler{l, r, n, v}
 }
 
 func (u *UserHandler) GetAllUsers(rw http.ResponseWriter, h *http.Request) {
@@ -60,6 +63,7 @@ func (u *UserHandler) GetUserById(rw http.ResponseWriter, h *http.Request) {
 		u.logger.Printf("Patient with id: '%s' not found", id)
 		return
 	}
+	user.Password = ""
 
 	err = user.ToJSON(rw)
 	if err != nil {
@@ -121,7 +125,12 @@ func (u *UserHandler) FindByUsername(username string) (*model.User, error) {
 func (u *UserHandler) RegisterUser(rw http.ResponseWriter, h *http.Request) {
 	userDTO := h.Context().Value(KeyProduct{}).(*model.User)
 	hashPw, _ := HashPassword(userDTO.Password)
-	user := model.User{Name: userDTO.Name, Surname: userDTO.Surname, PhoneNumber: userDTO.PhoneNumber, Email: userDTO.Email, Username: userDTO.Username, Password: hashPw, BirthDate: userDTO.BirthDate, Role: 0}
+	user := model.User{Name: userDTO.Name, Surname: userDTO.Surname, PhoneNumber: userDTO.PhoneNumber, Email: userDTO.Email, Username: userDTO.Username, Password: hashPw, BirthDate: userDTO.BirthDate, Role: 0,
+		Language: userDTO.Language, EmailUnverified: true}
+	if !model.ValidEmail(user.Email) {
+		http.Error(rw, "email is not a valid email address", http.StatusBadRequest)
+		return
+	}
 
 	existsEmail, _ := u.FindByEmail(user.Email)
 	if existsEmail != nil {
@@ -136,7 +145,14 @@ func (u *UserHandler) RegisterUser(rw http.ResponseWriter, h *http.Request) {
 		return
 	}
 
-	u.repo.Insert(&user)
+	if err := u.repo.Insert(&user); err != nil {
+		http.Error(rw, "Unable to register user", http.StatusInternalServerError)
+		return
+	}
+	// The welcome email follows once the address is verified
+	if err := u.verifier.Send(&user, user.Email); err != nil {
+		u.logger.Printf("Verification email for user %s was not sent: %v", user.ID.Hex(), err)
+	}
 	rw.WriteHeader(http.StatusCreated)
 	json.NewEncoder(rw).Encode(user)
 	rw.Header().Set("Content-Type", "application/json")
@@ -163,12 +179,13 @@ func (u *UserHandler) LoginUser(rw http.ResponseWriter, h *http.Request) {
 		u.logger.Printf("Username or Password is incorrect")
 		return
 	}
-	stringRole := "USER"
-	if user.Role == 1 {
-		stringRole = "ADMIN"
+	if !u.verifier.LoginAllowed(user) {
+		http.Error(rw, "Verify your email address before logging in", http.StatusForbidden)
+		return
 	}
+	stringRole := roleName(user.Role)
 
-	validToken, err := GenerateJWT(user.Email, stringRole)
+	validToken, err := GenerateJWT(user.Email, stringRole, user.SessionVersion)
 	if err != nil {
 		http.Error(rw, "Failed to genetare token", http.StatusBadRequest)
 		u.logger.Printf("Failed to genetare token")
@@ -188,10 +205,38 @@ func (u *UserHandler) UpdateUser(rw http.ResponseWriter, h *http.Request) {
 	id := vars["id"]
 	user := h.Context().Value(KeyProduct{}).(*model.User)
 
-	u.repo.UpdateUser(id, user)
+	if err := u.repo.UpdateUser(id, user); err != nil {
+		http.Error(rw, "Unable to update user", http.StatusInternalServerError)
+		return
+	}
 	rw.WriteHeader(http.StatusOK)
 }
 
+// SetUserRole lets an admin make a registered user operations staff or a partner, or a customer again.
+// The user logs in again to get a token with the new role.
+func (u *UserHandler) SetUserRole(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+	assignment := h.Context().Value(KeyProduct{}).(*model.RoleAssignment)
+
+	role, ok := model.AssignableRoles[strings.ToUpper(assignment.Role)]
+	if !ok {
+		http.Error(rw, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	err := u.repo.SetRole(id, role)
+	if err == repo.ErrUserNotFound {
+		http.Error(rw, "User with given id not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to change the role", http.StatusInternalServerError)
+		return
+	}
+	u.logger.Printf("User %s was given the role %s", id, strings.ToUpper(assignment.Role))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
 func (u *UserHandler) ProbaAut(rw http.ResponseWriter, h *http.Request) {
 	if h.Header["Role"][0] != "ADMIN" {
 		http.Error(rw, "You're not admin", http.StatusUnauthorized)
@@ -207,7 +252,7 @@ func (u *UserHandler) MiddlewareUserDeserialization(next http.Handler) http.Hand
 		err := user.FromJSON(h.Body)
 		if err != nil {
 			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
-			u.logger.Fatal(err)
+			u.logger.Print(err)
 			return
 		}
 
@@ -217,13 +262,30 @@ func (u *UserHandler) MiddlewareUserDeserialization(next http.Handler) http.Hand
 		next.ServeHTTP(rw, h)
 	})
 }
+func (u *UserHandler) MiddlewareRoleAssignmentDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		assignment := &model.RoleAssignment{}
+		err := assignment.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			u.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, assignment)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
+
 func (u *UserHandler) MiddlewareAuthDeserialization(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
 		auth := &model.Authentication{}
 		err := auth.FromJSON(h.Body)
 		if err != nil {
 			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
-			u.logger.Fatal(err)
+			u.logger.Print(err)
 			return
 		}
 
@@ -243,51 +305,42 @@ func (u *UserHandler) MiddlewareContentTypeSet(next http.Handler) http.Handler {
 }
 
 func (u *UserHandler) IsAuthorizedAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
-		tokenString := GetJWT(h.Header)
-		var mySigningKey = []byte("secretkey")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("there was an error in parsing")
-			}
-			return mySigningKey, nil
-		})
+	return u.authorize(next, "ADMIN")
+}
 
-		if err != nil {
-			http.Error(rw, "Your Token has been expired", http.StatusUnauthorized)
-			return
-		}
+// IsAuthorizedUser lets customers and partners, who book on behalf of customers, through
+func (u *UserHandler) IsAuthorizedUser(next http.Handler) http.Handler {
+	return u.authorize(next, "USER", "PARTNER")
+}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if claims["role"] == "ADMIN" {
-				h.Header.Set("Role", "ADMIN")
-				next.ServeHTTP(rw, h)
-				return
-			}
-		}
-		http.Error(rw, "Not Authorized", http.StatusUnauthorized)
-	})
+func (u *UserHandler) IsAuthorizedPartner(next http.Handler) http.Handler {
+	return u.authorize(next, "PARTNER")
 }
 
-func (u *UserHandler) IsAuthorizedUser(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
-		tokenString := GetJWT(h.Header)
-		var mySigningKey = []byte("secretkey")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("there was an error in parsing")
-			}
-			return mySigningKey, nil
-		})
+// IsAuthorizedOps lets operations staff and admins through
+func (u *UserHandler) IsAuthorizedOps(next http.Handler) http.Handler {
+	return u.authorize(next, "ADMIN", "OPS")
+}
 
+// authorize checks the JWT, revoked tokens included, and passes the request on if the token's role is one of the given roles
+func (u *UserHandler) authorize(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		claims, err := TokenClaims(h)
 		if err != nil {
 			http.Error(rw, "Your Token has been expired", http.StatusUnauthorized)
 			return
 		}
+		// Tokens issued before the password was reset no longer work
+		user, err := u.repo.GetByEmail(fmt.Sprint(claims["email"]))
+		if err != nil || !sameSessions(claims, user) {
+			http.Error(rw, "Your Token has been revoked", http.StatusUnauthorized)
+			return
+		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if claims["role"] == "USER" {
-				h.Header.Set("Role", "USER")
+		for _, role := range roles {
+			if claims["role"] == role {
+				h.Header.Set("Role", role)
+				h.Header.Set("Email", fmt.Sprint(claims["email"]))
 				next.ServeHTTP(rw, h)
 				return
 			}
@@ -296,6 +349,34 @@ func (u *UserHandler) IsAuthorizedUser(next http.Handler) http.Handler {
 	})
 }
 
+// TokenClaims returns the claims of the request's JWT if the token is valid
+func TokenClaims(h *http.Request) (jwt.MapClaims, error) {
+	tokenString := GetJWT(h.Header)
+	var mySigningKey = []byte("secretkey")
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("there was an error in parsing")
+		}
+		return mySigningKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// sameSessions reports whether the token was issued for the user's current session version,
+// tokens issued before the claim existed count as version 0
+func sameSessions(claims jwt.MapClaims, user *model.User) bool {
+	sessions, _ := claims["sessions"].(float64)
+	return int(sessions) == user.SessionVersion
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes), err
@@ -304,7 +385,22 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
-func GenerateJWT(email, role string) (string, error) {
+
+// roleName is the role as tokens carry it
+func roleName(role model.Role) string {
+	switch role {
+	case model.Admin:
+		return "ADMIN"
+	case model.Ops:
+		return "OPS"
+	case model.Partner:
+		return "PARTNER"
+	}
+	return "USER"
+}
+
+// GenerateJWT issues a login token, sessions is the user's session version that revokes it once raised
+func GenerateJWT(email, role string, sessions int) (string, error) {
 	var mySigningKey = []byte("secretkey")
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
@@ -312,12 +408,19 @@ func GenerateJWT(email, role string) (string, error) {
 	claims["authorized"] = true
 	claims["email"] = email
 	claims["role"] = role
+	claims["sessions"] = sessions
 	claims["exp"] = time.Now().Add(time.Minute * 30).Unix()
 
 	tokenString, _ := token.SignedString(mySigningKey)
 
 	return tokenString, nil
 }
+
+// CurrentUser loads the user whose email the authorization middleware put into the request header
+func CurrentUser(userRepo *repo.UserRepo, h *http.Request) (*model.User, error) {
+	return userRepo.GetByEmail(h.Header.Get("Email"))
+}
+
 func GetJWT(r http.Header) string {
 	bearToken := r.Get("Authorization")
 	strArr := strings.Split(bearToken, " ")
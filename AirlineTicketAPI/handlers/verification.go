@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"Rest/tokens"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Links in account emails point to APP_URL, the local API if it is not set
+	defaultAppURL = "http://localhost:8080"
+	// emailTokenPurpose keeps email verification tokens from being used for anything else
+	emailTokenPurpose = "email"
+	emailTokenTTL     = 24 * time.Hour
+	// A verification email is sent at most once per resendInterval
+	resendInterval = time.Minute
+)
+
+// REQUIRE_EMAIL_VERIFICATION decides what an account with an unverified email cannot do, booking if it is not set.
+// VerifyNever turns the check off, for example while no mail transport is set up.
+const (
+	VerifyBeforeLogin   = "login"
+	VerifyBeforeBooking = "booking"
+	VerifyNever         = "off"
+)
+
+// VerificationStore keeps the verifications of the users, repo.UserRepo stores them with the users
+type VerificationStore interface {
+	GetById(id string) (*model.User, error)
+	GetByEmail(email string) (*model.User, error)
+	StartVerification(user *model.User, verification *model.EmailVerification, sentBefore time.Time) error
+}
+
+// EmailVerifier sends the signed single-use links that verify email addresses. The emails go through the notifier,
+// so its transport decides where they end up: SMTP, a local directory or memory.
+type EmailVerifier struct {
+	logger *log.Logger
+
+	userRepo VerificationStore
+	notifier *notifications.Notifier
+	signer   *tokens.Signer
+	appURL   string
+	required string
+}
+
+func NewEmailVerifier(l *log.Logger, u VerificationStore, n *notifications.Notifier, s *tokens.Signer) *EmailVerifier {
+	appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = defaultAppURL
+	}
+	required := strings.ToLower(os.Getenv("REQUIRE_EMAIL_VERIFICATION"))
+	switch required {
+	case VerifyBeforeLogin, VerifyBeforeBooking:
+	case VerifyNever:
+		l.Println("REQUIRE_EMAIL_VERIFICATION is off, accounts with an unverified email can book")
+	case "":
+		required = VerifyBeforeBooking
+	default:
+		l.Printf("REQUIRE_EMAIL_VERIFICATION must be %s, %s or %s, a verified email is required to book", VerifyBeforeLogin, VerifyBeforeBooking, VerifyNever)
+		required = VerifyBeforeBooking
+	}
+	return &EmailVerifier{l, u, n, s, appURL, required}
+}
+
+// Required reports whether a verified email is needed to log in or to book
+func (v *EmailVerifier) Required(stage string) bool {
+	switch v.required {
+	case VerifyBeforeLogin:
+		return true
+	case VerifyBeforeBooking:
+		return stage == VerifyBeforeBooking
+	}
+	return false
+}
+
+// LoginAllowed reports whether the user may log in, an unverified email keeps them out when it must be verified before login
+func (v *EmailVerifier) LoginAllowed(user *model.User) bool {
+	return !user.EmailUnverified || !v.Required(VerifyBeforeLogin)
+}
+
+// BookingAllowed reports whether the user may book, an unverified email keeps them from it when it must be verified before booking
+func (v *EmailVerifier) BookingAllowed(user *model.User) bool {
+	return !user.EmailUnverified || !v.Required(VerifyBeforeBooking)
+}
+
+// Send emails a link verifying the address to it and records the verification on the user, links sent before
+// stop working. It returns repo.ErrVerificationThrottled if a link was sent within the resend interval.
+func (v *EmailVerifier) Send(user *model.User, email string) error {
+	nonce, err := tokens.Nonce()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	verification := &model.EmailVerification{Email: email, Nonce: nonce, ExpiresAt: now.Add(emailTokenTTL), SentAt: now}
+	if err := v.userRepo.StartVerification(user, verification, now.Add(-resendInterval)); err != nil {
+		return err
+	}
+	user.EmailVerification = verification
+	if email != user.Email {
+		user.PendingEmail = email
+	}
+
+	token := v.signer.Sign(tokens.Claims{Purpose: emailTokenPurpose, Subject: user.ID.Hex(), Nonce: nonce, ExpiresAt: verification.ExpiresAt})
+	data := notifications.Data{
+		"Name":             user.Name,
+		"Email":            email,
+		"Link":             v.link("/email/verify", token),
+		"ExpiresInMinutes": int(emailTokenTTL.Minutes()),
+	}
+	if err := v.notifier.Notify(email, user.Language, notifications.EmailVerificationTemplate, data); err != nil {
+		v.logger.Printf("Unable to queue %s for %s: %v", notifications.EmailVerificationTemplate, email, err)
+		return err
+	}
+	return nil
+}
+
+// Verify checks a link from a verification email and returns the user it was sent to with the verified address
+func (v *EmailVerifier) Verify(token string) (*model.User, *tokens.Claims, error) {
+	claims, err := v.signer.Verify(token, emailTokenPurpose, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := v.userRepo.GetById(claims.Subject)
+	if err != nil || user.EmailVerification == nil || user.EmailVerification.Nonce != claims.Nonce {
+		return nil, nil, repo.ErrTokenUsed
+	}
+	return user, claims, nil
+}
+
+// link is the address of an account email link carrying the token
+func (v *EmailVerifier) link(path string, token string) string {
+	return v.appURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// RequireVerifiedEmail keeps users whose email is not verified from booking when verification is required.
+// It runs after the authorization middleware, which tells who the user is.
+func (v *EmailVerifier) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		if v.Required(VerifyBeforeBooking) {
+			// The authorization middleware puts the email of the logged in user into the header, see CurrentUser
+			user, err := v.userRepo.GetByEmail(h.Header.Get("Email"))
+			if err != nil {
+				http.Error(rw, "User not found", http.StatusUnauthorized)
+				return
+			}
+			if !v.BookingAllowed(user) {
+				http.Error(rw, "Verify your email address before booking", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(rw, h)
+	})
+}
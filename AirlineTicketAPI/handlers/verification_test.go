@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"Rest/tokens"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryUsers keeps users and their verifications in memory the way repo.UserRepo stores them
+type memoryUsers struct {
+	mu    sync.Mutex
+	users map[string]*model.User
+}
+
+func (s *memoryUsers) GetById(id string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryUsers) GetByEmail(email string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *memoryUsers) StartVerification(user *model.User, verification *model.EmailVerification, sentBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.users[user.ID.Hex()]
+	if stored.EmailVerification != nil && !stored.EmailVerification.SentAt.Before(sentBefore) {
+		return repo.ErrVerificationThrottled
+	}
+	copied := *verification
+	stored.EmailVerification = &copied
+	return nil
+}
+
+// confirm does what VerifyEmail has the repo do once a link is verified
+func (s *memoryUsers) confirm(claims *tokens.Claims) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.users[claims.Subject]
+	if stored.EmailVerification == nil || stored.EmailVerification.Nonce != claims.Nonce {
+		return repo.ErrTokenUsed
+	}
+	stored.Email, stored.EmailVerification, stored.EmailUnverified = stored.EmailVerification.Email, nil, false
+	return nil
+}
+
+func (s *memoryUsers) add(email string, unverified bool) *model.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := &model.User{ID: primitive.NewObjectID(), Name: "Ana", Email: email, EmailUnverified: unverified}
+	s.users[user.ID.Hex()] = user
+	copied := *user
+	return &copied
+}
+
+// memoryQueue is a notification queue kept in memory
+type memoryQueue struct {
+	mu            sync.Mutex
+	notifications []*notifications.Notification
+}
+
+func (q *memoryQueue) Enqueue(notification *notifications.Notification) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.notifications = append(q.notifications, notification)
+	return nil
+}
+
+func (q *memoryQueue) ClaimDue(lockFor time.Duration) (*notifications.Notification, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, notification := range q.notifications {
+		if notification.Status == notifications.Pending && !notification.NextAttemptAt.After(time.Now()) {
+			notification.NextAttemptAt = time.Now().Add(lockFor)
+			return notification, nil
+		}
+	}
+	return nil, notifications.ErrQueueEmpty
+}
+
+func (q *memoryQueue) Save(notification *notifications.Notification) error {
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`token=([^\s"<&]+)`)
+
+func newTestVerifier(t *testing.T, required string) (*EmailVerifier, *memoryUsers, *notifications.MemoryTransport) {
+	t.Helper()
+	t.Setenv("APP_URL", "https://app.example.com")
+	t.Setenv("REQUIRE_EMAIL_VERIFICATION", required)
+	logger := log.New(io.Discard, "", 0)
+	users := &memoryUsers{users: map[string]*model.User{}}
+	transport := notifications.NewMemoryTransport()
+	notifier := notifications.NewNotifier(logger, &memoryQueue{}, transport)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	notifier.Start(ctx, 5*time.Millisecond)
+	return NewEmailVerifier(logger, users, notifier, tokens.NewSigner([]byte("test signing key"))), users, transport
+}
+
+// sentTokens waits until the transport sent n emails and returns the tokens of their links
+func sentTokens(t *testing.T, transport *notifications.MemoryTransport, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(transport.Sent()) < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sent := transport.Sent()
+	if len(sent) != n {
+		t.Fatalf("expected %d emails, got %d", n, len(sent))
+	}
+	found := []string{}
+	for _, message := range sent {
+		match := tokenPattern.FindStringSubmatch(message.Text)
+		if match == nil {
+			t.Fatalf("no link in the email to %s: %s", message.To, message.Text)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, token)
+	}
+	return found
+}
+
+func TestVerificationLinkWorksOnce(t *testing.T) {
+	verifier, users, transport := newTestVerifier(t, "")
+	user := users.add("ana@example.com", true)
+
+	if err := verifier.Send(user, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	token := sentTokens(t, transport, 1)[0]
+	if transport.Sent()[0].To != "ana@example.com" {
+		t.Fatalf("link was sent to %s", transport.Sent()[0].To)
+	}
+
+	verified, claims, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.ID != user.ID || verified.EmailVerification.Email != "ana@example.com" {
+		t.Fatalf("link verified %s for user %s", verified.EmailVerification.Email, verified.ID.Hex())
+	}
+	if err := users.confirm(claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := verifier.Verify(token); err != repo.ErrTokenUsed {
+		t.Fatalf("expected a used link to fail with %v, got %v", repo.ErrTokenUsed, err)
+	}
+}
+
+func TestExpiredOrChangedVerificationLinkIsRejected(t *testing.T) {
+	verifier, users, transport := newTestVerifier(t, "")
+	user := users.add("ana@example.com", true)
+	if err := verifier.Send(user, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	token := sentTokens(t, transport, 1)[0]
+
+	// The link as it was sent, a day and a bit later
+	stored, _ := users.GetById(user.ID.Hex())
+	expired := verifier.signer.Sign(tokens.Claims{Purpose: emailTokenPurpose, Subject: user.ID.Hex(), Nonce: stored.EmailVerification.Nonce,
+		ExpiresAt: time.Now().Add(-time.Minute)})
+	if _, _, err := verifier.Verify(expired); err != tokens.ErrExpiredToken {
+		t.Fatalf("expected %v, got %v", tokens.ErrExpiredToken, err)
+	}
+	if _, _, err := verifier.Verify(token + "x"); err != tokens.ErrInvalidToken {
+		t.Fatalf("expected %v for a changed link, got %v", tokens.ErrInvalidToken, err)
+	}
+	// A token signed for something else is no verification link
+	other := verifier.signer.Sign(tokens.Claims{Purpose: "other", Subject: user.ID.Hex(), Nonce: stored.EmailVerification.Nonce,
+		ExpiresAt: time.Now().Add(time.Hour)})
+	if _, _, err := verifier.Verify(other); err != tokens.ErrInvalidToken {
+		t.Fatalf("expected %v for a token of another purpose, got %v", tokens.ErrInvalidToken, err)
+	}
+}
+
+func TestVerificationEmailsAreThrottledAndANewLinkReplacesTheOld(t *testing.T) {
+	verifier, users, transport := newTestVerifier(t, "")
+	user := users.add("ana@example.com", true)
+
+	if err := verifier.Send(user, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Send(user, user.Email); err != repo.ErrVerificationThrottled {
+		t.Fatalf("expected a second email within %s to be throttled, got %v", resendInterval, err)
+	}
+	first := sentTokens(t, transport, 1)[0]
+
+	// Once the resend interval passed a new link goes out and the old one stops working
+	users.mu.Lock()
+	users.users[user.ID.Hex()].EmailVerification.SentAt = time.Now().Add(-resendInterval - time.Second)
+	users.mu.Unlock()
+	if err := verifier.Send(user, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	second := sentTokens(t, transport, 2)[1]
+
+	if _, _, err := verifier.Verify(first); err != repo.ErrTokenUsed {
+		t.Fatalf("expected the replaced link to fail with %v, got %v", repo.ErrTokenUsed, err)
+	}
+	if _, _, err := verifier.Verify(second); err != nil {
+		t.Fatalf("the new link does not work: %v", err)
+	}
+}
+
+func TestRequiredVerificationBlocksLogin(t *testing.T) {
+	cases := map[string]bool{"": true, VerifyNever: true, VerifyBeforeBooking: true, VerifyBeforeLogin: false}
+	for required, allowed := range cases {
+		verifier, users, _ := newTestVerifier(t, required)
+		if verifier.LoginAllowed(users.add("new@example.com", true)) != allowed {
+			t.Errorf("REQUIRE_EMAIL_VERIFICATION=%q: expected an unverified user allowed to log in to be %v", required, allowed)
+		}
+		if !verifier.LoginAllowed(users.add("verified@example.com", false)) {
+			t.Errorf("REQUIRE_EMAIL_VERIFICATION=%q: a verified user cannot log in", required)
+		}
+	}
+}
+
+func TestRequiredVerificationBlocksBooking(t *testing.T) {
+	cases := map[string]int{"": http.StatusForbidden, VerifyNever: http.StatusOK, VerifyBeforeBooking: http.StatusForbidden,
+		VerifyBeforeLogin: http.StatusForbidden}
+	for required, status := range cases {
+		verifier, users, _ := newTestVerifier(t, required)
+		users.add("new@example.com", true)
+		users.add("verified@example.com", false)
+		book := verifier.RequireVerifiedEmail(http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+			rw.WriteHeader(http.StatusOK)
+		}))
+
+		for email, expected := range map[string]int{"new@example.com": status, "verified@example.com": http.StatusOK} {
+			request := httptest.NewRequest(http.MethodPost, "/bookings", nil)
+			request.Header.Set("Email", email)
+			recorder := httptest.NewRecorder()
+			book.ServeHTTP(recorder, request)
+			if recorder.Code != expected {
+				t.Errorf("REQUIRE_EMAIL_VERIFICATION=%q: expected %d booking as %s, got %d", required, expected, email, recorder.Code)
+			}
+		}
+		if !verifier.BookingAllowed(users.add("another@example.com", false)) {
+			t.Errorf("REQUIRE_EMAIL_VERIFICATION=%q: a verified user cannot book", required)
+		}
+	}
+}
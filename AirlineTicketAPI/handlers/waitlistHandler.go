@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"Rest/bus"
+	"Rest/model"
+	"Rest/notifications"
+	"Rest/repo"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How long an instance may take to hold the seats for a claimed entry before another one takes over
+const waitlistClaimTimeout = time.Minute
+
+type WaitlistHandler struct {
+	logger *log.Logger
+
+	repo       *repo.WaitlistRepo
+	flightRepo *repo.FlightRepo
+	userRepo   *repo.UserRepo
+	holds      *HoldHandler
+	flightBus  *bus.Bus
+	notifier   *notifications.Notifier
+	verifier   *EmailVerifier
+}
+
+func NewWaitlistHandler(l *log.Logger, r *repo.WaitlistRepo, f *repo.FlightRepo, u *repo.UserRepo, hh *HoldHandler, b *bus.Bus,
+	n *notifications.Notifier, v *EmailVerifier) *WaitlistHandler {
+	return &WaitlistHandler{l, r, f, u, hh, b, n, v}
+}
+
+// JoinWaitlist puts the logged in user on the waitlist of a flight that does not have enough free seats
+func (w *WaitlistHandler) JoinWaitlist(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	flightId := vars["id"]
+	request := h.Context().Value(KeyProduct{}).(*model.WaitlistRequest)
+
+	bookingRequest, err := request.BookingRequest(flightId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := CurrentUser(w.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	flight, err := w.flightRepo.GetById(flightId)
+	if err != nil {
+		http.Error(rw, "Flight with given id not found", http.StatusNotFound)
+		return
+	}
+	if flight.CurrentStatus() == model.FlightCancelled || !flight.Date.After(time.Now()) {
+		http.Error(rw, "Flight is no longer available", http.StatusConflict)
+		return
+	}
+	seats := bookingRequest.SeatedPassengers()
+	sellable, err := w.holds.bookings.sellable(flight)
+	if err != nil {
+		http.Error(rw, "Unable to check the free seats", http.StatusInternalServerError)
+		return
+	}
+	if sellable >= seats {
+		http.Error(rw, "Flight has enough free seats, book it instead", http.StatusConflict)
+		return
+	}
+
+	entry := model.WaitlistEntry{
+		FlightId:  flightId,
+		FareClass: request.FareClass,
+		UserId:    user.ID.Hex(),
+		Request:   *bookingRequest,
+		Seats:     seats,
+		Priority:  model.TierPriority(user.LoyaltyTier),
+		Status:    model.WaitlistWaiting,
+		CreatedAt: time.Now(),
+	}
+	err = w.repo.Insert(&entry)
+	if err == repo.ErrAlreadyWaitlisted {
+		http.Error(rw, "You are already on the waitlist of this flight", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to join the waitlist", http.StatusInternalServerError)
+		return
+	}
+	entry.Position, _ = w.repo.Position(&entry)
+
+	rw.WriteHeader(http.StatusCreated)
+	entry.ToJSON(rw)
+}
+
+// GetMyWaitlist lists the logged in user's waitlist entries with their position in the queue
+func (w *WaitlistHandler) GetMyWaitlist(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(w.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	entries, err := w.repo.GetByUser(user.ID.Hex())
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range entries {
+		if entry.Status == model.WaitlistWaiting {
+			entry.Position, _ = w.repo.Position(entry)
+		}
+	}
+
+	err = entries.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		w.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (w *WaitlistHandler) LeaveWaitlist(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	user, err := CurrentUser(w.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	entry, err := w.repo.GetById(id)
+	if err != nil || entry.UserId != user.ID.Hex() {
+		http.Error(rw, "Waitlist entry with given id not found", http.StatusNotFound)
+		return
+	}
+
+	err = w.repo.Cancel(entry)
+	if err == repo.ErrWaitlistNotWaiting {
+		http.Error(rw, "Waitlist entry is no longer waiting", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to leave the waitlist", http.StatusInternalServerError)
+		return
+	}
+	entry.ToJSON(rw)
+}
+
+// GetFlightWaitlist shows admins the queue of a flight in the order it is served, ?fareClass= filters it
+func (w *WaitlistHandler) GetFlightWaitlist(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	entries, err := w.repo.GetByFlight(id, h.URL.Query().Get("fareClass"))
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		return
+	}
+	err = entries.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		w.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// Start offers released seats to the waitlist until the context is done. Seat changes published on the flight bus
+// are handled right away, every interval all flights with a waitlist are checked as well so that changes made by
+// other instances are not missed.
+func (w *WaitlistHandler) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		sub, _, _ := w.flightBus.Subscribe(nil, "")
+		defer func() {
+			if sub != nil {
+				w.flightBus.Unsubscribe(sub)
+			}
+		}()
+
+		for {
+			// A nil channel blocks, so a dropped subscription waits for the next tick to subscribe again
+			var updates chan bus.FlightUpdate
+			if sub != nil {
+				updates = sub.C
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-updates:
+				if !ok {
+					sub = nil
+					continue
+				}
+				// Overbooked flights may still have seats to sell below zero, offerSeats checks the limit
+				w.offerSeats(update.FlightId)
+			case <-ticker.C:
+				if sub == nil {
+					sub, _, _ = w.flightBus.Subscribe(nil, "")
+				}
+				w.offerAll()
+			}
+		}
+	}()
+}
+
+func (w *WaitlistHandler) offerAll() {
+	flightIds, err := w.repo.FlightsWaiting()
+	if err != nil {
+		w.logger.Print("Unable to read the waitlist: ", err)
+		return
+	}
+	for _, flightId := range flightIds {
+		w.offerSeats(flightId)
+	}
+}
+
+// offerSeats holds the free seats of the flight for the waitlist entries that fit, in queue order
+func (w *WaitlistHandler) offerSeats(flightId string) {
+	for {
+		flight, err := w.flightRepo.GetById(flightId)
+		if err != nil || flight.CurrentStatus() == model.FlightCancelled || !flight.Date.After(time.Now()) {
+			return
+		}
+		sellable, err := w.holds.bookings.sellable(flight)
+		if err != nil || sellable <= 0 {
+			return
+		}
+
+		entry, err := w.repo.ClaimNext(flightId, sellable, waitlistClaimTimeout)
+		if err == repo.ErrWaitlistEmpty {
+			return
+		}
+		if err != nil {
+			w.logger.Printf("Unable to claim a waitlist entry of flight %s: %v", flightId, err)
+			return
+		}
+
+		// Holds for the waitlist are placed without a request, the verification RequireVerifiedEmail asks for is checked here.
+		// Like a failed hold the entry stays claimed until its lock runs out, so the entries behind it go first.
+		user, err := w.userRepo.GetById(entry.UserId)
+		if err != nil {
+			w.logger.Printf("Owner of waitlist entry %s not found, skipping it: %v", entry.ID.Hex(), err)
+			continue
+		}
+		if !w.verifier.BookingAllowed(user) {
+			w.logger.Printf("Owner of waitlist entry %s cannot book until their email is verified, skipping it", entry.ID.Hex())
+			continue
+		}
+		hold, err := w.holds.placeHold(entry.UserId, &entry.Request)
+		if errors.Is(err, repo.ErrNotEnoughSeats) {
+			// Someone else took the seats first, the entry keeps its place for the next release
+			w.repo.Requeue(entry)
+			return
+		}
+		if err != nil {
+			// The entry stays claimed until its lock runs out and is offered again then, the entries behind it go first
+			w.logger.Printf("Unable to hold seats for waitlist entry %s, skipping it: %v", entry.ID.Hex(), err)
+			continue
+		}
+		if err := w.repo.Offered(entry, hold.ID.Hex()); err != nil {
+			w.logger.Printf("Unable to record the offer of waitlist entry %s: %v", entry.ID.Hex(), err)
+		}
+		w.logger.Printf("Waitlist entry %s got hold %s on flight %s", entry.ID.Hex(), hold.ID.Hex(), flightId)
+		w.notifyOffer(flight, hold)
+	}
+}
+
+func (w *WaitlistHandler) notifyOffer(flight *model.Flight, hold *model.Hold) {
+	user, err := w.userRepo.GetById(hold.UserId)
+	if err != nil {
+		w.logger.Printf("Owner of hold %s not found, waitlist offer not sent", hold.ID.Hex())
+		return
+	}
+	notifyUser(w.logger, w.notifier, user, notifications.WaitlistOfferTemplate, notifications.Data{
+		"From":      flight.From,
+		"To":        flight.To,
+		"Date":      flight.Date.Format(emailDateFormat),
+		"Seats":     hold.Seats,
+		"ExpiresAt": hold.ExpiresAt.Format(emailDateFormat),
+		"HoldId":    hold.ID.Hex(),
+	})
+}
+
+func (w *WaitlistHandler) MiddlewareWaitlistDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		request := &model.WaitlistRequest{}
+		err := request.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			w.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, request)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
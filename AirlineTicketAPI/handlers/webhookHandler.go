@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"Rest/model"
+	"Rest/repo"
+	"Rest/webhooks"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type WebhookHandler struct {
+	logger   *log.Logger
+	repo     *repo.WebhookRepo
+	userRepo *repo.UserRepo
+}
+
+func NewWebhooksHandler(l *log.Logger, r *repo.WebhookRepo, u *repo.UserRepo) *WebhookHandler {
+	return &WebhookHandler{l, r, u}
+}
+
+// CreateSubscription subscribes a partner to event types. The signing secret is generated unless one is given
+// and is only returned in this response.
+func (wh *WebhookHandler) CreateSubscription(rw http.ResponseWriter, h *http.Request) {
+	subscriptionDTO := h.Context().Value(KeyProduct{}).(*webhooks.Subscription)
+	if err := subscriptionDTO.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partner, err := wh.userRepo.GetById(subscriptionDTO.PartnerId)
+	if err != nil {
+		http.Error(rw, "Partner with given id not found", http.StatusBadRequest)
+		return
+	}
+	// Deliveries are read and redelivered on the partner routes, only partners can reach them
+	if partner.Role != model.Partner {
+		http.Error(rw, "User with given id is not a partner", http.StatusBadRequest)
+		return
+	}
+
+	subscription := webhooks.Subscription{PartnerId: subscriptionDTO.PartnerId, URL: subscriptionDTO.URL,
+		Secret: subscriptionDTO.Secret, EventTypes: subscriptionDTO.EventTypes, CreatedAt: time.Now()}
+	if subscription.Secret == "" {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			http.Error(rw, "Unable to generate the secret", http.StatusInternalServerError)
+			wh.logger.Print(err)
+			return
+		}
+		subscription.Secret = secret
+	}
+	if err := wh.repo.InsertSubscription(&subscription); err != nil {
+		http.Error(rw, "Unable to save subscription", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	subscription.ToJSON(rw)
+}
+
+func (wh *WebhookHandler) GetAllSubscriptions(rw http.ResponseWriter, h *http.Request) {
+	subscriptions, err := wh.repo.GetAllSubscriptions()
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		wh.logger.Print("Database exception: ", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	err = subscriptions.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		wh.logger.Print("Unable to convert to json :", err)
+		return
+	}
+}
+
+func (wh *WebhookHandler) DeleteSubscription(rw http.ResponseWriter, h *http.Request) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	if err := wh.repo.DeleteSubscription(id); err != nil {
+		http.Error(rw, "Unable to delete subscription", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeadLetters lists the deliveries of all partners that gave up after webhooks.MaxAttempts
+func (wh *WebhookHandler) GetDeadLetters(rw http.ResponseWriter, h *http.Request) {
+	wh.writeDeliveries(rw, "", webhooks.Dead)
+}
+
+// GetMyDeliveries lists the logged in partner's deliveries, ?status= filters them by status
+func (wh *WebhookHandler) GetMyDeliveries(rw http.ResponseWriter, h *http.Request) {
+	user, err := CurrentUser(wh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return
+	}
+	wh.writeDeliveries(rw, user.ID.Hex(), h.URL.Query().Get("status"))
+}
+
+func (wh *WebhookHandler) GetDelivery(rw http.ResponseWriter, h *http.Request) {
+	delivery, ok := wh.ownDelivery(rw, h)
+	if !ok {
+		return
+	}
+
+	err := delivery.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		wh.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+// Redeliver queues a delivered or dead delivery again, it is sent with the same body and a new signature
+func (wh *WebhookHandler) Redeliver(rw http.ResponseWriter, h *http.Request) {
+	delivery, ok := wh.ownDelivery(rw, h)
+	if !ok {
+		return
+	}
+
+	delivery, err := wh.repo.Redeliver(delivery.ID.Hex())
+	if err == repo.ErrDeliveryNotFound {
+		http.Error(rw, "Delivery is still pending", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "Unable to redeliver", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	delivery.ToJSON(rw)
+}
+
+func (wh *WebhookHandler) writeDeliveries(rw http.ResponseWriter, partnerId string, status string) {
+	deliveries, err := wh.repo.GetDeliveries(partnerId, status)
+	if err != nil {
+		http.Error(rw, "Database exception", http.StatusInternalServerError)
+		wh.logger.Print("Database exception: ", err)
+		return
+	}
+
+	err = deliveries.ToJSON(rw)
+	if err != nil {
+		http.Error(rw, "Unable to convert to json", http.StatusInternalServerError)
+		wh.logger.Print("Unable to convert to json :", err)
+	}
+}
+
+func (wh *WebhookHandler) ownDelivery(rw http.ResponseWriter, h *http.Request) (*webhooks.Delivery, bool) {
+	vars := mux.Vars(h)
+	id := vars["id"]
+
+	user, err := CurrentUser(wh.userRepo, h)
+	if err != nil {
+		http.Error(rw, "User not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	delivery, err := wh.repo.GetDeliveryById(id)
+	if err != nil || delivery.PartnerId != user.ID.Hex() {
+		http.Error(rw, "Delivery with given id not found", http.StatusNotFound)
+		return nil, false
+	}
+	return delivery, true
+}
+
+func (wh *WebhookHandler) MiddlewareSubscriptionDeserialization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, h *http.Request) {
+		subscription := &webhooks.Subscription{}
+		err := subscription.FromJSON(h.Body)
+		if err != nil {
+			http.Error(rw, "Unable to decode json", http.StatusBadRequest)
+			wh.logger.Print(err)
+			return
+		}
+
+		ctx := context.WithValue(h.Context(), KeyProduct{}, subscription)
+		h = h.WithContext(ctx)
+
+		next.ServeHTTP(rw, h)
+	})
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"Rest/bus"
+	"Rest/events"
 	"Rest/handlers"
+	"Rest/notifications"
+	"Rest/payments"
 	"Rest/repo"
+	"Rest/tokens"
+	"Rest/vault"
+	"Rest/webhooks"
 	"context"
 	"log"
 	"net/http"
@@ -42,11 +49,71 @@ func main() {
 	// NoSQL: Checking if the connection was established
 	storeUser.PingUserRepo()
 
+	//NOTIFICATIONS
+	storeNotification, err := repo.NewNotificationRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeNotification.DisconnectNotificationRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeNotification.PingNotificationRepo()
+
+	// Emails are queued in Mongo and sent in the background, a mail outage only delays them
+	notifier := notifications.NewNotifier(logger, storeNotification, notifications.NewTransportFromEnv())
+	notifierContext, stopNotifier := context.WithCancel(context.Background())
+	defer stopNotifier()
+	notifier.Start(notifierContext, 10*time.Second)
+
+	//OUTBOX
+	storeOutbox, err := repo.NewOutboxRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeOutbox.DisconnectOutboxRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeOutbox.PingOutboxRepo()
+
+	//WEBHOOKS
+	storeWebhook, err := repo.NewWebhookRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeWebhook.DisconnectWebhookRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeWebhook.PingWebhookRepo()
+
+	// Partner events from the outbox become signed webhook deliveries, retried in the background
+	dispatcher := webhooks.NewDispatcher(logger, storeWebhook, &http.Client{Timeout: 10 * time.Second})
+	dispatcherContext, stopDispatcher := context.WithCancel(context.Background())
+	defer stopDispatcher()
+	dispatcher.Start(dispatcherContext, 5*time.Second)
+
+	webhookHandlers := handlers.NewWebhooksHandler(logger, storeWebhook, storeUser)
+
+	// Domain events are written to the outbox together with the state change and relayed to the sinks from there
+	relay := events.NewRelay(logger, storeOutbox, append(events.NewSinksFromEnv(logger), dispatcher))
+	relayContext, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	relay.Start(relayContext, time.Second)
+
 	//Initialize the handler and inject said logger
 
-	usersHandler := handlers.NewUsersHandler(logger, storeUser)
+	// Signed single-use links verifying the email of new accounts and of email changes
+	accountSigner, err := tokens.SignerFromEnv()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	emailVerifier := handlers.NewEmailVerifier(logger, storeUser, notifier, accountSigner)
+
+	usersHandler := handlers.NewUsersHandler(logger, storeUser, notifier, emailVerifier)
+
+	// In-process bus publishing flight seat, price and status changes to streaming clients
+	flightBus := bus.New()
 
-	storeFlight, err := repo.NewFlightRepo(timeoutContext, storeLogger)
+	storeFlight, err := repo.NewFlightRepo(timeoutContext, storeLogger, flightBus)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -55,9 +122,27 @@ func main() {
 	// NoSQL: Checking if the connection was established
 	storeFlight.PingFlightRepo()
 
-	//Initialize the handler and inject said logger
+	//FEE RULES
+	storeFeeRule, err := repo.NewFeeRuleRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeFeeRule.DisconnectFeeRuleRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeFeeRule.PingFeeRuleRepo()
+
+	feeHandlers := handlers.NewFeesHandler(logger, storeFeeRule)
+
+	//OVERBOOKING
+	storeOverbooking, err := repo.NewOverbookingRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeOverbooking.DisconnectOverbookingRepo(timeoutContext)
 
-	flightHandlers := handlers.NewFlightsHandler(logger, storeFlight)
+	// NoSQL: Checking if the connection was established
+	storeOverbooking.PingOverbookingRepo()
 
 	//TICKET
 	storeTicket, err := repo.NewTicketRepo(timeoutContext, storeLogger)
@@ -71,6 +156,186 @@ func main() {
 
 	ticketHandlers := handlers.NewTicketsHandler(logger, storeTicket, storeFlight, storeUser)
 
+	// Travel document numbers of travelers and passengers are encrypted at rest
+	documentVault, err := vault.FromEnv()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	//BOOKINGS
+	storeBooking, err := repo.NewBookingRepo(timeoutContext, storeLogger, documentVault)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeBooking.DisconnectBookingRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeBooking.PingBookingRepo()
+
+	//ANCILLARIES
+	storeAncillary, err := repo.NewAncillaryRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeAncillary.DisconnectAncillaryRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeAncillary.PingAncillaryRepo()
+
+	//PROMOTIONS
+	storePromotion, err := repo.NewPromotionRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storePromotion.DisconnectPromotionRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storePromotion.PingPromotionRepo()
+
+	promotionHandlers := handlers.NewPromotionsHandler(logger, storePromotion)
+
+	flightHandlers := handlers.NewFlightsHandler(logger, storeFlight, storeFeeRule, storeBooking, storeUser, notifier, storeAncillary, storePromotion)
+
+	storeRefund, err := repo.NewRefundRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeRefund.DisconnectRefundRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeRefund.PingRefundRepo()
+
+	//PAYMENTS
+	storePayment, err := repo.NewPaymentRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storePayment.DisconnectPaymentRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storePayment.PingPaymentRepo()
+
+	// Mock gateway until a real payment provider is configured
+	provider := payments.NewMockProvider()
+
+	//LOYALTY
+	storeLoyalty, err := repo.NewLoyaltyRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeLoyalty.DisconnectLoyaltyRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeLoyalty.PingLoyaltyRepo()
+
+	loyaltyHandlers := handlers.NewLoyaltyHandler(logger, storeLoyalty, storeUser, storeFlight, storeBooking, flightBus)
+
+	// Background job crediting the points of flown segments
+	loyaltyContext, stopLoyalty := context.WithCancel(context.Background())
+	defer stopLoyalty()
+	loyaltyHandlers.Start(loyaltyContext, time.Hour)
+
+	bookingHandlers := handlers.NewBookingsHandler(logger, storeBooking, storeFlight, storeUser, storeFeeRule, storeRefund, storePayment, provider, notifier, storeOverbooking, storeAncillary,
+		storePromotion, storeLoyalty)
+
+	//HOLDS
+	storeHold, err := repo.NewHoldRepo(timeoutContext, storeLogger, documentVault)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeHold.DisconnectHoldRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeHold.PingHoldRepo()
+
+	//TRAVELERS
+	storeTraveler, err := repo.NewTravelerRepo(timeoutContext, storeLogger, documentVault)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeTraveler.DisconnectTravelerRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeTraveler.PingTravelerRepo()
+
+	travelerHandlers := handlers.NewTravelersHandler(logger, storeTraveler, storeUser)
+
+	holdHandlers := handlers.NewHoldsHandler(logger, storeHold, storeFlight, storeUser, bookingHandlers, storePayment, provider, storeTraveler,
+		storeTicket)
+
+	// Background job giving back the seats of expired holds
+	reaperContext, stopReaper := context.WithCancel(context.Background())
+	defer stopReaper()
+	holdHandlers.StartReaper(reaperContext, 30*time.Second)
+
+	//WAITLIST
+	storeWaitlist, err := repo.NewWaitlistRepo(timeoutContext, storeLogger, documentVault)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeWaitlist.DisconnectWaitlistRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeWaitlist.PingWaitlistRepo()
+
+	waitlistHandlers := handlers.NewWaitlistHandler(logger, storeWaitlist, storeFlight, storeUser, holdHandlers, flightBus, notifier, emailVerifier)
+
+	// Background job holding released seats for the first customers on the waitlist
+	waitlistContext, stopWaitlist := context.WithCancel(context.Background())
+	defer stopWaitlist()
+	waitlistHandlers.Start(waitlistContext, time.Minute)
+
+	//CHECK-IN
+	storeCheckIn, err := repo.NewCheckInRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeCheckIn.DisconnectCheckInRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeCheckIn.PingCheckInRepo()
+
+	accountHandlers := handlers.NewAccountsHandler(logger, storeUser, storeBooking, storeFlight, notifier, emailVerifier)
+
+	//APIS
+	storeApis, err := repo.NewApisRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeApis.DisconnectApisRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeApis.PingApisRepo()
+
+	apisHandlers := handlers.NewApisHandler(logger, storeApis, storeFlight, storeCheckIn, bookingHandlers)
+
+	// Background job writing the passenger manifests of international flights once their check-in closed
+	apisContext, stopApis := context.WithCancel(context.Background())
+	defer stopApis()
+	apisHandlers.Start(apisContext, time.Minute)
+
+	checkInHandlers := handlers.NewCheckInsHandler(logger, storeCheckIn, storeFlight, bookingHandlers, apisHandlers)
+
+	exchangeHandlers := handlers.NewExchangesHandler(logger, storeCheckIn, bookingHandlers, holdHandlers)
+
+	ancillaryHandlers := handlers.NewAncillariesHandler(logger, storeAncillary, storeFlight, bookingHandlers, holdHandlers)
+
+	overbookingHandlers := handlers.NewOverbookingHandler(logger, storeOverbooking, storeFlight, storeCheckIn, bookingHandlers)
+
+	disruptionHandlers := handlers.NewDisruptionsHandler(logger, storeFlight, storeBooking, storeTicket, bookingHandlers)
+
+	//IDEMPOTENCY
+	storeIdempotency, err := repo.NewIdempotencyRepo(timeoutContext, storeLogger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer storeIdempotency.DisconnectIdempotencyRepo(timeoutContext)
+
+	// NoSQL: Checking if the connection was established
+	storeIdempotency.PingIdempotencyRepo()
+
+	idempotencyHandlers := handlers.NewIdempotencyHandler(logger, storeIdempotency)
+
 	//Initialize the router and add a middleware for all the requests
 	router := mux.NewRouter()
 
@@ -81,16 +346,75 @@ func main() {
 	registerUserRouter.HandleFunc("/registration", usersHandler.RegisterUser)
 	registerUserRouter.Use(usersHandler.MiddlewareUserDeserialization)
 
+	//Lookups return the whole user, only admins may make them
 	getByEmailRouter := router.Methods(http.MethodGet).Subrouter()
 	getByEmailRouter.HandleFunc("/existsEmail/{email}", usersHandler.GetUserByEmail)
+	getByEmailRouter.Use(usersHandler.IsAuthorizedAdmin)
 
 	getByUsernameRouter := router.Methods(http.MethodGet).Subrouter()
 	getByUsernameRouter.HandleFunc("/existsUsername/{username}", usersHandler.GetUserByUsername)
+	getByUsernameRouter.Use(usersHandler.IsAuthorizedAdmin)
 
 	//Login
 	loginUserRouter := router.Methods(http.MethodPost).Subrouter()
 	loginUserRouter.HandleFunc("/login", usersHandler.LoginUser)
 	loginUserRouter.Use(usersHandler.MiddlewareAuthDeserialization)
+	//account of the logged in user
+	getMeRouter := router.Methods(http.MethodGet).Subrouter()
+	getMeRouter.HandleFunc("/me", accountHandlers.GetMe)
+	getMeRouter.Use(usersHandler.IsAuthorizedUser)
+
+	updateMeRouter := router.Methods(http.MethodPost).Subrouter()
+	updateMeRouter.HandleFunc("/me", accountHandlers.UpdateMe)
+	updateMeRouter.Use(accountHandlers.MiddlewareProfileUpdateDeserialization)
+	updateMeRouter.Use(usersHandler.IsAuthorizedUser)
+
+	changePasswordRouter := router.Methods(http.MethodPost).Subrouter()
+	changePasswordRouter.HandleFunc("/me/password", accountHandlers.ChangePassword)
+	changePasswordRouter.Use(accountHandlers.MiddlewarePasswordChangeDeserialization)
+	changePasswordRouter.Use(usersHandler.IsAuthorizedUser)
+
+	deleteMeRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteMeRouter.HandleFunc("/me/delete", accountHandlers.DeleteMe)
+	deleteMeRouter.Use(accountHandlers.MiddlewareAccountDeletionDeserialization)
+	deleteMeRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//links from the email verification messages
+	verifyEmailRouter := router.Methods(http.MethodGet).Subrouter()
+	verifyEmailRouter.HandleFunc("/email/verify", accountHandlers.VerifyEmail)
+
+	resendVerificationRouter := router.Methods(http.MethodPost).Subrouter()
+	resendVerificationRouter.HandleFunc("/email/resend", accountHandlers.ResendVerification)
+	resendVerificationRouter.Use(accountHandlers.MiddlewareEmailAddressDeserialization)
+
+	//forgotten password, the reset link from the email carries the token
+	forgotPasswordRouter := router.Methods(http.MethodPost).Subrouter()
+	forgotPasswordRouter.HandleFunc("/password/forgot", accountHandlers.ForgotPassword)
+	forgotPasswordRouter.Use(accountHandlers.MiddlewareEmailAddressDeserialization)
+
+	resetPasswordPageRouter := router.Methods(http.MethodGet).Subrouter()
+	resetPasswordPageRouter.HandleFunc("/password/reset", accountHandlers.ResetPasswordPage)
+
+	resetPasswordRouter := router.Methods(http.MethodPost).Subrouter()
+	resetPasswordRouter.HandleFunc("/password/reset", accountHandlers.ResetPassword)
+	resetPasswordRouter.Use(accountHandlers.MiddlewareNewPasswordDeserialization)
+
+	//user management
+	getUserRouter := router.Methods(http.MethodGet).Subrouter()
+	getUserRouter.HandleFunc("/admin/get-user/{id}", usersHandler.GetUserById)
+	getUserRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	updateUserRouter := router.Methods(http.MethodPost).Subrouter()
+	updateUserRouter.HandleFunc("/admin/update-user/{id}", usersHandler.UpdateUser)
+	updateUserRouter.Use(usersHandler.MiddlewareUserDeserialization)
+	updateUserRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//operations staff and partners register like customers, then an admin gives them their role
+	setUserRoleRouter := router.Methods(http.MethodPost).Subrouter()
+	setUserRoleRouter.HandleFunc("/admin/set-user-role/{id}", usersHandler.SetUserRole)
+	setUserRoleRouter.Use(usersHandler.MiddlewareRoleAssignmentDeserialization)
+	setUserRoleRouter.Use(usersHandler.IsAuthorizedAdmin)
+
 	//Proba autorizacije
 	probaautRouter := router.Methods(http.MethodPost).Subrouter()
 	probaautRouter.HandleFunc("/proba", usersHandler.ProbaAut)
@@ -103,8 +427,12 @@ func main() {
 	createFlightRouter.Use(usersHandler.IsAuthorizedAdmin)
 	//delete flight
 	deleteFlightRouter := router.Methods(http.MethodPost).Subrouter()
-	deleteFlightRouter.HandleFunc("/admin/delete-flight/{id}", flightHandlers.DeleteFlight)
+	deleteFlightRouter.HandleFunc("/admin/delete-flight/{id}", disruptionHandlers.DeleteFlight)
 	deleteFlightRouter.Use(usersHandler.IsAuthorizedAdmin)
+	//cancel flight, affected bookings get rebooked or refunded
+	cancelFlightRouter := router.Methods(http.MethodPost).Subrouter()
+	cancelFlightRouter.HandleFunc("/admin/cancel-flight/{id}", disruptionHandlers.CancelFlight)
+	cancelFlightRouter.Use(usersHandler.IsAuthorizedAdmin)
 	//get flight
 	getAllFlightsRouter := router.Methods(http.MethodGet).Subrouter()
 	getAllFlightsRouter.HandleFunc("/admin/get-all-flights", flightHandlers.GetAllFlights)
@@ -116,15 +444,51 @@ func main() {
 	getFlightByIdRouter := router.Methods(http.MethodGet).Subrouter()
 	getFlightByIdRouter.HandleFunc("/get-flight-byId/{id}", flightHandlers.GetFlightById)
 
+	//flight status, gate and terminal
+	flightStatusRouter := router.Methods(http.MethodPost).Subrouter()
+	flightStatusRouter.HandleFunc("/ops/flights/{id}/status", flightHandlers.UpdateFlightStatus)
+	flightStatusRouter.Use(flightHandlers.MiddlewareStatusDeserialization)
+	flightStatusRouter.Use(usersHandler.IsAuthorizedOps)
+
+	staffFlightRouter := router.Methods(http.MethodGet).Subrouter()
+	staffFlightRouter.HandleFunc("/ops/flights/{id}", flightHandlers.GetStaffFlight)
+	staffFlightRouter.Use(usersHandler.IsAuthorizedOps)
+
+	//stream of seat, price and status changes
+	streamHandlers := handlers.NewStreamHandler(logger, flightBus)
+	streamFlightsRouter := router.Methods(http.MethodGet).Subrouter()
+	streamFlightsRouter.HandleFunc("/flights/stream", streamHandlers.StreamFlights)
+
+	//quote flight with taxes and fees
+	quoteFlightRouter := router.Methods(http.MethodPost).Subrouter()
+	quoteFlightRouter.HandleFunc("/quote", flightHandlers.QuoteFlight)
+	quoteFlightRouter.Use(flightHandlers.MiddlewareQuoteDeserialization)
+
+	//FEE RULES
+	createFeeRuleRouter := router.Methods(http.MethodPost).Subrouter()
+	createFeeRuleRouter.HandleFunc("/admin/create-fee-rule", feeHandlers.CreateFeeRule)
+	createFeeRuleRouter.Use(feeHandlers.MiddlewareFeeRuleDeserialization)
+	createFeeRuleRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	getAllFeeRulesRouter := router.Methods(http.MethodGet).Subrouter()
+	getAllFeeRulesRouter.HandleFunc("/admin/get-all-fee-rules", feeHandlers.GetAllFeeRules)
+	getAllFeeRulesRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	deleteFeeRuleRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteFeeRuleRouter.HandleFunc("/admin/delete-fee-rule/{id}", feeHandlers.DeleteFeeRule)
+	deleteFeeRuleRouter.Use(usersHandler.IsAuthorizedAdmin)
+
 	//getAllFlightsRouter.Use(flightHandlers.MiddlewareFlightDeserialization)
 	//deleteFlightRouter.Use(usersHandler.IsAuthorizedAdmin)
 
 	//TICKETS
-	//Buy tickets
+	//Buy tickets, the seats are held and paid through checkout like a booking
 	createTicketRouter := router.Methods(http.MethodPost).Subrouter()
-	createTicketRouter.HandleFunc("/user/create-ticket", ticketHandlers.CreateTicket)
+	createTicketRouter.HandleFunc("/user/create-ticket", holdHandlers.CreateTicket)
+	createTicketRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
 	createTicketRouter.Use(ticketHandlers.MiddlewareTicketDeserialization)
 	createTicketRouter.Use(usersHandler.IsAuthorizedUser)
+	createTicketRouter.Use(emailVerifier.RequireVerifiedEmail)
 
 	//Get tickets for user
 	getTicketForUserRouter := router.Methods(http.MethodPost).Subrouter()
@@ -132,12 +496,300 @@ func main() {
 	getTicketForUserRouter.Use(ticketHandlers.MiddlewareTicketDeserialization)
 	getTicketForUserRouter.Use(usersHandler.IsAuthorizedUser)
 
+	//Invoice for a bought ticket
+	getInvoiceRouter := router.Methods(http.MethodGet).Subrouter()
+	getInvoiceRouter.HandleFunc("/user/get-invoice/{id}", ticketHandlers.GetInvoice)
+	getInvoiceRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//BOOKINGS
+	//Retried booking, hold and payment requests with the same Idempotency-Key get the first response replayed,
+	//the middleware goes first so that it reads the body before the deserialization does
+	//Create booking with passengers, paid with the card in the request
+	createBookingRouter := router.Methods(http.MethodPost).Subrouter()
+	createBookingRouter.HandleFunc("/bookings", holdHandlers.CreateBooking)
+	createBookingRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	createBookingRouter.Use(bookingHandlers.MiddlewareBookingDeserialization)
+	createBookingRouter.Use(usersHandler.IsAuthorizedUser)
+	createBookingRouter.Use(emailVerifier.RequireVerifiedEmail)
+
+	//Bookings of the logged in user
+	getMyBookingsRouter := router.Methods(http.MethodGet).Subrouter()
+	getMyBookingsRouter.HandleFunc("/bookings", bookingHandlers.GetMyBookings)
+	getMyBookingsRouter.HandleFunc("/bookings/{id:[0-9a-f]{24}}", bookingHandlers.GetBookingById)
+	getMyBookingsRouter.HandleFunc("/bookings/{id:[0-9a-f]{24}}/receipt", bookingHandlers.GetReceipt)
+	getMyBookingsRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//Hold seats during checkout, then confirm into a booking
+	createHoldRouter := router.Methods(http.MethodPost).Subrouter()
+	createHoldRouter.HandleFunc("/holds", holdHandlers.CreateHold)
+	createHoldRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	createHoldRouter.Use(bookingHandlers.MiddlewareBookingDeserialization)
+	createHoldRouter.Use(usersHandler.IsAuthorizedUser)
+	createHoldRouter.Use(emailVerifier.RequireVerifiedEmail)
+
+	confirmHoldRouter := router.Methods(http.MethodPost).Subrouter()
+	confirmHoldRouter.HandleFunc("/holds/{id}/confirm", holdHandlers.ConfirmHold)
+	confirmHoldRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	confirmHoldRouter.Use(holdHandlers.MiddlewareCardDeserialization)
+	confirmHoldRouter.Use(usersHandler.IsAuthorizedUser)
+	confirmHoldRouter.Use(emailVerifier.RequireVerifiedEmail)
+
+	releaseHoldRouter := router.Methods(http.MethodPost).Subrouter()
+	releaseHoldRouter.HandleFunc("/holds/{id}/release", holdHandlers.ReleaseHold)
+	releaseHoldRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	releaseHoldRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//PAYMENTS
+	//Answer the 3-D Secure challenge of a payment
+	challengeRouter := router.Methods(http.MethodPost).Subrouter()
+	challengeRouter.HandleFunc("/payments/{id}/3ds", holdHandlers.CompleteChallenge)
+	challengeRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	challengeRouter.Use(holdHandlers.MiddlewareChallengeDeserialization)
+	challengeRouter.Use(usersHandler.IsAuthorizedUser)
+	challengeRouter.Use(emailVerifier.RequireVerifiedEmail)
+
+	getPaymentRouter := router.Methods(http.MethodGet).Subrouter()
+	getPaymentRouter.HandleFunc("/payments/{id}", holdHandlers.GetPayment)
+	getPaymentRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//Cancel booking, fare rules decide the refund
+	cancelBookingRouter := router.Methods(http.MethodPost).Subrouter()
+	cancelBookingRouter.HandleFunc("/bookings/{id}/cancel", bookingHandlers.CancelBooking)
+	cancelBookingRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	cancelBookingRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//Admin cancel without penalty
+	adminCancelBookingRouter := router.Methods(http.MethodPost).Subrouter()
+	adminCancelBookingRouter.HandleFunc("/admin/bookings/{id}/cancel", bookingHandlers.AdminCancelBooking)
+	adminCancelBookingRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	adminCancelBookingRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//Resolve a booking disrupted by a flight cancellation
+	disruptedBookingRouter := router.Methods(http.MethodPost).Subrouter()
+	disruptedBookingRouter.HandleFunc("/bookings/{id}/disruption/rebook", disruptionHandlers.RebookBooking)
+	disruptedBookingRouter.HandleFunc("/bookings/{id}/disruption/refund", disruptionHandlers.RefundBooking)
+	disruptedBookingRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	disruptedBookingRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//Lookup by locator and surname, no login needed
+	lookupBookingRouter := router.Methods(http.MethodGet).Subrouter()
+	lookupBookingRouter.HandleFunc("/bookings/lookup", bookingHandlers.LookupBooking)
+
+	//saved traveler profiles of the logged in user
+	getTravelersRouter := router.Methods(http.MethodGet).Subrouter()
+	getTravelersRouter.HandleFunc("/travelers", travelerHandlers.GetMyTravelers)
+	getTravelersRouter.HandleFunc("/travelers/{id}", travelerHandlers.GetTravelerById)
+	getTravelersRouter.Use(usersHandler.IsAuthorizedUser)
+
+	saveTravelerRouter := router.Methods(http.MethodPost).Subrouter()
+	saveTravelerRouter.HandleFunc("/travelers", travelerHandlers.CreateTraveler)
+	saveTravelerRouter.HandleFunc("/travelers/{id}/update", travelerHandlers.UpdateTraveler)
+	saveTravelerRouter.Use(travelerHandlers.MiddlewareTravelerDeserialization)
+	saveTravelerRouter.Use(usersHandler.IsAuthorizedUser)
+
+	deleteTravelerRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteTravelerRouter.HandleFunc("/travelers/{id}/delete", travelerHandlers.DeleteTraveler)
+	deleteTravelerRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//frequent-flyer account of the logged in user
+	loyaltyRouter := router.Methods(http.MethodGet).Subrouter()
+	loyaltyRouter.HandleFunc("/loyalty", loyaltyHandlers.GetMyAccount)
+	loyaltyRouter.HandleFunc("/loyalty/ledger", loyaltyHandlers.GetMyLedger)
+	loyaltyRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//waitlist for sold out flights
+	joinWaitlistRouter := router.Methods(http.MethodPost).Subrouter()
+	joinWaitlistRouter.HandleFunc("/flights/{id}/waitlist", waitlistHandlers.JoinWaitlist)
+	joinWaitlistRouter.Use(waitlistHandlers.MiddlewareWaitlistDeserialization)
+	joinWaitlistRouter.Use(usersHandler.IsAuthorizedUser)
+	joinWaitlistRouter.Use(emailVerifier.RequireVerifiedEmail)
+
+	getMyWaitlistRouter := router.Methods(http.MethodGet).Subrouter()
+	getMyWaitlistRouter.HandleFunc("/waitlist", waitlistHandlers.GetMyWaitlist)
+	getMyWaitlistRouter.Use(usersHandler.IsAuthorizedUser)
+
+	leaveWaitlistRouter := router.Methods(http.MethodPost).Subrouter()
+	leaveWaitlistRouter.HandleFunc("/waitlist/{id}/cancel", waitlistHandlers.LeaveWaitlist)
+	leaveWaitlistRouter.Use(usersHandler.IsAuthorizedUser)
+
+	getFlightWaitlistRouter := router.Methods(http.MethodGet).Subrouter()
+	getFlightWaitlistRouter.HandleFunc("/admin/flights/{id}/waitlist", waitlistHandlers.GetFlightWaitlist)
+	getFlightWaitlistRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//online check-in and boarding passes of the logged in user's booking
+	checkInRouter := router.Methods(http.MethodPost).Subrouter()
+	checkInRouter.HandleFunc("/bookings/{id}/check-in", checkInHandlers.CheckIn)
+	checkInRouter.Use(checkInHandlers.MiddlewareCheckInDeserialization)
+	checkInRouter.Use(usersHandler.IsAuthorizedUser)
+
+	boardingPassRouter := router.Methods(http.MethodGet).Subrouter()
+	boardingPassRouter.HandleFunc("/bookings/{id}/boarding-passes", checkInHandlers.GetBoardingPasses)
+	boardingPassRouter.HandleFunc("/bookings/{id}/boarding-passes/{checkInId}/barcode", checkInHandlers.GetBoardingPassBarcode)
+	boardingPassRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//gate agents scan boarding passes
+	scanBoardingPassRouter := router.Methods(http.MethodPost).Subrouter()
+	scanBoardingPassRouter.HandleFunc("/ops/boarding/scan", checkInHandlers.ScanBoardingPass)
+	scanBoardingPassRouter.Use(checkInHandlers.MiddlewareBoardingScanDeserialization)
+	scanBoardingPassRouter.Use(usersHandler.IsAuthorizedOps)
+
+	flightBoardingRouter := router.Methods(http.MethodGet).Subrouter()
+	flightBoardingRouter.HandleFunc("/ops/flights/{id}/boarding", checkInHandlers.GetFlightBoarding)
+	flightBoardingRouter.Use(usersHandler.IsAuthorizedOps)
+
+	//exchange a booked flight for another flight on the same route
+	exchangeRouter := router.Methods(http.MethodPost).Subrouter()
+	exchangeRouter.HandleFunc("/bookings/{id}/exchange/quote", exchangeHandlers.QuoteExchange)
+	exchangeRouter.HandleFunc("/bookings/{id}/exchange", exchangeHandlers.ExchangeBooking)
+	exchangeRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	exchangeRouter.Use(exchangeHandlers.MiddlewareExchangeDeserialization)
+	exchangeRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//ancillary catalog and ancillaries bought after booking
+	createAncillaryRouter := router.Methods(http.MethodPost).Subrouter()
+	createAncillaryRouter.HandleFunc("/admin/create-ancillary", ancillaryHandlers.CreateAncillary)
+	createAncillaryRouter.Use(ancillaryHandlers.MiddlewareAncillaryDeserialization)
+	createAncillaryRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	getAllAncillariesRouter := router.Methods(http.MethodGet).Subrouter()
+	getAllAncillariesRouter.HandleFunc("/admin/get-all-ancillaries", ancillaryHandlers.GetAllAncillaries)
+	getAllAncillariesRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	deleteAncillaryRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteAncillaryRouter.HandleFunc("/admin/delete-ancillary/{id}", ancillaryHandlers.DeleteAncillary)
+	deleteAncillaryRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	flightAncillariesRouter := router.Methods(http.MethodGet).Subrouter()
+	flightAncillariesRouter.HandleFunc("/flights/{id}/ancillaries", ancillaryHandlers.GetFlightAncillaries)
+
+	buyAncillariesRouter := router.Methods(http.MethodPost).Subrouter()
+	buyAncillariesRouter.HandleFunc("/bookings/{id}/ancillaries", ancillaryHandlers.BuyAncillaries)
+	buyAncillariesRouter.Use(idempotencyHandlers.MiddlewareIdempotency)
+	buyAncillariesRouter.Use(ancillaryHandlers.MiddlewareAncillaryRequestDeserialization)
+	buyAncillariesRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//promo codes of marketing campaigns
+	createPromotionRouter := router.Methods(http.MethodPost).Subrouter()
+	createPromotionRouter.HandleFunc("/admin/create-promotion", promotionHandlers.CreatePromotion)
+	createPromotionRouter.Use(promotionHandlers.MiddlewarePromotionDeserialization)
+	createPromotionRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	getPromotionsRouter := router.Methods(http.MethodGet).Subrouter()
+	getPromotionsRouter.HandleFunc("/admin/get-all-promotions", promotionHandlers.GetAllPromotions)
+	getPromotionsRouter.HandleFunc("/admin/promotions/report", promotionHandlers.GetRedemptionReport)
+	getPromotionsRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	deletePromotionRouter := router.Methods(http.MethodPost).Subrouter()
+	deletePromotionRouter.HandleFunc("/admin/delete-promotion/{id}", promotionHandlers.DeletePromotion)
+	deletePromotionRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//airport countries and travel document rules between countries
+	createAirportRouter := router.Methods(http.MethodPost).Subrouter()
+	createAirportRouter.HandleFunc("/admin/create-airport", apisHandlers.CreateAirport)
+	createAirportRouter.Use(apisHandlers.MiddlewareAirportDeserialization)
+	createAirportRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	createDocumentRuleRouter := router.Methods(http.MethodPost).Subrouter()
+	createDocumentRuleRouter.HandleFunc("/admin/create-document-rule", apisHandlers.CreateDocumentRule)
+	createDocumentRuleRouter.Use(apisHandlers.MiddlewareDocumentRuleDeserialization)
+	createDocumentRuleRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	getAllApisRouter := router.Methods(http.MethodGet).Subrouter()
+	getAllApisRouter.HandleFunc("/admin/get-all-airports", apisHandlers.GetAllAirports)
+	getAllApisRouter.HandleFunc("/admin/get-all-document-rules", apisHandlers.GetAllDocumentRules)
+	getAllApisRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	deleteApisRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteApisRouter.HandleFunc("/admin/delete-airport/{id}", apisHandlers.DeleteAirport)
+	deleteApisRouter.HandleFunc("/admin/delete-document-rule/{id}", apisHandlers.DeleteDocumentRule)
+	deleteApisRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//advance passenger information of the logged in user's bookings
+	apiStatusRouter := router.Methods(http.MethodGet).Subrouter()
+	apiStatusRouter.HandleFunc("/bookings/{id}/api-data", apisHandlers.GetApiStatus)
+	apiStatusRouter.Use(usersHandler.IsAuthorizedUser)
+
+	apiDataRouter := router.Methods(http.MethodPost).Subrouter()
+	apiDataRouter.HandleFunc("/bookings/{id}/passengers/{passengerId}/api-data", apisHandlers.UpdateApiData)
+	apiDataRouter.Use(apisHandlers.MiddlewareApiDataDeserialization)
+	apiDataRouter.Use(usersHandler.IsAuthorizedUser)
+
+	//passenger manifests for the authorities
+	getManifestRouter := router.Methods(http.MethodGet).Subrouter()
+	getManifestRouter.HandleFunc("/ops/flights/{id}/manifest", apisHandlers.GetManifest)
+	getManifestRouter.Use(usersHandler.IsAuthorizedOps)
+
+	exportManifestRouter := router.Methods(http.MethodPost).Subrouter()
+	exportManifestRouter.HandleFunc("/ops/flights/{id}/manifest", apisHandlers.ExportManifest)
+	exportManifestRouter.Use(usersHandler.IsAuthorizedOps)
+
+	//overbooking limits per route and per flight
+	createOverbookingRuleRouter := router.Methods(http.MethodPost).Subrouter()
+	createOverbookingRuleRouter.HandleFunc("/admin/create-overbooking-rule", overbookingHandlers.CreateOverbookingRule)
+	createOverbookingRuleRouter.Use(overbookingHandlers.MiddlewareOverbookingRuleDeserialization)
+	createOverbookingRuleRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	getAllOverbookingRulesRouter := router.Methods(http.MethodGet).Subrouter()
+	getAllOverbookingRulesRouter.HandleFunc("/admin/get-all-overbooking-rules", overbookingHandlers.GetAllOverbookingRules)
+	getAllOverbookingRulesRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	deleteOverbookingRuleRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteOverbookingRuleRouter.HandleFunc("/admin/delete-overbooking-rule/{id}", overbookingHandlers.DeleteOverbookingRule)
+	deleteOverbookingRuleRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	flightOverbookingRouter := router.Methods(http.MethodPost).Subrouter()
+	flightOverbookingRouter.HandleFunc("/admin/flights/{id}/overbooking", overbookingHandlers.SetFlightOverbooking)
+	flightOverbookingRouter.Use(overbookingHandlers.MiddlewareOverbookingSettingsDeserialization)
+	flightOverbookingRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//denied boarding on oversold flights
+	volunteerRouter := router.Methods(http.MethodPost).Subrouter()
+	volunteerRouter.HandleFunc("/bookings/{id}/volunteer", overbookingHandlers.Volunteer)
+	volunteerRouter.Use(overbookingHandlers.MiddlewareVolunteerDeserialization)
+	volunteerRouter.Use(usersHandler.IsAuthorizedUser)
+
+	oversaleRouter := router.Methods(http.MethodGet).Subrouter()
+	oversaleRouter.HandleFunc("/ops/flights/{id}/oversale", overbookingHandlers.GetOversale)
+	oversaleRouter.HandleFunc("/ops/flights/{id}/denied-boarding", overbookingHandlers.GetDeniedBoardings)
+	oversaleRouter.Use(usersHandler.IsAuthorizedOps)
+
+	denyBoardingRouter := router.Methods(http.MethodPost).Subrouter()
+	denyBoardingRouter.HandleFunc("/ops/flights/{id}/denied-boarding", overbookingHandlers.DenyBoarding)
+	denyBoardingRouter.Use(overbookingHandlers.MiddlewareDeniedBoardingDeserialization)
+	denyBoardingRouter.Use(usersHandler.IsAuthorizedOps)
+
+	//partner webhook subscriptions, managed by admins
+	createWebhookRouter := router.Methods(http.MethodPost).Subrouter()
+	createWebhookRouter.HandleFunc("/admin/webhooks", webhookHandlers.CreateSubscription)
+	createWebhookRouter.Use(webhookHandlers.MiddlewareSubscriptionDeserialization)
+	createWebhookRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	getWebhooksRouter := router.Methods(http.MethodGet).Subrouter()
+	getWebhooksRouter.HandleFunc("/admin/webhooks", webhookHandlers.GetAllSubscriptions)
+	getWebhooksRouter.HandleFunc("/admin/webhooks/dead-letters", webhookHandlers.GetDeadLetters)
+	getWebhooksRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	deleteWebhookRouter := router.Methods(http.MethodPost).Subrouter()
+	deleteWebhookRouter.HandleFunc("/admin/webhooks/{id}/delete", webhookHandlers.DeleteSubscription)
+	deleteWebhookRouter.Use(usersHandler.IsAuthorizedAdmin)
+
+	//delivery log of the logged in partner
+	getDeliveriesRouter := router.Methods(http.MethodGet).Subrouter()
+	getDeliveriesRouter.HandleFunc("/partner/webhooks/deliveries", webhookHandlers.GetMyDeliveries)
+	getDeliveriesRouter.HandleFunc("/partner/webhooks/deliveries/{id}", webhookHandlers.GetDelivery)
+	getDeliveriesRouter.Use(usersHandler.IsAuthorizedPartner)
+
+	redeliverRouter := router.Methods(http.MethodPost).Subrouter()
+	redeliverRouter.HandleFunc("/partner/webhooks/deliveries/{id}/redeliver", webhookHandlers.Redeliver)
+	redeliverRouter.Use(usersHandler.IsAuthorizedPartner)
+
 	//
 	headersOk := gorillaHandlers.AllowedHeaders([]string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization",
-		"accept", "origin", "Cache-Control", "X-Requested-With"})
+		"accept", "origin", "Cache-Control", "X-Requested-With", "Last-Event-ID", "Idempotency-Key"})
+	exposedOk := gorillaHandlers.ExposedHeaders([]string{"Idempotent-Replayed"})
 	originsOk := gorillaHandlers.AllowedOrigins([]string{"*"})
 	methodsOk := gorillaHandlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
-	cors := gorillaHandlers.CORS(headersOk, originsOk, methodsOk)
+	cors := gorillaHandlers.CORS(headersOk, exposedOk, originsOk, methodsOk)
 	//Initialize the server
 	server := http.Server{
 		Addr:         ":" + port,
@@ -146,6 +798,8 @@ func main() {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	// Ends open flight streams, otherwise Shutdown would wait for them until the timeout
+	server.RegisterOnShutdown(flightBus.Close)
 
 	logger.Println("Server listening on port", port)
 	//Distribute all the connections to goroutines
@@ -156,7 +810,7 @@ func main() {
 		}
 	}()
 
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt)
 	signal.Notify(sigCh, os.Kill)
 
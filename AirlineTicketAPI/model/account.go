@@ -0,0 +1,188 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Passwords are at least MinPasswordLength characters, bcrypt only looks at the first MaxPasswordLength bytes
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// AnonymizedName replaces the names of passengers and users whose account was deleted
+const AnonymizedName = "DELETED"
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()/-]{6,20}$`)
+
+// EmailVerification is the outstanding verification of an email address, the token sent for it carries Nonce
+type EmailVerification struct {
+	Email     string    `bson:"email" json:"email"`
+	Nonce     string    `bson:"nonce" json:"-"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	SentAt    time.Time `bson:"sentAt" json:"sentAt"`
+}
+
+// PasswordReset is the outstanding reset of a forgotten password, only the hash of the token sent for it is stored
+type PasswordReset struct {
+	TokenHash string    `bson:"tokenHash" json:"-"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	SentAt    time.Time `bson:"sentAt" json:"sentAt"`
+}
+
+// ProfileUpdate changes the profile of the logged in user, fields left out keep their value
+type ProfileUpdate struct {
+	Name        string     `json:"name"`
+	Surname     string     `json:"surname"`
+	PhoneNumber string     `json:"phoneNumber"`
+	Email       string     `json:"email"`
+	BirthDate   *time.Time `json:"birthdate"`
+	Language    string     `json:"language"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// NewPassword sets the password with the token from a password reset email
+type NewPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// AccountDeletion confirms the deletion of the account with its password
+type AccountDeletion struct {
+	Password string `json:"password"`
+}
+
+// RoleAssignment gives a user one of the AssignableRoles, staff and partners register like customers and an admin promotes them
+type RoleAssignment struct {
+	Role string `json:"role"`
+}
+
+// AssignableRoles are the roles an admin can give by name, the names are the roles carried by login tokens
+var AssignableRoles = map[string]Role{
+	"USER":    Client,
+	"OPS":     Ops,
+	"PARTNER": Partner,
+}
+
+// EmailAddress asks for something to be sent to the address, such as a new verification link
+type EmailAddress struct {
+	Email string `json:"email"`
+}
+
+// EmailToken is a token from a link sent by email
+type EmailToken struct {
+	Token string `json:"token"`
+}
+
+// ValidatePassword enforces the password policy: 8 to 72 characters with at least one letter and one digit
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return errors.New("password must be 8 to 72 characters long")
+	}
+	letter, digit := false, false
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		return errors.New("password must contain a letter and a digit")
+	}
+	return nil
+}
+
+// ValidEmail reports whether the value is a bare email address
+func ValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+func (u *ProfileUpdate) Validate() error {
+	u.Email = strings.TrimSpace(u.Email)
+	u.PhoneNumber = strings.TrimSpace(u.PhoneNumber)
+	if u.Email != "" && !ValidEmail(u.Email) {
+		return errors.New("email is not a valid email address")
+	}
+	if u.PhoneNumber != "" && !phonePattern.MatchString(u.PhoneNumber) {
+		return errors.New("phoneNumber is not a valid phone number")
+	}
+	if u.BirthDate != nil && u.BirthDate.After(time.Now()) {
+		return errors.New("invalid birthdate")
+	}
+	return nil
+}
+
+// Apply puts the changed fields on the user, a new email only becomes pending
+func (u *ProfileUpdate) Apply(user *User) {
+	if strings.TrimSpace(u.Name) != "" {
+		user.Name = strings.TrimSpace(u.Name)
+	}
+	if strings.TrimSpace(u.Surname) != "" {
+		user.Surname = strings.TrimSpace(u.Surname)
+	}
+	if u.PhoneNumber != "" {
+		user.PhoneNumber = u.PhoneNumber
+	}
+	if u.BirthDate != nil {
+		user.BirthDate = *u.BirthDate
+	}
+	if u.Language != "" {
+		user.Language = u.Language
+	}
+	if u.Email != "" && u.Email != user.Email {
+		user.PendingEmail = u.Email
+	}
+}
+
+// Anonymize removes the personal data of the passengers, the fares and payments stay for the accounts
+func (b *Booking) Anonymize() {
+	for i := range b.Passengers {
+		p := &b.Passengers[i]
+		*p = Passenger{ID: p.ID, Type: p.Type, Name: AnonymizedName, Surname: AnonymizedName}
+	}
+}
+
+func (u *ProfileUpdate) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(u)
+}
+
+func (c *PasswordChange) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(c)
+}
+
+func (p *NewPassword) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(p)
+}
+
+func (a *AccountDeletion) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(a)
+}
+
+func (t *EmailToken) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(t)
+}
+
+func (a *RoleAssignment) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(a)
+}
+
+func (e *EmailAddress) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(e)
+}
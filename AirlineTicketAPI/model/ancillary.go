@@ -0,0 +1,217 @@
+package model
+
+import (
+	"Rest/payments"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AncillaryKind string
+
+const (
+	AncillaryBaggage  AncillaryKind = "baggage"
+	AncillaryMeal     AncillaryKind = "meal"
+	AncillaryPriority AncillaryKind = "priority_boarding"
+	AncillarySeat     AncillaryKind = "seat"
+)
+
+// AncillaryFee is the fare line kind of ancillaries bought with a flight
+const AncillaryFee FeeKind = "ancillary"
+
+// Ancillary is a product sold on top of a seat. It is offered on one flight when FlightId is set,
+// otherwise on every flight of the route, an empty From or To matches every airport.
+// Inventory limits how many are sold per flight, zero means unlimited.
+type Ancillary struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind      AncillaryKind      `bson:"kind" json:"kind"`
+	Code      string             `bson:"code" json:"code"`
+	Name      string             `bson:"name" json:"name"`
+	Price     float64            `bson:"price" json:"price"`
+	From      string             `bson:"from,omitempty" json:"from,omitempty"`
+	To        string             `bson:"to,omitempty" json:"to,omitempty"`
+	FlightId  string             `bson:"flightId,omitempty" json:"flightId,omitempty"`
+	Inventory int                `bson:"inventory" json:"inventory"`
+	// Remaining is left on the flight the catalog was asked for, it is never stored
+	Remaining *int `bson:"-" json:"remaining,omitempty"`
+}
+
+type Ancillaries []*Ancillary
+
+// AncillarySelection asks for Quantity of an ancillary for one passenger on one flight of the booking
+type AncillarySelection struct {
+	AncillaryId string `bson:"ancillaryId" json:"ancillaryId"`
+	FlightId    string `bson:"flightId" json:"flightId"`
+	PassengerId string `bson:"passengerId" json:"passengerId"`
+	Quantity    int    `bson:"quantity" json:"quantity"`
+}
+
+// AncillaryItem is an ancillary bought for a passenger, priced at the time it was bought
+type AncillaryItem struct {
+	AncillarySelection `bson:",inline"`
+	Kind               AncillaryKind `bson:"kind" json:"kind"`
+	Code               string        `bson:"code" json:"code"`
+	Name               string        `bson:"name" json:"name"`
+	Price              float64       `bson:"price" json:"price"`
+	Amount             float64       `bson:"amount" json:"amount"`
+	// PaymentId is set on ancillaries bought after the booking, they are paid separately
+	PaymentId   string    `bson:"paymentId,omitempty" json:"paymentId,omitempty"`
+	PurchasedAt time.Time `bson:"purchasedAt" json:"purchasedAt"`
+}
+
+// AncillaryRequest buys ancillaries for a confirmed booking, the card pays for them
+type AncillaryRequest struct {
+	Ancillaries []AncillarySelection `json:"ancillaries"`
+	Card        *payments.Card       `json:"card,omitempty"`
+}
+
+func (a *Ancillary) Validate() error {
+	switch a.Kind {
+	case AncillaryBaggage, AncillaryMeal, AncillaryPriority, AncillarySeat:
+	default:
+		return errors.New("kind must be baggage, meal, priority_boarding or seat")
+	}
+	if a.Code == "" || a.Name == "" {
+		return errors.New("code and name are required")
+	}
+	if a.Price < 0 || a.Inventory < 0 {
+		return errors.New("price and inventory cannot be negative")
+	}
+	return nil
+}
+
+// OfferedOn reports whether the ancillary can be bought for the flight
+func (a *Ancillary) OfferedOn(flight *Flight) bool {
+	if a.FlightId != "" {
+		return a.FlightId == flight.ID.Hex()
+	}
+	return (a.From == "" || a.From == flight.From) && (a.To == "" || a.To == flight.To)
+}
+
+// Find returns the ancillary of the catalog with the given id
+func (catalog Ancillaries) Find(id string) *Ancillary {
+	for _, a := range catalog {
+		if a.ID.Hex() == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// Price turns the selections made for the flight into priced items, selections for other flights are skipped.
+// Passengers are checked only when they are given.
+func (catalog Ancillaries) Price(selections []AncillarySelection, flight *Flight, passengers []Passenger, now time.Time) ([]AncillaryItem, error) {
+	items := []AncillaryItem{}
+	for _, selection := range selections {
+		if selection.FlightId != flight.ID.Hex() {
+			continue
+		}
+		ancillary := catalog.Find(selection.AncillaryId)
+		if ancillary == nil || !ancillary.OfferedOn(flight) {
+			return nil, fmt.Errorf("ancillary %s is not offered on flight %s", selection.AncillaryId, selection.FlightId)
+		}
+		if selection.Quantity < 1 {
+			return nil, fmt.Errorf("ancillary %s: quantity must be at least 1", selection.AncillaryId)
+		}
+		if passengers != nil && !hasPassenger(passengers, selection.PassengerId) {
+			return nil, fmt.Errorf("ancillary %s: passenger %s is not on the booking", selection.AncillaryId, selection.PassengerId)
+		}
+		items = append(items, AncillaryItem{
+			AncillarySelection: selection,
+			Kind:               ancillary.Kind,
+			Code:               ancillary.Code,
+			Name:               ancillary.Name,
+			Price:              ancillary.Price,
+			Amount:             roundAmount(ancillary.Price * float64(selection.Quantity)),
+			PurchasedAt:        now,
+		})
+	}
+	return items, nil
+}
+
+func hasPassenger(passengers []Passenger, id string) bool {
+	for _, p := range passengers {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAncillaries puts the items on the fare as lines of their own and adds them to its total
+func (b *FareBreakdown) AddAncillaries(items []AncillaryItem) {
+	for _, item := range items {
+		description := item.Name
+		if item.PassengerId != "" {
+			description += " (" + item.PassengerId + ")"
+		}
+		if item.Quantity > 1 {
+			description += fmt.Sprintf(" x%d", item.Quantity)
+		}
+		b.Ancillaries = append(b.Ancillaries, FareLine{Kind: AncillaryFee, Code: item.Code, Description: description, Amount: item.Amount})
+		b.Total += item.Amount
+	}
+	b.Total = roundAmount(b.Total)
+}
+
+// AncillaryTotal is what the ancillaries on the fare cost together
+func (b *FareBreakdown) AncillaryTotal() float64 {
+	total := 0.0
+	for _, line := range b.Ancillaries {
+		total += line.Amount
+	}
+	return roundAmount(total)
+}
+
+// AddAncillaries records ancillaries bought after the booking on their segments and in the booking total
+func (b *Booking) AddAncillaries(items []AncillaryItem) {
+	for i := range b.Segments {
+		segmentItems := []AncillaryItem{}
+		for _, item := range items {
+			if item.FlightId == b.Segments[i].FlightId {
+				segmentItems = append(segmentItems, item)
+			}
+		}
+		if len(segmentItems) > 0 && b.Segments[i].Fare != nil {
+			before := b.Segments[i].Fare.Total
+			b.Segments[i].Fare.AddAncillaries(segmentItems)
+			b.Total = roundAmount(b.Total + b.Segments[i].Fare.Total - before)
+		}
+	}
+	b.Ancillaries = append(b.Ancillaries, items...)
+}
+
+// AncillariesOn lists the selections of the items bought for the flight
+func (b *Booking) AncillariesOn(flightId string) []AncillarySelection {
+	selections := []AncillarySelection{}
+	for _, item := range b.Ancillaries {
+		if item.FlightId == flightId {
+			selections = append(selections, item.AncillarySelection)
+		}
+	}
+	return selections
+}
+
+func (a *Ancillary) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(a)
+}
+
+func (a *Ancillary) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(a)
+}
+
+func (a *Ancillaries) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(a)
+}
+
+func (r *AncillaryRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
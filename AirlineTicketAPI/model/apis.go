@@ -0,0 +1,332 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AnyCountry in a document rule matches every country
+const AnyCountry = "*"
+
+// Passenger data a document rule can ask for, listed when it is missing
+const (
+	ApiDocument       = "document"
+	ApiDocumentType   = "document type"
+	ApiNationality    = "nationality"
+	ApiGender         = "gender"
+	ApiDocumentExpiry = "document expiry"
+)
+
+// Airport ties an airport code used on flights to the ISO 3166 three letter code of its country
+type Airport struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code    string             `bson:"code" json:"code"`
+	Name    string             `bson:"name" json:"name"`
+	Country string             `bson:"country" json:"country"`
+}
+
+type Airports []*Airport
+
+// DocumentRule is the Advance Passenger Information (APIS) authorities require for flights between two countries.
+// Rules with AnyCountry only apply to international flights, domestic flights need a rule naming their country twice.
+// Documents lists the accepted document types, any document is accepted when it is empty.
+// MinValidityDays is how long after departure the document must still be valid.
+type DocumentRule struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FromCountry        string             `bson:"fromCountry" json:"fromCountry"`
+	ToCountry          string             `bson:"toCountry" json:"toCountry"`
+	Documents          []string           `bson:"documents,omitempty" json:"documents,omitempty"`
+	RequireNationality bool               `bson:"requireNationality" json:"requireNationality"`
+	RequireGender      bool               `bson:"requireGender" json:"requireGender"`
+	RequireExpiry      bool               `bson:"requireExpiry" json:"requireExpiry"`
+	MinValidityDays    int                `bson:"minValidityDays,omitempty" json:"minValidityDays,omitempty"`
+}
+
+type DocumentRules []*DocumentRule
+
+// ApiData is the passport or identity card data of one passenger sent for the authorities
+type ApiData struct {
+	Document       string     `json:"document"`
+	DocumentType   string     `json:"documentType"`
+	Nationality    string     `json:"nationality"`
+	DocumentExpiry *time.Time `json:"documentExpiry"`
+	Gender         string     `json:"gender"`
+}
+
+// PassengerApiStatus lists what a passenger of a booking is still missing for one of its flights
+type PassengerApiStatus struct {
+	FlightId    string   `json:"flightId"`
+	PassengerId string   `json:"passengerId"`
+	Required    bool     `json:"required"`
+	Missing     []string `json:"missing,omitempty"`
+}
+
+type PassengerApiStatuses []*PassengerApiStatus
+
+// Manifest is the passenger list of a flight reported to the authorities of the countries it flies between
+type Manifest struct {
+	Flight      *Flight             `json:"flight"`
+	FromCountry string              `json:"fromCountry"`
+	ToCountry   string              `json:"toCountry"`
+	Passengers  []ManifestPassenger `json:"passengers"`
+	CreatedAt   time.Time           `json:"createdAt"`
+}
+
+// ManifestPassenger is a ticketed passenger of the flight, Seat is empty until they check in
+type ManifestPassenger struct {
+	Passenger
+	Locator   string   `json:"locator"`
+	Seat      string   `json:"seat,omitempty"`
+	CheckedIn bool     `json:"checkedIn"`
+	Missing   []string `json:"missing,omitempty"`
+}
+
+// ManifestExport tells where a manifest was written and how many of its passengers lack API data
+type ManifestExport struct {
+	FlightId   string    `json:"flightId"`
+	Format     string    `json:"format"`
+	File       string    `json:"file"`
+	Passengers int       `json:"passengers"`
+	Incomplete int       `json:"incomplete"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+func (a *Airport) Validate() error {
+	a.Code = strings.ToUpper(strings.TrimSpace(a.Code))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	if !airportCodePattern.MatchString(a.Code) {
+		return errors.New("code must be a three letter IATA airport code")
+	}
+	if !countryCodePattern.MatchString(a.Country) {
+		return errors.New("country must be a three letter country code")
+	}
+	return nil
+}
+
+// Code returns the IATA code of the airport a flight names by its code or its name, empty if the airport is not known
+func (airports Airports) Code(place string) string {
+	for _, airport := range airports {
+		if strings.EqualFold(airport.Code, place) || strings.EqualFold(airport.Name, strings.TrimSpace(place)) {
+			return airport.Code
+		}
+	}
+	return ""
+}
+
+// Country returns the country of the airport, empty if the airport is not known
+func (airports Airports) Country(code string) string {
+	for _, airport := range airports {
+		if strings.EqualFold(airport.Code, code) {
+			return airport.Country
+		}
+	}
+	return ""
+}
+
+func (r *DocumentRule) Validate() error {
+	r.FromCountry = strings.ToUpper(strings.TrimSpace(r.FromCountry))
+	r.ToCountry = strings.ToUpper(strings.TrimSpace(r.ToCountry))
+	for _, country := range []string{r.FromCountry, r.ToCountry} {
+		if country != AnyCountry && !countryCodePattern.MatchString(country) {
+			return errors.New("countries must be three letter country codes or *")
+		}
+	}
+	for _, document := range r.Documents {
+		if document != PassportDocument && document != IdCardDocument {
+			return errors.New("documents must be passport or id_card")
+		}
+	}
+	if r.MinValidityDays < 0 {
+		return errors.New("minValidityDays cannot be negative")
+	}
+	return nil
+}
+
+// Match returns the rule for a flight between the countries, nil if no data is required.
+// A rule naming a country wins over one matching it with AnyCountry.
+func (rules DocumentRules) Match(fromCountry string, toCountry string) *DocumentRule {
+	if fromCountry == "" || toCountry == "" {
+		return nil
+	}
+	var match *DocumentRule
+	best := -1
+	for _, rule := range rules {
+		score := 0
+		switch rule.FromCountry {
+		case fromCountry:
+			score += 2
+		case AnyCountry:
+		default:
+			continue
+		}
+		switch rule.ToCountry {
+		case toCountry:
+			score += 2
+		case AnyCountry:
+		default:
+			continue
+		}
+		if fromCountry == toCountry && score < 4 {
+			continue
+		}
+		if score > best {
+			match, best = rule, score
+		}
+	}
+	return match
+}
+
+// Missing lists the data the passenger still needs for a flight departing at the given time
+func (r *DocumentRule) Missing(p *Passenger, departure time.Time) []string {
+	missing := []string{}
+	if r == nil {
+		return missing
+	}
+	if strings.TrimSpace(p.Document) == "" {
+		missing = append(missing, ApiDocument)
+	}
+	if p.DocumentType == "" {
+		missing = append(missing, ApiDocumentType)
+	} else if len(r.Documents) > 0 && !contains(r.Documents, p.DocumentType) {
+		missing = append(missing, fmt.Sprintf("%s (%s is not accepted, use %s)", ApiDocumentType, p.DocumentType, strings.Join(r.Documents, " or ")))
+	}
+	if r.RequireNationality && p.Nationality == "" {
+		missing = append(missing, ApiNationality)
+	}
+	if r.RequireGender && p.Gender == "" {
+		missing = append(missing, ApiGender)
+	}
+	if (r.RequireExpiry || r.MinValidityDays > 0) && p.DocumentExpiry == nil {
+		missing = append(missing, ApiDocumentExpiry)
+	} else if p.DocumentExpiry != nil && p.DocumentExpiry.Before(departure.AddDate(0, 0, r.MinValidityDays)) {
+		if r.MinValidityDays == 0 {
+			missing = append(missing, ApiDocumentExpiry+" (document expires before departure)")
+		} else {
+			missing = append(missing, fmt.Sprintf("%s (document must be valid %d days after departure)", ApiDocumentExpiry, r.MinValidityDays))
+		}
+	}
+	return missing
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the data and upper-cases its codes and document number
+func (d *ApiData) Validate() error {
+	d.Document = strings.ToUpper(strings.ReplaceAll(d.Document, " ", ""))
+	d.Nationality = strings.ToUpper(d.Nationality)
+	d.Gender = strings.ToUpper(d.Gender)
+	if !documentNumberPattern.MatchString(d.Document) {
+		return errors.New("document must be 5 to 20 letters and digits")
+	}
+	if d.DocumentType != PassportDocument && d.DocumentType != IdCardDocument {
+		return errors.New("documentType must be passport or id_card")
+	}
+	if d.Nationality != "" && !countryCodePattern.MatchString(d.Nationality) {
+		return errors.New("nationality must be a three letter country code")
+	}
+	if d.Gender != "" && !genderPattern.MatchString(d.Gender) {
+		return errors.New("gender must be M, F or X")
+	}
+	return nil
+}
+
+// Apply puts the data on the passenger, values left out keep what the passenger had
+func (d *ApiData) Apply(p *Passenger) {
+	p.Document = d.Document
+	p.DocumentType = d.DocumentType
+	if d.Nationality != "" {
+		p.Nationality = d.Nationality
+	}
+	if d.DocumentExpiry != nil {
+		p.DocumentExpiry = d.DocumentExpiry
+	}
+	if d.Gender != "" {
+		p.Gender = d.Gender
+	}
+}
+
+// MarshalJSON writes the document number in full, the manifest is what the authorities get
+func (p ManifestPassenger) MarshalJSON() ([]byte, error) {
+	type passenger Passenger
+	return json.Marshal(struct {
+		passenger
+		Locator   string   `json:"locator"`
+		Seat      string   `json:"seat,omitempty"`
+		CheckedIn bool     `json:"checkedIn"`
+		Missing   []string `json:"missing,omitempty"`
+	}{passenger(p.Passenger), p.Locator, p.Seat, p.CheckedIn, p.Missing})
+}
+
+// Incomplete counts the passengers of the manifest that lack required data
+func (m *Manifest) Incomplete() int {
+	n := 0
+	for _, p := range m.Passengers {
+		if len(p.Missing) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *Airport) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(a)
+}
+
+func (a *Airport) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(a)
+}
+
+func (a *Airports) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(a)
+}
+
+func (r *DocumentRule) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (r *DocumentRule) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (r *DocumentRules) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (d *ApiData) FromJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	return dec.Decode(d)
+}
+
+func (s *PassengerApiStatuses) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(s)
+}
+
+func (e *ManifestExport) ToJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(e)
+}
+
+func (m *Manifest) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(m)
+}
@@ -0,0 +1,383 @@
+package model
+
+import (
+	"Rest/payments"
+	"Rest/vault"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PassengerType string
+
+const (
+	AdultPassenger  PassengerType = "adult"
+	ChildPassenger  PassengerType = "child"
+	InfantPassenger PassengerType = "infant"
+)
+
+const (
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+)
+
+const (
+	CouponOpen      = "open"
+	CouponCancelled = "cancelled"
+)
+
+// LocatorAlphabet leaves out characters that are easily mixed up when read over the phone (0/O, 1/I)
+const LocatorAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const LocatorLength = 6
+
+type Passenger struct {
+	ID          string        `bson:"id" json:"id"`
+	Name        string        `bson:"name" json:"name"`
+	Surname     string        `bson:"surname" json:"surname"`
+	DateOfBirth time.Time     `bson:"dateOfBirth" json:"dateOfBirth"`
+	Document    string        `bson:"document" json:"document"`
+	Type        PassengerType `bson:"type" json:"type"`
+	// TravelerId selects a saved traveler profile, its data fills in whatever is not given on the passenger
+	TravelerId     string     `bson:"travelerId,omitempty" json:"travelerId,omitempty"`
+	Gender         string     `bson:"gender,omitempty" json:"gender,omitempty"`
+	DocumentType   string     `bson:"documentType,omitempty" json:"documentType,omitempty"`
+	Nationality    string     `bson:"nationality,omitempty" json:"nationality,omitempty"`
+	DocumentExpiry *time.Time `bson:"documentExpiry,omitempty" json:"documentExpiry,omitempty"`
+	FrequentFlyer  string     `bson:"frequentFlyer,omitempty" json:"frequentFlyer,omitempty"`
+}
+
+// MarshalJSON masks the document number, only the API manifests for the authorities carry it in full
+func (p Passenger) MarshalJSON() ([]byte, error) {
+	type passenger Passenger
+	masked := passenger(p)
+	masked.Document = vault.Mask(p.Document)
+	return json.Marshal(masked)
+}
+
+// Coupon is the ticket of one passenger for one segment of the booking
+type Coupon struct {
+	Number      string `bson:"number" json:"number"`
+	PassengerId string `bson:"passengerId" json:"passengerId"`
+	FlightId    string `bson:"flightId" json:"flightId"`
+	Status      string `bson:"status" json:"status"`
+}
+
+// Segment keeps the fare and fare rules the flight had when it was booked
+type Segment struct {
+	FlightId  string         `bson:"flightId" json:"flightId"`
+	FareClass string         `bson:"fareClass,omitempty" json:"fareClass,omitempty"`
+	Fare      *FareBreakdown `bson:"fare" json:"fare"`
+	Rules     *FareRules     `bson:"fareRules,omitempty" json:"fareRules,omitempty"`
+}
+
+// Booking (PNR) groups the passengers travelling together, identified by its record locator
+type Booking struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Locator     string             `bson:"locator" json:"locator"`
+	UserId      string             `bson:"userId" json:"userId"`
+	Passengers  []Passenger        `bson:"passengers" json:"passengers"`
+	Segments    []Segment          `bson:"segments" json:"segments"`
+	Coupons     []Coupon           `bson:"coupons" json:"coupons"`
+	Total       float64            `bson:"total" json:"total"`
+	Currency    string             `bson:"currency" json:"currency"`
+	PaymentId   string             `bson:"paymentId,omitempty" json:"paymentId,omitempty"`
+	Status      string             `bson:"status" json:"status"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	CancelledAt *time.Time         `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	Ancillaries []AncillaryItem    `bson:"ancillaries,omitempty" json:"ancillaries,omitempty"`
+	PromoCodes  []string           `bson:"promoCodes,omitempty" json:"promoCodes,omitempty"`
+	// Disruptions flag the segments whose flight was cancelled, one for every cancelled flight
+	Disruptions []Disruption `bson:"disruptions,omitempty" json:"disruptions,omitempty"`
+	// Changes lists every exchange of a segment for another flight, oldest first
+	Changes []Change `bson:"changes,omitempty" json:"changes,omitempty"`
+	// Flights shows the current operational status of every segment, it is never stored
+	Flights []FlightStatus `bson:"-" json:"flights,omitempty"`
+}
+
+const (
+	DisruptionPending  = ""
+	DisruptionRebooked = "rebooked"
+	DisruptionRefunded = "refunded"
+)
+
+// Disruption flags a booking or ticket on a cancelled flight until the passenger is rebooked or refunded
+type Disruption struct {
+	FlightId         string     `bson:"flightId" json:"flightId"`
+	Reason           string     `bson:"reason" json:"reason"`
+	FlaggedAt        time.Time  `bson:"flaggedAt" json:"flaggedAt"`
+	Resolution       string     `bson:"resolution" json:"resolution"`
+	RebookedFlightId string     `bson:"rebookedFlightId,omitempty" json:"rebookedFlightId,omitempty"`
+	ResolvedAt       *time.Time `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+}
+
+type Bookings []*Booking
+
+// BookingSummary is what anyone knowing the locator and a passenger's surname sees of a booking,
+// documents, dates of birth and payment details are left out
+type BookingSummary struct {
+	Locator    string             `json:"locator"`
+	Status     string             `json:"status"`
+	Passengers []PassengerSummary `json:"passengers"`
+	Segments   []SegmentSummary   `json:"segments"`
+}
+
+type PassengerSummary struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Surname string        `json:"surname"`
+	Type    PassengerType `json:"type"`
+}
+
+// SegmentSummary shows the flight of a segment and whether its passengers can check in online now
+type SegmentSummary struct {
+	FlightStatus
+	FareClass       string    `json:"fareClass,omitempty"`
+	CheckInOpensAt  time.Time `json:"checkInOpensAt"`
+	CheckInClosesAt time.Time `json:"checkInClosesAt"`
+	CheckInOpen     bool      `json:"checkInOpen"`
+}
+
+type BookingRequest struct {
+	FlightIds     []string    `bson:"flightIds" json:"flightIds"`
+	Passengers    []Passenger `bson:"passengers" json:"passengers"`
+	SelectedSeats int         `bson:"selectedSeats" json:"selectedSeats"`
+	CheckedBags   int         `bson:"checkedBags" json:"checkedBags"`
+	// FareClass is the booking class of every flight, economy (Y) if it is not given
+	FareClass string `bson:"fareClass" json:"fareClass"`
+	// Ancillaries refer to the passengers by their position, P1 is the first passenger
+	Ancillaries []AncillarySelection `bson:"ancillaries,omitempty" json:"ancillaries,omitempty"`
+	PromoCodes  []string             `bson:"promoCodes,omitempty" json:"promoCodes,omitempty"`
+	// PayWithPoints makes an award booking, the base fares are paid with loyalty points
+	PayWithPoints bool `bson:"payWithPoints,omitempty" json:"payWithPoints,omitempty"`
+	// Points were taken from the loyalty account when the seats were reserved
+	Points int `bson:"points,omitempty" json:"-"`
+	// Ticket requests come from POST /user/create-ticket, their checkout also issues a ticket for the booking
+	Ticket bool `bson:"ticket,omitempty" json:"-"`
+	// Card pays for the booking, it is never stored
+	Card *payments.Card `bson:"-" json:"card,omitempty"`
+}
+
+// Validate checks the passenger list and numbers the passengers P1, P2, ...
+func (r *BookingRequest) Validate() error {
+	if len(r.FlightIds) == 0 {
+		return errors.New("at least one flight is required")
+	}
+	seen := map[string]bool{}
+	for _, id := range r.FlightIds {
+		if seen[id] {
+			return errors.New("a flight can appear only once in a booking")
+		}
+		seen[id] = true
+	}
+	if len(r.Passengers) == 0 {
+		return errors.New("at least one passenger is required")
+	}
+	if r.SelectedSeats < 0 || r.CheckedBags < 0 || r.SelectedSeats > len(r.Passengers) {
+		return errors.New("invalid number of seats or bags")
+	}
+	if r.FareClass == "" {
+		r.FareClass = DefaultFareClass
+	}
+	if !fareClassPattern.MatchString(r.FareClass) {
+		return errors.New("fareClass must be a single booking class letter")
+	}
+	r.PromoCodes = NormalizeCodes(r.PromoCodes)
+
+	adults, infants := 0, 0
+	for i := range r.Passengers {
+		p := &r.Passengers[i]
+		p.ID = fmt.Sprintf("P%d", i+1)
+		if strings.TrimSpace(p.Name) == "" || strings.TrimSpace(p.Surname) == "" {
+			return fmt.Errorf("passenger %d: name and surname are required", i+1)
+		}
+		if p.DateOfBirth.IsZero() || p.DateOfBirth.After(time.Now()) {
+			return fmt.Errorf("passenger %d: invalid date of birth", i+1)
+		}
+		switch p.Type {
+		case AdultPassenger:
+			adults++
+		case ChildPassenger:
+		case InfantPassenger:
+			infants++
+		default:
+			return fmt.Errorf("passenger %d: type must be adult, child or infant", i+1)
+		}
+	}
+	if adults == 0 {
+		return errors.New("at least one adult passenger is required")
+	}
+	if infants > adults {
+		return errors.New("every infant must travel with an adult")
+	}
+	for _, selection := range r.Ancillaries {
+		if !seen[selection.FlightId] {
+			return fmt.Errorf("ancillary %s is for a flight that is not booked", selection.AncillaryId)
+		}
+		if !hasPassenger(r.Passengers, selection.PassengerId) {
+			return fmt.Errorf("ancillary %s is for an unknown passenger", selection.AncillaryId)
+		}
+		if selection.Quantity < 1 {
+			return fmt.Errorf("ancillary %s: quantity must be at least 1", selection.AncillaryId)
+		}
+	}
+	return nil
+}
+
+// SeatedPassengers is the number of passengers occupying a seat, infants travel on an adult's lap
+func (r *BookingRequest) SeatedPassengers() int {
+	return seated(r.Passengers)
+}
+
+// FareRules returns the rules the segment was sold under, DefaultFareRules for bookings from before fare rules existed
+func (s *Segment) FareRules() FareRules {
+	if s.Rules == nil {
+		return DefaultFareRules
+	}
+	return *s.Rules
+}
+
+func (b *Booking) SeatedPassengers() int {
+	return seated(b.Passengers)
+}
+
+func seated(passengers []Passenger) int {
+	n := 0
+	for _, p := range passengers {
+		if p.Type != InfantPassenger {
+			n++
+		}
+	}
+	return n
+}
+
+// HasPassenger reports whether any passenger on the booking carries the given surname
+func (b *Booking) HasPassenger(surname string) bool {
+	for _, p := range b.Passengers {
+		if strings.EqualFold(strings.TrimSpace(p.Surname), strings.TrimSpace(surname)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Booking) Passenger(id string) *Passenger {
+	for i := range b.Passengers {
+		if b.Passengers[i].ID == id {
+			return &b.Passengers[i]
+		}
+	}
+	return nil
+}
+
+// Coupon returns the passenger's coupon for the flight
+func (b *Booking) Coupon(passengerId string, flightId string) *Coupon {
+	for i := range b.Coupons {
+		if b.Coupons[i].PassengerId == passengerId && b.Coupons[i].FlightId == flightId {
+			return &b.Coupons[i]
+		}
+	}
+	return nil
+}
+
+func (b *Booking) HasSegment(flightId string) bool {
+	for _, segment := range b.Segments {
+		if segment.FlightId == flightId {
+			return true
+		}
+	}
+	return false
+}
+
+// MoveSegment points the segment and coupons flown on one flight to another flight
+func (b *Booking) MoveSegment(fromFlightId string, toFlightId string) {
+	for i := range b.Segments {
+		if b.Segments[i].FlightId == fromFlightId {
+			b.Segments[i].FlightId = toFlightId
+		}
+	}
+	for i := range b.Coupons {
+		if b.Coupons[i].FlightId == fromFlightId {
+			b.Coupons[i].FlightId = toFlightId
+		}
+	}
+	for i := range b.Ancillaries {
+		if b.Ancillaries[i].FlightId == fromFlightId {
+			b.Ancillaries[i].FlightId = toFlightId
+		}
+	}
+}
+
+// PendingDisruption returns the unresolved disruption of the flight, of any flight if flightId is empty
+func (b *Booking) PendingDisruption(flightId string) *Disruption {
+	for i := range b.Disruptions {
+		d := &b.Disruptions[i]
+		if d.Resolution == DisruptionPending && (flightId == "" || d.FlightId == flightId) {
+			return d
+		}
+	}
+	return nil
+}
+
+// IssueCoupons creates one coupon per passenger per segment
+func (b *Booking) IssueCoupons() {
+	b.Coupons = []Coupon{}
+	for s, segment := range b.Segments {
+		for _, p := range b.Passengers {
+			b.Coupons = append(b.Coupons, Coupon{
+				Number:      fmt.Sprintf("%s-%s-%d", b.Locator, p.ID, s+1),
+				PassengerId: p.ID,
+				FlightId:    segment.FlightId,
+				Status:      CouponOpen,
+			})
+		}
+	}
+}
+
+// Summary is the redacted view of the booking, flights holds the flights of its segments by id
+func (b *Booking) Summary(flights map[string]*Flight, now time.Time) *BookingSummary {
+	summary := &BookingSummary{Locator: b.Locator, Status: b.Status, Passengers: []PassengerSummary{}, Segments: []SegmentSummary{}}
+	for _, p := range b.Passengers {
+		summary.Passengers = append(summary.Passengers, PassengerSummary{ID: p.ID, Name: p.Name, Surname: p.Surname, Type: p.Type})
+	}
+	for _, segment := range b.Segments {
+		flight, ok := flights[segment.FlightId]
+		if !ok {
+			continue
+		}
+		opens, closes := flight.CheckInWindow()
+		summary.Segments = append(summary.Segments, SegmentSummary{
+			FlightStatus:    flight.StatusView(),
+			FareClass:       segment.FareClass,
+			CheckInOpensAt:  opens,
+			CheckInClosesAt: closes,
+			CheckInOpen: b.Status == BookingConfirmed && flight.CurrentStatus() != FlightCancelled &&
+				!now.Before(opens) && now.Before(closes),
+		})
+	}
+	return summary
+}
+
+func (b *Booking) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(b)
+}
+
+func (s *BookingSummary) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(s)
+}
+
+func (b *Bookings) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(b)
+}
+
+func (r *BookingRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
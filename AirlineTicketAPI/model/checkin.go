@@ -0,0 +1,120 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	CheckedIn = "checked_in"
+	Boarded   = "boarded"
+)
+
+// Seats are a row of 1 to 99 followed by a letter, 12C
+var seatPattern = regexp.MustCompile(`^[1-9][0-9]?[A-K]$`)
+
+// CheckIn is one passenger checked in on one flight, it carries the boarding pass handed to the passenger
+type CheckIn struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BookingId     string             `bson:"bookingId" json:"bookingId"`
+	Locator       string             `bson:"locator" json:"locator"`
+	FlightId      string             `bson:"flightId" json:"flightId"`
+	PassengerId   string             `bson:"passengerId" json:"passengerId"`
+	PassengerName string             `bson:"passengerName" json:"passengerName"`
+	// Seat is empty for infants, they travel on an adult's lap
+	Seat         string     `bson:"seat,omitempty" json:"seat,omitempty"`
+	Sequence     int        `bson:"sequence" json:"sequence"`
+	BoardingPass string     `bson:"boardingPass" json:"boardingPass"`
+	Status       string     `bson:"status" json:"status"`
+	CheckedInAt  time.Time  `bson:"checkedInAt" json:"checkedInAt"`
+	BoardedAt    *time.Time `bson:"boardedAt,omitempty" json:"boardedAt,omitempty"`
+	BoardedBy    string     `bson:"boardedBy,omitempty" json:"boardedBy,omitempty"`
+}
+
+type CheckIns []*CheckIn
+
+// SeatRequest is the seat a passenger wants, an empty seat is assigned automatically
+type SeatRequest struct {
+	PassengerId string `json:"passengerId"`
+	Seat        string `json:"seat"`
+}
+
+// CheckInRequest checks in passengers of a booking on one of its flights, all of them if Passengers is empty
+type CheckInRequest struct {
+	FlightId   string        `json:"flightId"`
+	Passengers []SeatRequest `json:"passengers"`
+}
+
+// BoardingScan is a boarding pass read at the gate of a flight
+type BoardingScan struct {
+	Barcode  string `json:"barcode"`
+	FlightId string `json:"flightId"`
+}
+
+func ValidSeat(seat string) bool {
+	return seatPattern.MatchString(seat)
+}
+
+// Validate checks the request against the booking and fills in every passenger when none were given
+func (r *CheckInRequest) Validate(booking *Booking) error {
+	if r.FlightId == "" {
+		return errors.New("flightId is required")
+	}
+	if len(r.Passengers) == 0 {
+		for _, p := range booking.Passengers {
+			r.Passengers = append(r.Passengers, SeatRequest{PassengerId: p.ID})
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, seat := range r.Passengers {
+		passenger := booking.Passenger(seat.PassengerId)
+		if passenger == nil {
+			return fmt.Errorf("passenger %s is not on the booking", seat.PassengerId)
+		}
+		if seen[seat.PassengerId] {
+			return fmt.Errorf("passenger %s appears twice", seat.PassengerId)
+		}
+		seen[seat.PassengerId] = true
+		if seat.Seat == "" {
+			continue
+		}
+		if passenger.Type == InfantPassenger {
+			return fmt.Errorf("passenger %s is an infant and gets no seat", seat.PassengerId)
+		}
+		if !ValidSeat(seat.Seat) {
+			return fmt.Errorf("seat %s is not a valid seat", seat.Seat)
+		}
+		if seen[seat.Seat] {
+			return fmt.Errorf("seat %s is requested twice", seat.Seat)
+		}
+		seen[seat.Seat] = true
+	}
+	return nil
+}
+
+func (c *CheckIn) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(c)
+}
+
+func (c *CheckIns) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(c)
+}
+
+func (r *CheckInRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (s *BoardingScan) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(s)
+}
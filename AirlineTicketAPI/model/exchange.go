@@ -0,0 +1,139 @@
+package model
+
+import (
+	"Rest/payments"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// Change is one exchange of a booked segment for another flight on the same route
+type Change struct {
+	FromFlightId   string         `bson:"fromFlightId" json:"fromFlightId"`
+	ToFlightId     string         `bson:"toFlightId" json:"toFlightId"`
+	OldFare        *FareBreakdown `bson:"oldFare" json:"oldFare"`
+	NewFare        *FareBreakdown `bson:"newFare" json:"newFare"`
+	FareDifference float64        `bson:"fareDifference" json:"fareDifference"`
+	ChangeFee      float64        `bson:"changeFee" json:"changeFee"`
+	// Amount is what the customer paid for the change, negative when money was returned
+	Amount   float64 `bson:"amount" json:"amount"`
+	Currency string  `bson:"currency" json:"currency"`
+	// PaymentId is the payment that collected a positive amount
+	PaymentId string    `bson:"paymentId,omitempty" json:"paymentId,omitempty"`
+	ChangedAt time.Time `bson:"changedAt" json:"changedAt"`
+}
+
+// InventoryMove is what an exchange takes from the new flight and gives back to the old one
+type InventoryMove struct {
+	FromFlightId string
+	ToFlightId   string
+	Seats        int
+	// Overbook is how far below zero the free seats of the new flight may go
+	Overbook int
+	// Ancillaries are the counted ancillaries moving with the passengers, on the old flight,
+	// Inventory is the per flight inventory of each of them
+	Ancillaries []AncillarySelection
+	Inventory   map[string]int
+}
+
+// InventoryRelease is what a cancellation gives back to the unflown flights of a booking
+type InventoryRelease struct {
+	FlightIds []string
+	Seats     int
+	// Ancillaries are the counted ancillaries bought for those flights
+	Ancillaries []AncillarySelection
+}
+
+// ExchangeRequest moves the passengers of a booking from one of its flights to another flight on the same route.
+// Amount is the total of the quote the customer accepted, the card pays it when it is above zero.
+type ExchangeRequest struct {
+	FromFlightId string         `json:"fromFlightId"`
+	ToFlightId   string         `json:"toFlightId"`
+	Amount       float64        `json:"amount"`
+	Card         *payments.Card `json:"card,omitempty"`
+}
+
+// ExchangeQuote prices an exchange: the fare difference plus the change fee, charged when positive and returned when negative
+type ExchangeQuote struct {
+	BookingId      string         `json:"bookingId"`
+	FromFlightId   string         `json:"fromFlightId"`
+	ToFlightId     string         `json:"toFlightId"`
+	OldFare        *FareBreakdown `json:"oldFare"`
+	NewFare        *FareBreakdown `json:"newFare"`
+	FareDifference float64        `json:"fareDifference"`
+	ChangeFee      float64        `json:"changeFee"`
+	Amount         float64        `json:"amount"`
+	Currency       string         `json:"currency"`
+}
+
+func (r *ExchangeRequest) Validate() error {
+	if r.FromFlightId == "" || r.ToFlightId == "" {
+		return errors.New("fromFlightId and toFlightId are required")
+	}
+	if r.FromFlightId == r.ToFlightId {
+		return errors.New("toFlightId must be a different flight")
+	}
+	return nil
+}
+
+// Reprice prices the passengers of the segment on another flight. Base fare, taxes and surcharges follow the new flight,
+// the seat and baggage fees and the ancillaries already paid are carried over. Promo code discounts are not.
+func (rules FeeRules) Reprice(segment *Segment, flight *Flight) *FareBreakdown {
+	fare := rules.Breakdown(flight, segment.Fare.Passengers, 0, 0)
+	fare.SeatFees = segment.Fare.SeatFees
+	fare.BaggageFees = segment.Fare.BaggageFees
+	fare.Ancillaries = segment.Fare.Ancillaries
+	fare.Total = roundAmount(fare.Total + fare.SeatFees + fare.BaggageFees + fare.AncillaryTotal())
+	return fare
+}
+
+// QuoteExchange works out what exchanging the segment for a segment with the new fare costs,
+// the change fee comes from the fare rules of the segment being given up
+func QuoteExchange(segment *Segment, newFare *FareBreakdown) *ExchangeQuote {
+	quote := &ExchangeQuote{
+		FromFlightId: segment.FlightId,
+		OldFare:      segment.Fare,
+		NewFare:      newFare,
+		Currency:     newFare.Currency,
+	}
+	quote.FareDifference = roundAmount(newFare.Total - segment.Fare.Total)
+	quote.ChangeFee = roundAmount(segment.FareRules().ChangeFee * float64(segment.Fare.Passengers))
+	quote.Amount = roundAmount(quote.FareDifference + quote.ChangeFee)
+	return quote
+}
+
+// Exchange moves the segment and its coupons to the quoted flight and records the change on the booking
+func (b *Booking) Exchange(quote *ExchangeQuote, rules FareRules, changedAt time.Time) *Change {
+	for i := range b.Segments {
+		if b.Segments[i].FlightId == quote.FromFlightId {
+			b.Segments[i].Fare = quote.NewFare
+			b.Segments[i].Rules = &rules
+		}
+	}
+	b.MoveSegment(quote.FromFlightId, quote.ToFlightId)
+	b.Total = roundAmount(b.Total + quote.Amount)
+
+	b.Changes = append(b.Changes, Change{
+		FromFlightId:   quote.FromFlightId,
+		ToFlightId:     quote.ToFlightId,
+		OldFare:        quote.OldFare,
+		NewFare:        quote.NewFare,
+		FareDifference: quote.FareDifference,
+		ChangeFee:      quote.ChangeFee,
+		Amount:         quote.Amount,
+		Currency:       quote.Currency,
+		ChangedAt:      changedAt,
+	})
+	return &b.Changes[len(b.Changes)-1]
+}
+
+func (r *ExchangeRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (q *ExchangeQuote) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(q)
+}
@@ -0,0 +1,278 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Currency in which all fares and fees are expressed
+const Currency = "EUR"
+
+type FeeKind string
+
+const (
+	AirportTax    FeeKind = "airport_tax"
+	FuelSurcharge FeeKind = "fuel_surcharge"
+	ServiceFee    FeeKind = "service_fee"
+	SeatFee       FeeKind = "seat"
+	BaggageFee    FeeKind = "baggage"
+	PointsFee     FeeKind = "points"
+)
+
+const (
+	DepartureSide = "departure"
+	ArrivalSide   = "arrival"
+)
+
+// FeeRule is a configurable tax or fee added on top of the base fare.
+// Airport taxes are matched against the flight's From (departure) or To (arrival) airport,
+// an empty Airport or "*" matches every airport.
+// The charged amount is Amount plus Percent of the base fare, per passenger unless PerBooking is set.
+// Seat and baggage rules are charged per selected seat and per checked bag.
+type FeeRule struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind       FeeKind            `bson:"kind" json:"kind"`
+	Code       string             `bson:"code" json:"code"`
+	Airport    string             `bson:"airport,omitempty" json:"airport,omitempty"`
+	Side       string             `bson:"side,omitempty" json:"side,omitempty"`
+	Amount     float64            `bson:"amount" json:"amount"`
+	Percent    float64            `bson:"percent,omitempty" json:"percent,omitempty"`
+	PerBooking bool               `bson:"perBooking" json:"perBooking"`
+}
+
+type FeeRules []*FeeRule
+
+// FareLine is one itemized amount of a fare breakdown
+type FareLine struct {
+	Kind        FeeKind `bson:"kind" json:"kind"`
+	Code        string  `bson:"code" json:"code"`
+	Description string  `bson:"description" json:"description"`
+	Airport     string  `bson:"airport,omitempty" json:"airport,omitempty"`
+	Amount      float64 `bson:"amount" json:"amount"`
+}
+
+type FareBreakdown struct {
+	Currency      string     `bson:"currency" json:"currency"`
+	Passengers    int        `bson:"passengers" json:"passengers"`
+	BaseFare      float64    `bson:"baseFare" json:"baseFare"`
+	Taxes         []FareLine `bson:"taxes" json:"taxes"`
+	FuelSurcharge float64    `bson:"fuelSurcharge" json:"fuelSurcharge"`
+	ServiceFee    float64    `bson:"serviceFee" json:"serviceFee"`
+	SeatFees      float64    `bson:"seatFees" json:"seatFees"`
+	BaggageFees   float64    `bson:"baggageFees" json:"baggageFees"`
+	Ancillaries   []FareLine `bson:"ancillaries,omitempty" json:"ancillaries,omitempty"`
+	Discounts     []FareLine `bson:"discounts,omitempty" json:"discounts,omitempty"`
+	// PaidWithPoints is the part of the base fare an award booking paid with Points instead of money
+	PaidWithPoints float64 `bson:"paidWithPoints,omitempty" json:"paidWithPoints,omitempty"`
+	Points         int     `bson:"points,omitempty" json:"points,omitempty"`
+	Total          float64 `bson:"total" json:"total"`
+}
+
+type QuoteRequest struct {
+	FlightId      string `json:"flightId"`
+	NumberOfSeats int    `json:"numberOfSeats"`
+	SelectedSeats int    `json:"selectedSeats"`
+	CheckedBags   int    `json:"checkedBags"`
+	// Ancillaries are priced for the quoted flight, their flight and passenger are not needed
+	Ancillaries []AncillarySelection `json:"ancillaries"`
+	FareClass   string               `json:"fareClass"`
+	PromoCodes  []string             `json:"promoCodes"`
+	// PayWithPoints quotes an award booking, showing the points that pay for the base fare
+	PayWithPoints bool `json:"payWithPoints"`
+}
+
+type Invoice struct {
+	Number   string     `json:"number"`
+	TicketId string     `json:"ticketId"`
+	UserId   string     `json:"userId"`
+	IssuedAt time.Time  `json:"issuedAt"`
+	Currency string     `json:"currency"`
+	Lines    []FareLine `json:"lines"`
+	Total    float64    `json:"total"`
+}
+
+// Validate checks the kind and side of the rule and that it never charges a negative amount
+func (rule *FeeRule) Validate() error {
+	switch rule.Kind {
+	case AirportTax, FuelSurcharge, ServiceFee, SeatFee, BaggageFee:
+	default:
+		return errors.New("unknown fee kind")
+	}
+	if rule.Side != "" && rule.Side != DepartureSide && rule.Side != ArrivalSide {
+		return errors.New("side must be departure or arrival")
+	}
+	if rule.Amount < 0 || rule.Percent < 0 {
+		return errors.New("amount and percent cannot be negative")
+	}
+	return nil
+}
+
+func (rule *FeeRule) matchesAirport(airport string) bool {
+	return rule.Airport == "" || rule.Airport == "*" || strings.EqualFold(rule.Airport, airport)
+}
+
+func (rule *FeeRule) charge(baseFarePerPassenger float64, units int) float64 {
+	amount := rule.Amount + baseFarePerPassenger*rule.Percent/100
+	if rule.PerBooking {
+		return amount
+	}
+	return amount * float64(units)
+}
+
+// Breakdown itemizes the price of buying passengers seats on the flight with the given add-ons
+func (rules FeeRules) Breakdown(flight *Flight, passengers, selectedSeats, checkedBags int) *FareBreakdown {
+	base := float64(flight.Price)
+	b := &FareBreakdown{
+		Currency:   Currency,
+		Passengers: passengers,
+		BaseFare:   roundAmount(base * float64(passengers)),
+		Taxes:      []FareLine{},
+	}
+
+	for _, rule := range rules {
+		switch rule.Kind {
+		case AirportTax:
+			airport := flight.From
+			if rule.Side == ArrivalSide {
+				airport = flight.To
+			}
+			if !rule.matchesAirport(airport) {
+				continue
+			}
+			b.Taxes = append(b.Taxes, FareLine{
+				Kind:        rule.Kind,
+				Code:        rule.Code,
+				Description: "Airport tax (" + sideOrDeparture(rule.Side) + ")",
+				Airport:     airport,
+				Amount:      roundAmount(rule.charge(base, passengers)),
+			})
+		case FuelSurcharge:
+			b.FuelSurcharge += rule.charge(base, passengers)
+		case ServiceFee:
+			b.ServiceFee += rule.charge(base, passengers)
+		case SeatFee:
+			if selectedSeats > 0 {
+				b.SeatFees += rule.charge(base, selectedSeats)
+			}
+		case BaggageFee:
+			if checkedBags > 0 {
+				b.BaggageFees += rule.charge(base, checkedBags)
+			}
+		}
+	}
+
+	b.FuelSurcharge = roundAmount(b.FuelSurcharge)
+	b.ServiceFee = roundAmount(b.ServiceFee)
+	b.SeatFees = roundAmount(b.SeatFees)
+	b.BaggageFees = roundAmount(b.BaggageFees)
+	b.Total = b.BaseFare + b.FuelSurcharge + b.ServiceFee + b.SeatFees + b.BaggageFees
+	for _, tax := range b.Taxes {
+		b.Total += tax.Amount
+	}
+	b.Total = roundAmount(b.Total)
+	return b
+}
+
+// Lines flattens the breakdown into invoice lines, omitting zero amounts
+func (b *FareBreakdown) Lines() []FareLine {
+	lines := []FareLine{{Code: "FARE", Description: "Base fare", Amount: b.BaseFare}}
+	lines = append(lines, b.Taxes...)
+	extras := []FareLine{
+		{Kind: FuelSurcharge, Code: "YQ", Description: "Fuel surcharge", Amount: b.FuelSurcharge},
+		{Kind: ServiceFee, Code: "SF", Description: "Service fee", Amount: b.ServiceFee},
+		{Kind: SeatFee, Code: "SEAT", Description: "Seat selection", Amount: b.SeatFees},
+		{Kind: BaggageFee, Code: "BAG", Description: "Checked baggage", Amount: b.BaggageFees},
+	}
+	for _, line := range extras {
+		if line.Amount != 0 {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, b.Ancillaries...)
+	lines = append(lines, b.Discounts...)
+	if b.Points > 0 {
+		lines = append(lines, FareLine{Kind: PointsFee, Code: "PTS", Description: fmt.Sprintf("Paid with %d points", b.Points), Amount: -b.PaidWithPoints})
+	}
+	return lines
+}
+
+// NewInvoice builds the invoice of a purchased ticket from the breakdown stored on it
+func NewInvoice(ticket *Ticket) *Invoice {
+	invoice := &Invoice{
+		Number:   "INV-" + strings.ToUpper(ticket.ID.Hex()),
+		TicketId: ticket.ID.Hex(),
+		UserId:   ticket.UserId,
+		IssuedAt: ticket.ID.Timestamp(),
+		Currency: Currency,
+		Lines:    []FareLine{},
+	}
+	if ticket.Fare != nil {
+		invoice.Currency = ticket.Fare.Currency
+		invoice.Lines = ticket.Fare.Lines()
+		invoice.Total = ticket.Fare.Total
+	}
+	return invoice
+}
+
+func sideOrDeparture(side string) string {
+	if side == ArrivalSide {
+		return ArrivalSide
+	}
+	return DepartureSide
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func (r *FeeRules) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (r *FeeRule) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (r *FeeRule) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (b *FareBreakdown) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(b)
+}
+
+// Validate checks the numbers of the quote, defaults the fare class to economy and normalizes the promo codes
+func (q *QuoteRequest) Validate() error {
+	if q.NumberOfSeats < 1 || q.SelectedSeats < 0 || q.CheckedBags < 0 || q.SelectedSeats > q.NumberOfSeats {
+		return errors.New("invalid number of seats or add-ons")
+	}
+	if q.FareClass == "" {
+		q.FareClass = DefaultFareClass
+	}
+	if !fareClassPattern.MatchString(q.FareClass) {
+		return errors.New("fareClass must be a single booking class letter")
+	}
+	q.PromoCodes = NormalizeCodes(q.PromoCodes)
+	return nil
+}
+
+func (q *QuoteRequest) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(q)
+}
+
+func (i *Invoice) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(i)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"time"
@@ -11,13 +12,190 @@ import (
 
 type Flight struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number    string             `bson:"number,omitempty" json:"number"`
 	From      string             `bson:"from" json:"from"`
 	To        string             `bson:"to,omitempty" json:"to"`
 	Price     float32            `bson:"price,omitempty" json:"price"`
 	FreeSeats int                `bson:"freeseats" json:"freeseats"`
 	Date      time.Time          `bson:"date,omitempty" json:"date"`
+	Rules     *FareRules         `bson:"fareRules,omitempty" json:"fareRules,omitempty"`
+	Status    string             `bson:"status,omitempty" json:"status"`
+	Gate      string             `bson:"gate,omitempty" json:"gate,omitempty"`
+	Terminal  string             `bson:"terminal,omitempty" json:"terminal,omitempty"`
+	// EstimatedDeparture is set while the flight is delayed
+	EstimatedDeparture *time.Time     `bson:"estimatedDeparture,omitempty" json:"estimatedDeparture,omitempty"`
+	DivertedTo         string         `bson:"divertedTo,omitempty" json:"divertedTo,omitempty"`
+	StatusHistory      []StatusChange `bson:"statusHistory,omitempty" json:"statusHistory,omitempty"`
+	// Capacity is the number of physical seats, FreeSeats goes below zero when the flight is oversold
+	Capacity int `bson:"capacity,omitempty" json:"capacity,omitempty"`
+	// OverbookingLimit overrides the overbooking rule of the route for this flight when it is set
+	OverbookingLimit *int `bson:"overbookingLimit,omitempty" json:"overbookingLimit,omitempty"`
+	// Online check-in opens and closes this many minutes before departure, 24 hours and 1 hour if not set
+	CheckInOpensMinutes  int `bson:"checkInOpensMinutes,omitempty" json:"checkInOpensMinutes,omitempty"`
+	CheckInClosesMinutes int `bson:"checkInClosesMinutes,omitempty" json:"checkInClosesMinutes,omitempty"`
+	// Distance of the route in kilometres, loyalty points are earned by it
+	Distance int `bson:"distance,omitempty" json:"distance,omitempty"`
+	// ManifestExportedAt is set once the APIS manifest was written after check-in closed
+	ManifestExportedAt *time.Time `bson:"manifestExportedAt,omitempty" json:"manifestExportedAt,omitempty"`
+	// Fare is the itemized price, filled in for search results only
+	Fare *FareBreakdown `bson:"-" json:"fare,omitempty"`
 }
 
+const (
+	FlightScheduled = "scheduled"
+	FlightBoarding  = "boarding"
+	FlightDeparted  = "departed"
+	FlightDelayed   = "delayed"
+	FlightCancelled = "cancelled"
+	FlightDiverted  = "diverted"
+	FlightLanded    = "landed"
+)
+
+const (
+	DefaultCheckInOpensMinutes  = 24 * 60
+	DefaultCheckInClosesMinutes = 60
+)
+
+// StatusChange is one entry of a flight's status history, holding the operational data after the change
+type StatusChange struct {
+	Status             string     `bson:"status" json:"status"`
+	Gate               string     `bson:"gate,omitempty" json:"gate,omitempty"`
+	Terminal           string     `bson:"terminal,omitempty" json:"terminal,omitempty"`
+	EstimatedDeparture *time.Time `bson:"estimatedDeparture,omitempty" json:"estimatedDeparture,omitempty"`
+	DivertedTo         string     `bson:"divertedTo,omitempty" json:"divertedTo,omitempty"`
+	Note               string     `bson:"note,omitempty" json:"note,omitempty"`
+	// ChangedBy is the email of the staff member, it is only shown to staff
+	ChangedBy string    `bson:"changedBy" json:"changedBy,omitempty"`
+	ChangedAt time.Time `bson:"changedAt" json:"changedAt"`
+}
+
+// FlightStatus is the operational view of a flight shown to passengers on their bookings
+type FlightStatus struct {
+	FlightId           string     `json:"flightId"`
+	From               string     `json:"from"`
+	To                 string     `json:"to"`
+	Date               time.Time  `json:"date"`
+	Status             string     `json:"status"`
+	Gate               string     `json:"gate,omitempty"`
+	Terminal           string     `json:"terminal,omitempty"`
+	EstimatedDeparture *time.Time `json:"estimatedDeparture,omitempty"`
+	DivertedTo         string     `json:"divertedTo,omitempty"`
+}
+
+// HideStaff removes the staff emails from the status history of a flight shown to the public
+func (f *Flight) HideStaff() {
+	for i := range f.StatusHistory {
+		f.StatusHistory[i].ChangedBy = ""
+	}
+}
+
+func (f Flights) HideStaff() {
+	for _, flight := range f {
+		flight.HideStaff()
+	}
+}
+
+// CurrentStatus treats flights created before statuses existed as scheduled
+func (f *Flight) CurrentStatus() string {
+	if f.Status == "" {
+		return FlightScheduled
+	}
+	return f.Status
+}
+
+// Departure is the estimated departure of a delayed flight and the scheduled one otherwise
+func (f *Flight) Departure() time.Time {
+	if f.EstimatedDeparture != nil {
+		return *f.EstimatedDeparture
+	}
+	return f.Date
+}
+
+// CheckInWindow returns when online check-in for the flight opens and closes
+func (f *Flight) CheckInWindow() (time.Time, time.Time) {
+	opens, closes := f.CheckInOpensMinutes, f.CheckInClosesMinutes
+	if opens <= 0 {
+		opens = DefaultCheckInOpensMinutes
+	}
+	if closes <= 0 {
+		closes = DefaultCheckInClosesMinutes
+	}
+	departure := f.Departure()
+	return departure.Add(-time.Duration(opens) * time.Minute), departure.Add(-time.Duration(closes) * time.Minute)
+}
+
+func (f *Flight) StatusView() FlightStatus {
+	return FlightStatus{
+		FlightId:           f.ID.Hex(),
+		From:               f.From,
+		To:                 f.To,
+		Date:               f.Date,
+		Status:             f.CurrentStatus(),
+		Gate:               f.Gate,
+		Terminal:           f.Terminal,
+		EstimatedDeparture: f.EstimatedDeparture,
+		DivertedTo:         f.DivertedTo,
+	}
+}
+
+// Apply validates a status update against the flight and fills in the values that were left out
+func (c *StatusChange) Apply(f *Flight) error {
+	if c.Status == "" {
+		c.Status = f.CurrentStatus()
+	}
+	if c.Gate == "" {
+		c.Gate = f.Gate
+	}
+	if c.Terminal == "" {
+		c.Terminal = f.Terminal
+	}
+
+	switch c.Status {
+	case FlightScheduled, FlightBoarding, FlightDeparted, FlightLanded:
+		c.EstimatedDeparture = nil
+		c.DivertedTo = ""
+	case FlightDelayed:
+		if c.EstimatedDeparture == nil {
+			c.EstimatedDeparture = f.EstimatedDeparture
+		}
+		if c.EstimatedDeparture == nil || !c.EstimatedDeparture.After(f.Date) {
+			return errors.New("a delayed flight needs an estimated departure after the scheduled one")
+		}
+		c.DivertedTo = ""
+	case FlightDiverted:
+		if c.DivertedTo == "" {
+			return errors.New("a diverted flight needs the airport it was diverted to")
+		}
+	case FlightCancelled:
+		return errors.New("flights are cancelled through the cancellation workflow")
+	default:
+		return errors.New("unknown flight status")
+	}
+	return nil
+}
+
+// FareRules decide how much of the fare is returned when a booking is cancelled.
+// Refundable fares cancelled at least CancellationDeadlineHours before departure get everything back
+// except CancellationFee per passenger, in every other case only the taxes are refunded.
+type FareRules struct {
+	Refundable                bool    `bson:"refundable" json:"refundable"`
+	CancellationFee           float64 `bson:"cancellationFee" json:"cancellationFee"`
+	CancellationDeadlineHours int     `bson:"cancellationDeadlineHours" json:"cancellationDeadlineHours"`
+	// ChangeFee is charged per passenger when the segment is exchanged for another flight
+	ChangeFee float64 `bson:"changeFee" json:"changeFee"`
+}
+
+// DefaultFareRules apply to flights created without fare rules and to flights from before fare rules existed:
+// refundable without a fee up to a day before departure, exchanged without a change fee
+var DefaultFareRules = FareRules{Refundable: true, CancellationDeadlineHours: 24}
+
+// FareRules returns the rules of the flight, DefaultFareRules if it has none
+func (f *Flight) FareRules() FareRules {
+	if f.Rules == nil {
+		return DefaultFareRules
+	}
+	return *f.Rules
+}
 
 type SearchCriteria struct {
 	From         string `bson:"from" json:"from"`
@@ -31,7 +209,6 @@ func (t *Ticket) ToJSON(rw http.ResponseWriter) error {
 	return e.Encode(t)
 }
 
-
 type Flights []*Flight
 
 func (u *Flights) ToJSON(w io.Writer) error {
@@ -57,3 +234,8 @@ func (u *SearchCriteria) FromJSON(r io.Reader) error {
 	d := json.NewDecoder(r)
 	return d.Decode(u)
 }
+
+func (c *StatusChange) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(c)
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	HoldActive    = "active"
+	HoldConfirmed = "confirmed"
+	HoldReleased  = "released"
+	HoldExpired   = "expired"
+)
+
+// Hold keeps seats reserved for a booking request while the customer checks out
+type Hold struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId    string             `bson:"userId" json:"userId"`
+	Request   BookingRequest     `bson:"request" json:"request"`
+	Seats     int                `bson:"seats" json:"seats"`
+	Status    string             `bson:"status" json:"status"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	BookingId string             `bson:"bookingId,omitempty" json:"bookingId,omitempty"`
+}
+
+func (h *Hold) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(h)
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key header
+// so that a retry of the same request gets the same response instead of being executed again
+type IdempotencyRecord struct {
+	// ID is derived from the key and the user sending it, the same key of two users never clashes
+	ID          string    `bson:"_id"`
+	Key         string    `bson:"key"`
+	Scope       string    `bson:"scope"`
+	RequestHash string    `bson:"requestHash"`
+	Status      string    `bson:"status"`
+	StatusCode  int       `bson:"statusCode,omitempty"`
+	ContentType string    `bson:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	LockedUntil time.Time `bson:"lockedUntil"`
+	CreatedAt   time.Time `bson:"createdAt"`
+	// ExpiresAt drives the TTL index, Mongo removes the record some time after it
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
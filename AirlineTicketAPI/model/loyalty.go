@@ -0,0 +1,147 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	LedgerEarn   = "earn"
+	LedgerRedeem = "redeem"
+	// LedgerReturn gives back points of an award booking that was cancelled or never completed
+	LedgerReturn = "return"
+)
+
+// Tiers are qualified for with the points earned on flights over the last TierWindow
+const (
+	SilverPoints = 25000
+	GoldPoints   = 50000
+	TierWindow   = 365 * 24 * time.Hour
+)
+
+// Every flown segment earns at least MinimumPoints, flights without a known distance earn just that
+const MinimumPoints = 250
+
+// PointsPerEuro is what one euro of base fare costs when it is paid with points
+const PointsPerEuro = 100
+
+// A flight counts as flown this long after its departure unless it was cancelled
+const flownAfter = 24 * time.Hour
+
+// LoyaltyAccount is the frequent-flyer account of a user. Its balance only changes together with a ledger entry.
+type LoyaltyAccount struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId    string             `bson:"userId" json:"userId"`
+	Balance   int                `bson:"balance" json:"balance"`
+	Tier      string             `bson:"tier,omitempty" json:"tier,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	// QualifyingPoints were earned within the tier window, NextTierPoints are still missing for the next tier.
+	// Both are worked out when the account is shown and never stored.
+	QualifyingPoints int `bson:"-" json:"qualifyingPoints"`
+	NextTierPoints   int `bson:"-" json:"nextTierPoints,omitempty"`
+}
+
+// LedgerEntry is one immutable change of a loyalty balance, Points is negative when points are spent
+type LedgerEntry struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId      string             `bson:"userId" json:"userId"`
+	Kind        string             `bson:"kind" json:"kind"`
+	Points      int                `bson:"points" json:"points"`
+	BookingId   string             `bson:"bookingId,omitempty" json:"bookingId,omitempty"`
+	FlightId    string             `bson:"flightId,omitempty" json:"flightId,omitempty"`
+	Description string             `bson:"description" json:"description"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type Ledger []*LedgerEntry
+
+// fareClassMultipliers rewards the premium cabins, unknown classes earn like deep discount economy
+var fareClassMultipliers = map[string]float64{
+	"F": 3, "A": 3,
+	"J": 2, "C": 2, "D": 2, "I": 2, "Z": 2,
+	"W": 1.5, "P": 1.5,
+	"Y": 1, "B": 1, "M": 1,
+	"H": 0.5, "K": 0.5, "L": 0.5, "Q": 0.5, "V": 0.5,
+}
+
+// EarnPoints is what a flown segment of the given distance in kilometres earns in the fare class
+func EarnPoints(distance int, fareClass string) int {
+	if fareClass == "" {
+		fareClass = DefaultFareClass
+	}
+	multiplier, ok := fareClassMultipliers[fareClass]
+	if !ok {
+		multiplier = 0.25
+	}
+	points := int(math.Round(float64(distance) * multiplier))
+	if points < MinimumPoints {
+		return MinimumPoints
+	}
+	return points
+}
+
+// TierFor is the tier qualified for with the points earned within the tier window
+func TierFor(qualifyingPoints int) string {
+	switch {
+	case qualifyingPoints >= GoldPoints:
+		return TierGold
+	case qualifyingPoints >= SilverPoints:
+		return TierSilver
+	}
+	return ""
+}
+
+// PointsToNextTier is how many points are missing for the next tier, zero at the top tier
+func PointsToNextTier(qualifyingPoints int) int {
+	switch {
+	case qualifyingPoints >= GoldPoints:
+		return 0
+	case qualifyingPoints >= SilverPoints:
+		return GoldPoints - qualifyingPoints
+	}
+	return SilverPoints - qualifyingPoints
+}
+
+// Flown reports whether passengers of the flight have flown it
+func (f *Flight) Flown(now time.Time) bool {
+	switch f.CurrentStatus() {
+	case FlightLanded, FlightDiverted:
+		return true
+	case FlightCancelled:
+		return false
+	}
+	return f.Date.Add(flownAfter).Before(now)
+}
+
+// PayWithPoints covers the base fare left after discounts with points, taxes and fees are still paid by card
+func (b *FareBreakdown) PayWithPoints() {
+	covered := roundAmount(b.BaseFare + b.DiscountTotal())
+	b.Points = int(math.Ceil(covered * PointsPerEuro))
+	b.PaidWithPoints = covered
+	b.Total = roundAmount(b.Total - covered)
+}
+
+// Points is what was paid with points for the booking
+func (b *Booking) Points() int {
+	points := 0
+	for _, segment := range b.Segments {
+		if segment.Fare != nil {
+			points += segment.Fare.Points
+		}
+	}
+	return points
+}
+
+func (a *LoyaltyAccount) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(a)
+}
+
+func (l *Ledger) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(l)
+}
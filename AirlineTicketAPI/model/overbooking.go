@@ -0,0 +1,153 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OverbookingRule lets a route sell Percent more seats than the capacity of its flights,
+// based on how many passengers usually do not show up
+type OverbookingRule struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	From    string             `bson:"from" json:"from"`
+	To      string             `bson:"to" json:"to"`
+	Percent float64            `bson:"percent" json:"percent"`
+}
+
+type OverbookingRules []*OverbookingRule
+
+// Limit is how many seats the flight may be oversold by. A limit set on the flight wins over the rule
+// of its route, flights without a capacity are never oversold.
+func (rules OverbookingRules) Limit(f *Flight) int {
+	if f.Capacity <= 0 {
+		return 0
+	}
+	if f.OverbookingLimit != nil {
+		return *f.OverbookingLimit
+	}
+	for _, rule := range rules {
+		if rule.From == f.From && rule.To == f.To {
+			return int(math.Floor(float64(f.Capacity) * rule.Percent / 100))
+		}
+	}
+	return 0
+}
+
+// OverbookingSettings changes the capacity and overbooking limit of one flight
+type OverbookingSettings struct {
+	Capacity int  `json:"capacity"`
+	Limit    *int `json:"overbookingLimit"`
+}
+
+const (
+	// Volunteered passengers offered to give up their seat, they are offloaded first when the flight is oversold
+	DeniedBoardingVolunteered = "volunteered"
+	DeniedBoardingOffloaded   = "offloaded"
+)
+
+// DeniedBoarding records a booking taken off an oversold flight, the compensation it is owed
+// and the flight it was rebooked onto. Parties are offloaded together.
+type DeniedBoarding struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FlightId         string             `bson:"flightId" json:"flightId"`
+	BookingId        string             `bson:"bookingId" json:"bookingId"`
+	Locator          string             `bson:"locator" json:"locator"`
+	UserId           string             `bson:"userId" json:"userId"`
+	Seats            int                `bson:"seats" json:"seats"`
+	Voluntary        bool               `bson:"voluntary" json:"voluntary"`
+	Status           string             `bson:"status" json:"status"`
+	Compensation     float64            `bson:"compensation" json:"compensation"`
+	Currency         string             `bson:"currency" json:"currency"`
+	RebookedFlightId string             `bson:"rebookedFlightId,omitempty" json:"rebookedFlightId,omitempty"`
+	VolunteeredAt    *time.Time         `bson:"volunteeredAt,omitempty" json:"volunteeredAt,omitempty"`
+	OffloadedAt      *time.Time         `bson:"offloadedAt,omitempty" json:"offloadedAt,omitempty"`
+	OffloadedBy      string             `bson:"offloadedBy,omitempty" json:"offloadedBy,omitempty"`
+}
+
+type DeniedBoardings []*DeniedBoarding
+
+// VolunteerRequest offers to give up the seats of a booking on one of its flights
+type VolunteerRequest struct {
+	FlightId string `json:"flightId"`
+}
+
+// DeniedBoardingRequest asks to free Seats seats on an oversold flight, all oversold seats if it is zero.
+// Compensation is per seated passenger, the defaults apply when it is left out.
+type DeniedBoardingRequest struct {
+	Seats                   int     `json:"seats"`
+	VoluntaryCompensation   float64 `json:"voluntaryCompensation"`
+	InvoluntaryCompensation float64 `json:"involuntaryCompensation"`
+}
+
+// Oversale is the seat situation of a flight at the gate
+type Oversale struct {
+	FlightId   string          `json:"flightId"`
+	Capacity   int             `json:"capacity"`
+	Limit      int             `json:"overbookingLimit"`
+	Sold       int             `json:"sold"`
+	CheckedIn  int             `json:"checkedIn"`
+	Oversold   int             `json:"oversold"`
+	Volunteers DeniedBoardings `json:"volunteers"`
+}
+
+// DeniedBoardingResult lists who was offloaded and how many seats are still missing
+type DeniedBoardingResult struct {
+	FlightId  string          `json:"flightId"`
+	Needed    int             `json:"needed"`
+	Offloaded DeniedBoardings `json:"offloaded"`
+	Shortfall int             `json:"shortfall"`
+}
+
+func (r *OverbookingRule) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (r *OverbookingRule) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (r *OverbookingRules) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (s *OverbookingSettings) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(s)
+}
+
+func (d *DeniedBoarding) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(d)
+}
+
+func (d *DeniedBoardings) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(d)
+}
+
+func (r *DeniedBoardingRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (r *VolunteerRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
+
+func (o *Oversale) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(o)
+}
+
+func (r *DeniedBoardingResult) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DiscountKind string
+
+const (
+	PercentDiscount DiscountKind = "percent"
+	FixedDiscount   DiscountKind = "fixed"
+)
+
+// DiscountFee is the fare line kind of promotion discounts, their amounts are negative
+const DiscountFee FeeKind = "discount"
+
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9]{3,20}$`)
+
+// ErrPromotionNotApplicable is wrapped by every reason a promo code cannot be used for a booking
+var ErrPromotionNotApplicable = errors.New("promo code cannot be used")
+
+// Promotion is a promo code of a marketing campaign. It discounts the base fare, taxes and fees are always paid in full.
+// A percent discount applies to every flight it is valid for, a fixed discount once per booking.
+// Route, travel dates and fare classes restrict the flights it is valid for, empty restrictions match every flight.
+// MaxUses limits the bookings made with the code and MaxUsesPerUser the bookings of one customer, zero means unlimited.
+// Only stackable codes can be combined with other codes on one booking.
+type Promotion struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code           string             `bson:"code" json:"code"`
+	Campaign       string             `bson:"campaign" json:"campaign"`
+	Kind           DiscountKind       `bson:"kind" json:"kind"`
+	Value          float64            `bson:"value" json:"value"`
+	ValidFrom      time.Time          `bson:"validFrom" json:"validFrom"`
+	ValidUntil     time.Time          `bson:"validUntil" json:"validUntil"`
+	From           string             `bson:"from,omitempty" json:"from,omitempty"`
+	To             string             `bson:"to,omitempty" json:"to,omitempty"`
+	TravelFrom     *time.Time         `bson:"travelFrom,omitempty" json:"travelFrom,omitempty"`
+	TravelUntil    *time.Time         `bson:"travelUntil,omitempty" json:"travelUntil,omitempty"`
+	FareClasses    []string           `bson:"fareClasses,omitempty" json:"fareClasses,omitempty"`
+	MaxUses        int                `bson:"maxUses" json:"maxUses"`
+	MaxUsesPerUser int                `bson:"maxUsesPerUser" json:"maxUsesPerUser"`
+	Stackable      bool               `bson:"stackable" json:"stackable"`
+	// Uses counts the bookings made with the code, holds included
+	Uses      int       `bson:"uses" json:"uses"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+type Promotions []*Promotion
+
+// Redemption records the discount a booking got with a promo code
+type Redemption struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code       string             `bson:"code" json:"code"`
+	UserId     string             `bson:"userId" json:"userId"`
+	BookingId  string             `bson:"bookingId" json:"bookingId"`
+	Locator    string             `bson:"locator" json:"locator"`
+	Discount   float64            `bson:"discount" json:"discount"`
+	Currency   string             `bson:"currency" json:"currency"`
+	RedeemedAt time.Time          `bson:"redeemedAt" json:"redeemedAt"`
+}
+
+// PromotionReport sums up the redemptions of a promo code
+type PromotionReport struct {
+	Code     string  `bson:"_id" json:"code"`
+	Campaign string  `bson:"-" json:"campaign"`
+	Uses     int     `bson:"-" json:"uses"`
+	MaxUses  int     `bson:"-" json:"maxUses"`
+	Bookings int     `bson:"bookings" json:"bookings"`
+	Users    int     `bson:"users" json:"users"`
+	Discount float64 `bson:"discount" json:"discount"`
+	Currency string  `bson:"-" json:"currency"`
+}
+
+type PromotionReports []*PromotionReport
+
+// NormalizeCodes upper-cases the promo codes and drops blanks and repeats
+func NormalizeCodes(codes []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !seen[code] {
+			seen[code] = true
+			normalized = append(normalized, code)
+		}
+	}
+	return normalized
+}
+
+func (p *Promotion) Validate() error {
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	if !promoCodePattern.MatchString(p.Code) {
+		return errors.New("code must be 3 to 20 letters and digits")
+	}
+	switch p.Kind {
+	case PercentDiscount:
+		if p.Value <= 0 || p.Value > 100 {
+			return errors.New("percent discount must be above 0 and at most 100")
+		}
+	case FixedDiscount:
+		if p.Value <= 0 {
+			return errors.New("fixed discount must be above 0")
+		}
+	default:
+		return errors.New("kind must be percent or fixed")
+	}
+	if p.ValidFrom.IsZero() || !p.ValidUntil.After(p.ValidFrom) {
+		return errors.New("validUntil must be after validFrom")
+	}
+	if p.TravelFrom != nil && p.TravelUntil != nil && !p.TravelUntil.After(*p.TravelFrom) {
+		return errors.New("travelUntil must be after travelFrom")
+	}
+	for _, class := range p.FareClasses {
+		if !fareClassPattern.MatchString(class) {
+			return errors.New("fare classes must be single booking class letters")
+		}
+	}
+	if p.MaxUses < 0 || p.MaxUsesPerUser < 0 {
+		return errors.New("usage limits cannot be negative")
+	}
+	return nil
+}
+
+// ValidAt reports whether the code can be used at the given time
+func (p *Promotion) ValidAt(now time.Time) bool {
+	return !now.Before(p.ValidFrom) && now.Before(p.ValidUntil)
+}
+
+// AppliesTo reports whether the code discounts the flight booked in the fare class
+func (p *Promotion) AppliesTo(flight *Flight, fareClass string) bool {
+	if (p.From != "" && !strings.EqualFold(p.From, flight.From)) || (p.To != "" && !strings.EqualFold(p.To, flight.To)) {
+		return false
+	}
+	if (p.TravelFrom != nil && flight.Date.Before(*p.TravelFrom)) || (p.TravelUntil != nil && !flight.Date.Before(*p.TravelUntil)) {
+		return false
+	}
+	if len(p.FareClasses) == 0 {
+		return true
+	}
+	for _, class := range p.FareClasses {
+		if class == fareClass {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply discounts the fares of the flights, booked in the fare class, with the promotions in their order.
+// fares[i] is the fare of flights[i]. A fare is never discounted below its taxes and fees.
+func (promotions Promotions) Apply(fares []*FareBreakdown, flights []*Flight, fareClass string, now time.Time) error {
+	for _, p := range promotions {
+		if len(promotions) > 1 && !p.Stackable {
+			return fmt.Errorf("%w: %s cannot be combined with other codes", ErrPromotionNotApplicable, p.Code)
+		}
+		if !p.ValidAt(now) {
+			return fmt.Errorf("%w: %s is not valid at this time", ErrPromotionNotApplicable, p.Code)
+		}
+	}
+
+	for _, p := range promotions {
+		applied := false
+		left := p.Value
+		for i, flight := range flights {
+			if !p.AppliesTo(flight, fareClass) {
+				continue
+			}
+			applied = true
+			fare := fares[i]
+			discountable := roundAmount(fare.BaseFare + fare.DiscountTotal())
+			amount := left
+			if p.Kind == PercentDiscount {
+				amount = roundAmount(discountable * p.Value / 100)
+			}
+			if amount > discountable {
+				amount = discountable
+			}
+			if p.Kind == FixedDiscount {
+				left = roundAmount(left - amount)
+			}
+			if amount <= 0 {
+				continue
+			}
+			fare.Discounts = append(fare.Discounts, FareLine{Kind: DiscountFee, Code: p.Code, Description: "Promo code " + p.Code, Amount: -amount})
+			fare.Total = roundAmount(fare.Total - amount)
+		}
+		if !applied {
+			return fmt.Errorf("%w: %s does not apply to the booked flights", ErrPromotionNotApplicable, p.Code)
+		}
+	}
+	return nil
+}
+
+// DiscountTotal is the sum of the discounts on the fare, it is negative or zero
+func (b *FareBreakdown) DiscountTotal() float64 {
+	total := 0.0
+	for _, line := range b.Discounts {
+		total += line.Amount
+	}
+	return roundAmount(total)
+}
+
+// Discount is what the booking saved with the promo code over all of its segments
+func (b *Booking) Discount(code string) float64 {
+	total := 0.0
+	for _, segment := range b.Segments {
+		if segment.Fare == nil {
+			continue
+		}
+		for _, line := range segment.Fare.Discounts {
+			if line.Code == code {
+				total -= line.Amount
+			}
+		}
+	}
+	return roundAmount(total)
+}
+
+func (p *Promotion) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(p)
+}
+
+func (p *Promotion) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(p)
+}
+
+func (p *Promotions) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(p)
+}
+
+func (r *PromotionReports) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Refund records the money returned for a cancelled booking
+type Refund struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BookingId string             `bson:"bookingId" json:"bookingId"`
+	UserId    string             `bson:"userId" json:"userId"`
+	Amount    float64            `bson:"amount" json:"amount"`
+	Penalty   float64            `bson:"penalty" json:"penalty"`
+	Currency  string             `bson:"currency" json:"currency"`
+	ByAdmin   bool               `bson:"byAdmin" json:"byAdmin"`
+	PaymentId string             `bson:"paymentId,omitempty" json:"paymentId,omitempty"`
+	Reason    string             `bson:"reason" json:"reason"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	// Settled is set once the amount was returned through the payment provider
+	Settled bool `bson:"settled" json:"settled"`
+}
+
+type Refunds []*Refund
+
+// SegmentRefund works out how much of a segment's fare is returned when it is cancelled at the given time.
+// Waiving the penalty (admin cancellations) returns the full fare.
+func SegmentRefund(segment *Segment, departure time.Time, now time.Time, waivePenalty bool) (amount float64, penalty float64) {
+	if segment.Fare == nil {
+		return 0, 0
+	}
+	total := segment.Fare.Total
+	if waivePenalty {
+		return total, 0
+	}
+
+	rules := segment.FareRules()
+	deadline := departure.Add(-time.Duration(rules.CancellationDeadlineHours) * time.Hour)
+	if rules.Refundable && now.Before(deadline) {
+		fee := rules.CancellationFee * float64(segment.Fare.Passengers)
+		if fee > total {
+			fee = total
+		}
+		return roundAmount(total - fee), roundAmount(fee)
+	}
+
+	// Taxes and ancillaries of a flight that is not flown are always returned
+	returned := segment.Fare.AncillaryTotal()
+	for _, tax := range segment.Fare.Taxes {
+		returned += tax.Amount
+	}
+	return roundAmount(returned), roundAmount(total - returned)
+}
+
+func (r *Refund) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
+
+func (r *Refunds) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(r)
+}
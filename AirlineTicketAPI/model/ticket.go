@@ -1,7 +1,9 @@
 package model
 
 import (
+	"Rest/payments"
 	"encoding/json"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io"
 )
@@ -11,10 +13,37 @@ type Ticket struct {
 	UserId        string             `bson:"userId" json:"userId"`
 	FlightId      string             `bson:"flightId" json:"flightId"`
 	NumberOfSeats int                `bson:"numberOfSeats" json:"numberOfSeats"`
+	SelectedSeats int                `bson:"selectedSeats,omitempty" json:"selectedSeats,omitempty"`
+	CheckedBags   int                `bson:"checkedBags,omitempty" json:"checkedBags,omitempty"`
+	Fare          *FareBreakdown     `bson:"fare,omitempty" json:"fare,omitempty"`
+	// Disruption is set when the flight was cancelled, tickets are rebooked or refunded by staff
+	Disruption *Disruption `bson:"disruption,omitempty" json:"disruption,omitempty"`
+	// BookingId is the booking a ticket bought through holds and checkout was issued for, its seats and payment are the booking's
+	BookingId string `bson:"bookingId,omitempty" json:"bookingId,omitempty"`
+	// Passengers and Card are only read from the purchase, the passengers are kept on the booking and the card is never stored
+	Passengers []Passenger    `bson:"-" json:"passengers,omitempty"`
+	Card       *payments.Card `bson:"-" json:"card,omitempty"`
 }
 
 type Tickets []*Ticket
 
+// BookingRequest is what the purchase of the ticket holds and pays for, a booking of its one flight.
+// Without passengers the account holder travels alone.
+func (t *Ticket) BookingRequest(holder *User) (*BookingRequest, error) {
+	if t.NumberOfSeats < 0 {
+		return nil, errors.New("invalid number of seats")
+	}
+	passengers := t.Passengers
+	if len(passengers) == 0 {
+		if t.NumberOfSeats > 1 {
+			return nil, errors.New("passengers are required for more than one seat")
+		}
+		passengers = []Passenger{{Name: holder.Name, Surname: holder.Surname, DateOfBirth: holder.BirthDate, Type: AdultPassenger}}
+	}
+	return &BookingRequest{FlightIds: []string{t.FlightId}, Passengers: passengers, SelectedSeats: t.SelectedSeats,
+		CheckedBags: t.CheckedBags, Ticket: true}, nil
+}
+
 func (t *Ticket) FromJSON(r io.Reader) error {
 	d := json.NewDecoder(r)
 	return d.Decode(t)
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"Rest/vault"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PassportDocument = "passport"
+	IdCardDocument   = "id_card"
+)
+
+var (
+	documentNumberPattern = regexp.MustCompile(`^[A-Z0-9]{5,20}$`)
+	countryCodePattern    = regexp.MustCompile(`^[A-Z]{3}$`)
+	airportCodePattern    = regexp.MustCompile(`^[A-Z]{3}$`)
+	genderPattern         = regexp.MustCompile(`^[MFX]$`)
+)
+
+// TravelDocument is a passport or identity card. Its number is encrypted at rest and masked in responses.
+// Nationality and the issuing country are ISO 3166 three letter codes.
+type TravelDocument struct {
+	Type           string    `bson:"type" json:"type"`
+	Number         string    `bson:"number" json:"number"`
+	Nationality    string    `bson:"nationality" json:"nationality"`
+	IssuingCountry string    `bson:"issuingCountry" json:"issuingCountry"`
+	ExpiresOn      time.Time `bson:"expiresOn" json:"expiresOn"`
+}
+
+type FrequentFlyerNumber struct {
+	Airline string `bson:"airline" json:"airline"`
+	Number  string `bson:"number" json:"number"`
+}
+
+// Traveler is a saved profile of someone the user books for, themselves or family members
+type Traveler struct {
+	ID             primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	UserId         string                `bson:"userId" json:"userId"`
+	Name           string                `bson:"name" json:"name"`
+	Surname        string                `bson:"surname" json:"surname"`
+	DateOfBirth    time.Time             `bson:"dateOfBirth" json:"dateOfBirth"`
+	Gender         string                `bson:"gender,omitempty" json:"gender,omitempty"`
+	Documents      []TravelDocument      `bson:"documents" json:"documents"`
+	FrequentFlyers []FrequentFlyerNumber `bson:"frequentFlyers,omitempty" json:"frequentFlyers,omitempty"`
+	CreatedAt      time.Time             `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time             `bson:"updatedAt" json:"updatedAt"`
+}
+
+type Travelers []*Traveler
+
+// Validate checks the profile and upper-cases its codes and document numbers
+func (t *Traveler) Validate() error {
+	if strings.TrimSpace(t.Name) == "" || strings.TrimSpace(t.Surname) == "" {
+		return errors.New("name and surname are required")
+	}
+	if t.DateOfBirth.IsZero() || t.DateOfBirth.After(time.Now()) {
+		return errors.New("invalid date of birth")
+	}
+	t.Gender = strings.ToUpper(t.Gender)
+	if t.Gender != "" && !genderPattern.MatchString(t.Gender) {
+		return errors.New("gender must be M, F or X")
+	}
+	for i := range t.Documents {
+		d := &t.Documents[i]
+		d.Number = strings.ToUpper(strings.ReplaceAll(d.Number, " ", ""))
+		d.Nationality = strings.ToUpper(d.Nationality)
+		d.IssuingCountry = strings.ToUpper(d.IssuingCountry)
+		if d.Type != PassportDocument && d.Type != IdCardDocument {
+			return fmt.Errorf("document %d: type must be passport or id_card", i+1)
+		}
+		if !documentNumberPattern.MatchString(d.Number) {
+			return fmt.Errorf("document %d: number must be 5 to 20 letters and digits", i+1)
+		}
+		if !countryCodePattern.MatchString(d.Nationality) || !countryCodePattern.MatchString(d.IssuingCountry) {
+			return fmt.Errorf("document %d: nationality and issuing country must be three letter country codes", i+1)
+		}
+		if d.ExpiresOn.IsZero() {
+			return fmt.Errorf("document %d: expiry date is required", i+1)
+		}
+	}
+	for i := range t.FrequentFlyers {
+		f := &t.FrequentFlyers[i]
+		f.Airline = strings.ToUpper(f.Airline)
+		if len(f.Airline) != 2 || strings.TrimSpace(f.Number) == "" {
+			return errors.New("frequent-flyer numbers need a two letter airline code and a number")
+		}
+	}
+	return nil
+}
+
+// PassengerType is the type the traveler books as on the given date
+func (t *Traveler) PassengerType(on time.Time) PassengerType {
+	switch age := ageOn(t.DateOfBirth, on); {
+	case age < 2:
+		return InfantPassenger
+	case age < 12:
+		return ChildPassenger
+	}
+	return AdultPassenger
+}
+
+func ageOn(birth time.Time, on time.Time) int {
+	age := on.Year() - birth.Year()
+	if on.YearDay() < birth.YearDay() {
+		age--
+	}
+	return age
+}
+
+// Document returns the travel document valid the longest, nil if the traveler has none
+func (t *Traveler) Document() *TravelDocument {
+	var document *TravelDocument
+	for i := range t.Documents {
+		if document == nil || t.Documents[i].ExpiresOn.After(document.ExpiresOn) {
+			document = &t.Documents[i]
+		}
+	}
+	return document
+}
+
+// KeepNumbers takes the numbers of documents sent back masked from the stored profile, matched by their position.
+// A masked number that does not mask the stored document at its position stays masked and fails validation.
+func (t *Traveler) KeepNumbers(stored *Traveler) {
+	for i := range t.Documents {
+		if !strings.Contains(t.Documents[i].Number, "*") || i >= len(stored.Documents) {
+			continue
+		}
+		if d := stored.Documents[i]; vault.Mask(d.Number) == t.Documents[i].Number {
+			t.Documents[i].Number = d.Number
+		}
+	}
+}
+
+// FillPassenger completes the passenger with the data of the traveler, details given on the passenger are kept
+func (t *Traveler) FillPassenger(p *Passenger, now time.Time) {
+	p.TravelerId = t.ID.Hex()
+	if p.Name == "" {
+		p.Name = t.Name
+	}
+	if p.Surname == "" {
+		p.Surname = t.Surname
+	}
+	if p.DateOfBirth.IsZero() {
+		p.DateOfBirth = t.DateOfBirth
+	}
+	if p.Type == "" {
+		p.Type = t.PassengerType(now)
+	}
+	if p.Gender == "" {
+		p.Gender = t.Gender
+	}
+	if document := t.Document(); document != nil && p.Document == "" {
+		expiresOn := document.ExpiresOn
+		p.Document = document.Number
+		p.DocumentType = document.Type
+		p.Nationality = document.Nationality
+		p.DocumentExpiry = &expiresOn
+	}
+	if len(t.FrequentFlyers) > 0 && p.FrequentFlyer == "" {
+		p.FrequentFlyer = t.FrequentFlyers[0].Airline + t.FrequentFlyers[0].Number
+	}
+}
+
+func (t *Traveler) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(t)
+}
+
+func (t *Traveler) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(t)
+}
+
+func (t *Travelers) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(t)
+}
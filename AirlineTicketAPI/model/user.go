@@ -18,6 +18,21 @@ type User struct {
 	Password    string             `bson:"password" json:"password"`
 	BirthDate   time.Time          `bson:"birthdate,omitempty" json:"birthdate"`
 	Role        Role               `bson:"role" json:"role"`
+	// Language of the emails sent to the user, en if empty
+	Language string `bson:"language,omitempty" json:"language"`
+	// LoyaltyTier is empty for members without a tier
+	LoyaltyTier string `bson:"loyaltyTier,omitempty" json:"loyaltyTier,omitempty"`
+	// EmailUnverified is set on registration until the link sent to the email is opened,
+	// accounts registered before verification existed count as verified
+	EmailUnverified bool `bson:"emailUnverified,omitempty" json:"emailUnverified,omitempty"`
+	// PendingEmail replaces Email once the link sent to it is opened
+	PendingEmail      string             `bson:"pendingEmail,omitempty" json:"pendingEmail,omitempty"`
+	EmailVerification *EmailVerification `bson:"emailVerification,omitempty" json:"-"`
+	PasswordReset     *PasswordReset     `bson:"passwordReset,omitempty" json:"-"`
+	// SessionVersion is carried by the login tokens, raising it revokes every token issued before
+	SessionVersion int `bson:"sessionVersion,omitempty" json:"-"`
+	// DeletedAt is set when the account was deleted and its personal data anonymized
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
 }
 
 type Role int
@@ -25,6 +40,14 @@ type Role int
 const (
 	Client = iota
 	Admin
+	Ops
+	// Partner is a travel agency or other reseller that books through the API and receives webhooks
+	Partner
+)
+
+const (
+	TierSilver = "silver"
+	TierGold   = "gold"
 )
 
 type Users []*User
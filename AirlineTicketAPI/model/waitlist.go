@@ -0,0 +1,96 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WaitlistWaiting = "waiting"
+	// WaitlistOffering is set while seats are being held for the entry
+	WaitlistOffering  = "offering"
+	WaitlistOffered   = "offered"
+	WaitlistCancelled = "cancelled"
+)
+
+// DefaultFareClass is the economy booking class used when the customer does not ask for one
+const DefaultFareClass = "Y"
+
+var fareClassPattern = regexp.MustCompile(`^[A-Z]$`)
+
+// WaitlistEntry waits for seats in a fare class of a sold out flight. When seats are released the first entry
+// that fits gets a hold on them; entries are served by loyalty tier first and by the time they joined second.
+// A flight has one seat inventory, so the fare classes of a flight share one queue.
+type WaitlistEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FlightId  string             `bson:"flightId" json:"flightId"`
+	FareClass string             `bson:"fareClass" json:"fareClass"`
+	UserId    string             `bson:"userId" json:"userId"`
+	Request   BookingRequest     `bson:"request" json:"request"`
+	Seats     int                `bson:"seats" json:"seats"`
+	Priority  int                `bson:"priority" json:"priority"`
+	Status    string             `bson:"status" json:"status"`
+	HoldId    string             `bson:"holdId,omitempty" json:"holdId,omitempty"`
+	// LockedUntil lets another instance take over an offer that was interrupted
+	LockedUntil *time.Time `bson:"lockedUntil,omitempty" json:"-"`
+	// Position is the number of entries ahead in the queue plus one, it is never stored
+	Position  int        `bson:"-" json:"position,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	OfferedAt *time.Time `bson:"offeredAt,omitempty" json:"offeredAt,omitempty"`
+}
+
+type WaitlistEntries []*WaitlistEntry
+
+// WaitlistRequest joins the waitlist of a flight with the passengers that would be booked
+type WaitlistRequest struct {
+	FareClass     string      `json:"fareClass"`
+	Passengers    []Passenger `json:"passengers"`
+	SelectedSeats int         `json:"selectedSeats"`
+	CheckedBags   int         `json:"checkedBags"`
+}
+
+// TierPriority ranks loyalty tiers on the waitlist, higher is served first
+func TierPriority(tier string) int {
+	switch tier {
+	case TierGold:
+		return 2
+	case TierSilver:
+		return 1
+	}
+	return 0
+}
+
+// BookingRequest turns the waitlist request into the request the hold is placed with
+func (r *WaitlistRequest) BookingRequest(flightId string) (*BookingRequest, error) {
+	if r.FareClass == "" {
+		r.FareClass = DefaultFareClass
+	}
+	if !fareClassPattern.MatchString(r.FareClass) {
+		return nil, errors.New("fareClass must be a single booking class letter")
+	}
+	request := &BookingRequest{FlightIds: []string{flightId}, FareClass: r.FareClass, Passengers: r.Passengers, SelectedSeats: r.SelectedSeats, CheckedBags: r.CheckedBags}
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (e *WaitlistEntry) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(e)
+}
+
+func (e *WaitlistEntries) ToJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(e)
+}
+
+func (r *WaitlistRequest) FromJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	return d.Decode(r)
+}
@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is a rendered email ready to be handed to a transport
+type Message struct {
+	To          string       `bson:"to" json:"to"`
+	Subject     string       `bson:"subject" json:"subject"`
+	Text        string       `bson:"text" json:"text"`
+	HTML        string       `bson:"html" json:"html"`
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+}
+
+type Attachment struct {
+	Filename    string `bson:"filename" json:"filename"`
+	ContentType string `bson:"contentType" json:"contentType"`
+	Data        []byte `bson:"data" json:"-"`
+}
+
+// Bytes encodes the message as MIME: a text and an HTML alternative, followed by the attachments
+func (m *Message) Bytes(from string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(&buf, "MIME-Version: 1.0\r\n")
+
+	mixed := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+
+	var alternatives bytes.Buffer
+	alternative := multipart.NewWriter(&alternatives)
+	if err := writePart(alternative, "text/plain; charset=utf-8", "", []byte(m.Text)); err != nil {
+		return nil, err
+	}
+	if err := writePart(alternative, "text/html; charset=utf-8", "", []byte(m.HTML)); err != nil {
+		return nil, err
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "multipart/alternative; boundary="+alternative.Boundary())
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(alternatives.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range m.Attachments {
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})
+		if err := writePart(mixed, attachment.ContentType, disposition, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePart adds a base64 encoded part, wrapped at 76 characters per line as MIME requires
+func writePart(w *multipart.Writer, contentType string, disposition string, data []byte) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	if disposition != "" {
+		header.Set("Content-Disposition", disposition)
+	}
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var lines strings.Builder
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded + "\r\n")
+	_, err = part.Write([]byte(lines.String()))
+	return err
+}
@@ -0,0 +1,41 @@
+package notifications
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	Pending = "pending"
+	Sent    = "sent"
+	// Failed notifications gave up after MaxAttempts and stay in the queue for inspection
+	Failed = "failed"
+)
+
+var ErrQueueEmpty = errors.New("no notification is due")
+
+// Notification is a rendered message waiting in the queue until a transport accepts it
+type Notification struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Template      string             `bson:"template" json:"template"`
+	Language      string             `bson:"language" json:"language"`
+	Message       Message            `bson:"message" json:"message"`
+	Status        string             `bson:"status" json:"status"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time          `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LastError     string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	SentAt        *time.Time         `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+}
+
+// Queue stores notifications until they are delivered, it survives restarts and mail outages
+type Queue interface {
+	Enqueue(notification *Notification) error
+	// ClaimDue locks the oldest due notification for lockFor so that no other worker sends it meanwhile,
+	// it returns ErrQueueEmpty if nothing is due
+	ClaimDue(lockFor time.Duration) (*Notification, error)
+	// Save stores the outcome of a delivery attempt
+	Save(notification *Notification) error
+}
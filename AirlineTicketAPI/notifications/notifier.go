@@ -0,0 +1,117 @@
+package notifications
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	MaxAttempts = 10
+	// The first retry waits retryDelay, every further one twice as long up to maxRetryDelay
+	retryDelay    = time.Minute
+	maxRetryDelay = 6 * time.Hour
+	sendTimeout   = 30 * time.Second
+)
+
+// Notifier renders messages into the queue and delivers them in the background,
+// so a mail outage only delays emails and never fails the request that caused them
+type Notifier struct {
+	logger    *log.Logger
+	queue     Queue
+	transport Transport
+}
+
+func NewNotifier(l *log.Logger, q Queue, t Transport) *Notifier {
+	return &Notifier{l, q, t}
+}
+
+// Notify queues the template rendered in the given language for the recipient
+func (n *Notifier) Notify(to string, language string, template string, data Data, attachments ...Attachment) error {
+	message, err := Render(template, language, data)
+	if err != nil {
+		return err
+	}
+	message.To = to
+	message.Attachments = attachments
+
+	now := time.Now()
+	return n.queue.Enqueue(&Notification{
+		Template:      template,
+		Language:      language,
+		Message:       message,
+		Status:        Pending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+}
+
+// Start delivers due notifications every interval until the context is done.
+// Several instances can run it at once, every notification is claimed by one of them.
+func (n *Notifier) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n.deliverDue(ctx)
+			}
+		}
+	}()
+}
+
+func (n *Notifier) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		notification, err := n.queue.ClaimDue(2 * sendTimeout)
+		if err == ErrQueueEmpty {
+			return
+		}
+		if err != nil {
+			n.logger.Print("Unable to read the notification queue: ", err)
+			return
+		}
+		n.deliver(ctx, notification)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, notification *Notification) {
+	sendContext, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	notification.Attempts++
+	err := n.transport.Send(sendContext, notification.Message)
+	if err == nil {
+		now := time.Now()
+		notification.Status = Sent
+		notification.SentAt = &now
+		notification.LastError = ""
+	} else {
+		notification.LastError = err.Error()
+		if notification.Attempts >= MaxAttempts {
+			notification.Status = Failed
+			n.logger.Printf("Giving up on %s to %s after %d attempts: %v", notification.Template, notification.Message.To, notification.Attempts, err)
+		} else {
+			notification.NextAttemptAt = time.Now().Add(backoff(notification.Attempts))
+			n.logger.Printf("Sending %s to %s failed, retrying at %s: %v", notification.Template, notification.Message.To,
+				notification.NextAttemptAt.Format(time.RFC3339), err)
+		}
+	}
+
+	if err := n.queue.Save(notification); err != nil {
+		n.logger.Printf("Unable to save notification %s: %v", notification.ID.Hex(), err)
+	}
+}
+
+func backoff(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
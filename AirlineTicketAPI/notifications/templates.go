@@ -0,0 +1,77 @@
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates every language has to provide
+const (
+	RegistrationTemplate        = "registration"
+	BookingConfirmationTemplate = "booking_confirmation"
+	CancellationTemplate        = "cancellation"
+	ScheduleChangeTemplate      = "schedule_change"
+	PasswordResetTemplate       = "password_reset"
+	WaitlistOfferTemplate       = "waitlist_offer"
+	EmailVerificationTemplate   = "email_verification"
+)
+
+// DefaultLanguage is used for users without a language and for languages we have no templates for
+const DefaultLanguage = "en"
+
+var Languages = []string{"en", "sr"}
+
+//go:embed templates
+var templateFS embed.FS
+
+// Data is what a template is rendered with
+type Data map[string]interface{}
+
+// Render produces the subject, text and HTML of a template in the given language.
+// The .txt template defines the subject and the text body, the .html template the title and content of the language's layout.
+func Render(name string, language string, data Data) (Message, error) {
+	if !Supported(language) {
+		language = DefaultLanguage
+	}
+	dir := "templates/" + language + "/"
+
+	text, err := texttemplate.ParseFS(templateFS, dir+name+".txt")
+	if err != nil {
+		return Message{}, fmt.Errorf("unknown template %s: %w", name, err)
+	}
+	html, err := htmltemplate.ParseFS(templateFS, dir+"layout.html", dir+name+".html")
+	if err != nil {
+		return Message{}, fmt.Errorf("unknown template %s: %w", name, err)
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return Message{}, err
+	}
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    textBody.String(),
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// Supported reports whether there are templates in the language
+func Supported(language string) bool {
+	for _, l := range Languages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
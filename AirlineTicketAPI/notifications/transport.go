@@ -0,0 +1,158 @@
+package notifications
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport delivers a rendered message
+type Transport interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewTransportFromEnv picks the transport named by MAIL_TRANSPORT: smtp (the default), file or memory.
+// SMTP uses SMTP_HOST, SMTP_PORT, SMTP_USERNAME and SMTP_PASSWORD, the file sink writes into MAIL_DIR.
+func NewTransportFromEnv() Transport {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@airline.local"
+	}
+
+	switch os.Getenv("MAIL_TRANSPORT") {
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileTransport(dir, from)
+	case "memory":
+		return NewMemoryTransport()
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	return &SMTPTransport{
+		Addr:     net.JoinHostPort(os.Getenv("SMTP_HOST"), port),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// SMTPTransport sends through an SMTP server, upgrading to TLS when the server offers STARTTLS
+type SMTPTransport struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, message Message) error {
+	data, err := message.Bytes(t.From)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(t.Addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.Username, t.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(t.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileTransport writes every message as an .eml file, handy for looking at mails locally
+type FileTransport struct {
+	dir  string
+	from string
+}
+
+func NewFileTransport(dir string, from string) *FileTransport {
+	return &FileTransport{dir, from}
+}
+
+func (t *FileTransport) Send(ctx context.Context, message Message) error {
+	data, err := message.Bytes(t.from)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(message.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), recipient)
+	return os.WriteFile(filepath.Join(t.dir, name), data, 0644)
+}
+
+// MemoryTransport keeps the messages in memory so tests can inspect what would have been sent
+type MemoryTransport struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, message Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, message)
+	return nil
+}
+
+// Sent returns a copy of all messages sent so far
+func (t *MemoryTransport) Sent() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.sent...)
+}
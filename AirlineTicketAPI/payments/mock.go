@@ -0,0 +1,126 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Test cards understood by the mock gateway, every other card number is approved
+const (
+	CardDeclined        = "4000000000000002"
+	CardChallenge       = "4000000000003220"
+	CardTimeout         = "4000000000000119"
+	CardCaptureDeclined = "4000000000000341"
+)
+
+// ChallengeCode is the 3-D Secure code the mock gateway accepts
+const ChallengeCode = "123456"
+
+type mockState string
+
+const (
+	mockPending    mockState = "pending"
+	mockAuthorized mockState = "authorized"
+	mockCaptured   mockState = "captured"
+	mockVoided     mockState = "voided"
+)
+
+type mockPayment struct {
+	card     string
+	amount   float64
+	refunded float64
+	state    mockState
+}
+
+// MockProvider is a deterministic in-memory gateway for local development.
+// The outcome depends only on the card number, see the Card* constants.
+type MockProvider struct {
+	mu       sync.Mutex
+	next     int
+	payments map[string]*mockPayment
+}
+
+func NewMockProvider() *MockProvider {
+	return &MockProvider{payments: map[string]*mockPayment{}}
+}
+
+func (m *MockProvider) Authorize(ctx context.Context, request AuthorizeRequest) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if request.Reference != "" {
+		payment, ok := m.payments[request.Reference]
+		if !ok || payment.state != mockPending {
+			return "", ErrUnknownReference
+		}
+		if request.ChallengeResponse != ChallengeCode {
+			delete(m.payments, request.Reference)
+			return "", ErrChallengeFailed
+		}
+		payment.state = mockAuthorized
+		return request.Reference, nil
+	}
+
+	card := strings.ReplaceAll(request.Card.Number, " ", "")
+	switch card {
+	case CardDeclined:
+		return "", ErrDeclined
+	case CardTimeout:
+		return "", ErrTimeout
+	}
+	if request.Amount <= 0 || len(card) < 12 {
+		return "", ErrDeclined
+	}
+
+	m.next++
+	reference := fmt.Sprintf("mock_%06d", m.next)
+	payment := &mockPayment{card: card, amount: request.Amount, state: mockAuthorized}
+	m.payments[reference] = payment
+	if card == CardChallenge {
+		payment.state = mockPending
+		return reference, ErrChallengeRequired
+	}
+	return reference, nil
+}
+
+func (m *MockProvider) Capture(ctx context.Context, reference string, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[reference]
+	if !ok || payment.state != mockAuthorized || amount > payment.amount {
+		return ErrUnknownReference
+	}
+	if payment.card == CardCaptureDeclined {
+		return ErrDeclined
+	}
+	payment.state = mockCaptured
+	payment.amount = amount
+	return nil
+}
+
+func (m *MockProvider) Void(ctx context.Context, reference string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[reference]
+	if !ok || (payment.state != mockAuthorized && payment.state != mockPending) {
+		return ErrUnknownReference
+	}
+	payment.state = mockVoided
+	return nil
+}
+
+func (m *MockProvider) Refund(ctx context.Context, reference string, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[reference]
+	if !ok || payment.state != mockCaptured || payment.refunded+amount > payment.amount+0.001 {
+		return ErrUnknownReference
+	}
+	payment.refunded += amount
+	return nil
+}
@@ -0,0 +1,80 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Status string
+
+const (
+	Created           Status = "created"
+	RequiresAction    Status = "requires_action"
+	Authorized        Status = "authorized"
+	Captured          Status = "captured"
+	Voided            Status = "voided"
+	PartiallyRefunded Status = "partially_refunded"
+	Refunded          Status = "refunded"
+	Failed            Status = "failed"
+)
+
+// transitions lists the states a payment may move to from each state
+var transitions = map[Status][]Status{
+	Created:           {RequiresAction, Authorized, Failed},
+	RequiresAction:    {Authorized, Failed},
+	Authorized:        {Captured, Voided, Failed},
+	Captured:          {PartiallyRefunded, Refunded},
+	PartiallyRefunded: {PartiallyRefunded, Refunded},
+}
+
+type Transition struct {
+	From Status    `bson:"from" json:"from"`
+	To   Status    `bson:"to" json:"to"`
+	At   time.Time `bson:"at" json:"at"`
+}
+
+// Payment is the money taken for one hold, it ends up captured for the booking created from it.
+// Exchanging a booked flight for a dearer one takes a payment of its own, without a hold.
+type Payment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId        string             `bson:"userId" json:"userId"`
+	HoldId        string             `bson:"holdId" json:"holdId"`
+	BookingId     string             `bson:"bookingId,omitempty" json:"bookingId,omitempty"`
+	Amount        float64            `bson:"amount" json:"amount"`
+	Refunded      float64            `bson:"refunded" json:"refunded"`
+	Currency      string             `bson:"currency" json:"currency"`
+	Status        Status             `bson:"status" json:"status"`
+	Reference     string             `bson:"reference,omitempty" json:"-"`
+	CardLast4     string             `bson:"cardLast4" json:"cardLast4"`
+	FailureReason string             `bson:"failureReason,omitempty" json:"failureReason,omitempty"`
+	History       []Transition       `bson:"history" json:"history"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// MoveTo changes the payment status if the state machine allows it
+func (p *Payment) MoveTo(to Status) error {
+	for _, allowed := range transitions[p.Status] {
+		if allowed == to {
+			p.History = append(p.History, Transition{From: p.Status, To: to, At: time.Now()})
+			p.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("payment cannot move from %s to %s", p.Status, to)
+}
+
+// Fail moves the payment to failed, remembering why
+func (p *Payment) Fail(reason error) {
+	if err := p.MoveTo(Failed); err == nil {
+		p.FailureReason = reason.Error()
+	}
+}
+
+func (p *Payment) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(p)
+}
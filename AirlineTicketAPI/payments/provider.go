@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var (
+	ErrDeclined          = errors.New("payment declined")
+	ErrTimeout           = errors.New("payment provider timed out")
+	ErrChallengeRequired = errors.New("3-D Secure challenge required")
+	ErrChallengeFailed   = errors.New("3-D Secure challenge failed")
+	ErrUnknownReference  = errors.New("unknown payment reference")
+)
+
+type Card struct {
+	Number string `json:"number"`
+	Expiry string `json:"expiry"`
+	CVC    string `json:"cvc"`
+	Holder string `json:"holder"`
+}
+
+type AuthorizeRequest struct {
+	Amount   float64
+	Currency string
+	Card     Card
+	// Reference and ChallengeResponse complete a 3-D Secure challenge of an earlier Authorize call
+	Reference         string
+	ChallengeResponse string
+}
+
+// Provider is a payment gateway. Authorize returns the gateway's reference for the payment;
+// when the card needs 3-D Secure it returns the reference together with ErrChallengeRequired
+// and the authorization is finished by calling Authorize again with the challenge response.
+type Provider interface {
+	Authorize(ctx context.Context, request AuthorizeRequest) (string, error)
+	Capture(ctx context.Context, reference string, amount float64) error
+	Void(ctx context.Context, reference string) error
+	Refund(ctx context.Context, reference string, amount float64) error
+}
+
+func (c Card) Last4() string {
+	if len(c.Number) < 4 {
+		return c.Number
+	}
+	return c.Number[len(c.Number)-4:]
+}
+
+type ChallengeResponse struct {
+	Code string `json:"code"`
+}
+
+func (c *Card) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(c)
+}
+
+func (c *ChallengeResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(c)
+}
@@ -0,0 +1,221 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var ErrAncillarySoldOut = errors.New("ancillary is sold out on the flight")
+
+// NoSQL: AncillaryRepo struct encapsulating Mongo api client
+type AncillaryRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewAncillaryRepo(ctx context.Context, logger *log.Logger) (*AncillaryRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &AncillaryRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// One sold counter per ancillary and flight, a reservation that would overrun it collides here
+	_, err = ar.getInventoryCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ancillaryId", Value: 1}, {Key: "flightId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return ar, nil
+}
+
+// Disconnect from database
+func (ar *AncillaryRepo) DisconnectAncillaryRepo(ctx context.Context) error {
+	err := ar.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (ar *AncillaryRepo) PingAncillaryRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := ar.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		ar.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := ar.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		ar.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (ar *AncillaryRepo) GetAll() (model.Ancillaries, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ancillariesCollection := ar.getCollection()
+
+	ancillaries := model.Ancillaries{}
+	cursor, err := ancillariesCollection.Find(ctx, bson.M{})
+	if err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &ancillaries); err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	return ancillaries, nil
+}
+
+func (ar *AncillaryRepo) Insert(ancillary *model.Ancillary) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ancillariesCollection := ar.getCollection()
+
+	result, err := ancillariesCollection.InsertOne(ctx, ancillary)
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	ancillary.ID = result.InsertedID.(primitive.ObjectID)
+	ar.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (ar *AncillaryRepo) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ancillariesCollection := ar.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := ancillariesCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	ar.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	return nil
+}
+
+// Sold returns how many of each ancillary were sold on the flight, by ancillary id
+func (ar *AncillaryRepo) Sold(flightId string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inventoryCollection := ar.getInventoryCollection()
+
+	var counters []struct {
+		AncillaryId string `bson:"ancillaryId"`
+		Sold        int    `bson:"sold"`
+	}
+	cursor, err := inventoryCollection.Find(ctx, bson.M{"flightId": flightId})
+	if err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &counters); err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	sold := map[string]int{}
+	for _, counter := range counters {
+		sold[counter.AncillaryId] = counter.Sold
+	}
+	return sold, nil
+}
+
+// Reserve atomically takes quantity of the ancillary's inventory on the flight.
+// It returns ErrAncillarySoldOut if fewer than quantity are left.
+func (ar *AncillaryRepo) Reserve(ancillaryId string, flightId string, quantity int, inventory int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inventoryCollection := ar.getInventoryCollection()
+
+	err := reserveAncillaryIn(ctx, inventoryCollection, ancillaryId, flightId, quantity, inventory)
+	if err != nil && err != ErrAncillarySoldOut {
+		ar.logger.Println(err)
+	}
+	return err
+}
+
+// reserveAncillaryIn counts quantity of the ancillary as sold on the flight, also within a transaction.
+// It returns ErrAncillarySoldOut if fewer than quantity are left of the inventory.
+func reserveAncillaryIn(ctx context.Context, inventoryCollection *mongo.Collection, ancillaryId string, flightId string, quantity int,
+	inventory int) error {
+	if quantity > inventory {
+		return ErrAncillarySoldOut
+	}
+	filter := bson.M{
+		"ancillaryId": ancillaryId,
+		"flightId":    flightId,
+		"sold":        bson.M{"$lte": inventory - quantity},
+	}
+	update := bson.M{"$inc": bson.M{"sold": quantity}}
+	_, err := inventoryCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	// The upsert collides with the counter of the flight when not enough are left
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAncillarySoldOut
+	}
+	return err
+}
+
+// Release gives quantity of the ancillary back to the flight's inventory
+func (ar *AncillaryRepo) Release(ancillaryId string, flightId string, quantity int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inventoryCollection := ar.getInventoryCollection()
+
+	filter := bson.M{"ancillaryId": ancillaryId, "flightId": flightId}
+	_, err := inventoryCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"sold": -quantity}})
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (ar *AncillaryRepo) getCollection() *mongo.Collection {
+	ancillaryDatabase := ar.cli.Database("mongoDemo")
+	ancillariesCollection := ancillaryDatabase.Collection("ancillaries")
+	return ancillariesCollection
+}
+
+func (ar *AncillaryRepo) getInventoryCollection() *mongo.Collection {
+	ancillaryDatabase := ar.cli.Database("mongoDemo")
+	inventoryCollection := ancillaryDatabase.Collection("ancillaryInventory")
+	return inventoryCollection
+}
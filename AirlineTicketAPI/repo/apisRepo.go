@@ -0,0 +1,197 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var ErrAirportExists = errors.New("airport already exists")
+
+// NoSQL: ApisRepo struct encapsulating Mongo api client
+type ApisRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewApisRepo(ctx context.Context, logger *log.Logger) (*ApisRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &ApisRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// Every airport code belongs to one country
+	_, err = ar.getAirportsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return ar, nil
+}
+
+// Disconnect from database
+func (ar *ApisRepo) DisconnectApisRepo(ctx context.Context) error {
+	err := ar.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (ar *ApisRepo) PingApisRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := ar.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		ar.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := ar.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		ar.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (ar *ApisRepo) GetAllAirports() (model.Airports, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	airportsCollection := ar.getAirportsCollection()
+
+	airports := model.Airports{}
+	cursor, err := airportsCollection.Find(ctx, bson.M{})
+	if err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &airports); err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	return airports, nil
+}
+
+// InsertAirport returns ErrAirportExists if the airport code is taken
+func (ar *ApisRepo) InsertAirport(airport *model.Airport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	airportsCollection := ar.getAirportsCollection()
+
+	result, err := airportsCollection.InsertOne(ctx, airport)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAirportExists
+	}
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	airport.ID = result.InsertedID.(primitive.ObjectID)
+	ar.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (ar *ApisRepo) DeleteAirport(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	airportsCollection := ar.getAirportsCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := airportsCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	ar.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	return nil
+}
+
+func (ar *ApisRepo) GetAllRules() (model.DocumentRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := ar.getRulesCollection()
+
+	rules := model.DocumentRules{}
+	cursor, err := rulesCollection.Find(ctx, bson.M{})
+	if err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rules); err != nil {
+		ar.logger.Println(err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (ar *ApisRepo) InsertRule(rule *model.DocumentRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := ar.getRulesCollection()
+
+	result, err := rulesCollection.InsertOne(ctx, rule)
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	rule.ID = result.InsertedID.(primitive.ObjectID)
+	ar.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (ar *ApisRepo) DeleteRule(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := ar.getRulesCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := rulesCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		ar.logger.Println(err)
+		return err
+	}
+	ar.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	return nil
+}
+
+func (ar *ApisRepo) getAirportsCollection() *mongo.Collection {
+	apisDatabase := ar.cli.Database("mongoDemo")
+	airportsCollection := apisDatabase.Collection("airports")
+	return airportsCollection
+}
+
+func (ar *ApisRepo) getRulesCollection() *mongo.Collection {
+	apisDatabase := ar.cli.Database("mongoDemo")
+	rulesCollection := apisDatabase.Collection("documentRules")
+	return rulesCollection
+}
@@ -0,0 +1,635 @@
+package repo
+
+import (
+	"Rest/events"
+	"Rest/model"
+	"Rest/vault"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrBookingNotCancellable = errors.New("booking is not confirmed")
+	ErrDisruptionResolved    = errors.New("booking has no pending disruption")
+	ErrSegmentNotFound       = errors.New("booking has no segment on the flight")
+	ErrPassengerNotFound     = errors.New("booking has no such passenger")
+)
+
+// NoSQL: BookingRepo struct encapsulating Mongo api client
+type BookingRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+	// Encrypts the document numbers of the passengers before they are stored
+	vault *vault.Vault
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewBookingRepo(ctx context.Context, logger *log.Logger, v *vault.Vault) (*BookingRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	br := &BookingRepo{
+		cli:    client,
+		logger: logger,
+		vault:  v,
+	}
+
+	// Record locators have to be unique, Insert relies on this index to detect collisions
+	_, err = br.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "locator", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return br, nil
+}
+
+// Disconnect from database
+func (br *BookingRepo) DisconnectBookingRepo(ctx context.Context) error {
+	err := br.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (br *BookingRepo) PingBookingRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := br.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		br.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := br.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		br.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// Insert assigns a fresh record locator and coupons to the booking and stores it
+func (br *BookingRepo) Insert(booking *model.Booking) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	for attempt := 0; attempt < 5; attempt++ {
+		locator, err := newLocator()
+		if err != nil {
+			return err
+		}
+		booking.Locator = locator
+		booking.IssueCoupons()
+
+		stored := *booking
+		stored.Passengers, err = sealPassengers(br.vault, booking.Passengers)
+		if err != nil {
+			br.logger.Println(err)
+			return err
+		}
+		err = withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+			result, err := bookingsCollection.InsertOne(sc, &stored)
+			if err != nil {
+				return nil, err
+			}
+			booking.ID = result.InsertedID.(primitive.ObjectID)
+			event, err := events.New(events.BookingConfirmed, events.BookingAggregate, booking.ID.Hex(), booking)
+			return []*events.Event{event}, err
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			br.logger.Printf("Locator %s already taken, retrying", locator)
+			booking.ID = primitive.NilObjectID
+			continue
+		}
+		if err != nil {
+			br.logger.Println(err)
+			return err
+		}
+		br.logger.Printf("Documents ID: %v\n", booking.ID)
+		return nil
+	}
+	return errors.New("unable to generate a unique record locator")
+}
+
+func (br *BookingRepo) GetById(id string) (*model.Booking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bookingsCollection := br.getCollection()
+
+	var booking model.Booking
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := bookingsCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&booking)
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	br.open(&booking)
+	return &booking, nil
+}
+
+func (br *BookingRepo) GetByLocator(locator string) (*model.Booking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bookingsCollection := br.getCollection()
+
+	var booking model.Booking
+	err := bookingsCollection.FindOne(ctx, bson.M{"locator": strings.ToUpper(locator)}).Decode(&booking)
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	br.open(&booking)
+	return &booking, nil
+}
+
+func (br *BookingRepo) GetAllByUserId(userId string) (model.Bookings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bookingsCollection := br.getCollection()
+
+	bookings := model.Bookings{}
+	cursor, err := bookingsCollection.Find(ctx, bson.M{"userId": userId})
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	br.open(bookings...)
+	return bookings, nil
+}
+
+// Cancel moves a confirmed booking and all its coupons to cancelled, gives the seats and ancillaries of the release back
+// to the flights and records its refund in one transaction, the refund is settled afterwards. The status check makes
+// the transition happen exactly once, so seats and refunds are never returned twice.
+// Pending disruptions are resolved by the refund. The booking must still be on the segments the refund was worked out
+// for, it returns ErrBookingNotCancellable if it is no longer confirmed or was moved to other flights meanwhile.
+func (br *BookingRepo) Cancel(booking *model.Booking, refund *model.Refund, release *model.InventoryRelease) (*model.Booking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+	refundsCollection := br.cli.Database("mongoDemo").Collection("refunds")
+	flightsCollection := br.cli.Database("mongoDemo").Collection("flights")
+	inventoryCollection := br.cli.Database("mongoDemo").Collection("ancillaryInventory")
+
+	now := time.Now()
+	filter := bson.M{"_id": booking.ID, "status": model.BookingConfirmed, "segments": bson.M{"$size": len(booking.Segments)}}
+	if len(booking.Segments) > 0 {
+		flightIds := bson.A{}
+		for _, segment := range booking.Segments {
+			flightIds = append(flightIds, segment.FlightId)
+		}
+		filter["segments.flightId"] = bson.M{"$all": flightIds}
+	}
+	set := bson.M{
+		"status":             model.BookingCancelled,
+		"coupons.$[].status": model.CouponCancelled,
+		"cancelledAt":        now,
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if booking.PendingDisruption("") != nil {
+		set["disruptions.$[pending].resolution"] = model.DisruptionRefunded
+		set["disruptions.$[pending].resolvedAt"] = now
+		opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"pending.resolution": model.DisruptionPending}}})
+	}
+	update := bson.M{"$set": set}
+
+	var cancelled model.Booking
+	err := withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		err := bookingsCollection.FindOneAndUpdate(sc, filter, update, opts).Decode(&cancelled)
+		if err != nil {
+			return nil, err
+		}
+		br.open(&cancelled)
+		result, err := refundsCollection.InsertOne(sc, refund)
+		if err != nil {
+			return nil, err
+		}
+		refund.ID = result.InsertedID.(primitive.ObjectID)
+		event, err := events.New(events.BookingCancelled, events.BookingAggregate, cancelled.ID.Hex(), cancelled)
+		if err != nil {
+			return nil, err
+		}
+		newEvents := []*events.Event{event}
+
+		for _, flightId := range release.FlightIds {
+			flightID, _ := primitive.ObjectIDFromHex(flightId)
+			_, given, err := changeSeatsIn(sc, flightsCollection, bson.M{"_id": flightID}, release.Seats)
+			if err != nil {
+				return nil, err
+			}
+			newEvents = append(newEvents, given)
+		}
+		for _, selection := range release.Ancillaries {
+			_, err := inventoryCollection.UpdateOne(sc, bson.M{"ancillaryId": selection.AncillaryId, "flightId": selection.FlightId},
+				bson.M{"$inc": bson.M{"sold": -selection.Quantity}})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newEvents, nil
+	})
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrBookingNotCancellable
+	}
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	return &cancelled, nil
+}
+
+// GetConfirmedByFlightId returns the confirmed bookings with a segment on the flight
+func (br *BookingRepo) GetConfirmedByFlightId(flightId string) (model.Bookings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	bookings := model.Bookings{}
+	cursor, err := bookingsCollection.Find(ctx, bson.M{"segments.flightId": flightId, "status": model.BookingConfirmed})
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	br.open(bookings...)
+	return bookings, nil
+}
+
+// FlagDisrupted adds a disruption of the flight to every confirmed booking on it that does not have one yet,
+// bookings already disrupted on another flight included, and returns the bookings still waiting for a resolution
+func (br *BookingRepo) FlagDisrupted(flightId string, reason string) (model.Bookings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	filter := bson.M{
+		"segments.flightId":    flightId,
+		"status":               model.BookingConfirmed,
+		"disruptions.flightId": bson.M{"$ne": flightId},
+	}
+	update := bson.M{"$push": bson.M{"disruptions": model.Disruption{
+		FlightId:   flightId,
+		Reason:     reason,
+		FlaggedAt:  time.Now(),
+		Resolution: model.DisruptionPending,
+	}}}
+	pending := bson.M{
+		"status":      model.BookingConfirmed,
+		"disruptions": bson.M{"$elemMatch": bson.M{"flightId": flightId, "resolution": model.DisruptionPending}},
+	}
+
+	bookings := model.Bookings{}
+	err := withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		flagged := model.Bookings{}
+		cursor, err := bookingsCollection.Find(sc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(sc, &flagged); err != nil {
+			return nil, err
+		}
+		if len(flagged) == 0 {
+			return nil, nil
+		}
+		ids := bson.A{}
+		for _, booking := range flagged {
+			ids = append(ids, booking.ID)
+		}
+		if _, err := bookingsCollection.UpdateMany(sc, bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+			return nil, err
+		}
+
+		cursor, err = bookingsCollection.Find(sc, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(sc, &flagged); err != nil {
+			return nil, err
+		}
+		br.open(flagged...)
+		newEvents := []*events.Event{}
+		for _, booking := range flagged {
+			event, err := events.New(events.BookingDisrupted, events.BookingAggregate, booking.ID.Hex(), booking)
+			if err != nil {
+				return nil, err
+			}
+			newEvents = append(newEvents, event)
+		}
+		br.logger.Printf("Bookings flagged as disrupted: %v\n", len(flagged))
+		return newEvents, nil
+	})
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+
+	cursor, err := bookingsCollection.Find(ctx, pending)
+	if err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		br.logger.Println(err)
+		return nil, err
+	}
+	br.open(bookings...)
+	return bookings, nil
+}
+
+// Rebook stores the booking moved off the cancelled flight and resolves its disruption of that flight as rebooked.
+// The seats and counted ancillaries move to the new flight in the same transaction, like they do on an exchange.
+// Only a pending disruption can be resolved, so concurrent rebook and refund requests cannot both win.
+func (br *BookingRepo) Rebook(booking *model.Booking, disruption *model.Disruption, move *model.InventoryMove) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	now := time.Now()
+	disruption.Resolution = model.DisruptionRebooked
+	disruption.ResolvedAt = &now
+	filter := bson.M{
+		"_id":         booking.ID,
+		"status":      model.BookingConfirmed,
+		"disruptions": bson.M{"$elemMatch": bson.M{"flightId": disruption.FlightId, "resolution": model.DisruptionPending}},
+	}
+	update := bson.M{"$set": bson.M{
+		"segments":              booking.Segments,
+		"coupons":               booking.Coupons,
+		"ancillaries":           booking.Ancillaries,
+		"disruptions.$[flight]": disruption,
+	}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"flight.flightId": disruption.FlightId}}})
+	err := withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := bookingsCollection.UpdateOne(sc, filter, update, opts)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrDisruptionResolved
+		}
+		seatEvents, err := moveInventoryIn(sc, br.cli, move)
+		if err != nil {
+			return nil, err
+		}
+		event, err := events.New(events.BookingRebooked, events.BookingAggregate, booking.ID.Hex(), booking)
+		return append([]*events.Event{event}, seatEvents...), err
+	})
+	if err != nil && err != ErrDisruptionResolved && err != ErrNotEnoughSeats && err != ErrAncillarySoldOut {
+		br.logger.Println(err)
+	}
+	return err
+}
+
+// MoveSegment stores the booking's segments and coupons after one of its segments was moved off the flight.
+// The booking has to be confirmed and still be on the flight, so the same segment is never moved twice.
+func (br *BookingRepo) MoveSegment(booking *model.Booking, fromFlightId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	filter := bson.M{
+		"_id":               booking.ID,
+		"status":            model.BookingConfirmed,
+		"segments.flightId": fromFlightId,
+	}
+	update := bson.M{"$set": bson.M{
+		"segments": booking.Segments,
+		"coupons":  booking.Coupons,
+	}}
+	err := withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := bookingsCollection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrSegmentNotFound
+		}
+		event, err := events.New(events.BookingRebooked, events.BookingAggregate, booking.ID.Hex(), booking)
+		return []*events.Event{event}, err
+	})
+	if err != nil && err != ErrSegmentNotFound {
+		br.logger.Println(err)
+	}
+	return err
+}
+
+// Exchange stores a booking whose segment was exchanged for another flight together with its change history,
+// and in the same transaction takes the seats and ancillaries of the move from the new flight and gives them back to the old one.
+// It returns ErrSegmentNotFound if the booking is no longer confirmed or no longer on the flight it was exchanged from,
+// ErrNotEnoughSeats or ErrAncillarySoldOut if the new flight cannot take the passengers any more.
+func (br *BookingRepo) Exchange(booking *model.Booking, move *model.InventoryMove) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	filter := bson.M{
+		"_id":               booking.ID,
+		"status":            model.BookingConfirmed,
+		"segments.flightId": move.FromFlightId,
+	}
+	update := bson.M{"$set": bson.M{
+		"segments":    booking.Segments,
+		"coupons":     booking.Coupons,
+		"total":       booking.Total,
+		"changes":     booking.Changes,
+		"ancillaries": booking.Ancillaries,
+	}}
+	err := withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := bookingsCollection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrSegmentNotFound
+		}
+
+		seatEvents, err := moveInventoryIn(sc, br.cli, move)
+		if err != nil {
+			return nil, err
+		}
+		event, err := events.New(events.BookingChanged, events.BookingAggregate, booking.ID.Hex(), booking)
+		return append([]*events.Event{event}, seatEvents...), err
+	})
+	if err != nil && err != ErrSegmentNotFound && err != ErrNotEnoughSeats && err != ErrAncillarySoldOut {
+		br.logger.Println(err)
+	}
+	return err
+}
+
+// moveInventoryIn takes the seats and counted ancillaries of the move on the new flight and gives them back on the old one,
+// within the transaction that moves the booking. It returns the seat events of both flights.
+func moveInventoryIn(sc mongo.SessionContext, cli *mongo.Client, move *model.InventoryMove) ([]*events.Event, error) {
+	flightsCollection := cli.Database("mongoDemo").Collection("flights")
+	inventoryCollection := cli.Database("mongoDemo").Collection("ancillaryInventory")
+
+	toID, _ := primitive.ObjectIDFromHex(move.ToFlightId)
+	fromID, _ := primitive.ObjectIDFromHex(move.FromFlightId)
+	_, taken, err := changeSeatsIn(sc, flightsCollection, seatsAvailable(toID, move.Seats, move.Overbook), -move.Seats)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotEnoughSeats
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, given, err := changeSeatsIn(sc, flightsCollection, bson.M{"_id": fromID}, move.Seats)
+	if err != nil {
+		return nil, err
+	}
+	for _, selection := range move.Ancillaries {
+		err := reserveAncillaryIn(sc, inventoryCollection, selection.AncillaryId, move.ToFlightId, selection.Quantity, move.Inventory[selection.AncillaryId])
+		if err != nil {
+			return nil, err
+		}
+		_, err = inventoryCollection.UpdateOne(sc, bson.M{"ancillaryId": selection.AncillaryId, "flightId": move.FromFlightId},
+			bson.M{"$inc": bson.M{"sold": -selection.Quantity}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []*events.Event{taken, given}, nil
+}
+
+// AddAncillaries stores ancillaries bought for a confirmed booking together with its repriced segments and total
+func (br *BookingRepo) AddAncillaries(booking *model.Booking) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	filter := bson.M{"_id": booking.ID, "status": model.BookingConfirmed}
+	update := bson.M{"$set": bson.M{
+		"segments":    booking.Segments,
+		"total":       booking.Total,
+		"ancillaries": booking.Ancillaries,
+	}}
+	err := withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := bookingsCollection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrBookingNotCancellable
+		}
+		event, err := events.New(events.BookingChanged, events.BookingAggregate, booking.ID.Hex(), booking)
+		return []*events.Event{event}, err
+	})
+	if err != nil && err != ErrBookingNotCancellable {
+		br.logger.Println(err)
+	}
+	return err
+}
+
+// UpdatePassenger stores the travel document data of a passenger of a confirmed booking
+func (br *BookingRepo) UpdatePassenger(booking *model.Booking, passenger *model.Passenger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bookingsCollection := br.getCollection()
+
+	filter := bson.M{"_id": booking.ID, "status": model.BookingConfirmed, "passengers.id": passenger.ID}
+	sealed, err := sealPassengers(br.vault, []model.Passenger{*passenger})
+	if err != nil {
+		br.logger.Println(err)
+		return err
+	}
+	update := bson.M{"$set": bson.M{"passengers.$": sealed[0]}}
+	err = withEvents(ctx, br.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := bookingsCollection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrPassengerNotFound
+		}
+		event, err := events.New(events.BookingChanged, events.BookingAggregate, booking.ID.Hex(), booking)
+		return []*events.Event{event}, err
+	})
+	if err != nil && err != ErrPassengerNotFound {
+		br.logger.Println(err)
+	}
+	return err
+}
+
+// anonymizeBookingIn stores the anonymized passengers of a booking of a deleted account within the account deletion.
+// The passengers are also replaced in the booking's earlier events and their webhook deliveries,
+// and the returned BookingAnonymized tells partners to do the same.
+func anonymizeBookingIn(sc mongo.SessionContext, cli *mongo.Client, booking *model.Booking) (*events.Event, error) {
+	bookingsCollection := cli.Database("mongoDemo").Collection("bookings")
+
+	booking.Anonymize()
+	_, err := bookingsCollection.UpdateOne(sc, bson.M{"_id": booking.ID}, bson.M{"$set": bson.M{"passengers": booking.Passengers}})
+	if err != nil {
+		return nil, err
+	}
+	err = scrubOutbox(sc, cli, events.BookingAggregate, booking.ID.Hex(), map[string]interface{}{"passengers": booking.Passengers})
+	if err != nil {
+		return nil, err
+	}
+	if err := anonymizeCheckInsIn(sc, cli, booking.ID.Hex()); err != nil {
+		return nil, err
+	}
+	return events.New(events.BookingAnonymized, events.BookingAggregate, booking.ID.Hex(), booking)
+}
+
+// open decrypts the document numbers of the passengers of stored bookings
+func (br *BookingRepo) open(bookings ...*model.Booking) {
+	for _, booking := range bookings {
+		openPassengers(br.vault, br.logger, booking.Passengers)
+	}
+}
+
+func (br *BookingRepo) getCollection() *mongo.Collection {
+	bookingDatabase := br.cli.Database("mongoDemo")
+	bookingsCollection := bookingDatabase.Collection("bookings")
+	return bookingsCollection
+}
+
+func newLocator() (string, error) {
+	alphabet := model.LocatorAlphabet
+	locator := make([]byte, model.LocatorLength)
+	for i := range locator {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		locator[i] = alphabet[n.Int64()]
+	}
+	return string(locator), nil
+}
@@ -0,0 +1,269 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	seatIndex      = "flight_seat"
+	passengerIndex = "flight_passenger"
+)
+
+var (
+	ErrSeatTaken        = errors.New("seat is already taken")
+	ErrAlreadyCheckedIn = errors.New("passenger is already checked in")
+	ErrAlreadyBoarded   = errors.New("passenger has already boarded")
+)
+
+// NoSQL: CheckInRepo struct encapsulating Mongo api client
+type CheckInRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewCheckInRepo(ctx context.Context, logger *log.Logger) (*CheckInRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &CheckInRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// A seat is given to one passenger per flight and a passenger is checked in on a flight only once,
+	// infants have no seat and are left out of the seat index
+	_, err = cr.getCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "flightId", Value: 1}, {Key: "seat", Value: 1}},
+			Options: options.Index().SetName(seatIndex).SetUnique(true).SetPartialFilterExpression(bson.M{"seat": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "flightId", Value: 1}, {Key: "bookingId", Value: 1}, {Key: "passengerId", Value: 1}},
+			Options: options.Index().SetName(passengerIndex).SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "boardingPass", Value: 1}},
+		},
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return cr, nil
+}
+
+// Disconnect from database
+func (cr *CheckInRepo) DisconnectCheckInRepo(ctx context.Context) error {
+	err := cr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (cr *CheckInRepo) PingCheckInRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := cr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		cr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := cr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		cr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// Insert stores the check-in, it returns ErrSeatTaken or ErrAlreadyCheckedIn if the seat or the passenger is taken
+func (cr *CheckInRepo) Insert(checkIn *model.CheckIn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	result, err := checkInsCollection.InsertOne(ctx, checkIn)
+	if mongo.IsDuplicateKeyError(err) {
+		if strings.Contains(err.Error(), seatIndex) {
+			return ErrSeatTaken
+		}
+		return ErrAlreadyCheckedIn
+	}
+	if err != nil {
+		cr.logger.Println(err)
+		return err
+	}
+	checkIn.ID = result.InsertedID.(primitive.ObjectID)
+	cr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (cr *CheckInRepo) GetByBooking(bookingId string) (model.CheckIns, error) {
+	return cr.find(bson.M{"bookingId": bookingId})
+}
+
+func (cr *CheckInRepo) GetByFlight(flightId string) (model.CheckIns, error) {
+	return cr.find(bson.M{"flightId": flightId})
+}
+
+func (cr *CheckInRepo) find(filter bson.M) (model.CheckIns, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	checkIns := model.CheckIns{}
+	opts := options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}})
+	cursor, err := checkInsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		cr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &checkIns); err != nil {
+		cr.logger.Println(err)
+		return nil, err
+	}
+	return checkIns, nil
+}
+
+func (cr *CheckInRepo) GetByBoardingPass(barcode string) (*model.CheckIn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	var checkIn model.CheckIn
+	err := checkInsCollection.FindOne(ctx, bson.M{"boardingPass": barcode}).Decode(&checkIn)
+	if err != nil {
+		cr.logger.Println(err)
+		return nil, err
+	}
+	return &checkIn, nil
+}
+
+// TakenSeats lists the seats given out on the flight
+func (cr *CheckInRepo) TakenSeats(flightId string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	seats, err := checkInsCollection.Distinct(ctx, "seat", bson.M{"flightId": flightId, "seat": bson.M{"$exists": true}})
+	if err != nil {
+		cr.logger.Println(err)
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for _, seat := range seats {
+		taken[fmt.Sprint(seat)] = true
+	}
+	return taken, nil
+}
+
+// NextSequence hands out the check-in sequence numbers of a flight, 1, 2, 3, ...
+func (cr *CheckInRepo) NextSequence(flightId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sequencesCollection := cr.cli.Database("mongoDemo").Collection("checkInSequences")
+
+	var counter struct {
+		Value int `bson:"value"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := sequencesCollection.FindOneAndUpdate(ctx, bson.M{"_id": flightId}, bson.M{"$inc": bson.M{"value": 1}}, opts).Decode(&counter)
+	if err != nil {
+		cr.logger.Println(err)
+		return 0, err
+	}
+	return counter.Value, nil
+}
+
+// Board marks the passenger as boarded, it returns ErrAlreadyBoarded if the boarding pass was already scanned
+func (cr *CheckInRepo) Board(id primitive.ObjectID, boardedBy string) (*model.CheckIn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	filter := bson.M{"_id": id, "status": model.CheckedIn}
+	update := bson.M{"$set": bson.M{"status": model.Boarded, "boardedAt": time.Now(), "boardedBy": boardedBy}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var checkIn model.CheckIn
+	err := checkInsCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&checkIn)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAlreadyBoarded
+	}
+	if err != nil {
+		cr.logger.Println(err)
+		return nil, err
+	}
+	return &checkIn, nil
+}
+
+func (cr *CheckInRepo) Delete(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	_, err := checkInsCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		cr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Offload removes the check-ins of a booking on a flight, passengers that already boarded keep theirs
+func (cr *CheckInRepo) Offload(bookingId string, flightId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkInsCollection := cr.getCollection()
+
+	filter := bson.M{"bookingId": bookingId, "flightId": flightId, "status": model.CheckedIn}
+	_, err := checkInsCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		cr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// anonymizeCheckInsIn removes the names from the check-ins of a booking within the account deletion, boarding passes carry them too
+func anonymizeCheckInsIn(sc mongo.SessionContext, cli *mongo.Client, bookingId string) error {
+	checkInsCollection := cli.Database("mongoDemo").Collection("checkIns")
+
+	update := bson.M{"$set": bson.M{"passengerName": model.AnonymizedName, "boardingPass": ""}}
+	_, err := checkInsCollection.UpdateMany(sc, bson.M{"bookingId": bookingId}, update)
+	return err
+}
+
+func (cr *CheckInRepo) getCollection() *mongo.Collection {
+	checkInDatabase := cr.cli.Database("mongoDemo")
+	checkInsCollection := checkInDatabase.Collection("checkIns")
+	return checkInsCollection
+}
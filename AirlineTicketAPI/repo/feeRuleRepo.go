@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var ErrFeeRuleNotFound = errors.New("fee rule not found")
+
+// NoSQL: FeeRuleRepo struct encapsulating Mongo api client
+type FeeRuleRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewFeeRuleRepo(ctx context.Context, logger *log.Logger) (*FeeRuleRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FeeRuleRepo{
+		cli:    client,
+		logger: logger,
+	}, nil
+}
+
+// Disconnect from database
+func (fr *FeeRuleRepo) DisconnectFeeRuleRepo(ctx context.Context) error {
+	err := fr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (fr *FeeRuleRepo) PingFeeRuleRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := fr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		fr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := fr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		fr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (fr *FeeRuleRepo) GetAll() (model.FeeRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rulesCollection := fr.getCollection()
+
+	rules := model.FeeRules{}
+	rulesCursor, err := rulesCollection.Find(ctx, bson.M{})
+	if err != nil {
+		fr.logger.Println(err)
+		return nil, err
+	}
+	if err = rulesCursor.All(ctx, &rules); err != nil {
+		fr.logger.Println(err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (fr *FeeRuleRepo) Insert(rule *model.FeeRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := fr.getCollection()
+
+	result, err := rulesCollection.InsertOne(ctx, rule)
+	if err != nil {
+		fr.logger.Println(err)
+		return err
+	}
+	rule.ID = result.InsertedID.(primitive.ObjectID)
+	fr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (fr *FeeRuleRepo) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := fr.getCollection()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrFeeRuleNotFound
+	}
+	result, err := rulesCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		fr.logger.Println(err)
+		return err
+	}
+	fr.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	if result.DeletedCount == 0 {
+		return ErrFeeRuleNotFound
+	}
+	return nil
+}
+
+func (fr *FeeRuleRepo) getCollection() *mongo.Collection {
+	feeDatabase := fr.cli.Database("mongoDemo")
+	rulesCollection := feeDatabase.Collection("feeRules")
+	return rulesCollection
+}
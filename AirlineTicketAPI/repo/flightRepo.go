@@ -1,8 +1,11 @@
 package repo
 
 import (
+	"Rest/bus"
+	"Rest/events"
 	"Rest/model"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -16,14 +19,24 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+var (
+	ErrNotEnoughSeats   = errors.New("flight doesn't have enough available seats")
+	ErrFlightCancelled  = errors.New("flight is cancelled")
+	ErrManifestExported = errors.New("manifest of the flight was already exported")
+	ErrFlightNotFound   = errors.New("flight not found")
+	ErrFlightSold       = errors.New("flight has sold seats")
+)
+
 // NoSQL: ProductRepo struct encapsulating Mongo api client
 type FlightRepo struct {
 	cli    *mongo.Client
 	logger *log.Logger
+	// every change of seats, price or status is published to the bus
+	bus *bus.Bus
 }
 
 // NoSQL: Constructor which reads db configuration from environment
-func NewFlightRepo(ctx context.Context, logger *log.Logger) (*FlightRepo, error) {
+func NewFlightRepo(ctx context.Context, logger *log.Logger, b *bus.Bus) (*FlightRepo, error) {
 	dburi := os.Getenv("MONGO_DB_URI")
 
 	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
@@ -39,6 +52,7 @@ func NewFlightRepo(ctx context.Context, logger *log.Logger) (*FlightRepo, error)
 	return &FlightRepo{
 		cli:    client,
 		logger: logger,
+		bus:    b,
 	}, nil
 }
 
@@ -104,6 +118,7 @@ func (pr *FlightRepo) GetBySearchCriteria(search *model.SearchCriteria) (model.F
 				bson.D{{Key: "to", Value: bson.D{{Key: "$regex", Value: search.To}}}},
 				bson.D{{Key: "from", Value: bson.D{{Key: "$regex", Value: search.From}}}},
 				bson.D{{Key: "freeseats", Value: bson.D{{Key: "$gt", Value: search.TicketNumber}}}},
+				bson.D{{Key: "status", Value: bson.D{{Key: "$ne", Value: model.FlightCancelled}}}},
 				bson.D{{Key: "date", Value: bson.M{
 					"$gt": fromDate,
 					"$lt": toDate,
@@ -147,12 +162,20 @@ func (ur *FlightRepo) Insert(flight *model.Flight) error {
 	defer cancel()
 	flightsCollection := ur.getCollection()
 
-	result, err := flightsCollection.InsertOne(ctx, &flight)
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := flightsCollection.InsertOne(sc, &flight)
+		if err != nil {
+			return nil, err
+		}
+		flight.ID = result.InsertedID.(primitive.ObjectID)
+		event, err := events.New(events.FlightCreated, events.FlightAggregate, flight.ID.Hex(), flight)
+		return []*events.Event{event}, err
+	})
 	if err != nil {
 		ur.logger.Println(err)
 		return err
 	}
-	ur.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	ur.logger.Printf("Documents ID: %v\n", flight.ID)
 	return nil
 }
 
@@ -167,16 +190,325 @@ func (ur *FlightRepo) UpdateFlight(id string, flight *model.Flight) error {
 		"freeseats": flight.FreeSeats,
 		"price":     flight.Price,
 	}}
-	result, err := flightCollection.UpdateOne(ctx, filter, update)
-	ur.logger.Printf("Documents matched: %v\n", result.MatchedCount)
-	ur.logger.Printf("Documents updated: %v\n", result.ModifiedCount)
+	var matched int64
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := flightCollection.UpdateOne(sc, filter, update)
+		if err != nil || result.MatchedCount == 0 {
+			return nil, err
+		}
+		matched = result.MatchedCount
+		flight.ID = objID
+		event, err := events.New(events.FlightUpdated, events.FlightAggregate, id, bson.M{"id": id, "freeSeats": flight.FreeSeats, "price": flight.Price})
+		return []*events.Event{event}, err
+	})
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	ur.logger.Printf("Documents matched: %v\n", matched)
+
+	if matched > 0 {
+		ur.bus.Publish(flight)
+	}
+	return nil
+}
+
+// MarkCancelled sets the flight status to cancelled, it returns ErrFlightCancelled if it already was
+func (ur *FlightRepo) MarkCancelled(id string, changedBy string) (*model.Flight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objID, "status": bson.M{"$ne": model.FlightCancelled}}
+	change := model.StatusChange{Status: model.FlightCancelled, ChangedBy: changedBy, ChangedAt: time.Now()}
+	update := bson.M{
+		"$set":  bson.M{"status": model.FlightCancelled},
+		"$push": bson.M{"statusHistory": change},
+	}
+	flight, err := ur.updateWithEvent(ctx, filter, update, events.FlightCancelled)
+	if err != nil {
+		return nil, err
+	}
+	ur.bus.Publish(flight)
+	return flight, nil
+}
+
+// UpdateStatus sets the operational status of a flight that is not cancelled and records the change in its history
+func (ur *FlightRepo) UpdateStatus(id string, change *model.StatusChange) (*model.Flight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objID, "status": bson.M{"$ne": model.FlightCancelled}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":             change.Status,
+			"gate":               change.Gate,
+			"terminal":           change.Terminal,
+			"estimatedDeparture": change.EstimatedDeparture,
+			"divertedTo":         change.DivertedTo,
+		},
+		"$push": bson.M{"statusHistory": change},
+	}
+	flight, err := ur.updateWithEvent(ctx, filter, update, events.FlightStatusChanged)
+	if err != nil {
+		return nil, err
+	}
+	ur.bus.Publish(flight)
+	return flight, nil
+}
+
+// SetOverbooking sets the capacity and the overbooking limit of a flight that is not cancelled.
+// A zero capacity leaves the capacity as it is, without a limit the rule of the route applies again.
+func (ur *FlightRepo) SetOverbooking(id string, settings *model.OverbookingSettings) (*model.Flight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objID, "status": bson.M{"$ne": model.FlightCancelled}}
+	set := bson.M{}
+	update := bson.M{}
+	if settings.Capacity > 0 {
+		set["capacity"] = settings.Capacity
+	}
+	if settings.Limit != nil {
+		set["overbookingLimit"] = *settings.Limit
+	} else {
+		update["$unset"] = bson.M{"overbookingLimit": ""}
+	}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	flight, err := ur.updateWithEvent(ctx, filter, update, events.FlightUpdated)
+	if err != nil {
+		return nil, err
+	}
+	ur.bus.Publish(flight)
+	return flight, nil
+}
+
+// updateWithEvent changes a flight that is not cancelled and records the event with its new state.
+// It returns ErrFlightCancelled if no such flight matched.
+func (ur *FlightRepo) updateWithEvent(ctx context.Context, filter bson.M, update bson.M, eventType string) (*model.Flight, error) {
+	flightCollection := ur.getCollection()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var flight model.Flight
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		err := flightCollection.FindOneAndUpdate(sc, filter, update, opts).Decode(&flight)
+		if err != nil {
+			return nil, err
+		}
+		event, err := events.New(eventType, events.FlightAggregate, flight.ID.Hex(), flight)
+		return []*events.Event{event}, err
+	})
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrFlightCancelled
+	}
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	return &flight, nil
+}
+
+// FindNextAvailable returns the earliest flight on the route departing after the given time with enough free seats
+func (ur *FlightRepo) FindNextAvailable(from string, to string, after time.Time, seats int) (*model.Flight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flightCollection := ur.getCollection()
+
+	if now := time.Now(); after.Before(now) {
+		after = now
+	}
+	filter := bson.M{
+		"from":      from,
+		"to":        to,
+		"date":      bson.M{"$gt": after},
+		"freeseats": bson.M{"$gte": seats},
+		"status":    bson.M{"$ne": model.FlightCancelled},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: 1}})
+
+	var flight model.Flight
+	err := flightCollection.FindOne(ctx, filter, opts).Decode(&flight)
+	if err != nil {
+		return nil, err
+	}
+	return &flight, nil
+}
+
+// GetDepartedBetween returns the flights that were not cancelled and departed within the given time
+func (ur *FlightRepo) GetDepartedBetween(from time.Time, to time.Time) (model.Flights, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flightCollection := ur.getCollection()
+
+	filter := bson.M{
+		"date":   bson.M{"$gte": from, "$lt": to},
+		"status": bson.M{"$ne": model.FlightCancelled},
+	}
+	flights := model.Flights{}
+	cursor, err := flightCollection.Find(ctx, filter)
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &flights); err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	return flights, nil
+}
 
+// GetPendingManifests returns the flights departing within the given time whose manifest was not exported yet
+func (ur *FlightRepo) GetPendingManifests(from time.Time, to time.Time) (model.Flights, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flightCollection := ur.getCollection()
+
+	filter := bson.M{
+		"date":               bson.M{"$gte": from, "$lt": to},
+		"status":             bson.M{"$ne": model.FlightCancelled},
+		"manifestExportedAt": bson.M{"$exists": false},
+	}
+	flights := model.Flights{}
+	cursor, err := flightCollection.Find(ctx, filter)
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &flights); err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	return flights, nil
+}
+
+// MarkManifestExported claims the export of the flight's manifest, it returns ErrManifestExported if it was claimed before
+func (ur *FlightRepo) MarkManifestExported(id string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flightCollection := ur.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objID, "manifestExportedAt": bson.M{"$exists": false}}
+	result, err := flightCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"manifestExportedAt": at}})
 	if err != nil {
 		ur.logger.Println(err)
 		return err
 	}
+	if result.ModifiedCount == 0 {
+		return ErrManifestExported
+	}
 	return nil
 }
+
+// UnmarkManifestExported gives the export back when the manifest could not be written
+func (ur *FlightRepo) UnmarkManifestExported(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flightCollection := ur.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	_, err := flightCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$unset": bson.M{"manifestExportedAt": ""}})
+	if err != nil {
+		ur.logger.Println(err)
+	}
+	return err
+}
+
+// ReserveSeats atomically takes n seats on a flight that has not departed yet, free seats may go down to -overbook.
+// It returns ErrNotEnoughSeats if the flight is missing, departed or does not have n free seats.
+func (ur *FlightRepo) ReserveSeats(id string, n int, overbook int) (*model.Flight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	flight, err := ur.changeSeats(ctx, seatsAvailable(objID, n, overbook), -n)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotEnoughSeats
+	}
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	ur.bus.Publish(flight)
+	return flight, nil
+}
+
+// ReleaseSeats atomically gives n seats back to a flight
+func (ur *FlightRepo) ReleaseSeats(id string, n int) (*model.Flight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	flight, err := ur.changeSeats(ctx, bson.M{"_id": objID}, n)
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	ur.bus.Publish(flight)
+	return flight, nil
+}
+
+// Publish announces a flight whose seats were changed by another repository's transaction
+func (ur *FlightRepo) Publish(id string) {
+	flight, err := ur.GetById(id)
+	if err != nil {
+		return
+	}
+	ur.bus.Publish(flight)
+}
+
+// changeSeats applies a change of free seats and records it as an event, so consumers of the outbox see every seat sold or given back.
+// It returns mongo.ErrNoDocuments if no flight matched.
+func (ur *FlightRepo) changeSeats(ctx context.Context, filter bson.M, change int) (*model.Flight, error) {
+	flightCollection := ur.getCollection()
+
+	var flight *model.Flight
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		changed, event, err := changeSeatsIn(sc, flightCollection, filter, change)
+		if err != nil {
+			return nil, err
+		}
+		flight = changed
+		return []*events.Event{event}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return flight, nil
+}
+
+// seatsAvailable matches the flight if it has not departed yet and has n free seats, free seats may go down to -overbook
+func seatsAvailable(id primitive.ObjectID, n int, overbook int) bson.M {
+	return bson.M{
+		"_id":       id,
+		"freeseats": bson.M{"$gte": n - overbook},
+		"date":      bson.M{"$gt": time.Now()},
+		"status":    bson.M{"$ne": model.FlightCancelled},
+	}
+}
+
+// changeSeatsIn changes the free seats of the matched flight within a transaction and returns the event recording it
+func changeSeatsIn(sc mongo.SessionContext, flightCollection *mongo.Collection, filter bson.M, change int) (*model.Flight, *events.Event, error) {
+	update := bson.M{"$inc": bson.M{"freeseats": change}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var flight model.Flight
+	err := flightCollection.FindOneAndUpdate(sc, filter, update, opts).Decode(&flight)
+	if err != nil {
+		return nil, nil, err
+	}
+	id := flight.ID.Hex()
+	event, err := events.New(events.FlightSeatsChanged, events.FlightAggregate, id, bson.M{"id": id, "freeSeats": flight.FreeSeats, "change": change})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &flight, event, nil
+}
+
 func (pr *FlightRepo) Delete(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -193,6 +525,49 @@ func (pr *FlightRepo) Delete(id string) error {
 	return nil
 }
 
+// DeleteUnsold deletes a flight that never sold a seat. Every seat change is recorded in the outbox with the change,
+// so the flight is sold if it has a seat event, a booking or a ticket. Deleting the flight first makes seat changes
+// that run at the same time conflict with the transaction. It returns ErrFlightSold if the flight has sales
+// and ErrFlightNotFound if there is no such flight.
+func (ur *FlightRepo) DeleteUnsold(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	database := ur.cli.Database("mongoDemo")
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := ur.getCollection().DeleteOne(sc, bson.M{"_id": objID})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, ErrFlightNotFound
+		}
+		sales := []struct {
+			collection string
+			filter     bson.M
+		}{
+			{"outbox", bson.M{"aggregateType": events.FlightAggregate, "aggregateId": id, "type": events.FlightSeatsChanged}},
+			{"bookings", bson.M{"segments.flightId": id}},
+			{"tickets", bson.M{"flightId": id}},
+		}
+		for _, sale := range sales {
+			count, err := database.Collection(sale.collection).CountDocuments(sc, sale.filter, options.Count().SetLimit(1))
+			if err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				return nil, ErrFlightSold
+			}
+		}
+		return nil, nil
+	})
+	if err != nil && err != ErrFlightNotFound && err != ErrFlightSold {
+		ur.logger.Println(err)
+	}
+	return err
+}
+
 func (ur *FlightRepo) getCollection() *mongo.Collection {
 	userDatabase := ur.cli.Database("mongoDemo")
 	flightsCollection := userDatabase.Collection("flights")
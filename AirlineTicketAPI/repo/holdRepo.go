@@ -0,0 +1,179 @@
+package repo
+
+import (
+	"Rest/model"
+	"Rest/vault"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// NoSQL: HoldRepo struct encapsulating Mongo api client
+type HoldRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+	// Encrypts the document numbers of the passengers before they are stored
+	vault *vault.Vault
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewHoldRepo(ctx context.Context, logger *log.Logger, v *vault.Vault) (*HoldRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HoldRepo{
+		cli:    client,
+		logger: logger,
+		vault:  v,
+	}, nil
+}
+
+// Disconnect from database
+func (hr *HoldRepo) DisconnectHoldRepo(ctx context.Context) error {
+	err := hr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (hr *HoldRepo) PingHoldRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := hr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		hr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := hr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		hr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+var ErrHoldNotActive = errors.New("hold is not active")
+
+func (hr *HoldRepo) Insert(hold *model.Hold) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	holdsCollection := hr.getCollection()
+
+	stored := *hold
+	passengers, err := sealPassengers(hr.vault, hold.Request.Passengers)
+	if err != nil {
+		hr.logger.Println(err)
+		return err
+	}
+	stored.Request.Passengers = passengers
+	result, err := holdsCollection.InsertOne(ctx, &stored)
+	if err != nil {
+		hr.logger.Println(err)
+		return err
+	}
+	hold.ID = result.InsertedID.(primitive.ObjectID)
+	hr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (hr *HoldRepo) GetById(id string) (*model.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	holdsCollection := hr.getCollection()
+
+	var hold model.Hold
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := holdsCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&hold)
+	if err != nil {
+		hr.logger.Println(err)
+		return nil, err
+	}
+	openPassengers(hr.vault, hr.logger, hold.Request.Passengers)
+	return &hold, nil
+}
+
+// Claim atomically moves an active, unexpired hold to the given status.
+// Whoever claims the hold owns its seats, so confirming, releasing and expiring can never race.
+func (hr *HoldRepo) Claim(id string, status string) (*model.Hold, error) {
+	objID, _ := primitive.ObjectIDFromHex(id)
+	return hr.claim(bson.M{"_id": objID, "status": model.HoldActive, "expiresAt": bson.M{"$gt": time.Now()}}, status)
+}
+
+// ClaimExpired marks one expired active hold as expired and returns it, or ErrHoldNotActive if there is none.
+// Several reapers can run at once, each expired hold is claimed by exactly one of them.
+func (hr *HoldRepo) ClaimExpired() (*model.Hold, error) {
+	return hr.claim(bson.M{"status": model.HoldActive, "expiresAt": bson.M{"$lte": time.Now()}}, model.HoldExpired)
+}
+
+func (hr *HoldRepo) claim(filter bson.M, status string) (*model.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	holdsCollection := hr.getCollection()
+
+	update := bson.M{"$set": bson.M{"status": status}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var hold model.Hold
+	err := holdsCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&hold)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrHoldNotActive
+	}
+	if err != nil {
+		hr.logger.Println(err)
+		return nil, err
+	}
+	openPassengers(hr.vault, hr.logger, hold.Request.Passengers)
+	return &hold, nil
+}
+
+func (hr *HoldRepo) SetBookingId(id primitive.ObjectID, bookingId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	holdsCollection := hr.getCollection()
+
+	_, err := holdsCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"bookingId": bookingId}})
+	if err != nil {
+		hr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// anonymizeHoldsIn removes the personal data of the passengers of a deleted account's holds within the account deletion.
+// Active holds are left to expire, so their seats are released as usual.
+func anonymizeHoldsIn(sc mongo.SessionContext, cli *mongo.Client, userId string) error {
+	holdsCollection := cli.Database("mongoDemo").Collection("holds")
+
+	filter := bson.M{"userId": userId, "request.passengers": bson.M{"$type": "array"}}
+	_, err := holdsCollection.UpdateMany(sc, filter, anonymizePassengers("request.passengers"))
+	return err
+}
+
+func (hr *HoldRepo) getCollection() *mongo.Collection {
+	holdDatabase := hr.cli.Database("mongoDemo")
+	holdsCollection := holdDatabase.Collection("holds")
+	return holdsCollection
+}
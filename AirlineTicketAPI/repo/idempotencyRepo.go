@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var ErrIdempotencyKeyUsed = errors.New("idempotency key was already used")
+
+// NoSQL: IdempotencyRepo struct encapsulating Mongo api client
+type IdempotencyRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewIdempotencyRepo(ctx context.Context, logger *log.Logger) (*IdempotencyRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ir := &IdempotencyRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// Stored responses are removed by Mongo once they expire
+	_, err = ir.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return ir, nil
+}
+
+// Disconnect from database
+func (ir *IdempotencyRepo) DisconnectIdempotencyRepo(ctx context.Context) error {
+	err := ir.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (ir *IdempotencyRepo) PingIdempotencyRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := ir.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		ir.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := ir.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		ir.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// Begin stores the record of a new request. If the key is already taken it returns the stored record and ErrIdempotencyKeyUsed.
+func (ir *IdempotencyRepo) Begin(record *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recordsCollection := ir.getCollection()
+
+	_, err := recordsCollection.InsertOne(ctx, record)
+	if err == nil {
+		return record, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		ir.logger.Println(err)
+		return nil, err
+	}
+
+	var stored model.IdempotencyRecord
+	err = recordsCollection.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&stored)
+	if err != nil {
+		ir.logger.Println(err)
+		return nil, err
+	}
+	return &stored, ErrIdempotencyKeyUsed
+}
+
+// TakeOver locks a record whose request never finished, e.g. because the instance handling it crashed.
+// It fails with ErrIdempotencyKeyUsed if the record finished or another retry took it over first.
+func (ir *IdempotencyRepo) TakeOver(stored *model.IdempotencyRecord, lockedUntil time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recordsCollection := ir.getCollection()
+
+	filter := bson.M{"_id": stored.ID, "status": model.IdempotencyInProgress, "lockedUntil": stored.LockedUntil}
+	result, err := recordsCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lockedUntil": lockedUntil}})
+	if err != nil {
+		ir.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyKeyUsed
+	}
+	return nil
+}
+
+// Complete stores the response so that retries with the same key replay it
+func (ir *IdempotencyRepo) Complete(record *model.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recordsCollection := ir.getCollection()
+
+	update := bson.M{"$set": bson.M{
+		"status":      model.IdempotencyCompleted,
+		"statusCode":  record.StatusCode,
+		"contentType": record.ContentType,
+		"body":        record.Body,
+	}}
+	_, err := recordsCollection.UpdateOne(ctx, bson.M{"_id": record.ID}, update)
+	if err != nil {
+		ir.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Delete frees the key of a request that failed on the server side so that it can be retried
+func (ir *IdempotencyRepo) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recordsCollection := ir.getCollection()
+
+	_, err := recordsCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		ir.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (ir *IdempotencyRepo) getCollection() *mongo.Collection {
+	idempotencyDatabase := ir.cli.Database("mongoDemo")
+	recordsCollection := idempotencyDatabase.Collection("idempotencyKeys")
+	return recordsCollection
+}
@@ -0,0 +1,248 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrNotEnoughPoints = errors.New("not enough loyalty points")
+	ErrAlreadyCredited = errors.New("points were already credited for the segment")
+)
+
+// NoSQL: LoyaltyRepo struct encapsulating Mongo api client
+type LoyaltyRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewLoyaltyRepo(ctx context.Context, logger *log.Logger) (*LoyaltyRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lr := &LoyaltyRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	_, err = lr.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+	// A flown segment earns points once, crediting it again collides here
+	_, err = lr.getLedgerCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "bookingId", Value: 1}, {Key: "flightId", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"kind": model.LedgerEarn}),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return lr, nil
+}
+
+// Disconnect from database
+func (lr *LoyaltyRepo) DisconnectLoyaltyRepo(ctx context.Context) error {
+	err := lr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (lr *LoyaltyRepo) PingLoyaltyRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := lr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		lr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := lr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		lr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// GetAccount returns the user's loyalty account, an empty one if they never earned points
+func (lr *LoyaltyRepo) GetAccount(userId string) (*model.LoyaltyAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accountsCollection := lr.getCollection()
+
+	account := model.LoyaltyAccount{UserId: userId}
+	err := accountsCollection.FindOne(ctx, bson.M{"userId": userId}).Decode(&account)
+	if err != nil && err != mongo.ErrNoDocuments {
+		lr.logger.Println(err)
+		return nil, err
+	}
+	return &account, nil
+}
+
+// GetTiered returns the accounts holding a tier
+func (lr *LoyaltyRepo) GetTiered() ([]*model.LoyaltyAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accountsCollection := lr.getCollection()
+
+	accounts := []*model.LoyaltyAccount{}
+	cursor, err := accountsCollection.Find(ctx, bson.M{"tier": bson.M{"$nin": bson.A{"", nil}}})
+	if err != nil {
+		lr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		lr.logger.Println(err)
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// GetLedger returns the user's ledger entries, newest first
+func (lr *LoyaltyRepo) GetLedger(userId string) (model.Ledger, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ledgerCollection := lr.getLedgerCollection()
+
+	ledger := model.Ledger{}
+	cursor, err := ledgerCollection.Find(ctx, bson.M{"userId": userId}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		lr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &ledger); err != nil {
+		lr.logger.Println(err)
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// Post appends the entry to the ledger and changes the balance of the account by its points in one transaction.
+// Points are only spent down to a zero balance, otherwise ErrNotEnoughPoints is returned.
+func (lr *LoyaltyRepo) Post(entry *model.LedgerEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accountsCollection := lr.getCollection()
+	ledgerCollection := lr.getLedgerCollection()
+
+	session, err := lr.cli.StartSession()
+	if err != nil {
+		lr.logger.Println(err)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		filter := bson.M{"userId": entry.UserId}
+		update := bson.M{"$inc": bson.M{"balance": entry.Points}, "$setOnInsert": bson.M{"createdAt": entry.CreatedAt}}
+		if entry.Points < 0 {
+			filter["balance"] = bson.M{"$gte": -entry.Points}
+			result, err := accountsCollection.UpdateOne(sc, filter, update)
+			if err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 0 {
+				return nil, ErrNotEnoughPoints
+			}
+		} else if _, err := accountsCollection.UpdateOne(sc, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return nil, err
+		}
+
+		result, err := ledgerCollection.InsertOne(sc, entry)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyCredited
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.ID = result.InsertedID.(primitive.ObjectID)
+		return nil, nil
+	})
+	if err != nil && err != ErrNotEnoughPoints && err != ErrAlreadyCredited {
+		lr.logger.Println(err)
+	}
+	return err
+}
+
+// QualifyingPoints sums the points the user earned on flights since the given time
+func (lr *LoyaltyRepo) QualifyingPoints(userId string, since time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ledgerCollection := lr.getLedgerCollection()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userId, "kind": model.LedgerEarn, "createdAt": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "points": bson.M{"$sum": "$points"}}}},
+	}
+	var totals []struct {
+		Points int `bson:"points"`
+	}
+	cursor, err := ledgerCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		lr.logger.Println(err)
+		return 0, err
+	}
+	if err = cursor.All(ctx, &totals); err != nil {
+		lr.logger.Println(err)
+		return 0, err
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Points, nil
+}
+
+func (lr *LoyaltyRepo) SetTier(userId string, tier string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accountsCollection := lr.getCollection()
+
+	_, err := accountsCollection.UpdateOne(ctx, bson.M{"userId": userId}, bson.M{"$set": bson.M{"tier": tier}})
+	if err != nil {
+		lr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (lr *LoyaltyRepo) getCollection() *mongo.Collection {
+	loyaltyDatabase := lr.cli.Database("mongoDemo")
+	accountsCollection := loyaltyDatabase.Collection("loyaltyAccounts")
+	return accountsCollection
+}
+
+func (lr *LoyaltyRepo) getLedgerCollection() *mongo.Collection {
+	loyaltyDatabase := lr.cli.Database("mongoDemo")
+	ledgerCollection := loyaltyDatabase.Collection("loyaltyLedger")
+	return ledgerCollection
+}
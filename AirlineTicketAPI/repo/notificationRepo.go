@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"Rest/notifications"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// NoSQL: NotificationRepo struct encapsulating Mongo api client
+type NotificationRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewNotificationRepo(ctx context.Context, logger *log.Logger) (*NotificationRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotificationRepo{
+		cli:    client,
+		logger: logger,
+	}, nil
+}
+
+// Disconnect from database
+func (nr *NotificationRepo) DisconnectNotificationRepo(ctx context.Context) error {
+	err := nr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (nr *NotificationRepo) PingNotificationRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := nr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		nr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := nr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		nr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (nr *NotificationRepo) Enqueue(notification *notifications.Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notificationsCollection := nr.getCollection()
+
+	result, err := notificationsCollection.InsertOne(ctx, notification)
+	if err != nil {
+		nr.logger.Println(err)
+		return err
+	}
+	notification.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ClaimDue pushes the next attempt of the oldest due notification forward by lockFor and returns it
+func (nr *NotificationRepo) ClaimDue(lockFor time.Duration) (*notifications.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notificationsCollection := nr.getCollection()
+
+	now := time.Now()
+	filter := bson.M{"status": notifications.Pending, "nextAttemptAt": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lockFor)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetReturnDocument(options.After)
+
+	var notification notifications.Notification
+	err := notificationsCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&notification)
+	if err == mongo.ErrNoDocuments {
+		return nil, notifications.ErrQueueEmpty
+	}
+	if err != nil {
+		nr.logger.Println(err)
+		return nil, err
+	}
+	return &notification, nil
+}
+
+func (nr *NotificationRepo) Save(notification *notifications.Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notificationsCollection := nr.getCollection()
+
+	_, err := notificationsCollection.ReplaceOne(ctx, bson.M{"_id": notification.ID}, notification)
+	if err != nil {
+		nr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (nr *NotificationRepo) getCollection() *mongo.Collection {
+	notificationDatabase := nr.cli.Database("mongoDemo")
+	notificationsCollection := notificationDatabase.Collection("notifications")
+	return notificationsCollection
+}
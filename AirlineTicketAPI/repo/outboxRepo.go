@@ -0,0 +1,266 @@
+package repo
+
+import (
+	"Rest/events"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// NoSQL: OutboxRepo struct encapsulating Mongo api client
+type OutboxRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewOutboxRepo(ctx context.Context, logger *log.Logger) (*OutboxRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	or := &OutboxRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// The relay reads pending events aggregate by aggregate in sequence order
+	_, err = or.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "aggregateType", Value: 1}, {Key: "aggregateId", Value: 1}, {Key: "sequence", Value: 1}},
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return or, nil
+}
+
+// Disconnect from database
+func (or *OutboxRepo) DisconnectOutboxRepo(ctx context.Context) error {
+	err := or.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (or *OutboxRepo) PingOutboxRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := or.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		or.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := or.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		or.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// withEvents runs the change in a transaction and writes the events it returns to the outbox in that same transaction,
+// so an event is recorded if and only if its state change is committed. Transactions need Mongo to run as a replica set.
+func withEvents(ctx context.Context, cli *mongo.Client, change func(sc mongo.SessionContext) ([]*events.Event, error)) error {
+	session, err := cli.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		newEvents, err := change(sc)
+		if err != nil {
+			return nil, err
+		}
+		return nil, appendToOutbox(sc, cli, newEvents)
+	})
+	return err
+}
+
+// appendToOutbox numbers the events within their aggregates and stores them.
+// Two transactions changing the same aggregate conflict on its sequence counter, so sequence order is commit order.
+func appendToOutbox(sc mongo.SessionContext, cli *mongo.Client, newEvents []*events.Event) error {
+	database := cli.Database("mongoDemo")
+	for _, event := range newEvents {
+		var counter struct {
+			Sequence int64 `bson:"sequence"`
+		}
+		update := bson.M{"$inc": bson.M{"sequence": 1}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err := database.Collection("outboxSequences").FindOneAndUpdate(sc, bson.M{"_id": event.AggregateKey()}, update, opts).Decode(&counter)
+		if err != nil {
+			return err
+		}
+		event.Sequence = counter.Sequence
+
+		result, err := database.Collection("outbox").InsertOne(sc, event)
+		if err != nil {
+			return err
+		}
+		event.ID = result.InsertedID.(primitive.ObjectID)
+	}
+	return nil
+}
+
+// AcquireLease takes or renews the relay lease, it fails without an error while another relay holds it
+func (or *OutboxRepo) AcquireLease(owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	leasesCollection := or.cli.Database("mongoDemo").Collection("outboxLeases")
+
+	now := time.Now()
+	filter := bson.M{"_id": "relay", "$or": bson.A{bson.M{"owner": owner}, bson.M{"until": bson.M{"$lt": now}}}}
+	update := bson.M{"$set": bson.M{"owner": owner, "until": now.Add(ttl)}}
+	_, err := leasesCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		or.logger.Println(err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (or *OutboxRepo) Pending(limit int) ([]*events.Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	outboxCollection := or.getCollection()
+
+	filter := bson.M{"status": events.Pending, "nextAttemptAt": bson.M{"$lte": time.Now()}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "aggregateType", Value: 1}, {Key: "aggregateId", Value: 1}, {Key: "sequence", Value: 1}}).
+		SetLimit(int64(limit))
+
+	pending := []*events.Event{}
+	cursor, err := outboxCollection.Find(ctx, filter, opts)
+	if err != nil {
+		or.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &pending); err != nil {
+		or.logger.Println(err)
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (or *OutboxRepo) HasPendingBefore(event *events.Event) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	outboxCollection := or.getCollection()
+
+	filter := bson.M{
+		"status":        events.Pending,
+		"aggregateType": event.AggregateType,
+		"aggregateId":   event.AggregateId,
+		"sequence":      bson.M{"$lt": event.Sequence},
+	}
+	count, err := outboxCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		or.logger.Println(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (or *OutboxRepo) MarkDelivered(event *events.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	outboxCollection := or.getCollection()
+
+	update := bson.M{"$set": bson.M{
+		"status":      event.Status,
+		"deliveredTo": event.DeliveredTo,
+		"deliveredAt": event.DeliveredAt,
+	}}
+	_, err := outboxCollection.UpdateOne(ctx, bson.M{"_id": event.ID}, update)
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (or *OutboxRepo) Postpone(event *events.Event, until time.Time, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	outboxCollection := or.getCollection()
+
+	update := bson.M{"$set": bson.M{"attempts": event.Attempts, "lastError": reason, "nextAttemptAt": until}}
+	_, err := outboxCollection.UpdateOne(ctx, bson.M{"_id": event.ID}, update)
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+
+	later := bson.M{
+		"status":        events.Pending,
+		"aggregateType": event.AggregateType,
+		"aggregateId":   event.AggregateId,
+		"sequence":      bson.M{"$gt": event.Sequence},
+		"nextAttemptAt": bson.M{"$lt": until},
+	}
+	_, err = outboxCollection.UpdateMany(ctx, later, bson.M{"$set": bson.M{"nextAttemptAt": until}})
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (or *OutboxRepo) getCollection() *mongo.Collection {
+	outboxDatabase := or.cli.Database("mongoDemo")
+	outboxCollection := outboxDatabase.Collection("outbox")
+	return outboxCollection
+}
+
+// scrubOutbox replaces the fields in the payloads of an aggregate's events and in the webhook deliveries made of them,
+// in the transaction that erases the aggregate's personal data
+func scrubOutbox(sc mongo.SessionContext, cli *mongo.Client, aggregateType string, aggregateId string, fields map[string]interface{}) error {
+	outboxCollection := cli.Database("mongoDemo").Collection("outbox")
+
+	cursor, err := outboxCollection.Find(sc, bson.M{"aggregateType": aggregateType, "aggregateId": aggregateId})
+	if err != nil {
+		return err
+	}
+	stored := []*events.Event{}
+	if err = cursor.All(sc, &stored); err != nil {
+		return err
+	}
+	eventIds := bson.A{}
+	for _, event := range stored {
+		payload, err := events.Scrub(event.Payload, fields)
+		if err != nil {
+			return err
+		}
+		_, err = outboxCollection.UpdateOne(sc, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{"payload": payload}})
+		if err != nil {
+			return err
+		}
+		eventIds = append(eventIds, event.ID.Hex())
+	}
+	return scrubDeliveries(sc, cli, eventIds, fields)
+}
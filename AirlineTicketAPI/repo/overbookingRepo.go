@@ -0,0 +1,231 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrAlreadyVolunteered = errors.New("booking already volunteered for the flight")
+	ErrAlreadyOffloaded   = errors.New("booking was already offloaded from the flight")
+)
+
+// NoSQL: OverbookingRepo struct encapsulating Mongo api client
+type OverbookingRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewOverbookingRepo(ctx context.Context, logger *log.Logger) (*OverbookingRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	or := &OverbookingRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// A booking volunteers for and is offloaded from a flight only once
+	_, err = or.getDeniedCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "flightId", Value: 1}, {Key: "bookingId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return or, nil
+}
+
+// Disconnect from database
+func (or *OverbookingRepo) DisconnectOverbookingRepo(ctx context.Context) error {
+	err := or.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (or *OverbookingRepo) PingOverbookingRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := or.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		or.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := or.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		or.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (or *OverbookingRepo) GetAllRules() (model.OverbookingRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := or.getRulesCollection()
+
+	rules := model.OverbookingRules{}
+	rulesCursor, err := rulesCollection.Find(ctx, bson.M{})
+	if err != nil {
+		or.logger.Println(err)
+		return nil, err
+	}
+	if err = rulesCursor.All(ctx, &rules); err != nil {
+		or.logger.Println(err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (or *OverbookingRepo) InsertRule(rule *model.OverbookingRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := or.getRulesCollection()
+
+	result, err := rulesCollection.InsertOne(ctx, rule)
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+	rule.ID = result.InsertedID.(primitive.ObjectID)
+	or.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (or *OverbookingRepo) DeleteRule(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rulesCollection := or.getRulesCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := rulesCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+	or.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	return nil
+}
+
+// Volunteer records that the booking gives up its seats on the flight if it turns out to be oversold
+func (or *OverbookingRepo) Volunteer(denied *model.DeniedBoarding) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deniedCollection := or.getDeniedCollection()
+
+	result, err := deniedCollection.InsertOne(ctx, denied)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyVolunteered
+	}
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+	denied.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Offload stores the denied boarding of a booking, a volunteer's record is completed.
+// It returns ErrAlreadyOffloaded if the booking was offloaded from the flight before.
+func (or *OverbookingRepo) Offload(denied *model.DeniedBoarding) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deniedCollection := or.getDeniedCollection()
+
+	filter := bson.M{
+		"flightId":  denied.FlightId,
+		"bookingId": denied.BookingId,
+		"status":    bson.M{"$ne": model.DeniedBoardingOffloaded},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"locator":          denied.Locator,
+			"userId":           denied.UserId,
+			"seats":            denied.Seats,
+			"voluntary":        denied.Voluntary,
+			"status":           model.DeniedBoardingOffloaded,
+			"compensation":     denied.Compensation,
+			"currency":         denied.Currency,
+			"rebookedFlightId": denied.RebookedFlightId,
+			"offloadedAt":      denied.OffloadedAt,
+			"offloadedBy":      denied.OffloadedBy,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err := deniedCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(denied)
+	// The upsert collides with the unique index when the booking is already offloaded
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyOffloaded
+	}
+	if err != nil {
+		or.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// GetByFlight lists the denied boardings of a flight, status narrows it down when it is not empty
+func (or *OverbookingRepo) GetByFlight(flightId string, status string) (model.DeniedBoardings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deniedCollection := or.getDeniedCollection()
+
+	filter := bson.M{"flightId": flightId}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "volunteeredAt", Value: 1}, {Key: "offloadedAt", Value: 1}})
+
+	denied := model.DeniedBoardings{}
+	cursor, err := deniedCollection.Find(ctx, filter, opts)
+	if err != nil {
+		or.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &denied); err != nil {
+		or.logger.Println(err)
+		return nil, err
+	}
+	return denied, nil
+}
+
+func (or *OverbookingRepo) getRulesCollection() *mongo.Collection {
+	overbookingDatabase := or.cli.Database("mongoDemo")
+	rulesCollection := overbookingDatabase.Collection("overbookingRules")
+	return rulesCollection
+}
+
+func (or *OverbookingRepo) getDeniedCollection() *mongo.Collection {
+	overbookingDatabase := or.cli.Database("mongoDemo")
+	deniedCollection := overbookingDatabase.Collection("deniedBoardings")
+	return deniedCollection
+}
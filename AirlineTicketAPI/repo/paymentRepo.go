@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"Rest/events"
+	"Rest/payments"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var ErrPaymentChanged = errors.New("payment was changed concurrently")
+
+// NoSQL: PaymentRepo struct encapsulating Mongo api client
+type PaymentRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewPaymentRepo(ctx context.Context, logger *log.Logger) (*PaymentRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentRepo{
+		cli:    client,
+		logger: logger,
+	}, nil
+}
+
+// Disconnect from database
+func (pr *PaymentRepo) DisconnectPaymentRepo(ctx context.Context) error {
+	err := pr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (pr *PaymentRepo) PingPaymentRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := pr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		pr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := pr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		pr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (pr *PaymentRepo) Insert(payment *payments.Payment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	paymentsCollection := pr.getCollection()
+
+	result, err := paymentsCollection.InsertOne(ctx, payment)
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	payment.ID = result.InsertedID.(primitive.ObjectID)
+	pr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (pr *PaymentRepo) GetById(id string) (*payments.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	paymentsCollection := pr.getCollection()
+
+	var payment payments.Payment
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := paymentsCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&payment)
+	if err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// Update stores the payment's new state, it fails with ErrPaymentChanged if the stored status is no longer the expected one
+func (pr *PaymentRepo) Update(payment *payments.Payment, expected payments.Status) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	paymentsCollection := pr.getCollection()
+
+	filter := bson.M{"_id": payment.ID, "status": expected}
+	err := withEvents(ctx, pr.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := paymentsCollection.ReplaceOne(sc, filter, payment)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrPaymentChanged
+		}
+		event, err := events.New(events.PaymentUpdated, events.PaymentAggregate, payment.ID.Hex(), payment)
+		return []*events.Event{event}, err
+	})
+	if err != nil && err != ErrPaymentChanged {
+		pr.logger.Println(err)
+	}
+	return err
+}
+
+func (pr *PaymentRepo) getCollection() *mongo.Collection {
+	paymentDatabase := pr.cli.Database("mongoDemo")
+	paymentsCollection := paymentDatabase.Collection("payments")
+	return paymentsCollection
+}
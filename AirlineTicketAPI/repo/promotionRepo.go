@@ -0,0 +1,330 @@
+package repo
+
+import (
+	"Rest/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrPromotionExists    = errors.New("promo code already exists")
+	ErrPromotionNotFound  = errors.New("promo code not found")
+	ErrPromotionUsedUp    = errors.New("promo code has been used up")
+	ErrPromotionUserLimit = errors.New("promo code was already used as many times as allowed")
+)
+
+// NoSQL: PromotionRepo struct encapsulating Mongo api client
+type PromotionRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewPromotionRepo(ctx context.Context, logger *log.Logger) (*PromotionRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &PromotionRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	_, err = pr.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+	// One usage counter per code and customer, a redemption over the customer's limit collides here
+	_, err = pr.getUsageCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}, {Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return pr, nil
+}
+
+// Disconnect from database
+func (pr *PromotionRepo) DisconnectPromotionRepo(ctx context.Context) error {
+	err := pr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (pr *PromotionRepo) PingPromotionRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := pr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		pr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := pr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		pr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (pr *PromotionRepo) GetAll() (model.Promotions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	promotionsCollection := pr.getCollection()
+
+	promotions := model.Promotions{}
+	cursor, err := promotionsCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &promotions); err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// GetByCodes returns the promotions of the codes in the order of the codes
+func (pr *PromotionRepo) GetByCodes(codes []string) (model.Promotions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	promotionsCollection := pr.getCollection()
+
+	found := model.Promotions{}
+	cursor, err := promotionsCollection.Find(ctx, bson.M{"code": bson.M{"$in": codes}})
+	if err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &found); err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+
+	promotions := model.Promotions{}
+	for _, code := range codes {
+		var promotion *model.Promotion
+		for _, p := range found {
+			if p.Code == code {
+				promotion = p
+			}
+		}
+		if promotion == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPromotionNotFound, code)
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, nil
+}
+
+func (pr *PromotionRepo) Insert(promotion *model.Promotion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	promotionsCollection := pr.getCollection()
+
+	result, err := promotionsCollection.InsertOne(ctx, promotion)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPromotionExists
+	}
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	promotion.ID = result.InsertedID.(primitive.ObjectID)
+	pr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (pr *PromotionRepo) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	promotionsCollection := pr.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := promotionsCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	pr.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	return nil
+}
+
+// Redeem counts one use of the promotion by the user. The global and the per user limit are both checked atomically,
+// the global use is given back when the user is over their limit.
+func (pr *PromotionRepo) Redeem(promotion *model.Promotion, userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	promotionsCollection := pr.getCollection()
+	usageCollection := pr.getUsageCollection()
+
+	filter := bson.M{"_id": promotion.ID}
+	if promotion.MaxUses > 0 {
+		filter["uses"] = bson.M{"$lt": promotion.MaxUses}
+	}
+	result, err := promotionsCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPromotionUsedUp
+	}
+
+	usage := bson.M{"code": promotion.Code, "userId": userId}
+	if promotion.MaxUsesPerUser > 0 {
+		usage["count"] = bson.M{"$lt": promotion.MaxUsesPerUser}
+	}
+	_, err = usageCollection.UpdateOne(ctx, usage, bson.M{"$inc": bson.M{"count": 1}}, options.Update().SetUpsert(true))
+	if err == nil {
+		return nil
+	}
+	if _, undoErr := promotionsCollection.UpdateOne(ctx, bson.M{"_id": promotion.ID}, bson.M{"$inc": bson.M{"uses": -1}}); undoErr != nil {
+		pr.logger.Println(undoErr)
+	}
+	// The upsert collides with the user's counter when they reached their limit
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPromotionUserLimit
+	}
+	pr.logger.Println(err)
+	return err
+}
+
+// Release gives back a use of the promotion counted for a booking that was not made
+func (pr *PromotionRepo) Release(promotion *model.Promotion, userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	promotionsCollection := pr.getCollection()
+	usageCollection := pr.getUsageCollection()
+
+	_, err := promotionsCollection.UpdateOne(ctx, bson.M{"_id": promotion.ID}, bson.M{"$inc": bson.M{"uses": -1}})
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	_, err = usageCollection.UpdateOne(ctx, bson.M{"code": promotion.Code, "userId": userId}, bson.M{"$inc": bson.M{"count": -1}})
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (pr *PromotionRepo) InsertRedemption(redemption *model.Redemption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	redemptionsCollection := pr.getRedemptionCollection()
+
+	result, err := redemptionsCollection.InsertOne(ctx, redemption)
+	if err != nil {
+		pr.logger.Println(err)
+		return err
+	}
+	redemption.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Report sums up the redemptions of every promo code, optionally of one campaign only
+func (pr *PromotionRepo) Report(campaign string) (model.PromotionReports, error) {
+	promotions, err := pr.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	redemptionsCollection := pr.getRedemptionCollection()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$code",
+			"bookings": bson.M{"$sum": 1},
+			"discount": bson.M{"$sum": "$discount"},
+			"userIds":  bson.M{"$addToSet": "$userId"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"bookings": 1,
+			"discount": 1,
+			"users":    bson.M{"$size": "$userIds"},
+		}}},
+	}
+	totals := model.PromotionReports{}
+	cursor, err := redemptionsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &totals); err != nil {
+		pr.logger.Println(err)
+		return nil, err
+	}
+
+	reports := model.PromotionReports{}
+	for _, promotion := range promotions {
+		if campaign != "" && promotion.Campaign != campaign {
+			continue
+		}
+		report := &model.PromotionReport{Code: promotion.Code}
+		for _, total := range totals {
+			if total.Code == promotion.Code {
+				report = total
+			}
+		}
+		report.Campaign = promotion.Campaign
+		report.Uses = promotion.Uses
+		report.MaxUses = promotion.MaxUses
+		report.Discount = math.Round(report.Discount*100) / 100
+		report.Currency = model.Currency
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (pr *PromotionRepo) getCollection() *mongo.Collection {
+	promotionDatabase := pr.cli.Database("mongoDemo")
+	promotionsCollection := promotionDatabase.Collection("promotions")
+	return promotionsCollection
+}
+
+func (pr *PromotionRepo) getUsageCollection() *mongo.Collection {
+	promotionDatabase := pr.cli.Database("mongoDemo")
+	usageCollection := promotionDatabase.Collection("promotionUsage")
+	return usageCollection
+}
+
+func (pr *PromotionRepo) getRedemptionCollection() *mongo.Collection {
+	promotionDatabase := pr.cli.Database("mongoDemo")
+	redemptionsCollection := promotionDatabase.Collection("redemptions")
+	return redemptionsCollection
+}
@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"Rest/events"
+	"Rest/model"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// NoSQL: RefundRepo struct encapsulating Mongo api client
+type RefundRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewRefundRepo(ctx context.Context, logger *log.Logger) (*RefundRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundRepo{
+		cli:    client,
+		logger: logger,
+	}, nil
+}
+
+// Disconnect from database
+func (rr *RefundRepo) DisconnectRefundRepo(ctx context.Context) error {
+	err := rr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (rr *RefundRepo) PingRefundRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := rr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		rr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := rr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		rr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+func (rr *RefundRepo) Insert(refund *model.Refund) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	refundsCollection := rr.getCollection()
+
+	err := withEvents(ctx, rr.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := refundsCollection.InsertOne(sc, refund)
+		if err != nil {
+			return nil, err
+		}
+		refund.ID = result.InsertedID.(primitive.ObjectID)
+		event, err := events.New(events.RefundIssued, events.BookingAggregate, refund.BookingId, refund)
+		return []*events.Event{event}, err
+	})
+	if err != nil {
+		rr.logger.Println(err)
+		return err
+	}
+	rr.logger.Printf("Documents ID: %v\n", refund.ID)
+	return nil
+}
+
+// Settle records how the refund of a cancelled booking was paid out, Settled is false if it has to be settled manually
+func (rr *RefundRepo) Settle(refund *model.Refund) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	refundsCollection := rr.getCollection()
+
+	update := bson.M{"$set": bson.M{"settled": refund.Settled, "paymentId": refund.PaymentId}}
+	err := withEvents(ctx, rr.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		_, err := refundsCollection.UpdateOne(sc, bson.M{"_id": refund.ID}, update)
+		if err != nil {
+			return nil, err
+		}
+		event, err := events.New(events.RefundIssued, events.BookingAggregate, refund.BookingId, refund)
+		return []*events.Event{event}, err
+	})
+	if err != nil {
+		rr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (rr *RefundRepo) GetAllByBookingId(bookingId string) (model.Refunds, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	refundsCollection := rr.getCollection()
+
+	refunds := model.Refunds{}
+	cursor, err := refundsCollection.Find(ctx, bson.M{"bookingId": bookingId})
+	if err != nil {
+		rr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &refunds); err != nil {
+		rr.logger.Println(err)
+		return nil, err
+	}
+	return refunds, nil
+}
+
+func (rr *RefundRepo) getCollection() *mongo.Collection {
+	refundDatabase := rr.cli.Database("mongoDemo")
+	refundsCollection := refundDatabase.Collection("refunds")
+	return refundsCollection
+}
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"Rest/events"
 	"Rest/model"
 	"context"
 	"fmt"
@@ -91,12 +92,20 @@ func (ur *TicketRepo) Insert(ticket *model.Ticket) error {
 	defer cancel()
 	ticketsCollection := ur.getCollection()
 
-	result, err := ticketsCollection.InsertOne(ctx, &ticket)
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := ticketsCollection.InsertOne(sc, &ticket)
+		if err != nil {
+			return nil, err
+		}
+		ticket.ID = result.InsertedID.(primitive.ObjectID)
+		event, err := events.New(events.TicketPurchased, events.TicketAggregate, ticket.ID.Hex(), ticket)
+		return []*events.Event{event}, err
+	})
 	if err != nil {
 		ur.logger.Println(err)
 		return err
 	}
-	ur.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	ur.logger.Printf("Documents ID: %v\n", ticket.ID)
 	return nil
 }
 
@@ -133,3 +142,66 @@ func (ur *TicketRepo) GetAllByUserId(userId string) (*model.Tickets, error) {
 
 	return &tickets, nil
 }
+
+func (ur *TicketRepo) CountByFlightId(flightId string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ticketCollection := ur.getCollection()
+
+	count, err := ticketCollection.CountDocuments(ctx, bson.M{"flightId": flightId})
+	if err != nil {
+		ur.logger.Println(err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FlagDisrupted marks the tickets of the cancelled flight as disrupted and returns the tickets it flagged.
+// Tickets issued with a booking are left alone, the disruption of their booking is resolved instead.
+func (ur *TicketRepo) FlagDisrupted(flightId string, reason string) (model.Tickets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ticketCollection := ur.getCollection()
+
+	filter := bson.M{"flightId": flightId, "disruption": bson.M{"$exists": false}, "bookingId": bson.M{"$exists": false}}
+	disruption := model.Disruption{FlightId: flightId, Reason: reason, FlaggedAt: time.Now(), Resolution: model.DisruptionPending}
+
+	tickets := model.Tickets{}
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		cursor, err := ticketCollection.Find(sc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(sc, &tickets); err != nil {
+			return nil, err
+		}
+		if len(tickets) == 0 {
+			return nil, nil
+		}
+		ids := bson.A{}
+		for _, ticket := range tickets {
+			ids = append(ids, ticket.ID)
+		}
+		if _, err := ticketCollection.UpdateMany(sc, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"disruption": disruption}}); err != nil {
+			return nil, err
+		}
+
+		newEvents := []*events.Event{}
+		for _, ticket := range tickets {
+			ticket.Disruption = &disruption
+			event, err := events.New(events.TicketDisrupted, events.TicketAggregate, ticket.ID.Hex(), ticket)
+			if err != nil {
+				return nil, err
+			}
+			newEvents = append(newEvents, event)
+		}
+		return newEvents, nil
+	})
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	ur.logger.Printf("Tickets flagged as disrupted: %v\n", len(tickets))
+	return tickets, nil
+}
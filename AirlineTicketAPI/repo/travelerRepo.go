@@ -0,0 +1,273 @@
+package repo
+
+import (
+	"Rest/model"
+	"Rest/vault"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var ErrTravelerNotFound = errors.New("traveler not found")
+
+// NoSQL: TravelerRepo struct encapsulating Mongo api client
+type TravelerRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+	// Encrypts the document numbers before they are stored
+	vault *vault.Vault
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewTravelerRepo(ctx context.Context, logger *log.Logger, v *vault.Vault) (*TravelerRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TravelerRepo{
+		cli:    client,
+		logger: logger,
+		vault:  v,
+	}, nil
+}
+
+// Disconnect from database
+func (tr *TravelerRepo) DisconnectTravelerRepo(ctx context.Context) error {
+	err := tr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (tr *TravelerRepo) PingTravelerRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := tr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		tr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := tr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		tr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// GetByUserId returns the saved travelers of the user with their document numbers decrypted
+func (tr *TravelerRepo) GetByUserId(userId string) (model.Travelers, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	travelersCollection := tr.getCollection()
+
+	travelers := model.Travelers{}
+	cursor, err := travelersCollection.Find(ctx, bson.M{"userId": userId}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		tr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &travelers); err != nil {
+		tr.logger.Println(err)
+		return nil, err
+	}
+	for _, traveler := range travelers {
+		tr.open(traveler)
+	}
+	return travelers, nil
+}
+
+// GetById returns the user's traveler with its document numbers decrypted
+func (tr *TravelerRepo) GetById(id string, userId string) (*model.Traveler, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	travelersCollection := tr.getCollection()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrTravelerNotFound
+	}
+	var traveler model.Traveler
+	err = travelersCollection.FindOne(ctx, bson.M{"_id": objID, "userId": userId}).Decode(&traveler)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTravelerNotFound
+	}
+	if err != nil {
+		tr.logger.Println(err)
+		return nil, err
+	}
+	tr.open(&traveler)
+	return &traveler, nil
+}
+
+func (tr *TravelerRepo) Insert(traveler *model.Traveler) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	travelersCollection := tr.getCollection()
+
+	sealed, err := tr.seal(traveler)
+	if err != nil {
+		return err
+	}
+	result, err := travelersCollection.InsertOne(ctx, sealed)
+	if err != nil {
+		tr.logger.Println(err)
+		return err
+	}
+	traveler.ID = result.InsertedID.(primitive.ObjectID)
+	tr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+// Update replaces the user's traveler
+func (tr *TravelerRepo) Update(traveler *model.Traveler) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	travelersCollection := tr.getCollection()
+
+	sealed, err := tr.seal(traveler)
+	if err != nil {
+		return err
+	}
+	result, err := travelersCollection.ReplaceOne(ctx, bson.M{"_id": traveler.ID, "userId": traveler.UserId}, sealed)
+	if err != nil {
+		tr.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrTravelerNotFound
+	}
+	return nil
+}
+
+func (tr *TravelerRepo) Delete(id string, userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	travelersCollection := tr.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := travelersCollection.DeleteOne(ctx, bson.M{"_id": objID, "userId": userId})
+	if err != nil {
+		tr.logger.Println(err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrTravelerNotFound
+	}
+	return nil
+}
+
+// deleteTravelersIn deletes every saved traveler of the user within the account deletion
+func deleteTravelersIn(sc mongo.SessionContext, cli *mongo.Client, userId string) error {
+	travelersCollection := cli.Database("mongoDemo").Collection("travelers")
+
+	_, err := travelersCollection.DeleteMany(sc, bson.M{"userId": userId})
+	return err
+}
+
+// seal returns a copy of the traveler with its document numbers encrypted
+func (tr *TravelerRepo) seal(traveler *model.Traveler) (*model.Traveler, error) {
+	sealed := *traveler
+	sealed.Documents = make([]model.TravelDocument, len(traveler.Documents))
+	for i, document := range traveler.Documents {
+		number, err := tr.vault.Seal(document.Number)
+		if err != nil {
+			tr.logger.Println(err)
+			return nil, err
+		}
+		document.Number = number
+		sealed.Documents[i] = document
+	}
+	return &sealed, nil
+}
+
+// open decrypts the document numbers of a stored traveler, numbers that cannot be decrypted are left empty
+func (tr *TravelerRepo) open(traveler *model.Traveler) {
+	for i := range traveler.Documents {
+		number, err := tr.vault.Open(traveler.Documents[i].Number)
+		if err != nil {
+			tr.logger.Printf("Document %d of traveler %s cannot be decrypted: %v", i+1, traveler.ID.Hex(), err)
+		}
+		traveler.Documents[i].Number = number
+	}
+}
+
+// sealPassengers returns a copy of the passengers with their document numbers encrypted,
+// bookings, holds and waitlist entries keep the documents copied from travelers or given for APIS sealed like the travelers do
+func sealPassengers(v *vault.Vault, passengers []model.Passenger) ([]model.Passenger, error) {
+	sealed := make([]model.Passenger, len(passengers))
+	for i, passenger := range passengers {
+		document, err := v.Seal(passenger.Document)
+		if err != nil {
+			return nil, err
+		}
+		passenger.Document = document
+		sealed[i] = passenger
+	}
+	return sealed, nil
+}
+
+// openPassengers decrypts the document numbers of stored passengers. Numbers stored before they were encrypted are kept,
+// numbers that cannot be decrypted are left empty.
+func openPassengers(v *vault.Vault, logger *log.Logger, passengers []model.Passenger) {
+	for i := range passengers {
+		document, err := v.Open(passengers[i].Document)
+		if err == vault.ErrNotSealed {
+			continue
+		}
+		if err != nil {
+			logger.Printf("Document of passenger %s cannot be decrypted: %v", passengers[i].ID, err)
+		}
+		passengers[i].Document = document
+	}
+}
+
+// anonymizePassengers is the update removing the personal data of every passenger in the array at path,
+// the stored passengers end up like the ones of Booking.Anonymize
+func anonymizePassengers(path string) bson.M {
+	each := path + ".$[]."
+	return bson.M{
+		"$set": bson.M{
+			each + "name":        model.AnonymizedName,
+			each + "surname":     model.AnonymizedName,
+			each + "dateOfBirth": time.Time{},
+			each + "document":    "",
+		},
+		"$unset": bson.M{
+			each + "travelerId":     "",
+			each + "gender":         "",
+			each + "documentType":   "",
+			each + "nationality":    "",
+			each + "documentExpiry": "",
+			each + "frequentFlyer":  "",
+		},
+	}
+}
+
+func (tr *TravelerRepo) getCollection() *mongo.Collection {
+	travelerDatabase := tr.cli.Database("mongoDemo")
+	travelersCollection := travelerDatabase.Collection("travelers")
+	return travelersCollection
+}
@@ -1,8 +1,10 @@
 package repo
 
 import (
+	"Rest/events"
 	"Rest/model"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -16,6 +18,13 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+var (
+	ErrTokenUsed             = errors.New("token was already used")
+	ErrVerificationThrottled = errors.New("a verification email was sent a moment ago")
+	ErrResetThrottled        = errors.New("a password reset email was sent a moment ago")
+	ErrUserNotFound          = errors.New("user not found")
+)
+
 // NoSQL: ProductRepo struct encapsulating Mongo api client
 type UserRepo struct {
 	cli    *mongo.Client
@@ -36,10 +45,21 @@ func NewUserRepo(ctx context.Context, logger *log.Logger) (*UserRepo, error) {
 		return nil, err
 	}
 
-	return &UserRepo{
+	ur := &UserRepo{
 		cli:    client,
 		logger: logger,
-	}, nil
+	}
+
+	// Password reset links look the user up by the hash of their token
+	_, err = ur.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "passwordReset.tokenHash", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return ur, nil
 }
 
 // Disconnect from database
@@ -141,12 +161,28 @@ func (ur *UserRepo) Insert(user *model.User) error {
 	defer cancel()
 	usersCollection := ur.getCollection()
 
-	result, err := usersCollection.InsertOne(ctx, &user)
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		result, err := usersCollection.InsertOne(sc, &user)
+		if err != nil {
+			return nil, err
+		}
+		user.ID = result.InsertedID.(primitive.ObjectID)
+
+		// The password hash never leaves the database
+		event, err := events.New(events.UserRegistered, events.UserAggregate, user.ID.Hex(), map[string]interface{}{
+			"id":       user.ID.Hex(),
+			"name":     user.Name,
+			"surname":  user.Surname,
+			"email":    user.Email,
+			"username": user.Username,
+		})
+		return []*events.Event{event}, err
+	})
 	if err != nil {
 		ur.logger.Println(err)
 		return err
 	}
-	ur.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	ur.logger.Printf("Documents ID: %v\n", user.ID)
 	return nil
 }
 
@@ -162,9 +198,277 @@ func (ur *UserRepo) UpdateUser(id string, user *model.User) error {
 		"surname": user.Surname,
 	}}
 	result, err := usersCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
 	ur.logger.Printf("Documents matched: %v\n", result.MatchedCount)
 	ur.logger.Printf("Documents updated: %v\n", result.ModifiedCount)
+	return nil
+}
+
+// UpdateProfile stores the profile fields a user edits themselves and the verification of a new email
+func (ur *UserRepo) UpdateProfile(user *model.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	update := bson.M{"$set": bson.M{
+		"name":              user.Name,
+		"surname":           user.Surname,
+		"phoneNumber":       user.PhoneNumber,
+		"birthdate":         user.BirthDate,
+		"language":          user.Language,
+		"pendingEmail":      user.PendingEmail,
+		"emailVerification": user.EmailVerification,
+	}}
+	_, err := usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// GetByPendingEmail returns the user changing their email to the given one
+func (ur *UserRepo) GetByPendingEmail(email string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	usersCollection := ur.getCollection()
+
+	var user model.User
+	err := usersCollection.FindOne(ctx, bson.M{"pendingEmail": email}).Decode(&user)
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// StartVerification records a new verification of the user's email, or of the email they change to.
+// It returns ErrVerificationThrottled if the last verification was sent after sentBefore.
+func (ur *UserRepo) StartVerification(user *model.User, verification *model.EmailVerification, sentBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	filter := bson.M{
+		"_id": user.ID,
+		"$or": bson.A{
+			bson.M{"emailVerification": bson.M{"$exists": false}},
+			bson.M{"emailVerification": nil},
+			bson.M{"emailVerification.sentAt": bson.M{"$lt": sentBefore}},
+		},
+	}
+	set := bson.M{"emailVerification": verification}
+	if verification.Email != user.Email {
+		set["pendingEmail"] = verification.Email
+	}
+	update := bson.M{"$set": set}
+
+	result, err := usersCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVerificationThrottled
+	}
+	return nil
+}
+
+// ConfirmEmail makes the verified email the user's email and marks it verified. The nonce of the verification must match,
+// so a token is used only once. It returns ErrTokenUsed otherwise.
+func (ur *UserRepo) ConfirmEmail(id string, nonce string, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objID, "emailVerification.nonce": nonce, "emailVerification.email": email}
+	update := bson.M{
+		"$set":   bson.M{"email": email},
+		"$unset": bson.M{"pendingEmail": "", "emailVerification": "", "emailUnverified": ""},
+	}
+	result, err := usersCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
 
+// SetPassword stores a new password and revokes the user's sessions, it returns the user with the new session version
+func (ur *UserRepo) SetPassword(id primitive.ObjectID, hash string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	update := bson.M{"$set": bson.M{"password": hash}, "$inc": bson.M{"sessionVersion": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user model.User
+	err := usersCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&user)
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// StartPasswordReset records a new reset of the user's password, links sent before stop working.
+// It returns ErrResetThrottled if the last reset was sent after sentBefore.
+func (ur *UserRepo) StartPasswordReset(id primitive.ObjectID, reset *model.PasswordReset, sentBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"passwordReset": bson.M{"$exists": false}},
+			bson.M{"passwordReset": nil},
+			bson.M{"passwordReset.sentAt": bson.M{"$lt": sentBefore}},
+		},
+	}
+	result, err := usersCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"passwordReset": reset}})
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrResetThrottled
+	}
+	return nil
+}
+
+// ResetPassword sets the password of the user holding the unexpired reset token and revokes their sessions.
+// The reset is removed in the same update, so a token is used only once. It returns ErrTokenUsed otherwise.
+func (ur *UserRepo) ResetPassword(tokenHash string, hash string, now time.Time) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	filter := bson.M{"passwordReset.tokenHash": tokenHash, "passwordReset.expiresAt": bson.M{"$gt": now}}
+	update := bson.M{
+		"$set":   bson.M{"password": hash},
+		"$inc":   bson.M{"sessionVersion": 1},
+		"$unset": bson.M{"passwordReset": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user model.User
+	err := usersCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTokenUsed
+	}
+	if err != nil {
+		ur.logger.Println(err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Anonymize removes the personal data of a deleted account, from its events too. The user is kept, with placeholders that can never
+// log in, so that bookings and payments still point to an account. Its bookings with their check-ins, holds, waitlist entries and
+// saved travelers are anonymized in the same transaction, so a failed deletion leaves nothing half done and can simply be retried.
+func (ur *UserRepo) Anonymize(id primitive.ObjectID, bookings model.Bookings, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	placeholder := "deleted-" + id.Hex()
+	update := bson.M{
+		"$set": bson.M{
+			"name":      model.AnonymizedName,
+			"surname":   model.AnonymizedName,
+			"email":     placeholder + "@invalid",
+			"username":  placeholder,
+			"password":  "",
+			"deletedAt": at,
+		},
+		"$unset": bson.M{"phoneNumber": "", "birthdate": "", "pendingEmail": "", "emailVerification": "", "passwordReset": ""},
+	}
+	err := withEvents(ctx, ur.cli, func(sc mongo.SessionContext) ([]*events.Event, error) {
+		_, err := usersCollection.UpdateOne(sc, bson.M{"_id": id}, update)
+		if err != nil {
+			return nil, err
+		}
+		err = scrubOutbox(sc, ur.cli, events.UserAggregate, id.Hex(), map[string]interface{}{
+			"name":     model.AnonymizedName,
+			"surname":  model.AnonymizedName,
+			"email":    placeholder + "@invalid",
+			"username": placeholder,
+		})
+		if err != nil {
+			return nil, err
+		}
+		newEvents := []*events.Event{}
+		for _, booking := range bookings {
+			event, err := anonymizeBookingIn(sc, ur.cli, booking)
+			if err != nil {
+				return nil, err
+			}
+			newEvents = append(newEvents, event)
+		}
+		if err := anonymizeHoldsIn(sc, ur.cli, id.Hex()); err != nil {
+			return nil, err
+		}
+		if err := anonymizeWaitlistIn(sc, ur.cli, id.Hex()); err != nil {
+			return nil, err
+		}
+		if err := deleteTravelersIn(sc, ur.cli, id.Hex()); err != nil {
+			return nil, err
+		}
+		event, err := events.New(events.UserDeleted, events.UserAggregate, id.Hex(), map[string]interface{}{"id": id.Hex()})
+		return append(newEvents, event), err
+	})
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// SetRole gives the user a new role and revokes their sessions, tokens carry the role they were issued with.
+// It returns ErrUserNotFound if there is no such user.
+func (ur *UserRepo) SetRole(id string, role model.Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	update := bson.M{"$set": bson.M{"role": role}, "$inc": bson.M{"sessionVersion": 1}}
+	result, err := usersCollection.UpdateOne(ctx, bson.M{"_id": objID, "deletedAt": bson.M{"$exists": false}}, update)
+	if err != nil {
+		ur.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetLoyaltyTier stores the tier the user qualified for, an empty tier removes it
+func (ur *UserRepo) SetLoyaltyTier(id string, tier string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usersCollection := ur.getCollection()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	update := bson.M{"$set": bson.M{"loyaltyTier": tier}}
+	if tier == "" {
+		update = bson.M{"$unset": bson.M{"loyaltyTier": ""}}
+	}
+	_, err := usersCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
 	if err != nil {
 		ur.logger.Println(err)
 		return err
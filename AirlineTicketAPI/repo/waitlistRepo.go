@@ -0,0 +1,314 @@
+package repo
+
+import (
+	"Rest/model"
+	"Rest/vault"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrWaitlistEmpty      = errors.New("no waitlist entry fits the free seats")
+	ErrWaitlistNotWaiting = errors.New("waitlist entry is not waiting")
+	ErrAlreadyWaitlisted  = errors.New("user is already on the waitlist of the flight")
+)
+
+// Queue order: loyalty tier first, then the time the entry joined
+var waitlistOrder = bson.D{{Key: "priority", Value: -1}, {Key: "createdAt", Value: 1}}
+
+// NoSQL: WaitlistRepo struct encapsulating Mongo api client
+type WaitlistRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+	// Encrypts the document numbers of the passengers before they are stored
+	vault *vault.Vault
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewWaitlistRepo(ctx context.Context, logger *log.Logger, v *vault.Vault) (*WaitlistRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wr := &WaitlistRepo{
+		cli:    client,
+		logger: logger,
+		vault:  v,
+	}
+
+	// Serves the queue of a flight in order
+	_, err = wr.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "flightId", Value: 1}, {Key: "status", Value: 1}, {Key: "priority", Value: -1}, {Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return wr, nil
+}
+
+// Disconnect from database
+func (wr *WaitlistRepo) DisconnectWaitlistRepo(ctx context.Context) error {
+	err := wr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (wr *WaitlistRepo) PingWaitlistRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := wr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		wr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := wr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		wr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+// Insert puts the entry on the waitlist, it returns ErrAlreadyWaitlisted if the user is still waiting for the flight
+func (wr *WaitlistRepo) Insert(entry *model.WaitlistEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	active := bson.M{"userId": entry.UserId, "flightId": entry.FlightId, "status": bson.M{"$in": bson.A{model.WaitlistWaiting, model.WaitlistOffering}}}
+	waiting, err := waitlistCollection.CountDocuments(ctx, active)
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	if waiting > 0 {
+		return ErrAlreadyWaitlisted
+	}
+	stored := *entry
+	stored.Request.Passengers, err = sealPassengers(wr.vault, entry.Request.Passengers)
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	result, err := waitlistCollection.InsertOne(ctx, &stored)
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+	wr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (wr *WaitlistRepo) GetById(id string) (*model.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	var entry model.WaitlistEntry
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := waitlistCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&entry)
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	openPassengers(wr.vault, wr.logger, entry.Request.Passengers)
+	return &entry, nil
+}
+
+func (wr *WaitlistRepo) GetByUser(userId string) (model.WaitlistEntries, error) {
+	return wr.find(bson.M{"userId": userId}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+}
+
+// GetByFlight returns the queue of a flight in the order it is served, only the given fare class if it is not empty
+func (wr *WaitlistRepo) GetByFlight(flightId string, fareClass string) (model.WaitlistEntries, error) {
+	filter := bson.M{"flightId": flightId}
+	if fareClass != "" {
+		filter["fareClass"] = fareClass
+	}
+	return wr.find(filter, options.Find().SetSort(waitlistOrder))
+}
+
+func (wr *WaitlistRepo) find(filter bson.M, opts *options.FindOptions) (model.WaitlistEntries, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	entries := model.WaitlistEntries{}
+	cursor, err := waitlistCollection.Find(ctx, filter, opts)
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	for _, entry := range entries {
+		openPassengers(wr.vault, wr.logger, entry.Request.Passengers)
+	}
+	return entries, nil
+}
+
+// Position counts the waiting entries of the flight served before the entry, plus one
+func (wr *WaitlistRepo) Position(entry *model.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	filter := bson.M{
+		"flightId": entry.FlightId,
+		"status":   model.WaitlistWaiting,
+		"$or": bson.A{
+			bson.M{"priority": bson.M{"$gt": entry.Priority}},
+			bson.M{"priority": entry.Priority, "createdAt": bson.M{"$lt": entry.CreatedAt}},
+		},
+	}
+	ahead, err := waitlistCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		wr.logger.Println(err)
+		return 0, err
+	}
+	return int(ahead) + 1, nil
+}
+
+// FlightsWaiting lists the flights that have someone waiting
+func (wr *WaitlistRepo) FlightsWaiting() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	ids, err := waitlistCollection.Distinct(ctx, "flightId", bson.M{"status": model.WaitlistWaiting})
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	flightIds := []string{}
+	for _, id := range ids {
+		flightIds = append(flightIds, fmt.Sprint(id))
+	}
+	return flightIds, nil
+}
+
+// ClaimNext takes the first entry of the flight's queue that needs at most seats seats and locks it for lockFor.
+// An entry whose offer was interrupted can be claimed again once its lock ran out.
+func (wr *WaitlistRepo) ClaimNext(flightId string, seats int, lockFor time.Duration) (*model.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	now := time.Now()
+	filter := bson.M{
+		"flightId": flightId,
+		"seats":    bson.M{"$lte": seats},
+		"$or": bson.A{
+			bson.M{"status": model.WaitlistWaiting},
+			bson.M{"status": model.WaitlistOffering, "lockedUntil": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"status": model.WaitlistOffering, "lockedUntil": now.Add(lockFor)}}
+	opts := options.FindOneAndUpdate().SetSort(waitlistOrder).SetReturnDocument(options.After)
+
+	var entry model.WaitlistEntry
+	err := waitlistCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrWaitlistEmpty
+	}
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	openPassengers(wr.vault, wr.logger, entry.Request.Passengers)
+	return &entry, nil
+}
+
+// Offered records the hold placed for a claimed entry
+func (wr *WaitlistRepo) Offered(entry *model.WaitlistEntry, holdId string) error {
+	now := time.Now()
+	entry.Status, entry.HoldId, entry.OfferedAt = model.WaitlistOffered, holdId, &now
+	return wr.update(entry.ID, model.WaitlistOffering, bson.M{
+		"$set":   bson.M{"status": model.WaitlistOffered, "holdId": holdId, "offeredAt": now},
+		"$unset": bson.M{"lockedUntil": ""},
+	})
+}
+
+// Requeue puts a claimed entry back in its place when the seats could not be held after all
+func (wr *WaitlistRepo) Requeue(entry *model.WaitlistEntry) error {
+	entry.Status = model.WaitlistWaiting
+	return wr.update(entry.ID, model.WaitlistOffering, bson.M{
+		"$set":   bson.M{"status": model.WaitlistWaiting},
+		"$unset": bson.M{"lockedUntil": ""},
+	})
+}
+
+// Cancel takes a waiting entry off the waitlist
+func (wr *WaitlistRepo) Cancel(entry *model.WaitlistEntry) error {
+	err := wr.update(entry.ID, model.WaitlistWaiting, bson.M{"$set": bson.M{"status": model.WaitlistCancelled}})
+	if err == nil {
+		entry.Status = model.WaitlistCancelled
+	}
+	return err
+}
+
+// anonymizeWaitlistIn takes a deleted account off the waitlists it is waiting on
+// and removes the personal data of the passengers of all of its entries, within the account deletion
+func anonymizeWaitlistIn(sc mongo.SessionContext, cli *mongo.Client, userId string) error {
+	waitlistCollection := cli.Database("mongoDemo").Collection("waitlist")
+
+	waiting := bson.M{"userId": userId, "status": model.WaitlistWaiting}
+	_, err := waitlistCollection.UpdateMany(sc, waiting, bson.M{"$set": bson.M{"status": model.WaitlistCancelled}})
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"userId": userId, "request.passengers": bson.M{"$type": "array"}}
+	_, err = waitlistCollection.UpdateMany(sc, filter, anonymizePassengers("request.passengers"))
+	return err
+}
+
+// update changes the entry only while it has the from status, it returns ErrWaitlistNotWaiting otherwise
+func (wr *WaitlistRepo) update(id primitive.ObjectID, from string, update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waitlistCollection := wr.getCollection()
+
+	result, err := waitlistCollection.UpdateOne(ctx, bson.M{"_id": id, "status": from}, update)
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrWaitlistNotWaiting
+	}
+	return nil
+}
+
+func (wr *WaitlistRepo) getCollection() *mongo.Collection {
+	waitlistDatabase := wr.cli.Database("mongoDemo")
+	waitlistCollection := waitlistDatabase.Collection("waitlist")
+	return waitlistCollection
+}
@@ -0,0 +1,316 @@
+package repo
+
+import (
+	"Rest/webhooks"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// NoSQL: module containing Mongo api client
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// NoSQL: WebhookRepo struct encapsulating Mongo api client
+type WebhookRepo struct {
+	cli    *mongo.Client
+	logger *log.Logger
+}
+
+// NoSQL: Constructor which reads db configuration from environment
+func NewWebhookRepo(ctx context.Context, logger *log.Logger) (*WebhookRepo, error) {
+	dburi := os.Getenv("MONGO_DB_URI")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(dburi))
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wr := &WebhookRepo{
+		cli:    client,
+		logger: logger,
+	}
+
+	// An event is delivered to a subscription only once, however often the outbox relays it
+	_, err = wr.getDeliveries().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "subscriptionId", Value: 1}, {Key: "eventId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Println(err)
+	}
+
+	return wr, nil
+}
+
+// Disconnect from database
+func (wr *WebhookRepo) DisconnectWebhookRepo(ctx context.Context) error {
+	err := wr.cli.Disconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check database connection
+func (wr *WebhookRepo) PingWebhookRepo() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check connection -> if no error, connection is established
+	err := wr.cli.Ping(ctx, readpref.Primary())
+	if err != nil {
+		wr.logger.Println(err)
+	}
+
+	// Print available databases
+	databases, err := wr.cli.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		wr.logger.Println(err)
+	}
+	fmt.Println(databases)
+}
+
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+func (wr *WebhookRepo) InsertSubscription(subscription *webhooks.Subscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	subscriptionsCollection := wr.getSubscriptions()
+
+	result, err := subscriptionsCollection.InsertOne(ctx, subscription)
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	subscription.ID = result.InsertedID.(primitive.ObjectID)
+	wr.logger.Printf("Documents ID: %v\n", result.InsertedID)
+	return nil
+}
+
+func (wr *WebhookRepo) GetAllSubscriptions() (webhooks.Subscriptions, error) {
+	return wr.findSubscriptions(bson.M{})
+}
+
+// SubscriptionsFor returns the subscriptions that want the event type
+func (wr *WebhookRepo) SubscriptionsFor(eventType string) (webhooks.Subscriptions, error) {
+	return wr.findSubscriptions(bson.M{"eventTypes": eventType})
+}
+
+func (wr *WebhookRepo) findSubscriptions(filter bson.M) (webhooks.Subscriptions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	subscriptionsCollection := wr.getSubscriptions()
+
+	subscriptions := webhooks.Subscriptions{}
+	cursor, err := subscriptionsCollection.Find(ctx, filter)
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &subscriptions); err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (wr *WebhookRepo) GetSubscriptionById(id string) (*webhooks.Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	subscriptionsCollection := wr.getSubscriptions()
+
+	var subscription webhooks.Subscription
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := subscriptionsCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&subscription)
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (wr *WebhookRepo) DeleteSubscription(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	subscriptionsCollection := wr.getSubscriptions()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	result, err := subscriptionsCollection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	wr.logger.Printf("Documents deleted: %v\n", result.DeletedCount)
+	return nil
+}
+
+func (wr *WebhookRepo) InsertDelivery(delivery *webhooks.Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deliveriesCollection := wr.getDeliveries()
+
+	result, err := deliveriesCollection.InsertOne(ctx, delivery)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ClaimDue pushes the next attempt of the oldest due delivery forward by lockFor and returns it
+func (wr *WebhookRepo) ClaimDue(lockFor time.Duration) (*webhooks.Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deliveriesCollection := wr.getDeliveries()
+
+	now := time.Now()
+	filter := bson.M{"status": webhooks.Pending, "nextAttemptAt": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lockFor)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetReturnDocument(options.After)
+
+	var delivery webhooks.Delivery
+	err := deliveriesCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, webhooks.ErrNoneDue
+	}
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (wr *WebhookRepo) SaveDelivery(delivery *webhooks.Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deliveriesCollection := wr.getDeliveries()
+
+	_, err := deliveriesCollection.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery)
+	if err != nil {
+		wr.logger.Println(err)
+		return err
+	}
+	return nil
+}
+
+// GetDeliveries returns the newest deliveries first, filtered by partner and status when they are not empty
+func (wr *WebhookRepo) GetDeliveries(partnerId string, status string) (webhooks.Deliveries, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deliveriesCollection := wr.getDeliveries()
+
+	filter := bson.M{}
+	if partnerId != "" {
+		filter["partnerId"] = partnerId
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(200)
+
+	deliveries := webhooks.Deliveries{}
+	cursor, err := deliveriesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (wr *WebhookRepo) GetDeliveryById(id string) (*webhooks.Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deliveriesCollection := wr.getDeliveries()
+
+	var delivery webhooks.Delivery
+	objID, _ := primitive.ObjectIDFromHex(id)
+	err := deliveriesCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&delivery)
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Redeliver queues a delivered or dead delivery again, with a fresh set of attempts.
+// It returns ErrDeliveryNotFound if the delivery does not exist or is still pending.
+func (wr *WebhookRepo) Redeliver(id string) (*webhooks.Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deliveriesCollection := wr.getDeliveries()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objID, "status": bson.M{"$ne": webhooks.Pending}}
+	update := bson.M{
+		"$set":   bson.M{"status": webhooks.Pending, "failures": 0, "nextAttemptAt": time.Now()},
+		"$unset": bson.M{"deliveredAt": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var delivery webhooks.Delivery
+	err := deliveriesCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		wr.logger.Println(err)
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (wr *WebhookRepo) getSubscriptions() *mongo.Collection {
+	webhookDatabase := wr.cli.Database("mongoDemo")
+	subscriptionsCollection := webhookDatabase.Collection("webhookSubscriptions")
+	return subscriptionsCollection
+}
+
+func (wr *WebhookRepo) getDeliveries() *mongo.Collection {
+	webhookDatabase := wr.cli.Database("mongoDemo")
+	deliveriesCollection := webhookDatabase.Collection("webhookDeliveries")
+	return deliveriesCollection
+}
+
+// scrubDeliveries replaces the fields in the bodies of the deliveries of the events, see scrubOutbox
+func scrubDeliveries(sc mongo.SessionContext, cli *mongo.Client, eventIds bson.A, fields map[string]interface{}) error {
+	deliveriesCollection := cli.Database("mongoDemo").Collection("webhookDeliveries")
+
+	cursor, err := deliveriesCollection.Find(sc, bson.M{"eventId": bson.M{"$in": eventIds}})
+	if err != nil {
+		return err
+	}
+	deliveries := webhooks.Deliveries{}
+	if err = cursor.All(sc, &deliveries); err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if err := delivery.Scrub(fields); err != nil {
+			return err
+		}
+		_, err = deliveriesCollection.UpdateOne(sc, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{"body": delivery.Body}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Package tokens makes the single-use tokens sent in account emails: signed ones, such as email verification links,
+// and random ones stored only as a hash, such as password reset links
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("token is invalid")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Claims is what a token vouches for. Nonce is also stored with the account,
+// the token is used up when the account forgets it.
+type Claims struct {
+	Purpose   string
+	Subject   string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+// Signer signs and verifies tokens with an HMAC-SHA256 key
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key}
+}
+
+// SignerFromEnv signs with ACCOUNT_TOKEN_KEY, it is required so that links cannot be forged with a known key
+func SignerFromEnv() (*Signer, error) {
+	key := os.Getenv("ACCOUNT_TOKEN_KEY")
+	if key == "" {
+		return nil, errors.New("ACCOUNT_TOKEN_KEY is not set, account emails cannot be signed")
+	}
+	return NewSigner([]byte(key)), nil
+}
+
+// Nonce returns a random value that makes every token unique
+func Nonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Random returns an unguessable URL safe token, only its Hash is stored
+func Random() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Hash is the SHA-256 of a random token, a leaked database does not give away tokens that still work
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the URL safe token for the claims
+func (s *Signer) Sign(claims Claims) string {
+	payload := strings.Join([]string{claims.Purpose, claims.Subject, claims.Nonce, strconv.FormatInt(claims.ExpiresAt.Unix(), 10)}, "|")
+	encoded := encoding.EncodeToString([]byte(payload))
+	return encoded + "." + s.signature(encoded)
+}
+
+// Verify checks the signature, purpose and expiry of the token and returns its claims
+func (s *Signer) Verify(token string, purpose string, now time.Time) (*Claims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.signature(encoded))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 4 || parts[0] != purpose {
+		return nil, ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &Claims{Purpose: parts[0], Subject: parts[1], Nonce: parts[2], ExpiresAt: time.Unix(expires, 0)}
+	if !now.Before(claims.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+func (s *Signer) signature(data string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(data))
+	return encoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,94 @@
+// Package vault encrypts personal data that is stored at rest, such as travel document numbers
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Sealed values start with the version of the key format so that keys can be rotated later
+const prefix = "v1:"
+
+var ErrNotSealed = errors.New("value is not sealed by the vault")
+
+// Vault seals values with AES-256-GCM, every value gets a random nonce
+type Vault struct {
+	aead cipher.AEAD
+}
+
+// New creates a vault with a 32 byte key
+func New(key []byte) (*Vault, error) {
+	if len(key) != 32 {
+		return nil, errors.New("vault key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{aead}, nil
+}
+
+// FromEnv creates the vault with the base64 encoded key in DOCUMENT_KEY, it is required.
+// Documents sealed with one key cannot be opened with another, so there is no fallback key.
+func FromEnv() (*Vault, error) {
+	encoded := os.Getenv("DOCUMENT_KEY")
+	if encoded == "" {
+		return nil, errors.New("DOCUMENT_KEY is not set, travel documents cannot be encrypted")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("DOCUMENT_KEY must be base64 encoded")
+	}
+	return New(key)
+}
+
+// Seal encrypts the value, an empty value stays empty
+func (v *Vault) Seal(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := v.aead.Seal(nonce, nonce, []byte(value), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed by Seal
+func (v *Vault) Open(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(sealed, prefix) {
+		return "", ErrNotSealed
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+	if err != nil || len(data) < v.aead.NonceSize() {
+		return "", ErrNotSealed
+	}
+	nonce, ciphertext := data[:v.aead.NonceSize()], data[v.aead.NonceSize():]
+	value, err := v.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Mask hides all but the last four characters of a value shown to users
+func Mask(value string) string {
+	if len(value) <= 4 {
+		return strings.Repeat("*", len(value))
+	}
+	return strings.Repeat("*", len(value)-4) + value[len(value)-4:]
+}
@@ -0,0 +1,98 @@
+package webhooks
+
+import (
+	"Rest/events"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	MaxAttempts = 8
+	// The first retry waits retryDelay, every further one twice as long up to maxRetryDelay
+	retryDelay    = 30 * time.Second
+	maxRetryDelay = time.Hour
+	sendTimeout   = 10 * time.Second
+)
+
+// Store keeps subscriptions and the deliveries made for them
+type Store interface {
+	SubscriptionsFor(eventType string) (Subscriptions, error)
+	GetSubscriptionById(id string) (*Subscription, error)
+	// InsertDelivery ignores a delivery of the same event to the same subscription that already exists
+	InsertDelivery(delivery *Delivery) error
+	// ClaimDue locks the oldest due delivery for lockFor, it returns ErrNoneDue if nothing is due
+	ClaimDue(lockFor time.Duration) (*Delivery, error)
+	SaveDelivery(delivery *Delivery) error
+}
+
+// Dispatcher is the outbox sink that turns every partner event into a delivery per interested subscription
+// and sends the deliveries in the background
+type Dispatcher struct {
+	logger *log.Logger
+	store  Store
+	client *http.Client
+}
+
+func NewDispatcher(l *log.Logger, s Store, client *http.Client) *Dispatcher {
+	return &Dispatcher{l, s, client}
+}
+
+func (d *Dispatcher) Name() string {
+	return "partner-webhooks"
+}
+
+// envelope is the body partners receive
+type envelope struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Deliver queues the event for the subscriptions that want it. Events about a user's booking only go to that user.
+func (d *Dispatcher) Deliver(ctx context.Context, event *events.Event) error {
+	if !PartnerEvents[event.Type] {
+		return nil
+	}
+	var owner struct {
+		UserId string `json:"userId"`
+	}
+	if err := json.Unmarshal(event.Payload, &owner); err != nil {
+		return err
+	}
+
+	subscriptions, err := d.store.SubscriptionsFor(event.Type)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(envelope{event.ID.Hex(), event.Type, event.OccurredAt, event.Payload})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		if owner.UserId != "" && owner.UserId != subscription.PartnerId {
+			continue
+		}
+		err := d.store.InsertDelivery(&Delivery{
+			SubscriptionId: subscription.ID.Hex(),
+			PartnerId:      subscription.PartnerId,
+			URL:            subscription.URL,
+			EventId:        event.ID.Hex(),
+			EventType:      event.Type,
+			Body:           body,
+			Status:         Pending,
+			Attempts:       []Attempt{},
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package webhooks
+
+import (
+	"Rest/events"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps subscriptions and deliveries like the repository does,
+// a delivery is unique by subscription and event
+type memoryStore struct {
+	mu            sync.Mutex
+	subscriptions Subscriptions
+	deliveries    []*Delivery
+}
+
+func (s *memoryStore) SubscriptionsFor(eventType string) (Subscriptions, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wanting := Subscriptions{}
+	for _, subscription := range s.subscriptions {
+		if subscription.Wants(eventType) {
+			wanting = append(wanting, subscription)
+		}
+	}
+	return wanting, nil
+}
+
+func (s *memoryStore) GetSubscriptionById(id string) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, subscription := range s.subscriptions {
+		if subscription.ID.Hex() == id {
+			return subscription, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (s *memoryStore) InsertDelivery(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.deliveries {
+		if existing.SubscriptionId == delivery.SubscriptionId && existing.EventId == delivery.EventId {
+			return nil
+		}
+	}
+	delivery.ID = primitive.NewObjectID()
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memoryStore) ClaimDue(lockFor time.Duration) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, delivery := range s.deliveries {
+		if delivery.Status == Pending && !delivery.NextAttemptAt.After(now) {
+			delivery.NextAttemptAt = now.Add(lockFor)
+			claimed := *delivery
+			return &claimed, nil
+		}
+	}
+	return nil, ErrNoneDue
+}
+
+func (s *memoryStore) SaveDelivery(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.deliveries {
+		if existing.ID == delivery.ID {
+			saved := *delivery
+			s.deliveries[i] = &saved
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// redeliver queues a delivered or dead delivery again the way the repository does
+func (s *memoryStore) redeliver(id primitive.ObjectID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, delivery := range s.deliveries {
+		if delivery.ID == id && delivery.Status != Pending {
+			delivery.Status = Pending
+			delivery.Failures = 0
+			delivery.NextAttemptAt = time.Now()
+			delivery.DeliveredAt = nil
+			return true
+		}
+	}
+	return false
+}
+
+// makeDue lets the retries run without waiting for their backoff
+func (s *memoryStore) makeDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, delivery := range s.deliveries {
+		delivery.NextAttemptAt = time.Now()
+	}
+}
+
+func (s *memoryStore) only(t *testing.T) *Delivery {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(s.deliveries))
+	}
+	delivery := *s.deliveries[0]
+	return &delivery
+}
+
+func (s *memoryStore) subscribe(partnerId string, url string, eventTypes ...string) *Subscription {
+	subscription := &Subscription{ID: primitive.NewObjectID(), PartnerId: partnerId, URL: url,
+		Secret: "whsec_test_" + partnerId, EventTypes: eventTypes, CreatedAt: time.Now()}
+	s.subscriptions = append(s.subscriptions, subscription)
+	return subscription
+}
+
+// receiver is a partner endpoint that checks the signature of every request it gets
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	body       []byte
+	eventType  string
+	deliveryId string
+	signature  string
+	verified   bool
+}
+
+func (r *receiver) ServeHTTP(rw http.ResponseWriter, h *http.Request) {
+	body, _ := io.ReadAll(h.Body)
+	signature := h.Header.Get(SignatureHeader)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, receivedRequest{body, h.Header.Get(EventTypeHeader), h.Header.Get(DeliveryHeader),
+		signature, verify(r.secret, signature, body, time.Now())})
+	rw.WriteHeader(r.status)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func (r *receiver) respond(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// verify checks a "t=<unix time>,v1=<hex HMAC>" signature the way a partner would, rejecting timestamps older than five minutes
+func verify(secret string, header string, body []byte, now time.Time) bool {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		if value, ok := strings.CutPrefix(part, "t="); ok {
+			timestamp = value
+		}
+		if value, ok := strings.CutPrefix(part, "v1="); ok {
+			signature = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(unix, 0)) > 5*time.Minute {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func newTestDispatcher(t *testing.T, status int) (*Dispatcher, *memoryStore, *receiver, *httptest.Server) {
+	t.Helper()
+	partner := &receiver{secret: "whsec_test_partner", status: status}
+	server := httptest.NewServer(partner)
+	t.Cleanup(server.Close)
+	store := &memoryStore{}
+	return NewDispatcher(log.New(io.Discard, "", 0), store, server.Client()), store, partner, server
+}
+
+func newEvent(t *testing.T, eventType string, payload interface{}) *events.Event {
+	t.Helper()
+	event, err := events.New(eventType, events.BookingAggregate, "booking-1", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.ID = primitive.NewObjectID()
+	return event
+}
+
+func TestDeliveryIsSignedWithTheSubscriptionSecret(t *testing.T) {
+	dispatcher, store, partner, server := newTestDispatcher(t, http.StatusOK)
+	store.subscribe("partner", server.URL, events.BookingConfirmed)
+	event := newEvent(t, events.BookingConfirmed, map[string]string{"userId": "partner", "locator": "ABC123"})
+
+	if err := dispatcher.Deliver(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.sendDue(context.Background())
+
+	requests := partner.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	request := requests[0]
+	if !request.verified {
+		t.Fatalf("signature %q does not verify", request.signature)
+	}
+	if verify("whsec_another_secret", request.signature, request.body, time.Now()) {
+		t.Fatal("signature verifies with another secret")
+	}
+	if verify(partner.secret, request.signature, append(request.body, ' '), time.Now()) {
+		t.Fatal("signature verifies for a changed body")
+	}
+	if verify(partner.secret, request.signature, request.body, time.Now().Add(10*time.Minute)) {
+		t.Fatal("an old signature verifies")
+	}
+
+	var body envelope
+	if err := json.Unmarshal(request.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	delivery := store.only(t)
+	if body.ID != event.ID.Hex() || body.Type != events.BookingConfirmed || request.eventType != events.BookingConfirmed {
+		t.Fatalf("unexpected envelope %+v with event header %q", body, request.eventType)
+	}
+	if request.deliveryId != delivery.ID.Hex() {
+		t.Fatalf("expected delivery header %s, got %s", delivery.ID.Hex(), request.deliveryId)
+	}
+	if delivery.Status != Delivered || delivery.DeliveredAt == nil || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusOK {
+		t.Fatalf("delivery was not recorded as delivered: %+v", delivery)
+	}
+}
+
+func TestDeliverQueuesAnEventOncePerSubscription(t *testing.T) {
+	dispatcher, store, partner, server := newTestDispatcher(t, http.StatusOK)
+	subscription := store.subscribe("partner", server.URL, events.BookingConfirmed)
+	store.subscribe("partner", server.URL, events.FlightCancelled)
+	store.subscribe("another partner", server.URL, events.BookingConfirmed)
+	event := newEvent(t, events.BookingConfirmed, map[string]string{"userId": "partner"})
+
+	// The outbox delivers at least once, the same event may come again
+	for i := 0; i < 3; i++ {
+		if err := dispatcher.Deliver(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	delivery := store.only(t)
+	if delivery.SubscriptionId != subscription.ID.Hex() || delivery.EventId != event.ID.Hex() {
+		t.Fatalf("event was queued for the wrong subscription: %+v", delivery)
+	}
+
+	dispatcher.sendDue(context.Background())
+	if err := dispatcher.Deliver(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.sendDue(context.Background())
+	if len(partner.received()) != 1 {
+		t.Fatalf("expected the event to be sent once, it was sent %d times", len(partner.received()))
+	}
+}
+
+func TestDeliverIgnoresEventsPartnersCannotSubscribeTo(t *testing.T) {
+	dispatcher, store, _, server := newTestDispatcher(t, http.StatusOK)
+	store.subscribe("partner", server.URL, events.BookingConfirmed)
+
+	if err := dispatcher.Deliver(context.Background(), newEvent(t, events.UserRegistered, map[string]string{})); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.deliveries) != 0 {
+		t.Fatal("an event partners cannot subscribe to was queued")
+	}
+}
+
+func TestFailedDeliveryBacksOffUntilItIsDead(t *testing.T) {
+	dispatcher, store, partner, server := newTestDispatcher(t, http.StatusInternalServerError)
+	store.subscribe("partner", server.URL, events.FlightCancelled)
+	if err := dispatcher.Deliver(context.Background(), newEvent(t, events.FlightCancelled, map[string]string{"id": "flight-1"})); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute}
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		dispatcher.sendDue(context.Background())
+
+		delivery := store.only(t)
+		if len(partner.received()) != attempt || delivery.Failures != attempt || len(delivery.Attempts) != attempt {
+			t.Fatalf("attempt %d: partner got %d requests, delivery has %d failures", attempt, len(partner.received()), delivery.Failures)
+		}
+		last := delivery.Attempts[attempt-1]
+		if last.StatusCode != http.StatusInternalServerError || last.Error == "" {
+			t.Fatalf("attempt %d was not recorded as failed: %+v", attempt, last)
+		}
+		if attempt == MaxAttempts {
+			if delivery.Status != Dead {
+				t.Fatalf("expected the delivery to be dead after %d attempts, it is %s", MaxAttempts, delivery.Status)
+			}
+			break
+		}
+
+		if delivery.Status != Pending {
+			t.Fatalf("attempt %d: expected the delivery to be pending, it is %s", attempt, delivery.Status)
+		}
+		delay := delivery.NextAttemptAt.Sub(last.At)
+		if delay < expected[attempt-1] || delay > expected[attempt-1]+time.Second {
+			t.Fatalf("attempt %d: expected a retry after %s, got %s", attempt, expected[attempt-1], delay)
+		}
+		// Nothing is sent before the backoff is over
+		dispatcher.sendDue(context.Background())
+		if len(partner.received()) != attempt {
+			t.Fatalf("attempt %d: the delivery was retried before its backoff", attempt)
+		}
+		store.makeDue()
+	}
+
+	store.makeDue()
+	dispatcher.sendDue(context.Background())
+	if len(partner.received()) != MaxAttempts {
+		t.Fatal("a dead delivery was sent again")
+	}
+}
+
+func TestBackoffIsCappedAtTheMaximumDelay(t *testing.T) {
+	if backoff(1) != retryDelay {
+		t.Fatalf("expected the first retry after %s, got %s", retryDelay, backoff(1))
+	}
+	if backoff(30) != maxRetryDelay {
+		t.Fatalf("expected the delay to be capped at %s, got %s", maxRetryDelay, backoff(30))
+	}
+}
+
+func TestRedeliveredDeadDeliveryIsSentAgain(t *testing.T) {
+	dispatcher, store, partner, server := newTestDispatcher(t, http.StatusServiceUnavailable)
+	store.subscribe("partner", server.URL, events.BookingCancelled)
+	if err := dispatcher.Deliver(context.Background(), newEvent(t, events.BookingCancelled, map[string]string{"userId": "partner"})); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		store.makeDue()
+		dispatcher.sendDue(context.Background())
+	}
+	dead := store.only(t)
+	if dead.Status != Dead {
+		t.Fatalf("expected a dead delivery, it is %s", dead.Status)
+	}
+
+	partner.respond(http.StatusNoContent)
+	if !store.redeliver(dead.ID) {
+		t.Fatal("dead delivery could not be redelivered")
+	}
+	dispatcher.sendDue(context.Background())
+
+	delivery := store.only(t)
+	if delivery.Status != Delivered || delivery.Failures != 0 || len(delivery.Attempts) != MaxAttempts+1 {
+		t.Fatalf("redelivery was not recorded: %+v", delivery)
+	}
+	requests := partner.received()
+	last := requests[len(requests)-1]
+	if !last.verified || string(last.body) != string(requests[0].body) || last.deliveryId != requests[0].deliveryId {
+		t.Fatal("redelivery was not sent with the same body and a valid signature")
+	}
+	if store.redeliver(primitive.NewObjectID()) {
+		t.Fatal("an unknown delivery was redelivered")
+	}
+}
+
+func TestScrubbedDeliveryKeepsItsEnvelope(t *testing.T) {
+	dispatcher, store, _, server := newTestDispatcher(t, http.StatusOK)
+	store.subscribe("partner", server.URL, events.BookingConfirmed)
+	event := newEvent(t, events.BookingConfirmed, map[string]interface{}{
+		"userId":     "partner",
+		"locator":    "ABC123",
+		"passengers": []map[string]string{{"name": "Ana", "surname": "Petrovic"}},
+	})
+	if err := dispatcher.Deliver(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	delivery := store.only(t)
+
+	if err := delivery.Scrub(map[string]interface{}{"passengers": []map[string]string{{"name": "DELETED"}}, "email": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	var body envelope
+	if err := json.Unmarshal(delivery.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ID != event.ID.Hex() || body.Type != events.BookingConfirmed {
+		t.Fatalf("envelope changed: %+v", body)
+	}
+	data := string(body.Data)
+	if strings.Contains(data, "Ana") || strings.Contains(data, "Petrovic") || !strings.Contains(data, "DELETED") {
+		t.Fatalf("passengers were not scrubbed: %s", data)
+	}
+	if !strings.Contains(data, `"locator":"ABC123"`) || strings.Contains(data, "email") {
+		t.Fatalf("fields other than the scrubbed ones changed: %s", data)
+	}
+}
@@ -0,0 +1,117 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var ErrNoneDue = errors.New("no webhook delivery is due")
+
+// Start sends due deliveries every interval until the context is done.
+// Several instances can run it at once, every delivery is claimed by one of them.
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.sendDue(ctx)
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) sendDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		delivery, err := d.store.ClaimDue(2 * sendTimeout)
+		if err == ErrNoneDue {
+			return
+		}
+		if err != nil {
+			d.logger.Print("Unable to read webhook deliveries: ", err)
+			return
+		}
+		d.send(ctx, delivery)
+	}
+}
+
+// send makes one attempt, a delivery that failed MaxAttempts times is moved to the dead letters
+func (d *Dispatcher) send(ctx context.Context, delivery *Delivery) {
+	attempt := d.post(ctx, delivery)
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	if attempt.Error != "" {
+		delivery.Failures++
+	}
+	switch {
+	case attempt.Error == "":
+		delivery.Status = Delivered
+		delivery.DeliveredAt = &attempt.At
+	case delivery.Failures >= MaxAttempts:
+		delivery.Status = Dead
+		d.logger.Printf("Webhook delivery %s to %s is dead after %d attempts: %s", delivery.ID.Hex(), delivery.URL, delivery.Failures, attempt.Error)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(backoff(delivery.Failures))
+	}
+
+	if err := d.store.SaveDelivery(delivery); err != nil {
+		d.logger.Printf("Unable to save webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery *Delivery) (attempt Attempt) {
+	sendContext, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	attempt.At = time.Now()
+	defer func() { attempt.Duration = time.Since(attempt.At).Milliseconds() }()
+
+	// The secret is looked up on every attempt so that a rotated secret is used right away
+	subscription, err := d.store.GetSubscriptionById(delivery.SubscriptionId)
+	if err != nil {
+		attempt.Error = "subscription not found"
+		return attempt
+	}
+
+	request, err := http.NewRequestWithContext(sendContext, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventTypeHeader, delivery.EventType)
+	request.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	request.Header.Set(SignatureHeader, Sign(subscription.Secret, attempt.At, delivery.Body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("partner responded with %s", response.Status)
+	}
+	return attempt
+}
+
+func backoff(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,173 @@
+package webhooks
+
+import (
+	"Rest/events"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	// Dead deliveries gave up after MaxAttempts and wait in the dead-letter list until a partner redelivers them
+	Dead = "dead"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventTypeHeader = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// PartnerEvents are the event types partners can subscribe to. Booking events only go to the partner who made the booking.
+var PartnerEvents = map[string]bool{
+	events.BookingConfirmed:    true,
+	events.BookingCancelled:    true,
+	events.BookingDisrupted:    true,
+	events.BookingRebooked:     true,
+	events.BookingChanged:      true,
+	events.BookingAnonymized:   true,
+	events.RefundIssued:        true,
+	events.FlightUpdated:       true,
+	events.FlightStatusChanged: true,
+	events.FlightCancelled:     true,
+}
+
+// Subscription sends the chosen event types of a partner to its URL
+type Subscription struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PartnerId  string             `bson:"partnerId" json:"partnerId"`
+	URL        string             `bson:"url" json:"url"`
+	Secret     string             `bson:"secret" json:"secret,omitempty"`
+	EventTypes []string           `bson:"eventTypes" json:"eventTypes"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type Subscriptions []*Subscription
+
+// Attempt is one try to deliver a webhook
+type Attempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	Duration   int64     `bson:"durationMs" json:"durationMs"`
+}
+
+// Delivery is one event on its way to one subscription
+type Delivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SubscriptionId string             `bson:"subscriptionId" json:"subscriptionId"`
+	PartnerId      string             `bson:"partnerId" json:"partnerId"`
+	URL            string             `bson:"url" json:"url"`
+	EventId        string             `bson:"eventId" json:"eventId"`
+	EventType      string             `bson:"eventType" json:"eventType"`
+	// Body is sent unchanged on every attempt so the partner can deduplicate by the event id in it
+	Body     json.RawMessage `bson:"body" json:"body"`
+	Status   string          `bson:"status" json:"status"`
+	Attempts []Attempt       `bson:"attempts" json:"attempts"`
+	// Failures since the delivery was queued or last redelivered, the attempts keep the whole history
+	Failures      int        `bson:"failures" json:"failures"`
+	NextAttemptAt time.Time  `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	CreatedAt     time.Time  `bson:"createdAt" json:"createdAt"`
+	DeliveredAt   *time.Time `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+}
+
+type Deliveries []*Delivery
+
+// Validate checks the subscription created by an admin
+func (s *Subscription) Validate() error {
+	if s.PartnerId == "" {
+		return errors.New("partnerId is required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	if len(s.EventTypes) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, eventType := range s.EventTypes {
+		if !PartnerEvents[eventType] {
+			return fmt.Errorf("unknown event type %s", eventType)
+		}
+	}
+	return nil
+}
+
+func (s *Subscription) Wants(eventType string) bool {
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrub replaces the fields of the event data in the body, a redelivery sends the scrubbed data
+func (d *Delivery) Scrub(fields map[string]interface{}) error {
+	var body envelope
+	if err := json.Unmarshal(d.Body, &body); err != nil {
+		return err
+	}
+	data, err := events.Scrub(body.Data, fields)
+	if err != nil {
+		return err
+	}
+	body.Data = data
+	d.Body, err = json.Marshal(body)
+	return err
+}
+
+// NewSecret generates the secret a subscription's payloads are signed with
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">".
+// Partners recompute it with their secret and should reject old timestamps to stop replays.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := fmt.Sprint(timestamp.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Subscription) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(s)
+}
+
+func (s *Subscription) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(s)
+}
+
+func (s *Subscriptions) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(s)
+}
+
+func (d *Delivery) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(d)
+}
+
+func (d *Deliveries) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(d)
+}